@@ -0,0 +1,5 @@
+pub use runtime_macros::task;
+
+pub fn spawn() -> tokio::runtime::Runtime {
+    tokio::runtime::Runtime::new().unwrap()
+}
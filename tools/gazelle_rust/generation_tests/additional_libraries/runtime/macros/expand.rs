@@ -0,0 +1,7 @@
+pub fn task(
+    _attribute: proc_macro::TokenStream,
+    item: proc_macro::TokenStream,
+) -> proc_macro::TokenStream {
+    let function: syn::ItemFn = syn::parse(item).unwrap();
+    quote::quote!(#function).into()
+}
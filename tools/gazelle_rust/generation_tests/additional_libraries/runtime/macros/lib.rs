@@ -0,0 +1,9 @@
+mod expand;
+
+#[proc_macro_attribute]
+pub fn task(
+    attribute: proc_macro::TokenStream,
+    item: proc_macro::TokenStream,
+) -> proc_macro::TokenStream {
+    expand::task(attribute, item)
+}
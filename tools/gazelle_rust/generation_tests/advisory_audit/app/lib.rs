@@ -0,0 +1,4 @@
+#[derive(serde::Serialize)]
+pub struct Settings {
+    pub name: String,
+}
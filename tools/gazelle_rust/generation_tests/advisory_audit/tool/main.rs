@@ -0,0 +1,4 @@
+fn main() {
+    let settings = app::Settings { name: String::new() };
+    println!("{}", serde_json::to_string(&settings).unwrap());
+}
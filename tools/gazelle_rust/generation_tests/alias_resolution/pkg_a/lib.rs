@@ -0,0 +1,3 @@
+pub fn hello() -> &'static str {
+    "Hello from pkg_a"
+}
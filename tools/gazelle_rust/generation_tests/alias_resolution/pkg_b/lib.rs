@@ -0,0 +1,5 @@
+use pkg_a::hello;
+
+pub fn greet() -> &'static str {
+    hello()
+}
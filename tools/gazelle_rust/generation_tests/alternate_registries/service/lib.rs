@@ -0,0 +1,6 @@
+use auth_client::Session;
+use serde::Serialize;
+
+pub fn start(session: Session) {
+    telemetry::record("start");
+}
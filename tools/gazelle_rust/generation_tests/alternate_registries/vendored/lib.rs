@@ -0,0 +1 @@
+pub use auth_client::Session;
@@ -0,0 +1,5 @@
+use foo::foo;
+
+pub fn baz() {
+    foo();
+}
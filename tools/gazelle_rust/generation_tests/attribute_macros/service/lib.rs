@@ -0,0 +1,4 @@
+#[async_trait]
+pub trait Store {
+    async fn load(&self, key: &str) -> Option<String>;
+}
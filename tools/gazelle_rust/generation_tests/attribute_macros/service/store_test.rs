@@ -0,0 +1,13 @@
+#[rstest]
+#[case("first")]
+fn loads(#[case] key: &str) {
+    assert!(!key.is_empty());
+}
+
+#[tokio::test(flavor = "multi_thread")]
+async fn loads_concurrently() {}
+
+#[cfg_attr(test, mockall::automock)]
+trait Clock {
+    fn now(&self) -> u64;
+}
@@ -0,0 +1,4 @@
+#[test]
+fn encodes() {
+    assert_eq!(codec::encode(0), 0x5a);
+}
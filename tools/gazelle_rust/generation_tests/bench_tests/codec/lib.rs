@@ -0,0 +1,3 @@
+pub fn encode(value: u8) -> u8 {
+    value ^ 0x5a
+}
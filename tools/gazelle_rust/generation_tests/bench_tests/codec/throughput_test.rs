@@ -0,0 +1,8 @@
+#![feature(test)]
+
+extern crate test;
+
+#[bench]
+fn encodes_bytes(bencher: &mut test::Bencher) {
+    bencher.iter(|| (0..=255u8).map(codec::encode).count());
+}
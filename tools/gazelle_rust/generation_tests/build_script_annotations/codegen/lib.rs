@@ -0,0 +1,3 @@
+pub fn protoc() -> std::path::PathBuf {
+    protobuf_src::protoc()
+}
@@ -0,0 +1,10 @@
+use openssl::ssl::SslConnector;
+use ring::digest;
+
+pub fn fingerprint(certificate: &[u8]) -> Vec<u8> {
+    digest::digest(&digest::SHA256, certificate).as_ref().to_vec()
+}
+
+pub fn connector() -> SslConnector {
+    SslConnector::builder(openssl::ssl::SslMethod::tls()).unwrap().build()
+}
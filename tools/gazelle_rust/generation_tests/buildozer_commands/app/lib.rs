@@ -0,0 +1,7 @@
+mod settings;
+
+pub use settings::Settings;
+
+pub fn parse(text: &str) -> serde_json::Result<Settings> {
+    serde_json::from_str(text)
+}
@@ -0,0 +1,6 @@
+use house_prelude::Result;
+
+pub fn detect() -> Result<bool> {
+    let _ = serde::de::IgnoredAny;
+    Ok(std_detect::is_x86_feature_detected!("avx2"))
+}
@@ -0,0 +1 @@
+pub use house_prelude::Result;
@@ -0,0 +1,5 @@
+pub const MAJOR: &str = env!("CARGO_PKG_VERSION_MAJOR");
+
+pub fn description() -> Option<&'static str> {
+    option_env!("CARGO_PKG_DESCRIPTION")
+}
@@ -0,0 +1,3 @@
+pub fn banner() -> String {
+    format!("tool {} ({})", env!("CARGO_PKG_VERSION"), env!("CARGO_PKG_REPOSITORY"))
+}
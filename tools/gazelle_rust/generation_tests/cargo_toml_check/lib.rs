@@ -0,0 +1,13 @@
+use nix::unistd::getpid;
+use serde::Serialize;
+
+#[derive(Serialize)]
+pub struct Process {
+    pub id: i32,
+}
+
+pub fn current() -> Process {
+    Process {
+        id: getpid().as_raw(),
+    }
+}
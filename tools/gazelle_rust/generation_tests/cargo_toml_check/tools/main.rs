@@ -0,0 +1,5 @@
+use tokio::runtime::Runtime;
+
+fn main() {
+    Runtime::new().unwrap();
+}
@@ -0,0 +1,3 @@
+#![cfg(target_os = "macos")]
+
+pub fn kqueue() {}
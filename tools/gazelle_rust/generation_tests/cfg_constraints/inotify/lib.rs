@@ -0,0 +1,3 @@
+#![cfg(all(target_os = "linux", feature = "inotify"))]
+
+pub fn watch() {}
@@ -0,0 +1,3 @@
+#![cfg(not(windows))]
+
+fn main() {}
@@ -0,0 +1,4 @@
+#[cfg(target_os = "linux")]
+mod linux;
+
+pub fn run() {}
@@ -0,0 +1,4 @@
+#![cfg(target_arch = "wasm32")]
+#![cfg(any(target_os = "wasi", target_os = "emscripten"))]
+
+pub fn fetch() {}
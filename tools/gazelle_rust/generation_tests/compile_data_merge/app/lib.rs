@@ -0,0 +1 @@
+pub const SCHEMA: &str = include_str!("schema.sql");
@@ -0,0 +1 @@
+pub const INDEX: &str = include_str!("static/index.html");
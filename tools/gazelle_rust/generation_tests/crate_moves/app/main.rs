@@ -0,0 +1,6 @@
+use geometry::Circle;
+
+fn main() {
+    let circle = Circle { radius: units::meters(3.0) };
+    println!("{}", circle.radius);
+}
@@ -0,0 +1,11 @@
+pub fn meters(feet: f64) -> f64 {
+    feet * 0.3048
+}
+
+#[cfg(test)]
+mod tests {
+    #[test]
+    fn converts() {
+        assert_eq!(super::meters(0.0), 0.0);
+    }
+}
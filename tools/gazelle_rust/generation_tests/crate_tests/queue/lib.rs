@@ -0,0 +1,15 @@
+pub fn channel() -> crossbeam::channel::Sender<u32> {
+    crossbeam::channel::unbounded().0
+}
+
+#[cfg(test)]
+mod tests {
+    use proptest::prelude::*;
+
+    proptest! {
+        #[test]
+        fn sends(value: u32) {
+            super::channel().send(value).unwrap();
+        }
+    }
+}
@@ -0,0 +1,5 @@
+use std::io::BufRead;
+
+pub fn certificates(reader: &mut dyn BufRead) -> usize {
+    rustls_pemfile::certs(reader).count()
+}
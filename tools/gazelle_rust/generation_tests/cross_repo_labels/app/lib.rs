@@ -0,0 +1,4 @@
+use own::Config;
+use pinned::Version;
+use platform::Target;
+use util::Cache;
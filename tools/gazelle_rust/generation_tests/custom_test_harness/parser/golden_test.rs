@@ -0,0 +1,9 @@
+use libtest_mimic::{Arguments, Trial};
+
+fn main() {
+    let trials = vec![Trial::test("golden", || {
+        assert_eq!(parser::parse("x").len(), 1);
+        Ok(())
+    })];
+    libtest_mimic::run(&Arguments::from_args(), trials).exit();
+}
@@ -0,0 +1,3 @@
+pub fn parse(text: &str) -> Vec<&str> {
+    text.split_whitespace().collect()
+}
@@ -0,0 +1,8 @@
+use criterion::{Criterion, criterion_group, criterion_main};
+
+fn parse_words(criterion: &mut Criterion) {
+    criterion.bench_function("parse", |bencher| bencher.iter(|| parser::parse("a b c")));
+}
+
+criterion_group!(benches, parse_words);
+criterion_main!(benches);
@@ -0,0 +1,9 @@
+fn parse_fixture(path: &std::path::Path) -> datatest_stable::Result<()> {
+    let text = std::fs::read_to_string(path)?;
+    assert!(!parser::parse(&text).is_empty());
+    Ok(())
+}
+
+datatest_stable::harness! {
+    { test = parse_fixture, root = "fixtures" },
+}
@@ -0,0 +1,4 @@
+#[test]
+fn parses() {
+    serde_json::from_str::<()>("null").unwrap();
+}
@@ -0,0 +1,9 @@
+use pkg_b::greet;
+
+pub fn hello() -> &'static str {
+    "Hello from pkg_a"
+}
+
+pub fn greet_twice() -> String {
+    format!("{} {}", greet(), greet())
+}
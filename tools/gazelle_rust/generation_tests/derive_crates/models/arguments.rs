@@ -0,0 +1,3 @@
+// clap isn't in Cargo.lock, so Parser isn't taken to be its derive.
+#[derive(Parser)]
+pub struct Arguments;
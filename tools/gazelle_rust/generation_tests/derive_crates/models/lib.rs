@@ -0,0 +1,9 @@
+mod arguments;
+
+#[derive(Debug, Clone, Serialize, Model)]
+pub struct User {
+    pub name: String,
+}
+
+#[derive(Debug, Error)]
+pub enum LoadError {}
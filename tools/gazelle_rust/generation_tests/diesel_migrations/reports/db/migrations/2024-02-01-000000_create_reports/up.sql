@@ -0,0 +1 @@
+CREATE TABLE reports (id INTEGER PRIMARY KEY);
@@ -0,0 +1,5 @@
+use diesel_migrations::{EmbeddedMigrations, embed_migrations};
+
+mod schema;
+
+pub const MIGRATIONS: EmbeddedMigrations = embed_migrations!();
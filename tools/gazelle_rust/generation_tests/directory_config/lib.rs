@@ -0,0 +1,11 @@
+use serde::Serialize;
+use tokio::runtime::Runtime;
+
+#[derive(Serialize)]
+pub struct Config {
+    pub name: String,
+}
+
+pub fn create_runtime() -> Runtime {
+    Runtime::new().unwrap()
+}
@@ -0,0 +1,5 @@
+use tokio::runtime::Runtime;
+
+pub fn create_runtime() -> Runtime {
+    Runtime::new().unwrap()
+}
@@ -0,0 +1,4 @@
+#![doc = include_str!("README.md")]
+#![cfg_attr(docsrs, doc = include_str!("../CHANGELOG.md"))]
+
+mod templates;
@@ -0,0 +1 @@
+pub const PAGE: &str = include_str!("templates/page.html");
@@ -0,0 +1,5 @@
+#![cfg(any())]
+
+fn main() {
+    legacy::run();
+}
@@ -0,0 +1 @@
+//! Tests move to clock_test.rs once the clock is injectable.
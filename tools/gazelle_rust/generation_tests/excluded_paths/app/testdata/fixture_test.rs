@@ -0,0 +1,4 @@
+#[test]
+fn fixture() {
+    app::parse();
+}
@@ -0,0 +1,4 @@
+#[test]
+fn parses() {
+    app::parse();
+}
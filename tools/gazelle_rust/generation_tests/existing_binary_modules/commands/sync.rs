@@ -0,0 +1 @@
+pub fn run(_config: String) {}
@@ -0,0 +1,3 @@
+pub fn load() -> String {
+    String::new()
+}
@@ -0,0 +1,6 @@
+mod commands;
+mod config;
+
+fn main() {
+    commands::sync::run(config::load());
+}
@@ -0,0 +1,8 @@
+lazy_static! {
+    static ref ATTEMPTS: u32 = 3;
+}
+
+fn main() {
+    let value: Result<u32, ()> = retry!(*ATTEMPTS, Ok(1));
+    println!("{:?}", value);
+}
@@ -0,0 +1,14 @@
+pub fn run<T, E>(attempts: u32, mut body: impl FnMut() -> Result<T, E>) -> Result<T, E> {
+    let mut result = body();
+    for _ in 1..attempts {
+        if result.is_ok() {
+            break;
+        }
+        result = body();
+    }
+    result
+}
+
+pub fn run_once() -> Result<(), ()> {
+    retry!(1, Ok(()))
+}
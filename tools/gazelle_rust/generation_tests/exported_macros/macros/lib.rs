@@ -0,0 +1,8 @@
+mod backoff;
+
+#[macro_export]
+macro_rules! retry {
+    ($attempts:expr, $body:expr) => {
+        $crate::backoff::run($attempts, || $body)
+    };
+}
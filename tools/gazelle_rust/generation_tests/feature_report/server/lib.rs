@@ -0,0 +1,9 @@
+use serde::Serialize;
+
+#[derive(Serialize)]
+pub struct Status {
+    pub healthy: bool,
+}
+
+#[tokio::main]
+pub async fn serve() {}
@@ -0,0 +1,3 @@
+pub fn spawn() {
+    tokio::runtime::Runtime::new().unwrap();
+}
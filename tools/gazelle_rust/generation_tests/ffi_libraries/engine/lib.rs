@@ -0,0 +1,8 @@
+mod state;
+
+use state::State;
+
+#[unsafe(no_mangle)]
+pub extern "C" fn engine_tick(ticks: u64) -> u64 {
+    State { ticks }.advance()
+}
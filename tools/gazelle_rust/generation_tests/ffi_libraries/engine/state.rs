@@ -0,0 +1,12 @@
+use serde::Serialize;
+
+#[derive(Serialize)]
+pub struct State {
+    pub ticks: u64,
+}
+
+impl State {
+    pub fn advance(&self) -> u64 {
+        self.ticks + 1
+    }
+}
@@ -0,0 +1,5 @@
+fn main() {
+    for token in lexer::lex("a b c") {
+        let _ = token;
+    }
+}
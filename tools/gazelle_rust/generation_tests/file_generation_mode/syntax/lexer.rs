@@ -0,0 +1,8 @@
+use token::Token;
+
+pub fn lex(source: &str) -> Vec<Token> {
+    source
+        .split_whitespace()
+        .map(|word| Token::Identifier(word.to_string()))
+        .collect()
+}
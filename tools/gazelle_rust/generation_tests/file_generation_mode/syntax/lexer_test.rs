@@ -0,0 +1,4 @@
+#[test]
+fn lexes_words() {
+    assert_eq!(lexer::lex("a b").len(), 2);
+}
@@ -0,0 +1,4 @@
+pub enum Token {
+    Identifier(String),
+    Number(i64),
+}
@@ -0,0 +1,7 @@
+#![no_std]
+#![cfg_attr(not(test), no_main)]
+
+#[riscv_rt::entry]
+fn start() -> ! {
+    loop {}
+}
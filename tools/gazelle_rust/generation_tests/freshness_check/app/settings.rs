@@ -0,0 +1,4 @@
+#[derive(serde::Deserialize)]
+pub struct Settings {
+    pub name: String,
+}
@@ -0,0 +1,4 @@
+fn main() {
+    let settings = app::parse("{}").unwrap();
+    println!("{}", settings.name);
+}
@@ -0,0 +1,4 @@
+mod bindings;
+mod config;
+
+pub use bindings::version;
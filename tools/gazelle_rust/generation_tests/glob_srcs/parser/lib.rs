@@ -0,0 +1,7 @@
+mod nested;
+
+pub use nested::tokens::Token;
+
+pub fn parse(input: &str) -> serde_json::Value {
+    serde_json::from_str(input).unwrap()
+}
@@ -0,0 +1 @@
+pub struct Token(pub regex::Regex);
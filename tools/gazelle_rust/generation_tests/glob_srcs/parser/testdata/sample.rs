@@ -0,0 +1,3 @@
+fn sample() -> anyhow::Result<()> {
+    Ok(())
+}
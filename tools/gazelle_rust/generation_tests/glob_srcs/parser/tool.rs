@@ -0,0 +1,3 @@
+fn main() {
+    clap::Command::new("tool");
+}
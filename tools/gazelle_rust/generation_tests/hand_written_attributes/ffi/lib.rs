@@ -0,0 +1,3 @@
+pub fn raw() -> u8 {
+    unsafe { *std::ptr::null() }
+}
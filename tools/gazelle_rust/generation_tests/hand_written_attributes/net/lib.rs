@@ -0,0 +1 @@
+pub fn connect() {}
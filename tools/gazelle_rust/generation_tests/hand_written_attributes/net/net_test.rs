@@ -0,0 +1,6 @@
+use net::connect;
+
+#[test]
+fn connects() {
+    connect();
+}
@@ -0,0 +1,6 @@
+#[test]
+fn parses() {}
+
+#[test]
+#[ignore]
+fn parses_slowly() {}
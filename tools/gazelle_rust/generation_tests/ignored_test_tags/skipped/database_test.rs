@@ -0,0 +1,7 @@
+#[test]
+#[ignore]
+fn migrates() {}
+
+#[test]
+#[ignore = "needs a database"]
+fn queries() {}
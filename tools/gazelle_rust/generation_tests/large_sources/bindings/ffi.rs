@@ -0,0 +1,40 @@
+// Generated by bindgen. Do not edit.
+
+use libc::c_uint;
+
+pub const FFI_CONSTANT_0: u32 = 0;
+pub const FFI_CONSTANT_1: u32 = 4099;
+pub const FFI_CONSTANT_2: u32 = 8198;
+pub const FFI_CONSTANT_3: u32 = 12297;
+pub const FFI_CONSTANT_4: u32 = 16396;
+pub const FFI_CONSTANT_5: u32 = 20495;
+pub const FFI_CONSTANT_6: u32 = 24594;
+pub const FFI_CONSTANT_7: u32 = 28693;
+pub const FFI_CONSTANT_8: u32 = 32792;
+pub const FFI_CONSTANT_9: u32 = 36891;
+pub const FFI_CONSTANT_10: u32 = 40990;
+pub const FFI_CONSTANT_11: u32 = 45089;
+pub const FFI_CONSTANT_12: u32 = 49188;
+pub const FFI_CONSTANT_13: u32 = 53287;
+pub const FFI_CONSTANT_14: u32 = 57386;
+pub const FFI_CONSTANT_15: u32 = 61485;
+pub const FFI_CONSTANT_16: u32 = 65584;
+pub const FFI_CONSTANT_17: u32 = 69683;
+pub const FFI_CONSTANT_18: u32 = 73782;
+pub const FFI_CONSTANT_19: u32 = 77881;
+pub const FFI_CONSTANT_20: u32 = 81980;
+pub const FFI_CONSTANT_21: u32 = 86079;
+pub const FFI_CONSTANT_22: u32 = 90178;
+pub const FFI_CONSTANT_23: u32 = 94277;
+pub const FFI_CONSTANT_24: u32 = 98376;
+pub const FFI_CONSTANT_25: u32 = 102475;
+pub const FFI_CONSTANT_26: u32 = 106574;
+pub const FFI_CONSTANT_27: u32 = 110673;
+pub const FFI_CONSTANT_28: u32 = 114772;
+pub const FFI_CONSTANT_29: u32 = 118871;
+pub const FFI_CONSTANT_30: u32 = 122970;
+pub const FFI_CONSTANT_31: u32 = 127069;
+
+pub fn version() -> c_uint {
+    FFI_CONSTANT_1
+}
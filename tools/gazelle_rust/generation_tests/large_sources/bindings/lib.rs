@@ -0,0 +1,4 @@
+mod ffi;
+mod tables;
+
+pub use ffi::version;
@@ -0,0 +1,5 @@
+use services::handle;
+
+pub fn shared() -> &'static str {
+    handle()
+}
@@ -0,0 +1,6 @@
+use services::handle;
+use services__auth::authenticate;
+
+pub fn route() -> &'static str {
+    if authenticate() { handle() } else { "denied" }
+}
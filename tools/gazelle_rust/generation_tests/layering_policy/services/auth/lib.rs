@@ -0,0 +1,3 @@
+pub fn authenticate() -> bool {
+    true
+}
@@ -0,0 +1,3 @@
+pub fn handle() -> &'static str {
+    "handled"
+}
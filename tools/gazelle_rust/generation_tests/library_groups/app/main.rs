@@ -0,0 +1,4 @@
+fn main() {
+    let bytes = libs__codec::encode("localhost");
+    println!("{} {}", bytes.len(), libs__net::connect("localhost"));
+}
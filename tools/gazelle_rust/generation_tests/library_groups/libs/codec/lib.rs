@@ -0,0 +1,3 @@
+pub fn encode(address: &str) -> Vec<u8> {
+    libs__net::connect(address).into_bytes()
+}
@@ -0,0 +1,3 @@
+pub fn connect(address: &str) -> String {
+    format!("connected to {address}")
+}
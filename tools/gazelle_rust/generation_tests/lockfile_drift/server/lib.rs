@@ -0,0 +1,6 @@
+use anyhow::Result;
+
+pub async fn serve() -> Result<()> {
+    tokio::task::yield_now().await;
+    Ok(())
+}
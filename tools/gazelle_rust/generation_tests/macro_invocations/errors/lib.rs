@@ -0,0 +1,3 @@
+mod patterns;
+
+define_error!(NotFound);
@@ -0,0 +1,3 @@
+lazy_static! {
+    static ref IDENTIFIER: regex::Regex = regex::Regex::new("[a-z_]+").unwrap();
+}
@@ -0,0 +1,3 @@
+pub fn fetch() -> reqwest::Client {
+    reqwest::Client::new()
+}
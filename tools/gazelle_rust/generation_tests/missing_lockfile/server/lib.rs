@@ -0,0 +1,3 @@
+pub async fn serve() {
+    tokio::task::yield_now().await;
+}
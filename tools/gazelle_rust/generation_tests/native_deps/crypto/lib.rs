@@ -0,0 +1,3 @@
+pub fn init() {
+    openssl_sys::init();
+}
@@ -0,0 +1,9 @@
+#[link(name = "z")]
+unsafe extern "C" {
+    fn crc32(crc: u64, buffer: *const u8, length: u32) -> u64;
+}
+
+#[link(name = "m")]
+unsafe extern "C" {
+    fn cos(x: f64) -> f64;
+}
@@ -0,0 +1,5 @@
+#![feature(never_type)]
+
+pub fn diverge() -> ! {
+    loop {}
+}
@@ -0,0 +1,22 @@
+pub fn connect(address: &str) {
+    log::info!("connecting to {address}");
+    #[cfg(feature = "tls")]
+    let _config = rustls::ClientConfig::builder();
+}
+
+#[cfg(feature = "serde")]
+pub fn describe(value: &impl serde::Serialize) {}
+
+#[cfg(feature = "hyper")]
+pub type Body = hyper::body::Incoming;
+
+#[cfg(feature = "async")]
+pub async fn pause() {
+    tokio::task::yield_now().await;
+}
+
+#[cfg(feature = "compression")]
+pub fn compress(data: &[u8]) -> Vec<u8> {
+    let encoder = flate2::write::GzEncoder::new(Vec::new(), flate2::Compression::default());
+    data.to_vec()
+}
@@ -0,0 +1,4 @@
+#[test]
+fn second() {
+    assert!(true);
+}
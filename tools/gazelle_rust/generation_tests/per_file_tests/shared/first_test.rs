@@ -0,0 +1,4 @@
+#[test]
+fn first() {
+    assert!(true);
+}
@@ -0,0 +1,13 @@
+use anyhow::Result;
+use serde::Serialize;
+
+#[derive(Serialize)]
+pub struct Config {
+    pub name: String,
+}
+
+pub fn load() -> Result<Config> {
+    Ok(Config {
+        name: String::new(),
+    })
+}
@@ -0,0 +1,6 @@
+use app_macros::Describe;
+
+#[derive(Describe, serde_derive::Serialize)]
+pub struct Config {
+    pub name: String,
+}
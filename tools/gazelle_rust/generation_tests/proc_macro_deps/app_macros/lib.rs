@@ -0,0 +1,4 @@
+#[proc_macro_derive(Describe)]
+pub fn describe(item: proc_macro::TokenStream) -> proc_macro::TokenStream {
+    item
+}
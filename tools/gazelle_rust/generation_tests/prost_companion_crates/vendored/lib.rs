@@ -0,0 +1,7 @@
+use pbjson_types::Struct;
+use prost_types::Timestamp;
+use tonic::{Request, Response, Status};
+
+pub async fn now(_request: Request<Struct>) -> Result<Response<Timestamp>, Status> {
+    Ok(Response::new(Timestamp::default()))
+}
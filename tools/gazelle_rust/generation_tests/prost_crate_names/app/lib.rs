@@ -0,0 +1,7 @@
+use invoice_rs::Invoice;
+use ledger_entries::Entry;
+use protos_users::User;
+
+pub fn bill(user: &User, entries: &[Entry]) -> Invoice {
+    Invoice::for_user(user, entries)
+}
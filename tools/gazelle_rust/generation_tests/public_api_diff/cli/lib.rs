@@ -0,0 +1,7 @@
+pub fn run(arguments: &[String]) -> i32 {
+    arguments.len() as i32
+}
+
+pub fn version() -> &'static str {
+    "1.0"
+}
@@ -0,0 +1,17 @@
+pub struct Config {
+    pub name: String,
+    pub retries: u32,
+}
+
+impl Config {
+    pub fn new(name: &str, retries: u32) -> Self {
+        Config {
+            name: name.to_string(),
+            retries,
+        }
+    }
+
+    pub fn with_retries(self, retries: u32) -> Self {
+        Config { retries, ..self }
+    }
+}
@@ -0,0 +1,3 @@
+pub const RETRIES: u32 = 3;
+
+pub struct Defaults;
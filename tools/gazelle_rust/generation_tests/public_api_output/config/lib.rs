@@ -0,0 +1,22 @@
+mod internal;
+pub mod net;
+
+pub use internal::Defaults;
+
+pub struct Config {
+    pub name: String,
+    retries: u32,
+}
+
+impl Config {
+    pub fn new(name: &str) -> Self {
+        Config {
+            name: name.to_string(),
+            retries: internal::RETRIES,
+        }
+    }
+
+    fn validate(&self) -> bool {
+        !self.name.is_empty()
+    }
+}
@@ -0,0 +1,8 @@
+pub mod tls;
+
+pub async fn connect(
+    address: &str,
+    timeout_seconds: u64,
+) -> std::io::Result<()> {
+    Ok(())
+}
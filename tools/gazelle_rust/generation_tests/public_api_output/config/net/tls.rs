@@ -0,0 +1,4 @@
+pub enum Version {
+    Tls12,
+    Tls13,
+}
@@ -0,0 +1,2 @@
+#[test]
+fn lexes() {}
@@ -0,0 +1,3 @@
+pub fn render(count: u64, ratio: f64) -> String {
+    format!("{} {}", itoa::Buffer::new().format(count), ryu::format(ratio))
+}
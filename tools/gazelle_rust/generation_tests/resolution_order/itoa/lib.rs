@@ -0,0 +1,3 @@
+pub fn format(value: u64) -> String {
+    value.to_string()
+}
@@ -0,0 +1,3 @@
+pub fn render(ratio: f64) -> String {
+    ryu::Buffer::new().format(ratio).to_string()
+}
@@ -0,0 +1,3 @@
+pub fn format(value: f64) -> String {
+    value.to_string()
+}
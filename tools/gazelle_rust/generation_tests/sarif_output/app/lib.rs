@@ -0,0 +1,10 @@
+use serde::Serialize;
+
+#[derive(Serialize)]
+pub struct Settings {
+    pub name: String,
+}
+
+pub fn load() -> Settings {
+    mystery::read_settings()
+}
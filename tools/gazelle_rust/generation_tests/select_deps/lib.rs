@@ -0,0 +1,9 @@
+use serde::Serialize;
+
+#[cfg(target_os = "linux")]
+use nix::sys::signal;
+
+#[derive(Serialize)]
+pub struct Config {
+    pub name: String,
+}
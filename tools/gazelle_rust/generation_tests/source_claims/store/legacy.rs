@@ -0,0 +1,3 @@
+mod util;
+
+pub fn open(key: util::Key) {}
@@ -0,0 +1,3 @@
+mod util;
+
+pub use util::Key;
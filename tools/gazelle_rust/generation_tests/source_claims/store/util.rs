@@ -0,0 +1 @@
+pub struct Key(pub u64);
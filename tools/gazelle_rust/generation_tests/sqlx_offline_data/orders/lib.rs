@@ -0,0 +1,7 @@
+pub async fn count(pool: &sqlx::PgPool) -> i64 {
+    sqlx::query_scalar!("select count(*) from orders")
+        .fetch_one(pool)
+        .await
+        .unwrap()
+        .unwrap_or(0)
+}
@@ -0,0 +1,10 @@
+pub struct User {
+    pub id: i64,
+}
+
+pub async fn load(pool: &sqlx::PgPool) -> Vec<User> {
+    sqlx::query_as!(User, "select id from users")
+        .fetch_all(pool)
+        .await
+        .unwrap()
+}
@@ -0,0 +1,3 @@
+mod circle;
+
+pub fn area() {}
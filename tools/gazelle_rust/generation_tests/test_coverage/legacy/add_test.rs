@@ -0,0 +1,4 @@
+#[test]
+fn adds() {
+    assert_eq!(legacy::add(2, 2), 4);
+}
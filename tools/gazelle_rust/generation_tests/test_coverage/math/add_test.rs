@@ -0,0 +1,4 @@
+#[test]
+fn adds() {
+    assert_eq!(math::add(2, 2), 4);
+}
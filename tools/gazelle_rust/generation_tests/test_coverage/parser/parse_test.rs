@@ -0,0 +1,4 @@
+#[test]
+fn splits() {
+    assert_eq!(parser::parse("a,b"), vec!["a", "b"]);
+}
@@ -0,0 +1,3 @@
+pub fn parse(input: &str) -> Vec<&str> {
+    input.split(',').collect()
+}
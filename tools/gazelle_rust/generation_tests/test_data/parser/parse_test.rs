@@ -0,0 +1,5 @@
+#[test]
+fn parses_input() {
+    let input = std::fs::read_to_string("testdata/input.json").unwrap();
+    assert!(!input.is_empty());
+}
@@ -0,0 +1,5 @@
+#[test]
+fn counts_tokens() {
+    let input = std::fs::read_to_string("data/words.txt").unwrap();
+    assert_eq!(input.split_whitespace().count(), 3);
+}
@@ -0,0 +1,3 @@
+pub fn tokens(input: &str) -> usize {
+    input.split_whitespace().count()
+}
@@ -0,0 +1,3 @@
+pub fn render(text: &str) -> String {
+    format!("<p>{text}</p>")
+}
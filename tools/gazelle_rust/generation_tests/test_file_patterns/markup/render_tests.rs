@@ -0,0 +1,4 @@
+#[test]
+fn renders() {
+    assert_eq!(markup::render("a"), "<p>a</p>");
+}
@@ -0,0 +1,4 @@
+#[test]
+fn escapes() {
+    assert!(markup::render("<").contains("<"));
+}
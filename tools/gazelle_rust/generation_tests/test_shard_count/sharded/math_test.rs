@@ -0,0 +1,14 @@
+#[test]
+fn one() {}
+
+#[test]
+fn two() {}
+
+#[test]
+fn three() {}
+
+#[test]
+fn four() {}
+
+#[test]
+fn five() {}
@@ -0,0 +1,5 @@
+#[test]
+fn first() {}
+
+#[test]
+fn second() {}
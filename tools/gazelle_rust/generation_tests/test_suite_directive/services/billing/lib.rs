@@ -0,0 +1 @@
+pub fn total() -> u64 { 0 }
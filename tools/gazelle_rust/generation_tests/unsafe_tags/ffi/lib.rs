@@ -0,0 +1,5 @@
+mod raw;
+
+pub fn length(bytes: &[u8]) -> usize {
+    raw::length(bytes)
+}
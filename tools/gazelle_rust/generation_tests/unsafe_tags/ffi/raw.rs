@@ -0,0 +1,7 @@
+pub fn length(bytes: &[u8]) -> usize {
+    unsafe { strlen(bytes.as_ptr()) }
+}
+
+extern "C" {
+    fn strlen(pointer: *const u8) -> usize;
+}
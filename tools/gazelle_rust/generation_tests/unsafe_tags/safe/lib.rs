@@ -0,0 +1,5 @@
+#![forbid(unsafe_code)]
+
+pub fn double(value: u32) -> u32 {
+    value * 2
+}
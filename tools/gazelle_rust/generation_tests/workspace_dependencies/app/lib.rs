@@ -0,0 +1,10 @@
+use serde::Serialize;
+
+#[derive(Serialize)]
+pub struct Config {
+    pub name: String,
+}
+
+pub fn render(config: &Config) -> String {
+    json::to_string(config).unwrap()
+}
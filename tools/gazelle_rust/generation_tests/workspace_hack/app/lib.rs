@@ -0,0 +1,3 @@
+pub fn greeting() -> String {
+    serde_json::to_string("hello").unwrap()
+}
@@ -0,0 +1 @@
+// This is a stub lib.rs.
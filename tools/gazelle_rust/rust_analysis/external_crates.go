@@ -0,0 +1,229 @@
+package rust_analysis
+
+// Metadata about external crates, parsed from Cargo.lock.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Crates locked in Cargo.lock, for resolving imports to the crate universe.
+type ExternalCrates struct {
+	cratesByImport map[string][]ExternalCrate
+	// Packages imported by another name, such as `foo = { package = "bar" }`,
+	// keyed by the normalized import.
+	packageByImport map[string]string
+}
+
+// A package in Cargo.lock.
+type ExternalCrate struct {
+	Name    string
+	Version string
+	// Where the package comes from, such as
+	// "registry+https://github.com/rust-lang/crates.io-index". Empty for
+	// workspace members and path dependencies.
+	Source string
+	// The packages it depends on, as "name" or, when several versions are
+	// locked, "name version".
+	Dependencies []string
+}
+
+const CratesIORegistrySource = "registry+https://github.com/rust-lang/crates.io-index"
+
+// Read the packages in a Cargo.lock. A missing or unreadable lockfile yields
+// no packages.
+func NewExternalCrates(lockfilePath string) *ExternalCrates {
+	externalCrates := &ExternalCrates{
+		cratesByImport:  make(map[string][]ExternalCrate),
+		packageByImport: make(map[string]string),
+	}
+	externalCrates.parseLockfile(lockfilePath)
+	return externalCrates
+}
+
+// Record that a package is imported by another name, as declared with
+// `importName = { package = "packageName" }` in Cargo.toml.
+func (externalCrates *ExternalCrates) AddRename(importName, packageName string) {
+	if NormalizeCrateName(importName) != NormalizeCrateName(packageName) {
+		externalCrates.packageByImport[NormalizeCrateName(importName)] = packageName
+	}
+}
+
+func (externalCrates *ExternalCrates) GetName(importName string) string {
+	if crate, ok := externalCrates.Get(importName); ok {
+		return crate.Name
+	}
+
+	return importName
+}
+
+// Report whether Cargo.lock contains a package for the import.
+func (externalCrates *ExternalCrates) Contains(importName string) bool {
+	_, ok := externalCrates.Get(importName)
+	return ok
+}
+
+// Return the package an import refers to. When several versions are locked,
+// this is the newest, which Cargo.lock lists last.
+func (externalCrates *ExternalCrates) Get(importName string) (ExternalCrate, bool) {
+	normalizedImport := NormalizeCrateName(importName)
+	if packageName, ok := externalCrates.packageByImport[normalizedImport]; ok {
+		normalizedImport = NormalizeCrateName(packageName)
+	}
+	crates := externalCrates.cratesByImport[normalizedImport]
+	if len(crates) == 0 {
+		return ExternalCrate{}, false
+	}
+	return crates[len(crates)-1], true
+}
+
+// Return the locked version of the package an import refers to, or "" if
+// Cargo.lock has none.
+func (externalCrates *ExternalCrates) GetVersion(importName string) string {
+	crate, _ := externalCrates.Get(importName)
+	return crate.Version
+}
+
+// Return the source of the package an import refers to, or "" if Cargo.lock
+// has none or it is local.
+func (externalCrates *ExternalCrates) GetSource(importName string) string {
+	crate, _ := externalCrates.Get(importName)
+	return crate.Source
+}
+
+// Return the index URL of the registry the package comes from, such as
+// "https://github.com/rust-lang/crates.io-index", or "" for packages from git
+// or the workspace.
+func (crate ExternalCrate) Registry() string {
+	kind, url, _ := strings.Cut(crate.Source, "+")
+	if kind != "registry" && kind != "sparse" {
+		return ""
+	}
+	return url
+}
+
+// Describe the package for diagnostics, such as "serde 1.0.210 from
+// crates.io".
+func (crate ExternalCrate) String() string {
+	return fmt.Sprintf("%s %s from %s", crate.Name, crate.Version, describeSource(crate.Source))
+}
+
+// Describe the package an import refers to for diagnostics, or return "" if
+// Cargo.lock has none.
+func (externalCrates *ExternalCrates) Describe(importName string) string {
+	version := externalCrates.GetVersion(importName)
+	if version == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s %s from %s", externalCrates.GetName(importName), version, describeSource(externalCrates.GetSource(importName)))
+}
+
+func describeSource(source string) string {
+	switch source {
+	case "":
+		return "the workspace"
+	case CratesIORegistrySource:
+		return "crates.io"
+	default:
+		// Sources are "<kind>+<url>", and git sources end in "#<commit>".
+		kind, url, _ := strings.Cut(source, "+")
+		url, _, _ = strings.Cut(url, "#")
+		return kind + " " + url
+	}
+}
+
+var (
+	lockfileFieldRegex = regexp.MustCompile(`^(name|version|source)\s*=\s*"([^"]+)"`)
+	// An entry of a package's dependencies, without the source that follows
+	// the version when several sources lock the same version.
+	lockfileDependencyRegex = regexp.MustCompile(`"([^" ]+(?: [^" ]+)?)(?: \([^"]*\))?"`)
+)
+
+// Read Cargo.lock and extract packages.
+func (externalCrates *ExternalCrates) parseLockfile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	inPackage := false
+	inDependencies := false
+	var currentPackage ExternalCrate
+	addPackage := func() {
+		if currentPackage.Name != "" {
+			normalized := strings.ReplaceAll(currentPackage.Name, "-", "_")
+			externalCrates.cratesByImport[normalized] = append(externalCrates.cratesByImport[normalized], currentPackage)
+		}
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "[[package]]" {
+			addPackage()
+			inPackage = true
+			inDependencies = false
+			currentPackage = ExternalCrate{}
+			continue
+		}
+
+		if inPackage && (inDependencies || strings.HasPrefix(trimmed, "dependencies")) {
+			for _, matches := range lockfileDependencyRegex.FindAllStringSubmatch(trimmed, -1) {
+				currentPackage.Dependencies = append(currentPackage.Dependencies, matches[1])
+			}
+			inDependencies = !strings.HasSuffix(trimmed, "]")
+			continue
+		}
+
+		if inPackage {
+			matches := lockfileFieldRegex.FindStringSubmatch(trimmed)
+			if len(matches) < 3 {
+				continue
+			}
+			switch matches[1] {
+			case "name":
+				currentPackage.Name = matches[2]
+			case "version":
+				currentPackage.Version = matches[2]
+			case "source":
+				currentPackage.Source = matches[2]
+			}
+		}
+	}
+	addPackage()
+
+	return scanner.Err()
+}
+
+// Return every package in Cargo.lock.
+func (externalCrates *ExternalCrates) Packages() []ExternalCrate {
+	var crates []ExternalCrate
+	for _, versions := range externalCrates.cratesByImport {
+		crates = append(crates, versions...)
+	}
+	return crates
+}
+
+// Return the locked package a Cargo.lock dependency entry refers to.
+func (externalCrates *ExternalCrates) LockedDependency(entry string) (ExternalCrate, bool) {
+	name, version, hasVersion := strings.Cut(entry, " ")
+	crates := externalCrates.cratesByImport[NormalizeCrateName(name)]
+	for _, crate := range crates {
+		if crate.Name == name && (!hasVersion || crate.Version == version) {
+			return crate, true
+		}
+	}
+	return ExternalCrate{}, false
+}
+
+// Return the name crates are imported by, with dashes in package names
+// replaced by underscores.
+func NormalizeCrateName(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
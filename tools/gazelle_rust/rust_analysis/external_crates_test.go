@@ -0,0 +1,144 @@
+package rust_analysis
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testLockfile = `version = 4
+
+[[package]]
+name = "app"
+version = "0.1.0"
+dependencies = [
+ "rand 0.7.3",
+ "rand 0.8.5",
+ "serde",
+ "tokio-util 0.7.1 (git+https://github.com/tokio-rs/tokio?rev=abc#0123456789abcdef)",
+]
+
+[[package]]
+name = "rand"
+version = "0.7.3"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+
+[[package]]
+name = "rand"
+version = "0.8.5"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+
+[[package]]
+name = "serde"
+version = "1.0.210"
+source = "sparse+https://index.example.com/"
+
+[[package]]
+name = "tokio-util"
+version = "0.7.1"
+source = "git+https://github.com/tokio-rs/tokio?rev=abc#0123456789abcdef"
+dependencies = ["serde"]
+`
+
+func TestExternalCrates(t *testing.T) {
+	lockfilePath := filepath.Join(t.TempDir(), "Cargo.lock")
+	if err := os.WriteFile(lockfilePath, []byte(testLockfile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	externalCrates := NewExternalCrates(lockfilePath)
+	externalCrates.AddRename("serialization", "serde")
+
+	tests := []struct {
+		importName   string
+		description  string
+		registry     string
+		dependencies []string
+	}{
+		{
+			importName:   "app",
+			description:  "app 0.1.0 from the workspace",
+			dependencies: []string{"rand 0.7.3", "rand 0.8.5", "serde", "tokio-util 0.7.1"},
+		},
+		// The newest of several locked versions.
+		{
+			importName:  "rand",
+			description: "rand 0.8.5 from crates.io",
+			registry:    "https://github.com/rust-lang/crates.io-index",
+		},
+		{
+			importName:  "serialization",
+			description: "serde 1.0.210 from sparse https://index.example.com/",
+			registry:    "https://index.example.com/",
+		},
+		{
+			importName:   "tokio_util",
+			description:  "tokio-util 0.7.1 from git https://github.com/tokio-rs/tokio?rev=abc",
+			dependencies: []string{"serde"},
+		},
+	}
+	for _, test := range tests {
+		crate, ok := externalCrates.Get(test.importName)
+		if !ok {
+			t.Errorf("%s isn't locked", test.importName)
+			continue
+		}
+		if description := crate.String(); description != test.description {
+			t.Errorf("%s: String() = %q, want %q", test.importName, description, test.description)
+		}
+		if registry := crate.Registry(); registry != test.registry {
+			t.Errorf("%s: Registry() = %q, want %q", test.importName, registry, test.registry)
+		}
+		if !slices.Equal(crate.Dependencies, test.dependencies) {
+			t.Errorf("%s: Dependencies = %q, want %q", test.importName, crate.Dependencies, test.dependencies)
+		}
+	}
+
+	for entry, wantVersion := range map[string]string{"rand 0.7.3": "0.7.3", "rand 0.8.5": "0.8.5", "serde": "1.0.210", "rand 0.9.0": ""} {
+		crate, ok := externalCrates.LockedDependency(entry)
+		if ok != (wantVersion != "") || crate.Version != wantVersion {
+			t.Errorf("LockedDependency(%q) = %q, %t, want version %q", entry, crate.Version, ok, wantVersion)
+		}
+	}
+
+	lockedTests := []struct {
+		importName  string
+		version     string
+		source      string
+		description string
+	}{
+		{importName: "app", version: "0.1.0", description: "app 0.1.0 from the workspace"},
+		{
+			importName:  "rand",
+			version:     "0.8.5",
+			source:      CratesIORegistrySource,
+			description: "rand 0.8.5 from crates.io",
+		},
+		{
+			importName:  "serialization",
+			version:     "1.0.210",
+			source:      "sparse+https://index.example.com/",
+			description: "serde 1.0.210 from sparse https://index.example.com/",
+		},
+		// Not in Cargo.lock.
+		{importName: "tokio"},
+	}
+	for _, test := range lockedTests {
+		if version := externalCrates.GetVersion(test.importName); version != test.version {
+			t.Errorf("GetVersion(%s) = %q, want %q", test.importName, version, test.version)
+		}
+		if source := externalCrates.GetSource(test.importName); source != test.source {
+			t.Errorf("GetSource(%s) = %q, want %q", test.importName, source, test.source)
+		}
+		if description := externalCrates.Describe(test.importName); description != test.description {
+			t.Errorf("Describe(%s) = %q, want %q", test.importName, description, test.description)
+		}
+	}
+
+	if externalCrates.Contains("tokio") {
+		t.Errorf("Contains(tokio) = true for a crate that isn't locked")
+	}
+	if packages := NewExternalCrates(filepath.Join(t.TempDir(), "Cargo.lock")).Packages(); len(packages) != 0 {
+		t.Errorf("a missing lockfile has packages %v", packages)
+	}
+}
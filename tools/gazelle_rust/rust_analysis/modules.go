@@ -0,0 +1,110 @@
+package rust_analysis
+
+// Discovery of a crate's source files by following its `mod` declarations.
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	messages "coppice/tools/gazelle_rust/proto"
+)
+
+// The files of a crate, relative to the directory its root file is in.
+type SourceTree interface {
+	// Parse a file. Files that exist but can't be parsed, such as generated
+	// ones, return an error. Called concurrently for the files of a crate.
+	Parse(file string) (*messages.ParseResponse, error)
+	// Report whether a file exists.
+	Exists(file string) bool
+}
+
+// A SourceTree of files on disk.
+type DirectorySourceTree struct {
+	Parser *Parser
+	Dir    string
+}
+
+func (tree DirectorySourceTree) Parse(file string) (*messages.ParseResponse, error) {
+	return tree.Parser.Parse(filepath.Join(tree.Dir, file))
+}
+
+func (tree DirectorySourceTree) Exists(file string) bool {
+	info, err := os.Stat(filepath.Join(tree.Dir, file))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// Returned by SourceTree.Parse for files that exist but aren't checked in.
+var ErrGeneratedFile = errors.New("generated file")
+
+// Bounds how many of a crate's files are parsed at once.
+const moduleDiscoveryConcurrency = 16
+
+// Return the sorted source files of the crate with rootFile, following `mod`
+// declarations to {mod}.rs or {mod}/mod.rs beside the declaring file.
+func DiscoverModules(tree SourceTree, rootFile string) []string {
+	srcs := []string{rootFile}
+	visited := make(map[string]bool)
+	visited[rootFile] = true
+
+	// Each round parses the files the previous round found concurrently, then
+	// follows their declarations in order, so which files are found doesn't
+	// depend on which parse finishes first.
+	for files := []string{rootFile}; len(files) > 0; {
+		responses := parseConcurrently(tree, files)
+		var found []string
+		for i, file := range files {
+			if responses[i] == nil {
+				continue
+			}
+
+			fileDir := filepath.Dir(file)
+			if fileDir == "." {
+				fileDir = ""
+			}
+
+			for _, modName := range responses[i].ExternalModules {
+				// Try adjacent file: {mod}.rs
+				adjacentFile := filepath.Join(fileDir, modName+".rs")
+				if !visited[adjacentFile] && tree.Exists(adjacentFile) {
+					visited[adjacentFile] = true
+					found = append(found, adjacentFile)
+					continue
+				}
+
+				// Try subdir with mod.rs: {mod}/mod.rs
+				modFile := filepath.Join(fileDir, modName, "mod.rs")
+				if !visited[modFile] && tree.Exists(modFile) {
+					visited[modFile] = true
+					found = append(found, modFile)
+				}
+			}
+		}
+		srcs = append(srcs, found...)
+		files = found
+	}
+
+	sort.Strings(srcs)
+	return srcs
+}
+
+// Parse files, at most moduleDiscoveryConcurrency at once, returning nil
+// responses for those that fail.
+func parseConcurrently(tree SourceTree, files []string) []*messages.ParseResponse {
+	responses := make([]*messages.ParseResponse, len(files))
+	semaphore := make(chan struct{}, moduleDiscoveryConcurrency)
+	var group sync.WaitGroup
+	for i, file := range files {
+		semaphore <- struct{}{}
+		group.Go(func() {
+			defer func() { <-semaphore }()
+			if response, err := tree.Parse(file); err == nil {
+				responses[i] = response
+			}
+		})
+	}
+	group.Wait()
+	return responses
+}
@@ -0,0 +1,84 @@
+package rust_analysis
+
+// On-disk cache of parse responses keyed by file contents.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/protobuf/proto"
+
+	messages "coppice/tools/gazelle_rust/proto"
+)
+
+type parseCache struct {
+	dir string
+	// Digest of the parser binary, so entries from other parser versions are
+	// never reused.
+	parserDigest []byte
+}
+
+func newParseCache(dir, parserPath string) (*parseCache, error) {
+	parserDigest, err := FileDigest(parserPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parseCache{
+		dir:          dir,
+		parserDigest: parserDigest,
+	}, nil
+}
+
+// Return the SHA-256 digest of a file's contents.
+func FileDigest(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(data)
+	return digest[:], nil
+}
+
+func (cache *parseCache) key(contents []byte) string {
+	hash := sha256.New()
+	hash.Write(cache.parserDigest)
+	hash.Write(contents)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (cache *parseCache) load(key string) (*messages.ParseResponse, bool) {
+	data, err := os.ReadFile(filepath.Join(cache.dir, key))
+	if err != nil {
+		return nil, false
+	}
+	response := &messages.ParseResponse{}
+	if err := proto.Unmarshal(data, response); err != nil {
+		return nil, false
+	}
+	return response, true
+}
+
+// Write an entry atomically so concurrent gazelle runs sharing the cache never
+// observe partial files. Failures only cost a future cache miss.
+func (cache *parseCache) store(key string, response *messages.ParseResponse) {
+	data, err := proto.Marshal(response)
+	if err != nil {
+		return
+	}
+	tempFile, err := os.CreateTemp(cache.dir, key+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, writeErr := tempFile.Write(data)
+	closeErr := tempFile.Close()
+	if writeErr != nil || closeErr != nil {
+		os.Remove(tempFile.Name())
+		return
+	}
+	if err := os.Rename(tempFile.Name(), filepath.Join(cache.dir, key)); err != nil {
+		os.Remove(tempFile.Name())
+	}
+}
@@ -0,0 +1,105 @@
+package rust_analysis
+
+// A parse daemon shared by consecutive gazelle runs. The first run starts it
+// in the background; later runs connect to it and reuse its warm process and
+// in-memory parse results. Only the parser is shared: each run still
+// configures the rust language from scratch. This is not a Bazel persistent
+// worker, and the daemon outlives the run that started it until no run has
+// used it for parseDaemonIdleTimeout.
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	parseDaemonIdleTimeout  = 10 * time.Minute
+	parseDaemonStartTimeout = 10 * time.Second
+)
+
+// Return the socket of the parse daemon for the parser binary, starting the
+// daemon if none is running.
+func ensureParseDaemon(parserPath string) string {
+	parserDigest, err := FileDigest(parserPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	socketDirectory, err := parseDaemonDirectory()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Keyed by the parser binary, so a rebuilt parser gets its own daemon.
+	socketPath := filepath.Join(socketDirectory, "parser_"+hex.EncodeToString(parserDigest[:8])+".sock")
+
+	if canDialUnixSocket(socketPath) {
+		return socketPath
+	}
+
+	cmd := exec.Command(
+		parserPath,
+		"listen",
+		"unix:"+socketPath,
+		"--idle-timeout-secs", fmt.Sprint(int(parseDaemonIdleTimeout.Seconds())),
+	)
+	// Detach from the terminal's process group so interrupting gazelle doesn't
+	// stop the daemon.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		log.Fatalf("start rust parse daemon: %v", err)
+	}
+	if err := cmd.Process.Release(); err != nil {
+		log.Fatal(err)
+	}
+
+	deadline := time.Now().Add(parseDaemonStartTimeout)
+	for !canDialUnixSocket(socketPath) {
+		if time.Now().After(deadline) {
+			log.Fatalf("rust parse daemon did not start listening on %s within %s", socketPath, parseDaemonStartTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return socketPath
+}
+
+// Return a directory only the current user can access for the daemon's
+// socket, so other users can't connect to it or take its place, creating it if
+// needed. It's under $XDG_RUNTIME_DIR when set, and the user cache directory
+// otherwise.
+func parseDaemonDirectory() (string, error) {
+	parent := os.Getenv("XDG_RUNTIME_DIR")
+	if parent == "" {
+		var err error
+		if parent, err = os.UserCacheDir(); err != nil {
+			return "", fmt.Errorf("find a directory for the rust parse daemon: %w", err)
+		}
+	}
+	directory := filepath.Join(parent, "gazelle_rust")
+	if err := os.MkdirAll(directory, 0o700); err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(directory)
+	if err != nil {
+		return "", err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || info.Mode().Perm()&0o077 != 0 || !ok || int(stat.Uid) != os.Getuid() {
+		return "", fmt.Errorf("%s must be a directory only the current user can access", directory)
+	}
+	return directory, nil
+}
+
+func canDialUnixSocket(path string) bool {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
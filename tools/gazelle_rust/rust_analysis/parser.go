@@ -0,0 +1,415 @@
+// Analysis of Rust sources shared by gazelle_rust and other tools: parsing
+// files with the Rust parser, discovering a crate's modules, and reading the
+// crates locked in Cargo.lock. Nothing here depends on gazelle.
+package rust_analysis
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/bazelbuild/rules_go/go/runfiles"
+	"google.golang.org/protobuf/proto"
+
+	messages "coppice/tools/gazelle_rust/proto"
+)
+
+// Bump together with PROTOCOL_VERSION in rust_parser/main.rs whenever the
+// framing or message semantics change incompatibly.
+const parserProtocolVersion = 1
+
+// Optional protocol features requested from the parser during the handshake.
+var requestedParserCapabilities = []string{fileContentsCapability, pipeliningCapability}
+
+// The parser accepts source contents in ParseRequest instead of reading files.
+const fileContentsCapability = "file_contents"
+
+// The parser accepts further requests before answering earlier ones, and
+// answers each as soon as it's parsed, tagged with the request's ID.
+const pipeliningCapability = "pipelining"
+
+// Parser manages IPC with a pool of Rust parser connections.
+type Parser struct {
+	connections chan *parserConnection
+	cache       *parseCache
+	// Requested capabilities the parser agreed to during the handshake.
+	capabilities map[string]bool
+}
+
+type ParserOptions struct {
+	// Number of concurrent parser connections.
+	WorkerCount int
+	// Directory for caching parse results. Disabled when empty.
+	CacheDir string
+	// Address (host:port) of a running `rust_parser listen` service. When
+	// empty, parser subprocesses are started instead.
+	Address string
+	// Connect to a background parse daemon shared across runs, starting it if
+	// needed, instead of starting subprocesses for this run only.
+	Daemon bool
+	// The rust_parser binary. When empty, it's found in the runfiles of the
+	// calling binary, which must depend on this package.
+	ParserPath string
+}
+
+// A connection to one parser, either a subprocess over stdin/stdout or a
+// parse service over a socket.
+type parserConnection struct {
+	// Nil for remote connections.
+	cmd    *exec.Cmd
+	writer io.WriteCloser
+	reader io.Reader
+	// Reused across messages, so large requests and responses don't each
+	// allocate their own buffer.
+	writeBuffer []byte
+	readBuffer  []byte
+
+	// Set when the parser agreed to pipelining. Responses are then read by
+	// dispatchResponses rather than by the request awaiting them.
+	pipelined     bool
+	nextRequestID uint64
+	pendingMutex  sync.Mutex
+	// Channels of the requests awaiting a response.
+	pendingByRequestID map[uint64]chan parseResult
+	// Why dispatchResponses stopped, failing requests sent after it.
+	dispatchErr  error
+	dispatchDone chan struct{}
+}
+
+type parseResult struct {
+	response *messages.ParseResponse
+	err      error
+}
+
+func NewParser(options ParserOptions) *Parser {
+	parser := &Parser{
+		connections: make(chan *parserConnection, options.WorkerCount),
+	}
+
+	parserPath, socketPath := options.ParserPath, ""
+	if options.Address == "" && parserPath == "" {
+		r, err := runfiles.New()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		parserPath, err = r.Rlocation("coppice/tools/gazelle_rust/rust_parser/main")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if options.Daemon {
+		socketPath = ensureParseDaemon(parserPath)
+	}
+
+	for range options.WorkerCount {
+		var connection *parserConnection
+		switch {
+		case options.Address != "":
+			connection = dialParserService("tcp", options.Address)
+		case options.Daemon:
+			connection = dialParserService("unix", socketPath)
+		default:
+			connection = startParserProcess(parserPath)
+		}
+		parser.capabilities = connection.handshake()
+		if parser.hasCapability(pipeliningCapability) {
+			connection.startDispatching()
+		}
+		parser.connections <- connection
+	}
+
+	if options.CacheDir != "" {
+		cache, err := newParseCache(options.CacheDir, parserPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		parser.cache = cache
+	}
+
+	return parser
+}
+
+func startParserProcess(path string) *parserConnection {
+	cmd := exec.Command(path, "serve")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	return &parserConnection{
+		cmd:    cmd,
+		writer: stdin,
+		reader: stdout,
+	}
+}
+
+func dialParserService(network, address string) *parserConnection {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		log.Fatalf("connect to rust parser service: %v", err)
+	}
+	return &parserConnection{
+		writer: conn,
+		reader: conn,
+	}
+}
+
+// Exchange protocol versions and return the capabilities the parser agreed to.
+func (connection *parserConnection) handshake() map[string]bool {
+	if err := connection.writeMessage(&messages.HandshakeRequest{
+		ProtocolVersion: parserProtocolVersion,
+		Capabilities:    requestedParserCapabilities,
+	}); err != nil {
+		log.Fatalf("rust parser handshake: %v", err)
+	}
+	response := &messages.HandshakeResponse{}
+	if err := connection.readMessage(response); err != nil {
+		log.Fatalf("rust parser handshake: %v", err)
+	}
+	if response.ProtocolVersion != parserProtocolVersion {
+		log.Fatalf("rust parser speaks protocol version %d, but gazelle expects version %d; rebuild both from the same revision", response.ProtocolVersion, parserProtocolVersion)
+	}
+
+	capabilities := make(map[string]bool)
+	for _, capability := range response.Capabilities {
+		capabilities[capability] = true
+	}
+	return capabilities
+}
+
+func (p *Parser) hasCapability(capability string) bool {
+	return p.capabilities[capability]
+}
+
+// Terminate the parser subprocesses and close service connections.
+func (p *Parser) Close() error {
+	close(p.connections)
+	var firstErr error
+	for connection := range p.connections {
+		connection.writer.Close()
+		if connection.pipelined {
+			// The parser exits after answering what's in flight, and
+			// reading from its stdout must finish before waiting for it.
+			<-connection.dispatchDone
+		}
+		if connection.cmd == nil {
+			continue
+		}
+		if err := connection.cmd.Wait(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (p *Parser) Parse(filePath string) (*messages.ParseResponse, error) {
+	if !p.hasCapability(fileContentsCapability) {
+		// The cache is keyed by contents, so it's skipped when only the path
+		// can be sent.
+		return p.parseRequest(&messages.ParseRequest{
+			FilePath: filePath,
+		}, "")
+	}
+
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", filePath, err)
+	}
+	return p.ParseContents(filePath, contents)
+}
+
+// Parse source that may not exist on disk, such as content from a cache or a
+// virtual file system. filePath is only used in diagnostics.
+func (p *Parser) ParseContents(filePath string, contents []byte) (*messages.ParseResponse, error) {
+	var cacheKey string
+	if p.cache != nil {
+		cacheKey = p.cache.key(contents)
+		if response, ok := p.cache.load(cacheKey); ok {
+			return checkResponse(response)
+		}
+	}
+
+	if !p.hasCapability(fileContentsCapability) {
+		return nil, fmt.Errorf("parse %s: parser does not accept file contents", filePath)
+	}
+	return p.parseRequest(&messages.ParseRequest{
+		FilePath: filePath,
+		Contents: contents,
+	}, cacheKey)
+}
+
+func (p *Parser) parseRequest(request *messages.ParseRequest, cacheKey string) (*messages.ParseResponse, error) {
+	connection := <-p.connections
+	var result parseResult
+	if connection.pipelined {
+		// The connection is only held while writing, so other requests are
+		// sent to it while this one is parsed.
+		pending := connection.send(request)
+		p.connections <- connection
+		result = <-pending
+	} else {
+		result.response, result.err = connection.exchange(request)
+		p.connections <- connection
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	response := result.response
+
+	if cacheKey != "" {
+		p.cache.store(cacheKey, response)
+	}
+
+	return checkResponse(response)
+}
+
+// The parser couldn't parse a file's contents as Rust.
+type ParseError struct {
+	Message string
+	// Where the syntax error is, if known.
+	Location *messages.SourceLocation
+}
+
+func (err *ParseError) Error() string {
+	return "parse error: " + err.Message
+}
+
+func checkResponse(response *messages.ParseResponse) (*messages.ParseResponse, error) {
+	if !response.Success {
+		return nil, &ParseError{Message: response.ErrorMsg, Location: response.ErrorLocation}
+	}
+	return response, nil
+}
+
+func (connection *parserConnection) exchange(request *messages.ParseRequest) (*messages.ParseResponse, error) {
+	if err := connection.writeMessage(request); err != nil {
+		return nil, err
+	}
+	response := &messages.ParseResponse{}
+	if err := connection.readMessage(response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (connection *parserConnection) startDispatching() {
+	connection.pipelined = true
+	connection.pendingByRequestID = make(map[uint64]chan parseResult)
+	connection.dispatchDone = make(chan struct{})
+	go connection.dispatchResponses()
+}
+
+// Write a request and return the channel its response will be delivered on.
+// Callers must hold the connection, as for exchange.
+func (connection *parserConnection) send(request *messages.ParseRequest) <-chan parseResult {
+	pending := make(chan parseResult, 1)
+	connection.nextRequestID++
+	request.RequestId = connection.nextRequestID
+
+	connection.pendingMutex.Lock()
+	if connection.dispatchErr != nil {
+		connection.pendingMutex.Unlock()
+		pending <- parseResult{err: connection.dispatchErr}
+		return pending
+	}
+	connection.pendingByRequestID[request.RequestId] = pending
+	connection.pendingMutex.Unlock()
+
+	if err := connection.writeMessage(request); err != nil {
+		if pending, ok := connection.takePending(request.RequestId); ok {
+			pending <- parseResult{err: err}
+		}
+	}
+	return pending
+}
+
+func (connection *parserConnection) takePending(requestID uint64) (chan parseResult, bool) {
+	connection.pendingMutex.Lock()
+	defer connection.pendingMutex.Unlock()
+	pending, ok := connection.pendingByRequestID[requestID]
+	delete(connection.pendingByRequestID, requestID)
+	return pending, ok
+}
+
+// Read responses until the connection closes, delivering each to the request
+// with its ID. Once reading fails, requests in flight and sent later fail too.
+func (connection *parserConnection) dispatchResponses() {
+	defer close(connection.dispatchDone)
+	var err error
+	for {
+		response := &messages.ParseResponse{}
+		if err = connection.readMessage(response); err != nil {
+			break
+		}
+		pending, ok := connection.takePending(response.RequestId)
+		if !ok {
+			err = fmt.Errorf("rust parser answered unknown request %d", response.RequestId)
+			break
+		}
+		pending <- parseResult{response: response}
+	}
+
+	connection.pendingMutex.Lock()
+	connection.dispatchErr = err
+	pendingByRequestID := connection.pendingByRequestID
+	connection.pendingByRequestID = nil
+	connection.pendingMutex.Unlock()
+	for _, pending := range pendingByRequestID {
+		pending <- parseResult{err: err}
+	}
+}
+
+// Length-prefixed protobuf protocol (little-endian u32 size + message bytes).
+func (connection *parserConnection) writeMessage(message proto.Message) error {
+	data, err := proto.MarshalOptions{}.MarshalAppend(connection.writeBuffer[:0], message)
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+	connection.writeBuffer = data
+
+	sizeBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sizeBytes, uint32(len(data)))
+	if _, err := connection.writer.Write(sizeBytes); err != nil {
+		return fmt.Errorf("write size: %w", err)
+	}
+	if _, err := connection.writer.Write(data); err != nil {
+		return fmt.Errorf("write message: %w", err)
+	}
+	return nil
+}
+
+func (connection *parserConnection) readMessage(message proto.Message) error {
+	sizeBytes := make([]byte, 4)
+	if _, err := io.ReadFull(connection.reader, sizeBytes); err != nil {
+		return fmt.Errorf("read response size: %w", err)
+	}
+	responseSize := binary.LittleEndian.Uint32(sizeBytes)
+
+	if uint32(cap(connection.readBuffer)) < responseSize {
+		connection.readBuffer = make([]byte, responseSize)
+	}
+	responseData := connection.readBuffer[:responseSize]
+	if _, err := io.ReadFull(connection.reader, responseData); err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+
+	if err := proto.Unmarshal(responseData, message); err != nil {
+		return fmt.Errorf("unmarshal response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package rust_analysis
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	messages "coppice/tools/gazelle_rust/proto"
+)
+
+// Start a parse service on a local port that agrees to the given capabilities
+// and answers each request with its contents as the only import, or with its
+// file path when it has none. With pipelining, it waits for batchSize requests
+// and answers them in reverse order.
+func startFakeParser(t *testing.T, capabilities []string, batchSize int) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeParser(conn, capabilities, batchSize)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func serveFakeParser(conn net.Conn, capabilities []string, batchSize int) {
+	defer conn.Close()
+	connection := &parserConnection{reader: conn, writer: conn}
+	handshake := &messages.HandshakeRequest{}
+	if err := connection.readMessage(handshake); err != nil {
+		return
+	}
+	var agreed []string
+	for _, capability := range handshake.Capabilities {
+		if slices.Contains(capabilities, capability) {
+			agreed = append(agreed, capability)
+		}
+	}
+	if err := connection.writeMessage(&messages.HandshakeResponse{
+		ProtocolVersion: parserProtocolVersion,
+		Capabilities:    agreed,
+	}); err != nil {
+		return
+	}
+	if !slices.Contains(agreed, pipeliningCapability) {
+		batchSize = 1
+	}
+
+	var batch []*messages.ParseRequest
+	for {
+		request := &messages.ParseRequest{}
+		if err := connection.readMessage(request); err != nil {
+			return
+		}
+		batch = append(batch, request)
+		if len(batch) < batchSize {
+			continue
+		}
+		for _, request := range slices.Backward(batch) {
+			response := &messages.ParseResponse{Success: true, RequestId: request.RequestId}
+			switch {
+			case request.Contents == nil:
+				response.Imports = []string{request.FilePath}
+			case string(request.Contents) == "invalid":
+				response.Success = false
+				response.ErrorMsg = "expected an item"
+			default:
+				response.Imports = []string{string(request.Contents)}
+			}
+			if err := connection.writeMessage(response); err != nil {
+				return
+			}
+		}
+		batch = nil
+	}
+}
+
+func TestParserPipelinedResponsesOutOfOrder(t *testing.T) {
+	address := startFakeParser(t, []string{fileContentsCapability, pipeliningCapability}, 4)
+	parser := NewParser(ParserOptions{WorkerCount: 1, Address: address})
+	defer parser.Close()
+	if !parser.hasCapability(pipeliningCapability) {
+		t.Fatal("parser didn't agree to pipelining")
+	}
+
+	// The fake parser answers once all four requests are in flight on the
+	// one connection, last first.
+	contents := []string{"alpha", "beta", "gamma", "delta"}
+	importsByContents := make(map[string][]string)
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+	for _, content := range contents {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			response, err := parser.ParseContents(content+".rs", []byte(content))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mutex.Lock()
+			importsByContents[content] = response.Imports
+			mutex.Unlock()
+		}()
+	}
+	wait.Wait()
+
+	for _, content := range contents {
+		if imports := importsByContents[content]; !slices.Equal(imports, []string{content}) {
+			t.Errorf("imports of %s = %q, want %q", content, imports, []string{content})
+		}
+	}
+}
+
+func TestParserCapabilities(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities []string
+		// Whether ParseContents is answered rather than refused.
+		acceptsContents bool
+	}{
+		{name: "no capabilities", capabilities: nil, acceptsContents: false},
+		{name: "file contents", capabilities: []string{fileContentsCapability}, acceptsContents: true},
+		{name: "pipelining", capabilities: []string{fileContentsCapability, pipeliningCapability}, acceptsContents: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parser := NewParser(ParserOptions{WorkerCount: 2, Address: startFakeParser(t, test.capabilities, 1)})
+			defer parser.Close()
+
+			response, err := parser.ParseContents("lib.rs", []byte("serde"))
+			switch {
+			case !test.acceptsContents && err == nil:
+				t.Errorf("ParseContents succeeded, but the parser doesn't accept file contents")
+			case test.acceptsContents && err != nil:
+				t.Error(err)
+			case test.acceptsContents && !slices.Equal(response.Imports, []string{"serde"}):
+				t.Errorf("imports = %q, want %q", response.Imports, []string{"serde"})
+			}
+
+			if !test.acceptsContents {
+				// Only the path is sent, for the parser to read.
+				response, err := parser.Parse("missing/lib.rs")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(response.Imports, []string{"missing/lib.rs"}) {
+					t.Errorf("imports = %q, want the file path", response.Imports)
+				}
+				return
+			}
+			_, err = parser.ParseContents("lib.rs", []byte("invalid"))
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) || parseErr.Message != "expected an item" {
+				t.Errorf("err = %v, want a ParseError", err)
+			}
+		})
+	}
+}
+
+func TestParserCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	// Only the parser binary's digest matters to the cache.
+	writeParser := func(contents string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "main")
+		if err := os.WriteFile(path, []byte(contents), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	parserPath := writeParser("version 1")
+
+	parser := NewParser(ParserOptions{
+		WorkerCount: 1,
+		Address:     startFakeParser(t, []string{fileContentsCapability}, 1),
+		CacheDir:    cacheDir,
+		ParserPath:  parserPath,
+	})
+	if _, err := parser.ParseContents("lib.rs", []byte("serde")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseContents("bad.rs", []byte("invalid")); err == nil {
+		t.Fatal("parsing invalid contents succeeded")
+	}
+	parser.Close()
+
+	// A parser that refuses file contents can only answer from the cache.
+	cachedParser := func(parserPath string) *Parser {
+		t.Helper()
+		return NewParser(ParserOptions{
+			WorkerCount: 1,
+			Address:     startFakeParser(t, nil, 1),
+			CacheDir:    cacheDir,
+			ParserPath:  parserPath,
+		})
+	}
+
+	parser = cachedParser(parserPath)
+	defer parser.Close()
+	response, err := parser.ParseContents("other.rs", []byte("serde"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(response.Imports, []string{"serde"}) {
+		t.Errorf("cached imports = %q, want %q", response.Imports, []string{"serde"})
+	}
+	var parseErr *ParseError
+	if _, err := parser.ParseContents("bad.rs", []byte("invalid")); !errors.As(err, &parseErr) || parseErr.Message != "expected an item" {
+		t.Errorf("cached failure = %v, want the parse error", err)
+	}
+	if _, err := parser.ParseContents("lib.rs", []byte("tokio")); err == nil {
+		t.Errorf("contents that were never parsed were answered from the cache")
+	}
+	// Files on disk are still parsed, by path and bypassing the cache.
+	sourcePath := filepath.Join(t.TempDir(), "lib.rs")
+	if err := os.WriteFile(sourcePath, []byte("serde"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if response, err := parser.Parse(sourcePath); err != nil {
+		t.Error(err)
+	} else if !slices.Equal(response.Imports, []string{sourcePath}) {
+		t.Errorf("imports = %q, want the file path", response.Imports)
+	}
+
+	upgradedParser := cachedParser(writeParser("version 2"))
+	defer upgradedParser.Close()
+	if _, err := upgradedParser.ParseContents("lib.rs", []byte("serde")); err == nil {
+		t.Errorf("an entry from another parser version was reused")
+	}
+}
@@ -0,0 +1,29 @@
+package rust_language
+
+// `# gazelle:rust_additional_library <name> <crate root>` declares another
+// library in the directory, such as a macros crate next to its runtime.
+// Each is generated and maintained like the package library, with its sources
+// discovered from its root. Applies only to the directory it is declared in,
+// and requires -rust_canonical_loads since the repository macros build one
+// library per package.
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+)
+
+func (l *rustLang) emitAdditionalLibraries(result *language.GenerateResult, rc *rustConfig, dir string, existingRuleNames, claimedFiles map[string]bool) {
+	for _, name := range slices.Sorted(maps.Keys(rc.additionalCrateRootByName)) {
+		crateRoot := rc.additionalCrateRootByName[name]
+		if existingRuleNames[name] || claimedFiles[crateRoot] || !l.fileExists(dir, crateRoot) {
+			continue
+		}
+		srcs := l.discoverModules(rc, dir, crateRoot)
+		for _, src := range srcs {
+			claimedFiles[src] = true
+		}
+		l.emitNewRule(result, rc, "rust_library", name, dir, srcs)
+	}
+}
@@ -0,0 +1,200 @@
+package rust_language
+
+// -rust_advisory_db=<directory> checks the crates.io packages that resolved
+// rules depend on, directly or through workspace libraries and other crates,
+// against a checkout of the RustSec advisory database
+// (https://github.com/rustsec/advisory-db). Each advisory affecting a locked
+// version is printed with the rules that depend on it, and gazelle exits
+// before any BUILD file is written, failing if any advisory is a
+// vulnerability rather than informational, such as an unmaintained crate.
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bazelbuild/bazel-gazelle/label"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+type advisoryAudit struct {
+	databaseDirectory string
+	// Advisories read from the database, keyed by package name.
+	advisoriesByCrate map[string][]*advisory
+}
+
+type advisory struct {
+	id    string
+	title string
+	url   string
+	// Such as "unmaintained" or "unsound". Empty for vulnerabilities.
+	informational string
+	// Version requirements, such as ">= 1.2.3, < 1.3.0".
+	patched    []string
+	unaffected []string
+}
+
+var advisoryTitleRegex = regexp.MustCompile(`^#\s+(.+)$`)
+
+func newAdvisoryAudit(databaseDirectory string) (*advisoryAudit, error) {
+	if info, err := os.Stat(filepath.Join(databaseDirectory, "crates")); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%s has no crates directory; expected a checkout of the RustSec advisory database", databaseDirectory)
+	}
+	return &advisoryAudit{
+		databaseDirectory: databaseDirectory,
+		advisoriesByCrate: make(map[string][]*advisory),
+	}, nil
+}
+
+// Print the advisories affecting the crates of each resolved rule and exit.
+func (audit *advisoryAudit) finish(reports *licenseReports) {
+	type finding struct {
+		advisory *advisory
+		crate    rust_analysis.ExternalCrate
+		rules    []label.Label
+	}
+	var findings []*finding
+	findingByKey := make(map[string]*finding)
+	for _, from := range reports.rules() {
+		for _, crate := range reports.crates(from) {
+			if crate.Source != rust_analysis.CratesIORegistrySource {
+				continue
+			}
+			for _, advisory := range audit.advisories(crate.Name) {
+				if !advisory.affects(crate.Version) {
+					continue
+				}
+				key := advisory.id + " " + crate.Version
+				if findingByKey[key] == nil {
+					findingByKey[key] = &finding{advisory: advisory, crate: crate}
+					findings = append(findings, findingByKey[key])
+				}
+				findingByKey[key].rules = append(findingByKey[key].rules, from)
+			}
+		}
+	}
+	slices.SortFunc(findings, func(a, b *finding) int {
+		return strings.Compare(a.advisory.id+" "+a.crate.Version, b.advisory.id+" "+b.crate.Version)
+	})
+
+	vulnerabilities := 0
+	for _, finding := range findings {
+		kind := "vulnerability"
+		if finding.advisory.informational != "" {
+			kind = finding.advisory.informational
+		} else {
+			vulnerabilities++
+		}
+		fmt.Printf("%s: %s %s (%s): %s\n", finding.advisory.id, finding.crate.Name, finding.crate.Version, kind, finding.advisory.title)
+		if finding.advisory.url != "" {
+			fmt.Printf("  %s\n", finding.advisory.url)
+		}
+		if len(finding.advisory.patched) > 0 {
+			fmt.Printf("  patched: %s\n", strings.Join(finding.advisory.patched, "; "))
+		} else {
+			fmt.Printf("  patched: no fixed version\n")
+		}
+		for _, from := range finding.rules {
+			fmt.Printf("  %s\n", from)
+		}
+	}
+	if vulnerabilities > 0 {
+		log.Fatalf("-rust_advisory_db: found %d vulnerabilities in crates that Rust rules depend on", vulnerabilities)
+	}
+	os.Exit(0)
+}
+
+// Return the advisories of a package, reading them on first use. Withdrawn
+// advisories are left out.
+func (audit *advisoryAudit) advisories(crateName string) []*advisory {
+	if advisories, ok := audit.advisoriesByCrate[crateName]; ok {
+		return advisories
+	}
+	var advisories []*advisory
+	paths, _ := filepath.Glob(filepath.Join(audit.databaseDirectory, "crates", crateName, "*.md"))
+	for _, advisoryPath := range paths {
+		advisory, withdrawn, err := readAdvisory(advisoryPath)
+		if err != nil {
+			log.Fatalf("-rust_advisory_db: %v", err)
+		}
+		if !withdrawn {
+			advisories = append(advisories, advisory)
+		}
+	}
+	audit.advisoriesByCrate[crateName] = advisories
+	return advisories
+}
+
+// The fields of an advisory's front matter the audit uses.
+type advisoryFrontMatter struct {
+	Advisory struct {
+		ID            string `toml:"id"`
+		URL           string `toml:"url"`
+		Informational string `toml:"informational"`
+		// The date the advisory was withdrawn, if it was.
+		Withdrawn string `toml:"withdrawn"`
+	} `toml:"advisory"`
+	Versions struct {
+		Patched    []string `toml:"patched"`
+		Unaffected []string `toml:"unaffected"`
+	} `toml:"versions"`
+}
+
+// Read an advisory: TOML front matter in a ```toml block, followed by a
+// Markdown heading with its title.
+func readAdvisory(advisoryPath string) (*advisory, bool, error) {
+	contents, err := os.ReadFile(advisoryPath)
+	if err != nil {
+		return nil, false, err
+	}
+	lines := strings.Split(string(contents), "\n")
+	start := slices.IndexFunc(lines, func(line string) bool { return strings.TrimSpace(line) == "```toml" })
+	length := -1
+	if start >= 0 {
+		length = slices.IndexFunc(lines[start+1:], func(line string) bool { return strings.TrimSpace(line) == "```" })
+	}
+	if length < 0 {
+		return nil, false, fmt.Errorf("%s: no ```toml front matter", advisoryPath)
+	}
+	var frontMatter advisoryFrontMatter
+	if err := toml.Unmarshal([]byte(strings.Join(lines[start+1:start+1+length], "\n")), &frontMatter); err != nil {
+		return nil, false, fmt.Errorf("%s: %w", advisoryPath, err)
+	}
+
+	advisory := &advisory{
+		id:            frontMatter.Advisory.ID,
+		url:           frontMatter.Advisory.URL,
+		informational: frontMatter.Advisory.Informational,
+		patched:       frontMatter.Versions.Patched,
+		unaffected:    frontMatter.Versions.Unaffected,
+	}
+	if advisory.id == "" {
+		advisory.id = strings.TrimSuffix(filepath.Base(advisoryPath), ".md")
+	}
+	if advisory.url == "" {
+		advisory.url = "https://rustsec.org/advisories/" + advisory.id
+	}
+	for _, line := range lines[start+1+length+1:] {
+		if matches := advisoryTitleRegex.FindStringSubmatch(strings.TrimSpace(line)); matches != nil {
+			advisory.title = matches[1]
+			break
+		}
+	}
+	return advisory, frontMatter.Advisory.Withdrawn != "", nil
+}
+
+// Report whether a version is neither patched nor unaffected.
+func (advisory *advisory) affects(version string) bool {
+	for _, requirement := range append(slices.Clone(advisory.patched), advisory.unaffected...) {
+		if matchesVersionRequirement(version, requirement) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package rust_language
+
+// alias() rules pointing at workspace crates, as when a library moves and its
+// old label stays behind during the migration. With
+// `# gazelle:rust_resolve_aliases enabled`, imports resolving to a crate with
+// an alias depend on the alias instead, so consumers use the label the
+// workspace asks for. When several aliases point at a crate, the first by
+// label wins.
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Aliases of workspace rules, found while indexing and shared by all
+// directories' configs.
+const aliasesByTargetKey = "rust_aliases"
+
+func recordAlias(c *config.Config, r *rule.Rule, pkg string) {
+	actual, err := label.Parse(r.AttrString("actual"))
+	if err != nil || actual.Repo != "" {
+		return
+	}
+	aliasesByTarget := c.Exts[aliasesByTargetKey].(map[label.Label][]label.Label)
+	target := actual.Abs("", pkg)
+	aliasesByTarget[target] = append(aliasesByTarget[target], label.New("", pkg, r.Name()))
+}
+
+// Return the label to depend on for a workspace rule: its alias, if aliases
+// are resolved to and it has one, or else the rule itself.
+func aliasedLabel(c *config.Config, rc *rustConfig, target label.Label) label.Label {
+	if !rc.resolveAliases {
+		return target
+	}
+	aliases := c.Exts[aliasesByTargetKey].(map[label.Label][]label.Label)[target]
+	if len(aliases) == 0 {
+		return target
+	}
+	return slices.MinFunc(aliases, func(a, b label.Label) int { return strings.Compare(a.String(), b.String()) })
+}
@@ -0,0 +1,25 @@
+package rust_language
+
+// `# gazelle:rust_binary_platform <label>` sets the platform of the
+// rust_binary rules in a directory and its subdirectories, so rules_rust
+// builds them for that platform whatever the command line's, as a subtree of
+// wasm32 or embedded binaries needs. Giving no value clears the setting,
+// leaving existing rules' platforms as written.
+
+import (
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func setBinaryPlatform(r *rule.Rule, rc *rustConfig, pkg string, existingRule *rule.Rule) {
+	if r.Kind() != "rust_binary" {
+		return
+	}
+	if rc.binaryPlatform == label.NoLabel {
+		if existingRule != nil && existingRule.Attr("platform") != nil {
+			r.SetAttr("platform", existingRule.Attr("platform"))
+		}
+		return
+	}
+	r.SetAttr("platform", rc.binaryPlatform.Rel("", pkg).String())
+}
@@ -0,0 +1,117 @@
+package rust_language
+
+// Some crates only build with a crate universe annotation: ring compiles its
+// C and assembly in its build script, protobuf-src runs cmake from its build
+// script, and openssl-sys's build script has to be told where OpenSSL is.
+// Without one, the failure shows up at link time, far from the dependency
+// needing it. With -rust_crate_universe_lockfile, each such crate that
+// resolved rules depend on, directly or not, and that the crate universe
+// builds without the build script or its environment is reported after
+// resolving, with the annotation to add. Values in angle brackets depend on
+// the repository.
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+type buildScriptAnnotation struct {
+	buildScriptEnv map[string]string
+}
+
+var buildScriptAnnotationByCrate = map[string]buildScriptAnnotation{
+	"openssl-sys":  {buildScriptEnv: map[string]string{"OPENSSL_DIR": "<OpenSSL installation>"}},
+	"protobuf-src": {buildScriptEnv: map[string]string{"CMAKE": "<cmake binary>"}},
+	"ring":         {},
+}
+
+type buildScriptAnnotations struct {
+	lockfilePath string
+	// Keyed by "<name> <version>". Crates the lockfile doesn't pin need
+	// repinning first.
+	pinned map[string]bool
+	// The build script environment variables of each crate the crate
+	// universe runs the build script of, keyed by "<name> <version>".
+	envByCrate map[string][]string
+}
+
+func newBuildScriptAnnotations(flags *rustFlags, universeLockfile *crateUniverseLockfile) *buildScriptAnnotations {
+	annotations := &buildScriptAnnotations{
+		lockfilePath: flags.crateUniverseLockfilePath,
+		pinned:       make(map[string]bool),
+		envByCrate:   make(map[string][]string),
+	}
+	for _, crate := range universeLockfile.Crates {
+		if len(crate.Repository) == 0 || string(crate.Repository) == "null" {
+			continue
+		}
+		annotations.pinned[crate.Name+" "+crate.Version] = true
+		attrs := crate.BuildScriptAttrs
+		if attrs == nil {
+			continue
+		}
+		env := slices.Collect(maps.Keys(attrs.BuildScriptEnv.Common))
+		for _, platformEnv := range attrs.BuildScriptEnv.Selects {
+			env = append(env, slices.Collect(maps.Keys(platformEnv))...)
+		}
+		annotations.envByCrate[crate.Name+" "+crate.Version] = env
+	}
+	return annotations
+}
+
+// Log the crates built without the annotation they need.
+func (annotations *buildScriptAnnotations) report(reports *licenseReports) {
+	importerByCrate := make(map[string]label.Label)
+	for _, from := range reports.rules() {
+		for _, crate := range reports.crates(from) {
+			key := crate.Name + " " + crate.Version
+			if _, ok := importerByCrate[key]; ok || !annotations.pinned[key] {
+				continue
+			}
+			if annotation, ok := buildScriptAnnotationByCrate[crate.Name]; ok && annotations.missing(key, annotation) != "" {
+				importerByCrate[key] = from
+			}
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(importerByCrate)) {
+		name, _, _ := strings.Cut(key, " ")
+		annotation := buildScriptAnnotationByCrate[name]
+		log.Printf("%s: depends on %s, which %s builds without %s; annotate it with %s", importerByCrate[key], key, annotations.lockfilePath, annotations.missing(key, annotation), annotation.starlark(name))
+	}
+}
+
+// Describe what the crate universe builds the crate without, or return "" if
+// nothing.
+func (annotations *buildScriptAnnotations) missing(key string, annotation buildScriptAnnotation) string {
+	env, ok := annotations.envByCrate[key]
+	if !ok {
+		return "its build script"
+	}
+	var missing []string
+	for _, name := range slices.Sorted(maps.Keys(annotation.buildScriptEnv)) {
+		if !slices.Contains(env, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return ""
+	}
+	return "build script environment " + strings.Join(missing, " ")
+}
+
+func (annotation buildScriptAnnotation) starlark(crateName string) string {
+	attrs := []string{fmt.Sprintf("crate = %q", crateName), `gen_build_script = "on"`}
+	if len(annotation.buildScriptEnv) > 0 {
+		var entries []string
+		for _, name := range slices.Sorted(maps.Keys(annotation.buildScriptEnv)) {
+			entries = append(entries, fmt.Sprintf("%q: %q", name, annotation.buildScriptEnv[name]))
+		}
+		attrs = append(attrs, "build_script_env = {"+strings.Join(entries, ", ")+"}")
+	}
+	return "crate.annotation(" + strings.Join(attrs, ", ") + ")"
+}
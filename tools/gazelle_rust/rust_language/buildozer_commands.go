@@ -0,0 +1,56 @@
+package rust_language
+
+// -rust_buildozer prints the changes to Rust rules as buildozer commands,
+// one per line, and exits without writing BUILD files, so the changes can be
+// fed to other BUILD editing pipelines or applied selectively. String lists
+// become `add` and `remove` commands, other values `set`, and new rules and
+// packages `new` and `touch`.
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// Print the commands for every package and exit.
+func printBuildozerCommands(changes *ruleChanges) {
+	for _, change := range changes.collect() {
+		switch change.action {
+		case touchChange:
+			fmt.Println("touch " + shellQuote(path.Join(change.pkg, changes.buildFileName)))
+		case loadChange:
+			printBuildozerCommand(change.target, "new_load", change.values[0], change.values[1])
+		case newChange:
+			printBuildozerCommand(label.New("", change.pkg, "__pkg__"), "new", change.values[0], change.target.Name)
+		case kindChange:
+			printBuildozerCommand(change.target, "set", "kind", change.values[0])
+		case addChange, removeChange:
+			printBuildozerCommand(change.target, append([]string{string(change.action), change.attr}, change.values...)...)
+		case removeAttrChange:
+			printBuildozerCommand(change.target, "remove", change.attr)
+		case setChange:
+			printBuildozerCommand(change.target, "set", change.attr, change.values[0])
+		case replaceChange:
+			log.Printf("%s: %s can't be expressed as buildozer commands; run without -rust_buildozer to update it", change.target, change.attr)
+		case deleteChange:
+			printBuildozerCommand(change.target, "delete")
+		}
+	}
+	os.Exit(0)
+}
+
+func printBuildozerCommand(target label.Label, args ...string) {
+	for i, arg := range args {
+		// buildozer splits commands on unescaped spaces.
+		args[i] = strings.ReplaceAll(arg, " ", `\ `)
+	}
+	fmt.Printf("buildozer %s %s\n", shellQuote(strings.Join(args, " ")), shellQuote(target.String()))
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
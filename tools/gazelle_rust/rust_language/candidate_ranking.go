@@ -0,0 +1,55 @@
+package rust_language
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+)
+
+type ambiguousImports string
+
+const (
+	// Fail when several workspace crates claim an import.
+	errorAmbiguousImports ambiguousImports = "error"
+	// Use the best ranked candidate.
+	rankAmbiguousImports ambiguousImports = "rank"
+)
+
+// Order workspace candidates independently of index iteration order: the
+// consuming package first, then by the depth of the nearest common ancestor
+// package, then lexicographically.
+func rankCandidates(candidates []resolve.FindResult, from label.Label) []resolve.FindResult {
+	ranked := slices.Clone(candidates)
+	slices.SortStableFunc(ranked, func(a, b resolve.FindResult) int {
+		aSamePackage := a.Label.Repo == from.Repo && a.Label.Pkg == from.Pkg
+		bSamePackage := b.Label.Repo == from.Repo && b.Label.Pkg == from.Pkg
+		if aSamePackage != bSamePackage {
+			if aSamePackage {
+				return -1
+			}
+			return 1
+		}
+		if aDepth, bDepth := commonAncestorDepth(a.Label, from), commonAncestorDepth(b.Label, from); aDepth != bDepth {
+			return bDepth - aDepth
+		}
+		return strings.Compare(a.Label.String(), b.Label.String())
+	})
+	return ranked
+}
+
+// Return the number of leading package path components shared with from, or
+// -1 for labels in other repositories.
+func commonAncestorDepth(candidate, from label.Label) int {
+	if candidate.Repo != from.Repo {
+		return -1
+	}
+	candidateParts := strings.Split(candidate.Pkg, "/")
+	fromParts := strings.Split(from.Pkg, "/")
+	depth := 0
+	for depth < len(candidateParts) && depth < len(fromParts) && candidateParts[depth] == fromParts[depth] && candidateParts[depth] != "" {
+		depth++
+	}
+	return depth
+}
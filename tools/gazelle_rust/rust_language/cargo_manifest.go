@@ -0,0 +1,299 @@
+package rust_language
+
+// The dependency tables of Cargo.toml files: [dependencies],
+// [dev-dependencies] and [build-dependencies], their [target.<cfg>.*]
+// variants, and [workspace.dependencies], which members inherit from with
+// `name = { workspace = true }` or `name.workspace = true`, along with the
+// [features] table, and the [package] table with the [workspace.package] its
+// fields inherit from the same way.
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+type cargoManifest struct {
+	// Relative to the repository root.
+	path string
+	// Whether the manifest has a [workspace] table.
+	isWorkspaceRoot bool
+	// Whether the manifest has a [package] table, rather than only a
+	// [workspace].
+	isPackage bool
+	// The dependencies of the manifest's package, in order.
+	dependencies []*manifestDependency
+	// [workspace.dependencies], keyed by the normalized name.
+	workspaceDependencyByImport map[string]*manifestDependency
+	// What each feature in [features] enables, such as `["dep:foo",
+	// "bar/std"]`.
+	enabledByFeature map[string][]string
+	// The string fields of [package], and of [workspace.package], such as
+	// version. Arrays, such as authors, are joined with ":" as cargo does.
+	packageFieldByKey          map[string]string
+	workspacePackageFieldByKey map[string]string
+	// The [package] fields declared with `workspace = true`.
+	inheritedPackageFields map[string]bool
+}
+
+type manifestDependency struct {
+	// The name the dependency is imported by.
+	name string
+	// The package in Cargo.lock, when the dependency is renamed with
+	// `package = "..."`.
+	packageName string
+	// Declared with `workspace = true`, inheriting the workspace dependency.
+	inherited bool
+	// The table declaring the dependency, such as "dev-dependencies".
+	table string
+	// Declared with `optional = true`, so only compiled in when a feature
+	// enables it.
+	optional bool
+	// The dependency's features enabled with `features = [...]`.
+	features []string
+}
+
+// The Cargo.toml in each package directory or its nearest ancestor, parsed
+// once per run for every feature reading them.
+type cargoManifestIndex struct {
+	repoRoot string
+	// Nil for directories no manifest governs.
+	manifestByDirectory map[string]*indexedManifest
+}
+
+type indexedManifest struct {
+	*cargoManifest
+	// The manifest with the [workspace] the package belongs to, if any.
+	workspaceRoot *cargoManifest
+}
+
+func newCargoManifestIndex(repoRoot string) *cargoManifestIndex {
+	return &cargoManifestIndex{repoRoot: repoRoot, manifestByDirectory: make(map[string]*indexedManifest)}
+}
+
+// Return the manifest in pkg or its nearest ancestor.
+func (index *cargoManifestIndex) manifestFor(pkg string) *indexedManifest {
+	if manifest, ok := index.manifestByDirectory[pkg]; ok {
+		return manifest
+	}
+
+	var manifest *indexedManifest
+	manifestPath := path.Join(pkg, "Cargo.toml")
+	if _, err := os.Stat(filepath.Join(index.repoRoot, manifestPath)); err == nil {
+		parsed, err := parseCargoManifest(filepath.Join(index.repoRoot, manifestPath))
+		if err != nil {
+			log.Fatalf("%s: %v", manifestPath, err)
+		}
+		parsed.path = manifestPath
+		manifest = &indexedManifest{cargoManifest: parsed}
+		manifest.workspaceRoot = index.workspaceRootFor(manifest)
+	} else if pkg != "" {
+		manifest = index.manifestFor(parentPackage(pkg))
+	}
+	index.manifestByDirectory[pkg] = manifest
+	return manifest
+}
+
+// Return the nearest manifest with a [workspace], starting from manifest
+// itself, as cargo does.
+func (index *cargoManifestIndex) workspaceRootFor(manifest *indexedManifest) *cargoManifest {
+	for manifest != nil {
+		if manifest.isWorkspaceRoot {
+			return manifest.cargoManifest
+		}
+		directory := path.Dir(manifest.path)
+		if directory == "." {
+			return nil
+		}
+		manifest = index.manifestFor(parentPackage(directory))
+	}
+	return nil
+}
+
+func parentPackage(pkg string) string {
+	parent := path.Dir(pkg)
+	if parent == "." {
+		return ""
+	}
+	return parent
+}
+
+const (
+	featuresTable              = "features"
+	packageTable               = "package"
+	workspaceTable             = "workspace"
+	workspaceDependenciesTable = "workspace.dependencies"
+)
+
+func parseCargoManifest(manifestPath string) (*cargoManifest, error) {
+	contents, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]any
+	metadata, err := toml.Decode(string(contents), &document)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &cargoManifest{
+		workspaceDependencyByImport: make(map[string]*manifestDependency),
+		enabledByFeature:            make(map[string][]string),
+		packageFieldByKey:           make(map[string]string),
+		workspacePackageFieldByKey:  make(map[string]string),
+		inheritedPackageFields:      make(map[string]bool),
+	}
+	workspace, isWorkspaceRoot := document[workspaceTable].(map[string]any)
+	manifest.isWorkspaceRoot = isWorkspaceRoot
+	pkg, isPackage := document[packageTable].(map[string]any)
+	manifest.isPackage = isPackage
+	setPackageFields(pkg, manifest.packageFieldByKey, manifest.inheritedPackageFields)
+	workspacePackage, _ := workspace[packageTable].(map[string]any)
+	setPackageFields(workspacePackage, manifest.workspacePackageFieldByKey, nil)
+	features, _ := document[featuresTable].(map[string]any)
+	for feature, enabled := range features {
+		manifest.enabledByFeature[feature] = manifestStrings(enabled)
+	}
+
+	// Keys are visited in file order, so dependencies keep their declared
+	// order. A dependency's own key is only listed when it isn't declared
+	// with dotted keys, as in `serde.workspace = true`, so each key's
+	// declaring prefix is used instead.
+	dependencyByKey := make(map[string]*manifestDependency)
+	visitedKeys := make(map[string]bool)
+	for _, key := range metadata.Keys() {
+		table, name, length := dependencyKey(key)
+		if table == "" || visitedKeys[key[:length].String()] {
+			continue
+		}
+		visitedKeys[key[:length].String()] = true
+		dependency, ok := dependencyByKey[table+":"+name]
+		if !ok {
+			dependency = &manifestDependency{name: name, table: table}
+			dependencyByKey[table+":"+name] = dependency
+			if table == workspaceDependenciesTable {
+				manifest.workspaceDependencyByImport[rust_analysis.NormalizeCrateName(name)] = dependency
+			} else {
+				manifest.dependencies = append(manifest.dependencies, dependency)
+			}
+		}
+		dependency.setProperties(manifestValue(document, key[:length]))
+	}
+	return manifest, nil
+}
+
+// Return the dependency table and name of a key within a dependency's
+// declaration, such as dependencies.serde or
+// target.'cfg(unix)'.dev-dependencies.libc.version, and the length of the
+// key declaring it, or empty strings for any other key.
+func dependencyKey(key toml.Key) (table, name string, length int) {
+	switch {
+	case len(key) >= 2 && isDependencyTable(key[0]):
+		return key[0], key[1], 2
+	case len(key) >= 3 && key[0] == workspaceTable && key[1] == "dependencies":
+		return workspaceDependenciesTable, key[2], 3
+	case len(key) >= 4 && key[0] == "target" && isDependencyTable(key[2]):
+		return key[2], key[3], 4
+	}
+	return "", "", 0
+}
+
+func isDependencyTable(table string) bool {
+	return table == "dependencies" || table == "dev-dependencies" || table == "build-dependencies"
+}
+
+// Return the value at a key of a decoded manifest, or nil.
+func manifestValue(document map[string]any, key toml.Key) any {
+	var value any = document
+	for _, part := range key {
+		table, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = table[part]
+	}
+	return value
+}
+
+// Return the strings of an array value, skipping other elements.
+func manifestStrings(value any) []string {
+	values, _ := value.([]any)
+	var strs []string
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
+
+// Record the properties of a dependency declared as a table. A dependency
+// declared in several tables, such as [dependencies] and a
+// [target.<cfg>.dependencies], accumulates them.
+func (dependency *manifestDependency) setProperties(value any) {
+	properties, ok := value.(map[string]any)
+	if !ok {
+		return
+	}
+	if packageName, ok := properties["package"].(string); ok {
+		dependency.packageName = packageName
+	}
+	if properties["workspace"] == true {
+		dependency.inherited = true
+	}
+	if properties["optional"] == true {
+		dependency.optional = true
+	}
+	dependency.features = append(dependency.features, manifestStrings(properties["features"])...)
+}
+
+// Record the string fields of a [package] or [workspace.package] table, and
+// in inheritedFields, the ones declared with `workspace = true`.
+func setPackageFields(fields map[string]any, fieldByKey map[string]string, inheritedFields map[string]bool) {
+	for key, value := range fields {
+		switch value := value.(type) {
+		case string:
+			fieldByKey[key] = value
+		case []any:
+			fieldByKey[key] = strings.Join(manifestStrings(value), ":")
+		case map[string]any:
+			if value["workspace"] == true && inheritedFields != nil {
+				inheritedFields[key] = true
+			}
+		}
+	}
+}
+
+// Return a [package] field, following inheritance from the workspace root's
+// [workspace.package].
+func (manifest *cargoManifest) packageField(key string, workspaceRoot *cargoManifest) (string, bool) {
+	if manifest.inheritedPackageFields[key] {
+		if workspaceRoot == nil {
+			return "", false
+		}
+		value, ok := workspaceRoot.workspacePackageFieldByKey[key]
+		return value, ok
+	}
+	value, ok := manifest.packageFieldByKey[key]
+	return value, ok
+}
+
+// Return the package in Cargo.lock the dependency refers to, following
+// inheritance from the workspace root's [workspace.dependencies].
+func (dependency *manifestDependency) lockedPackage(workspaceRoot *cargoManifest) string {
+	if dependency.inherited && workspaceRoot != nil {
+		if inheritedFrom, ok := workspaceRoot.workspaceDependencyByImport[rust_analysis.NormalizeCrateName(dependency.name)]; ok {
+			dependency = inheritedFrom
+		}
+	}
+	if dependency.packageName != "" {
+		return dependency.packageName
+	}
+	return dependency.name
+}
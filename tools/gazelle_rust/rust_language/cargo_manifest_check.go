@@ -0,0 +1,116 @@
+package rust_language
+
+// -rust_check_cargo_toml keeps Cargo.toml in sync with the BUILD files: every
+// crate universe crate a rule imports must be declared in the nearest
+// Cargo.toml above the rule's package, and every declared dependency must be
+// imported by some rule below it. Build dependencies are only used by build
+// scripts, so they may be declared without being imported.
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+type cargoManifestCheck struct {
+	manifests      *cargoManifestIndex
+	externalCrates *ExternalCrates
+	// The manifests governing the packages of imports, and their workspace
+	// roots, keyed by path.
+	checkedManifestByPath map[string]*checkedManifest
+	// Imports of packages above every Cargo.toml.
+	undeclaredImports []manifestImport
+}
+
+type checkedManifest struct {
+	*indexedManifest
+	// The first rule importing each crate, keyed by the normalized name.
+	importerByImport map[string]label.Label
+}
+
+type manifestImport struct {
+	importName string
+	from       label.Label
+}
+
+func newCargoManifestCheck(manifests *cargoManifestIndex, externalCrates *ExternalCrates) *cargoManifestCheck {
+	return &cargoManifestCheck{
+		manifests:             manifests,
+		externalCrates:        externalCrates,
+		checkedManifestByPath: make(map[string]*checkedManifest),
+	}
+}
+
+// Record a crate universe import of a rule.
+func (check *cargoManifestCheck) addImport(importName string, from label.Label) {
+	normalizedImport := rust_analysis.NormalizeCrateName(importName)
+	manifest := check.manifests.manifestFor(from.Pkg)
+	if manifest == nil {
+		check.undeclaredImports = append(check.undeclaredImports, manifestImport{importName: normalizedImport, from: from})
+		return
+	}
+	checked := check.checkedManifest(manifest)
+	if manifest.workspaceRoot != nil {
+		check.checkedManifest(check.manifests.manifestFor(parentPackage(manifest.workspaceRoot.path)))
+	}
+	if _, ok := checked.importerByImport[normalizedImport]; !ok {
+		checked.importerByImport[normalizedImport] = from
+	}
+}
+
+func (check *cargoManifestCheck) checkedManifest(manifest *indexedManifest) *checkedManifest {
+	checked, ok := check.checkedManifestByPath[manifest.path]
+	if !ok {
+		checked = &checkedManifest{indexedManifest: manifest, importerByImport: make(map[string]label.Label)}
+		check.checkedManifestByPath[manifest.path] = checked
+	}
+	return checked
+}
+
+// Report every inconsistency, and fail if there are any.
+func (check *cargoManifestCheck) report() {
+	var problems []string
+	for _, undeclared := range check.undeclaredImports {
+		problems = append(problems, fmt.Sprintf("%s: crate %q is imported, but no Cargo.toml governs the package", undeclared.from, undeclared.importName))
+	}
+
+	for _, manifestPath := range slices.Sorted(maps.Keys(check.checkedManifestByPath)) {
+		manifest := check.checkedManifestByPath[manifestPath]
+		dependencyByImport := make(map[string]*manifestDependency)
+		for _, dependency := range manifest.dependencies {
+			dependencyByImport[rust_analysis.NormalizeCrateName(dependency.name)] = dependency
+		}
+
+		for _, importName := range slices.Sorted(maps.Keys(manifest.importerByImport)) {
+			if _, ok := dependencyByImport[importName]; ok {
+				continue
+			}
+			problem := fmt.Sprintf("%s: crate %q is imported by %s but not declared", manifest.path, importName, manifest.importerByImport[importName])
+			if crate := check.externalCrates.Describe(importName); crate != "" {
+				problem += "; Cargo.lock has " + crate
+			}
+			problems = append(problems, problem)
+		}
+		for _, dependency := range manifest.dependencies {
+			normalizedName := rust_analysis.NormalizeCrateName(dependency.name)
+			if dependency.inherited && (manifest.workspaceRoot == nil || manifest.workspaceRoot.workspaceDependencyByImport[normalizedName] == nil) {
+				problems = append(problems, fmt.Sprintf("%s: dependency %q sets workspace = true, but no [workspace.dependencies] declares it", manifest.path, dependency.name))
+			}
+			if _, ok := manifest.importerByImport[normalizedName]; !ok && dependency.table != "build-dependencies" {
+				problems = append(problems, fmt.Sprintf("%s: dependency %q is declared but no rule imports it", manifest.path, dependency.name))
+			}
+		}
+	}
+
+	for _, problem := range problems {
+		log.Print(problem)
+	}
+	if len(problems) > 0 {
+		log.Fatalf("-rust_check_cargo_toml: found %d inconsistencies between Cargo.toml and BUILD files", len(problems))
+	}
+}
@@ -0,0 +1,91 @@
+package rust_language
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseCargoManifest(t *testing.T) {
+	manifestPath := filepath.Join(t.TempDir(), "Cargo.toml")
+	contents := `[package]
+name = "app"
+description = "Parses ranges like [a, b)"
+authors = ["A <a@example.com>", "B"]
+version.workspace = true
+license = { workspace = true }
+
+[dependencies]
+json = { package = "serde_json", version = "1" }
+serde.workspace = true
+tokio = { version = "1", optional = true, features = [
+    "rt",
+] }
+
+[target.'cfg(target_os = "linux")'.dependencies]
+tokio = { version = "1", features = ["net"] }
+
+[dev-dependencies.proptest]
+version = "1"
+
+[features]
+default = ["dep:tokio", "json/std"]
+
+[workspace.package]
+version = "1.2.3"
+
+[workspace.dependencies]
+serde = { version = "1", features = ["derive"] }
+`
+	if err := os.WriteFile(manifestPath, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := parseCargoManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !manifest.isPackage || !manifest.isWorkspaceRoot {
+		t.Errorf("isPackage = %t, isWorkspaceRoot = %t, want both", manifest.isPackage, manifest.isWorkspaceRoot)
+	}
+	for key, want := range map[string]string{
+		"description": "Parses ranges like [a, b)",
+		"authors":     "A <a@example.com>:B",
+		"version":     "1.2.3",
+	} {
+		if value, _ := manifest.packageField(key, manifest); value != want {
+			t.Errorf("packageField(%s) = %q, want %q", key, value, want)
+		}
+	}
+	if _, ok := manifest.packageField("license", manifest); ok {
+		t.Errorf("license is inherited, but [workspace.package] doesn't declare it")
+	}
+
+	wantDependencies := []manifestDependency{
+		{name: "json", packageName: "serde_json", table: "dependencies"},
+		{name: "serde", inherited: true, table: "dependencies"},
+		{name: "tokio", table: "dependencies", optional: true, features: []string{"rt", "net"}},
+		{name: "proptest", table: "dev-dependencies"},
+	}
+	if len(manifest.dependencies) != len(wantDependencies) {
+		t.Fatalf("got %d dependencies, want %d", len(manifest.dependencies), len(wantDependencies))
+	}
+	for i, want := range wantDependencies {
+		got := manifest.dependencies[i]
+		if got.name != want.name || got.packageName != want.packageName || got.inherited != want.inherited ||
+			got.table != want.table || got.optional != want.optional || !slices.Equal(got.features, want.features) {
+			t.Errorf("dependency %d = %+v, want %+v", i, *got, want)
+		}
+	}
+	if serde, ok := manifest.workspaceDependencyByImport["serde"]; !ok || !slices.Equal(serde.features, []string{"derive"}) {
+		t.Errorf("[workspace.dependencies] serde = %+v", serde)
+	}
+	if got := slices.Sorted(maps.Keys(manifest.enabledByFeature)); !slices.Equal(got, []string{"default"}) {
+		t.Errorf("features = %q, want default", got)
+	}
+	if got := manifest.enabledByFeature["default"]; !slices.Equal(got, []string{"dep:tokio", "json/std"}) {
+		t.Errorf("default enables %q", got)
+	}
+}
@@ -0,0 +1,166 @@
+package rust_language
+
+// Cargo sets CARGO_PKG_* variables from the [package] table of Cargo.toml
+// while compiling, and crates read them with `env!("CARGO_PKG_VERSION")` or
+// option_env!. Rules whose sources read them get rustc_env entries with the
+// values of the package's Cargo.toml, following [workspace.package]
+// inheritance, unless `# gazelle:rust_cargo_package_env <variable> <value>`
+// sets them. Other rustc_env entries are kept as written.
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+const cargoPackageVariablePrefix = "CARGO_PKG_"
+
+// The [package] field each variable is set from, as cargo does. Fields a
+// manifest leaves out are set to the empty string.
+var manifestFieldByCargoPackageVariable = map[string]string{
+	"CARGO_PKG_NAME":         "name",
+	"CARGO_PKG_VERSION":      "version",
+	"CARGO_PKG_AUTHORS":      "authors",
+	"CARGO_PKG_DESCRIPTION":  "description",
+	"CARGO_PKG_HOMEPAGE":     "homepage",
+	"CARGO_PKG_REPOSITORY":   "repository",
+	"CARGO_PKG_LICENSE":      "license",
+	"CARGO_PKG_LICENSE_FILE": "license-file",
+	"CARGO_PKG_README":       "readme",
+	"CARGO_PKG_RUST_VERSION": "rust-version",
+}
+
+// Return the value cargo gives a CARGO_PKG_* variable for the manifest's
+// package, or false for variables cargo doesn't set.
+func (manifest *indexedManifest) variable(variable string) (string, bool) {
+	if field, ok := manifestFieldByCargoPackageVariable[variable]; ok {
+		value, _ := manifest.packageField(field, manifest.workspaceRoot)
+		return value, true
+	}
+
+	version, _ := manifest.packageField("version", manifest.workspaceRoot)
+	// Build metadata isn't part of any variable.
+	version, _, _ = strings.Cut(version, "+")
+	release, pre, _ := strings.Cut(version, "-")
+	parts := strings.SplitN(release, ".", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	switch variable {
+	case "CARGO_PKG_VERSION_MAJOR":
+		return parts[0], true
+	case "CARGO_PKG_VERSION_MINOR":
+		return parts[1], true
+	case "CARGO_PKG_VERSION_PATCH":
+		return parts[2], true
+	case "CARGO_PKG_VERSION_PRE":
+		return pre, true
+	}
+	return "", false
+}
+
+func (l *rustLang) setCargoPackageEnv(r *rule.Rule, rc *rustConfig, dir string, sources []ParsedSource) {
+	var variables []string
+	for _, source := range sources {
+		for _, variable := range source.Response.EnvironmentVariables {
+			if strings.HasPrefix(variable, cargoPackageVariablePrefix) {
+				variables = append(variables, variable)
+			}
+		}
+	}
+	if len(variables) == 0 {
+		return
+	}
+
+	pkg := l.packageOf(dir)
+	manifest := l.cargoManifests.manifestFor(pkg)
+	if manifest != nil && !manifest.isPackage {
+		manifest = nil
+	}
+	environment := make(map[string]string)
+	var unset []string
+	for _, variable := range variables {
+		value, ok := rc.cargoPackageEnvByVariable[variable]
+		if !ok && manifest != nil {
+			value, ok = manifest.variable(variable)
+		}
+		if !ok {
+			unset = append(unset, variable)
+			continue
+		}
+		environment[variable] = value
+	}
+	if len(unset) > 0 {
+		slices.Sort(unset)
+		log.Printf("//%s:%s: reads %s, which no Cargo.toml [package] sets; add `# gazelle:%s <variable> <value>`", pkg, r.Name(), strings.Join(slices.Compact(unset), " and "), cargoPackageEnvDirective)
+	}
+	if len(environment) > 0 && !setEnvEntries(r, "rustc_env", environment, nil) {
+		log.Printf("//%s:%s: reads %s; add them to rustc_env by hand", pkg, r.Name(), strings.Join(slices.Sorted(maps.Keys(environment)), " and "))
+	}
+}
+
+// Set entries of a dict attribute such as rustc_env or env, and remove the
+// removed variables, keeping its other entries. A computed dict, such as a
+// select(), is left as written, returning false.
+func setEnvEntries(r *rule.Rule, attr string, valueByVariable map[string]string, removed []string) bool {
+	switch existing := r.Attr(attr).(type) {
+	case nil:
+		if len(valueByVariable) > 0 {
+			r.SetAttr(attr, valueByVariable)
+		}
+	case *bzl.DictExpr:
+		r.SetAttr(attr, envEntries{expr: existing, valueByVariable: valueByVariable, removed: removed})
+	default:
+		return false
+	}
+	return true
+}
+
+// A rustc_env or env value implementing rule.Merger, which sets its entries
+// in the existing dict so entries written by hand and their comments stay.
+type envEntries struct {
+	expr            *bzl.DictExpr
+	valueByVariable map[string]string
+	removed         []string
+}
+
+func (entries envEntries) BzlExpr() bzl.Expr {
+	if merged := entries.Merge(entries.expr); merged != nil {
+		return merged
+	}
+	return &bzl.DictExpr{}
+}
+
+func (entries envEntries) Merge(existing bzl.Expr) bzl.Expr {
+	dict, ok := existing.(*bzl.DictExpr)
+	if !ok {
+		return existing
+	}
+	merged := *dict
+	merged.List = nil
+	remaining := maps.Clone(entries.valueByVariable)
+	for _, entry := range dict.List {
+		if key, ok := entry.Key.(*bzl.StringExpr); ok {
+			if slices.Contains(entries.removed, key.Value) {
+				continue
+			}
+			if value, ok := remaining[key.Value]; ok {
+				entry = &bzl.KeyValueExpr{Comments: entry.Comments, Key: entry.Key, Value: &bzl.StringExpr{Value: value}}
+				delete(remaining, key.Value)
+			}
+		}
+		merged.List = append(merged.List, entry)
+	}
+	for _, variable := range slices.Sorted(maps.Keys(remaining)) {
+		merged.List = append(merged.List, &bzl.KeyValueExpr{Key: &bzl.StringExpr{Value: variable}, Value: &bzl.StringExpr{Value: remaining[variable]}})
+	}
+	if len(merged.List) == 0 {
+		// Removes the attribute.
+		return nil
+	}
+	return &merged
+}
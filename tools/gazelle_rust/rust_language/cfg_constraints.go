@@ -0,0 +1,192 @@
+package rust_language
+
+// A crate whose root is gated on the target with `#![cfg(...)]`, such as
+// `#![cfg(target_os = "linux")]`, compiles to nothing, or fails to compile,
+// elsewhere. Its rule's target_compatible_with gets the matching constraints
+// from @platforms, so building for other platforms skips it. Options without
+// a constraint, such as `feature = "tls"`, are left out, which only allows
+// more platforms. Alternatives like `unix` or `any(...)`, and negations, become
+// a select() of the constraints. Constraints already on the rule are kept, so
+// one the crate no longer needs has to be removed by hand, since gazelle can't
+// tell it from one written on purpose.
+
+import (
+	"slices"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+
+	messages "coppice/tools/gazelle_rust/proto"
+)
+
+const incompatibleConstraint = "@platforms//:incompatible"
+
+var osConstraintByTargetOS = map[string]string{
+	"android":    "@platforms//os:android",
+	"emscripten": "@platforms//os:emscripten",
+	"freebsd":    "@platforms//os:freebsd",
+	"fuchsia":    "@platforms//os:fuchsia",
+	"haiku":      "@platforms//os:haiku",
+	"ios":        "@platforms//os:ios",
+	"linux":      "@platforms//os:linux",
+	"macos":      "@platforms//os:osx",
+	"netbsd":     "@platforms//os:netbsd",
+	"none":       "@platforms//os:none",
+	"openbsd":    "@platforms//os:openbsd",
+	"tvos":       "@platforms//os:tvos",
+	"uefi":       "@platforms//os:uefi",
+	"visionos":   "@platforms//os:visionos",
+	"wasi":       "@platforms//os:wasi",
+	"watchos":    "@platforms//os:watchos",
+	"windows":    "@platforms//os:windows",
+}
+
+var cpuConstraintByTargetArch = map[string]string{
+	"aarch64":     "@platforms//cpu:aarch64",
+	"arm":         "@platforms//cpu:arm",
+	"loongarch64": "@platforms//cpu:loongarch64",
+	"riscv32":     "@platforms//cpu:riscv32",
+	"riscv64":     "@platforms//cpu:riscv64",
+	"s390x":       "@platforms//cpu:s390x",
+	"wasm32":      "@platforms//cpu:wasm32",
+	"wasm64":      "@platforms//cpu:wasm64",
+	"x86":         "@platforms//cpu:x86_32",
+	"x86_64":      "@platforms//cpu:x86_64",
+}
+
+// The target_os values of the unix target family that have a constraint.
+var unixTargetOSes = []string{"android", "freebsd", "haiku", "ios", "linux", "macos", "netbsd", "openbsd", "tvos", "visionos", "watchos"}
+
+// A condition on the target platform: one of the constraints holds, or with
+// negated, none of them does.
+type cfgTerm struct {
+	constraints []string
+	negated     bool
+}
+
+// Return the target_compatible_with constraints the crate root's cfg needs,
+// and the select() for each condition a list of constraints can't express.
+func cfgConstraints(r *rule.Rule, sources []ParsedSource) ([]string, []bzl.Expr) {
+	crateRoot := libraryCrateRoot(r)
+	if r.Kind() == "rust_binary" {
+		crateRoot = binaryCrateRoot(r)
+	}
+	index := slices.IndexFunc(sources, func(source ParsedSource) bool { return source.Src == crateRoot })
+	if crateRoot == "" || index < 0 || sources[index].Response.FileCfg == nil {
+		return nil, nil
+	}
+	var constraints []string
+	var selects []bzl.Expr
+	for _, term := range cfgTerms(sources[index].Response.FileCfg) {
+		switch {
+		case !term.negated && len(term.constraints) == 1:
+			if !slices.Contains(constraints, term.constraints[0]) {
+				constraints = append(constraints, term.constraints[0])
+			}
+		case !term.negated:
+			selects = append(selects, constraintSelect(term.constraints, []string{}, []string{incompatibleConstraint}))
+		default:
+			selects = append(selects, constraintSelect(term.constraints, []string{incompatibleConstraint}, []string{}))
+		}
+	}
+	return constraints, selects
+}
+
+// Return a select() of matched for each constraint and unmatched otherwise.
+func constraintSelect(constraints, matched, unmatched []string) bzl.Expr {
+	var entries []*bzl.KeyValueExpr
+	for _, constraint := range slices.Sorted(slices.Values(constraints)) {
+		entries = append(entries, &bzl.KeyValueExpr{Key: &bzl.StringExpr{Value: constraint}, Value: rule.ExprFromValue(matched)})
+	}
+	entries = append(entries, &bzl.KeyValueExpr{Key: &bzl.StringExpr{Value: "//conditions:default"}, Value: rule.ExprFromValue(unmatched)})
+	return &bzl.CallExpr{
+		X:    &bzl.Ident{Name: "select"},
+		List: []bzl.Expr{&bzl.DictExpr{List: entries, ForceMultiLine: true}},
+	}
+}
+
+// Return the conditions on the target platform that all hold when the
+// predicate does. Operands of all() that aren't conditions on the target are
+// left out.
+func cfgTerms(predicate *messages.CfgPredicate) []cfgTerm {
+	switch predicate.Operator {
+	case messages.CfgOperator_CFG_OPERATOR_ALL:
+		var terms []cfgTerm
+		for _, operand := range predicate.Operands {
+			terms = append(terms, cfgTerms(operand)...)
+		}
+		return terms
+	case messages.CfgOperator_CFG_OPERATOR_NOT:
+		if len(predicate.Operands) != 1 {
+			return nil
+		}
+		if constraints := alternativeConstraints(predicate.Operands[0]); constraints != nil {
+			return []cfgTerm{{constraints: constraints, negated: true}}
+		}
+		if operand := predicate.Operands[0]; operand.Operator == messages.CfgOperator_CFG_OPERATOR_NOT && len(operand.Operands) == 1 {
+			return cfgTerms(operand.Operands[0])
+		}
+		return nil
+	}
+	if constraints := alternativeConstraints(predicate); constraints != nil {
+		return []cfgTerm{{constraints: constraints}}
+	}
+	return nil
+}
+
+// Return the constraints one of which holds exactly when the predicate does,
+// or nil if an option has no constraint.
+func alternativeConstraints(predicate *messages.CfgPredicate) []string {
+	switch predicate.Operator {
+	case messages.CfgOperator_CFG_OPERATOR_OPTION:
+		return optionConstraints(predicate)
+	case messages.CfgOperator_CFG_OPERATOR_ANY:
+		var constraints []string
+		for _, operand := range predicate.Operands {
+			operandConstraints := alternativeConstraints(operand)
+			if operandConstraints == nil {
+				return nil
+			}
+			for _, constraint := range operandConstraints {
+				if !slices.Contains(constraints, constraint) {
+					constraints = append(constraints, constraint)
+				}
+			}
+		}
+		return constraints
+	}
+	return nil
+}
+
+func optionConstraints(option *messages.CfgPredicate) []string {
+	if option.Value == nil {
+		return familyConstraints(option.Name)
+	}
+	switch option.Name {
+	case "target_os":
+		if constraint, ok := osConstraintByTargetOS[option.GetValue()]; ok {
+			return []string{constraint}
+		}
+	case "target_arch":
+		if constraint, ok := cpuConstraintByTargetArch[option.GetValue()]; ok {
+			return []string{constraint}
+		}
+	case "target_family":
+		return familyConstraints(option.GetValue())
+	}
+	return nil
+}
+
+func familyConstraints(family string) []string {
+	switch family {
+	case "unix":
+		constraints := make([]string, len(unixTargetOSes))
+		for i, targetOS := range unixTargetOSes {
+			constraints[i] = osConstraintByTargetOS[targetOS]
+		}
+		return constraints
+	case "windows":
+		return []string{osConstraintByTargetOS["windows"]}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package rust_language
+
+import (
+	"log"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// Set compile_data to the files in the package the rule's sources read with
+// include_str! and include_bytes!, replacing the existing rule's entries for
+// package files no longer included. Entries marked `# keep`, labels and files
+// not in the package are kept, and computed values, such as a glob(), are
+// left as written.
+func (l *rustLang) setCompileData(r *rule.Rule, dir string, sources []ParsedSource, existingRule *rule.Rule) {
+	if keepExistingAttr(r, existingRule, "compile_data", true) {
+		return
+	}
+	var compileData []string
+	if existingRule != nil && existingRule.Attr("compile_data") != nil {
+		packageFiles := l.listPackageFiles(dir, true)
+		for _, element := range existingRule.Attr("compile_data").(*bzl.ListExpr).List {
+			entry := element.(*bzl.StringExpr).Value
+			if existingRule.ShouldKeep() || rule.ShouldKeep(element) || !slices.Contains(packageFiles, entry) {
+				compileData = append(compileData, entry)
+			}
+		}
+	}
+	for _, source := range sources {
+		for _, file := range source.Response.IncludedFiles {
+			if included, ok := includedPackageFile(source, file); ok {
+				compileData = append(compileData, included)
+			}
+		}
+	}
+
+	slices.Sort(compileData)
+	if compileData = slices.Compact(compileData); len(compileData) > 0 {
+		r.SetAttr("compile_data", compileData)
+	}
+}
+
+// Report included files outside the package, which need a label the package
+// can't infer, unless compile_data has an entry for a file of the same name.
+func checkIncludedFiles(r *rule.Rule, sources []ParsedSource, from label.Label) {
+	for _, source := range sources {
+		for _, file := range source.Response.IncludedFiles {
+			if _, ok := includedPackageFile(source, file); ok {
+				continue
+			}
+			hasEntry := slices.ContainsFunc(r.AttrStrings("compile_data"), func(entry string) bool {
+				return path.Base(strings.ReplaceAll(entry, ":", "/")) == path.Base(file)
+			})
+			if !hasEntry {
+				log.Printf("%s: %s includes %s, which is outside the package; add it to compile_data by hand", from, source.Src, file)
+			}
+		}
+	}
+}
+
+// Return the package-relative path of a file a source includes.
+func includedPackageFile(source ParsedSource, file string) (string, bool) {
+	included := path.Join(path.Dir(source.Src), file)
+	if path.IsAbs(file) || included == ".." || strings.HasPrefix(included, "../") {
+		return "", false
+	}
+	return included, true
+}
+
+// Replace the compile_data entries under a directory of the package at dir
+// with the files it has now, returning how many there are. Directories outside
+// the package are left to be added by hand.
+func (l *rustLang) setDirectoryCompileData(r *rule.Rule, dir, directory string) (int, bool) {
+	if directory == ".." || strings.HasPrefix(directory, "../") {
+		return 0, false
+	}
+	var files []string
+	for _, file := range l.listPackageFiles(filepath.Join(dir, directory), true) {
+		files = append(files, path.Join(directory, file))
+	}
+	if r.Attr("compile_data") != nil && r.AttrStrings("compile_data") == nil {
+		// Computed, and left as written.
+		return len(files), true
+	}
+	compileData := slices.DeleteFunc(r.AttrStrings("compile_data"), func(entry string) bool {
+		return strings.HasPrefix(entry, directory+"/")
+	})
+	compileData = append(compileData, files...)
+	slices.Sort(compileData)
+	if compileData = slices.Compact(compileData); len(compileData) > 0 {
+		r.SetAttr("compile_data", compileData)
+	}
+	return len(files), true
+}
@@ -0,0 +1,1038 @@
+package rust_language
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+// Command-line flags, which apply to the whole run rather than a directory.
+type rustFlags struct {
+	// The repository deliberately has no Cargo.lock, so external imports
+	// resolve to guessed labels without a warning.
+	noLockfile bool
+	// Path to cargo-bazel-lock.json, relative to the repository root, for
+	// checking it against Cargo.lock. Disabled when empty.
+	crateUniverseLockfilePath string
+	// Number of parser subprocesses to run.
+	parserWorkers int
+	// Fail instead of guessing a label when an import can't be resolved.
+	strict bool
+	// Directory for caching parse results across runs. Disabled when empty.
+	cacheDir string
+	// Load rules from rules_rust directly instead of the repository macros.
+	canonicalLoads bool
+	// Address of a running parse service to use instead of subprocesses.
+	parserAddress string
+	// Share a background parse daemon across runs.
+	parserDaemon bool
+	// File recording directory fingerprints for incremental runs. Disabled
+	// when empty.
+	stateFile string
+	// Remove hand-maintained deps no source file imports.
+	pruneUnusedDeps bool
+	// "<package>:<import>" to explain instead of updating BUILD files.
+	resolveQuery string
+	// Fail when Cargo.toml dependencies and crate universe imports disagree.
+	checkCargoToml bool
+	// Fail when a source file can't be parsed.
+	strictParse bool
+	// Size in bytes above which source files aren't parsed. Disabled when
+	// zero.
+	maxSourceSize int64
+	// File the run's diagnostics are written to as SARIF. Disabled when
+	// empty.
+	sarifOutput string
+	// Print buildozer commands instead of writing BUILD files.
+	buildozer bool
+	// Print out of date rules instead of writing BUILD files.
+	check bool
+	// Directory of extracted crate sources that license reports read
+	// licenses from. The crates.io sources in CARGO_HOME when empty.
+	licenseSources string
+	// Checkout of the RustSec advisory database to audit crates against
+	// instead of updating BUILD files. Disabled when empty.
+	advisoryDatabase string
+	// File every crate name is written to with its label as JSON. Disabled
+	// when empty.
+	crateMapOutput string
+	// Crate map from before crates moved, to migrate their rules and labels.
+	// Disabled when empty.
+	migrateMoves string
+	// Command repinning the crate universe, printed or, with repin, run when
+	// rules depend on crates it doesn't pin.
+	repinCommand string
+	repin        bool
+	// Print the features rules require of crates next to those the crate
+	// universe builds them with, instead of writing BUILD files.
+	featureReport bool
+	// File the public API of every library is written to as JSON, and one
+	// written at another revision to print the differences from instead of
+	// writing BUILD files. Disabled when empty.
+	publicAPIOutput string
+	publicAPIDiff   string
+}
+
+// Configuration for the rust extension, stored in config.Config.Exts.
+type rustConfig struct {
+	// Label prefix for crates from the crate universe.
+	cratesPrefix string
+	// Path to Cargo.lock, relative to the repository root. Set with the prefix
+	// for a subtree by rust_crate_universe.
+	lockfilePath string
+	// Label prefixes of packages from other registries than crates.io, by
+	// index URL.
+	prefixByRegistry map[string]string
+
+	// Whether rules are generated in this directory.
+	enabled bool
+	// Which new rules are generated in this directory.
+	generationMode generationMode
+	// Visibility of newly generated libraries.
+	visibility []string
+	// Whether library visibility follows their consumers.
+	visibilityMode visibilityMode
+	// Features set as crate_features on newly generated rules.
+	crateFeatures []string
+	// Crates that never become dependencies, such as the standard library's.
+	builtinCrates map[string]bool
+	// Native libraries, such as cc_library targets, that rules importing a
+	// crate link against, typically for -sys crates.
+	nativeDepsByCrate map[string][]label.Label
+	// What rules binding a native library with #[link] need, by its name.
+	nativeLinkByName map[string]nativeLink
+	// Crates provided by rules outside the crate universe.
+	providedLabelByCrate map[string]string
+	// Crates providing macros invoked by a bare name.
+	crateByMacro map[string]string
+	// Crates providing derives used by a bare name, overriding the well-known
+	// ones in Cargo.lock.
+	crateByDerive map[string]string
+	// How imports claimed by several workspace crates are resolved.
+	ambiguousImports ambiguousImports
+	// The sources imports resolve from, in order, and overrides by crate.
+	resolutionOrder        []resolutionStep
+	resolutionOrderByCrate map[string][]resolutionStep
+	// Dependencies this subtree may not have, accumulated from ancestors.
+	forbiddenDependencies []dependencyPattern
+	// Whether forbidden dependencies fail the run or only log.
+	layeringEnforcement layeringEnforcement
+	// Root file of the library in this directory, when it isn't lib.rs. Not
+	// inherited by subdirectories.
+	crateRoot string
+	// Root files of further libraries in this directory, by name. Not
+	// inherited by subdirectories.
+	additionalCrateRootByName map[string]string
+	// Whether a directory's only source file becomes its library when there
+	// is no lib.rs.
+	singleFileLibrary bool
+	// Whether libraries exporting C symbols get shared and static variants.
+	ffiLibraries bool
+	// Test functions per shard of rust_test rules. Disabled when zero.
+	testShardThreshold int
+	// Deps added to every rust_test.
+	defaultTestDeps []label.Label
+	// Tags of rust_test rules whose tests are all, or only some, #[ignore]d.
+	ignoredTestTagsByCoverage map[ignoredTestCoverage][]string
+	// rustc_flags of generated rules.
+	rustcFlags []string
+	// Tags and rustc_flags of rules whose sources use nightly features.
+	nightlyTags       []string
+	nightlyRustcFlags []string
+	// Tags and target_compatible_with of firmware rules.
+	firmwareTags                 []string
+	firmwareTargetCompatibleWith []string
+	// Tags of rules whose sources contain unsafe code.
+	unsafeTags []string
+	// LLVM_PROFILE_FILE of rust_test rules built with coverage
+	// instrumentation, or empty to leave them as written. Disabled removes
+	// the instrumentation.
+	testCoverageProfile  string
+	testCoverageDisabled bool
+	// Platform rust_binary rules are built for, or label.NoLabel.
+	binaryPlatform label.Label
+	// Whether test files are collected from the subdirectories of packages,
+	// rather than only the package directory itself.
+	recursiveTests bool
+	// Name of a test_suite in this directory aggregating the tests of its
+	// subtree. Not inherited by subdirectories.
+	testSuite string
+	// Name of a rust_library_group in this directory aggregating the
+	// libraries of its subtree. Not inherited by subdirectories.
+	libraryGroup string
+	// Whether test files share a rust_test or each get one.
+	testTargets testTargets
+	// Globs of the file names of test roots.
+	testFilePatterns []string
+	// Globs of the package files rust_test targets get in data.
+	testDataGlobs []string
+	// Test files with their own main, by repository-relative path.
+	customHarnessFiles map[string]bool
+	// Files parsed regardless of -rust_max_source_size, by repository-relative
+	// path.
+	largeSources map[string]bool
+	// Name of a gen_rust_project target in this directory for its subtree.
+	// Not inherited by subdirectories.
+	rustAnalyzerProject string
+	// The workspace-hack crate libraries depend on, instead of the one
+	// hakari.toml names.
+	workspaceHack         label.Label
+	workspaceHackDisabled bool
+	// Whether binaries get a write_file target listing the licenses of the
+	// crates they depend on.
+	licenseReports bool
+	// Whether imports of workspace crates with an alias() depend on the alias.
+	resolveAliases bool
+	// Whether imports of crates in a rust_library_group depend on the group.
+	resolveLibraryGroups bool
+	// Where rules invoking sqlx query macros find offline query data,
+	// relative to their package.
+	sqlxOfflineDirectory string
+	// The migrations diesel's embed_migrations!() embeds without an argument,
+	// relative to the package.
+	dieselMigrationsDirectory string
+	// CARGO_PKG_* values overriding those of Cargo.toml, by variable.
+	cargoPackageEnvByVariable map[string]string
+	// How rust_prost_library crates are named.
+	prostCrateNaming prostCrateNaming
+}
+
+type generationMode string
+
+const (
+	// One library, binaries, and a test target per package, following the
+	// conventions in GenerateRules.
+	packageGenerationMode generationMode = "package"
+	// Only maintain existing rules; never add new ones.
+	updateOnlyGenerationMode generationMode = "update_only"
+	// Like package, but each top-level file that declares no modules and isn't
+	// a binary or test becomes its own library, named after the file.
+	fileGenerationMode generationMode = "file"
+)
+
+// Crates provided by external rules. Code generated by rust_prost_library
+// uses the prost toolchain's runtime crates, so sources mixing their own prost
+// types with generated ones have to use the same crates. pbjson_types and
+// tonic_reflection aren't part of the toolchain and resolve through Cargo.lock.
+var defaultProvidedLabelByCrate = map[string]string{
+	"prost":       "@rules_rust_prost//private/3rdparty/crates:prost",
+	"prost_types": "@rules_rust_prost//private/3rdparty/crates:prost-types",
+	"tonic":       "@rules_rust_prost//private/3rdparty/crates:tonic",
+	"runfiles":    "@rules_rust//tools/runfiles",
+}
+
+// Copy the configuration for a subdirectory, so directives only apply to the
+// subtree they are declared in.
+func (rc *rustConfig) clone() *rustConfig {
+	clone := *rc
+	clone.visibility = slices.Clone(rc.visibility)
+	clone.crateFeatures = slices.Clone(rc.crateFeatures)
+	clone.builtinCrates = maps.Clone(rc.builtinCrates)
+	clone.providedLabelByCrate = maps.Clone(rc.providedLabelByCrate)
+	clone.prefixByRegistry = maps.Clone(rc.prefixByRegistry)
+	clone.nativeDepsByCrate = maps.Clone(rc.nativeDepsByCrate)
+	clone.nativeLinkByName = maps.Clone(rc.nativeLinkByName)
+	clone.crateByMacro = maps.Clone(rc.crateByMacro)
+	clone.crateByDerive = maps.Clone(rc.crateByDerive)
+	clone.customHarnessFiles = maps.Clone(rc.customHarnessFiles)
+	clone.largeSources = maps.Clone(rc.largeSources)
+	clone.resolutionOrderByCrate = maps.Clone(rc.resolutionOrderByCrate)
+	clone.forbiddenDependencies = slices.Clone(rc.forbiddenDependencies)
+	clone.defaultTestDeps = slices.Clone(rc.defaultTestDeps)
+	clone.ignoredTestTagsByCoverage = maps.Clone(rc.ignoredTestTagsByCoverage)
+	clone.rustcFlags = slices.Clone(rc.rustcFlags)
+	clone.nightlyTags = slices.Clone(rc.nightlyTags)
+	clone.nightlyRustcFlags = slices.Clone(rc.nightlyRustcFlags)
+	clone.firmwareTags = slices.Clone(rc.firmwareTags)
+	clone.firmwareTargetCompatibleWith = slices.Clone(rc.firmwareTargetCompatibleWith)
+	clone.unsafeTags = slices.Clone(rc.unsafeTags)
+	clone.cargoPackageEnvByVariable = maps.Clone(rc.cargoPackageEnvByVariable)
+	return &clone
+}
+
+func getRustConfig(c *config.Config) *rustConfig {
+	return c.Exts[langName].(*rustConfig)
+}
+
+func (l *rustLang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
+	rc := &rustConfig{
+		enabled:                   true,
+		generationMode:            packageGenerationMode,
+		visibility:                []string{"//:__subpackages__"},
+		builtinCrates:             maps.Clone(defaultBuiltinCrates),
+		providedLabelByCrate:      maps.Clone(defaultProvidedLabelByCrate),
+		prefixByRegistry:          make(map[string]string),
+		nativeDepsByCrate:         make(map[string][]label.Label),
+		nativeLinkByName:          make(map[string]nativeLink),
+		crateByMacro:              maps.Clone(defaultCrateByMacro),
+		crateByDerive:             make(map[string]string),
+		ignoredTestTagsByCoverage: make(map[ignoredTestCoverage][]string),
+		visibilityMode:            fixedVisibilityMode,
+		ambiguousImports:          errorAmbiguousImports,
+		resolutionOrder:           defaultResolutionOrder,
+		resolutionOrderByCrate:    make(map[string][]resolutionStep),
+		layeringEnforcement:       warnLayeringEnforcement,
+		recursiveTests:            true,
+		sqlxOfflineDirectory:      defaultSqlxOfflineDirectory,
+		testTargets:               aggregatedTestTargets,
+		testFilePatterns:          defaultTestFilePatterns,
+		customHarnessFiles:        make(map[string]bool),
+		largeSources:              make(map[string]bool),
+		dieselMigrationsDirectory: defaultDieselMigrationsDirectory,
+		cargoPackageEnvByVariable: make(map[string]string),
+		prostCrateNaming:          prostCrateNaming{source: protoProstCrateNames},
+	}
+	c.Exts[langName] = rc
+	c.Exts[externalCratesByLockfileKey] = make(map[string]*ExternalCrates)
+	c.Exts[aliasesByTargetKey] = make(map[label.Label][]label.Label)
+	c.Exts[libraryGroupsByMemberKey] = make(map[label.Label][]label.Label)
+
+	fs.StringVar(&rc.cratesPrefix, "rust_crates_prefix", "@crates//:", "label prefix for external crates from the crate universe")
+	fs.StringVar(&rc.lockfilePath, "rust_lockfile", "Cargo.lock", "path to Cargo.lock, relative to the repository root")
+	fs.BoolVar(&l.flags.noLockfile, "rust_no_lockfile", false, "the repository has no Cargo.lock; resolve external imports to crate universe labels named after the import without warning")
+	fs.StringVar(&l.flags.crateUniverseLockfilePath, "rust_crate_universe_lockfile", "", "path to the crate universe's cargo-bazel-lock.json, relative to the repository root, to warn when it disagrees with Cargo.lock")
+	fs.IntVar(&l.flags.parserWorkers, "rust_parser_workers", 1, "number of Rust parser subprocesses")
+	fs.BoolVar(&l.flags.strict, "rust_strict", false, "fail when an import can't be resolved instead of guessing a crate label")
+	fs.StringVar(&l.flags.cacheDir, "rust_cache_dir", "", "directory for caching parse results across runs")
+	fs.BoolVar(&l.flags.canonicalLoads, "rust_canonical_loads", false, "load rules from @rules_rust//rust:defs.bzl instead of the repository macros")
+	fs.StringVar(&l.flags.parserAddress, "rust_parser_address", "", "host:port of a running `rust_parser listen` service to use instead of parser subprocesses, which should be loopback or firewalled to trusted clients")
+	fs.StringVar(&l.flags.stateFile, "rust_state_file", "", "file recording per-directory input fingerprints, so unchanged directories are skipped on later runs")
+	fs.BoolVar(&l.flags.pruneUnusedDeps, "rust_prune_unused_deps", false, "remove deps marked # keep, or on rules marked # keep, that no source file imports")
+	fs.StringVar(&l.flags.resolveQuery, "rust_resolve_query", "", "print how <package>:<import> resolves and exit without writing BUILD files")
+	fs.BoolVar(&l.flags.parserDaemon, "rust_parser_daemon", false, "share a background Rust parse daemon across gazelle runs, starting it if none is running; it exits once unused for 10 minutes")
+	fs.BoolVar(&l.flags.checkCargoToml, "rust_check_cargo_toml", false, "fail when an imported crate is missing from the nearest Cargo.toml, or a Cargo.toml dependency is never imported")
+	fs.BoolVar(&l.flags.strictParse, "rust_strict_parse", false, "fail without writing BUILD files when a source file can't be parsed")
+	fs.Int64Var(&l.flags.maxSourceSize, "rust_max_source_size", 0, "size in bytes above which source files are kept in srcs without being parsed, or 0 for no limit")
+	fs.BoolVar(&l.flags.buildozer, "rust_buildozer", false, "print the changes to Rust rules as buildozer commands and exit without writing BUILD files")
+	fs.BoolVar(&l.flags.check, "rust_check", false, "print the Rust rules that are out of date, by BUILD file and attribute, and exit without writing BUILD files, failing if any is")
+	fs.StringVar(&l.flags.licenseSources, "rust_license_sources", "", "directory of extracted crate sources, named <name>-<version>, that license reports read licenses from, relative to the repository root; defaults to the crates.io sources in CARGO_HOME")
+	fs.StringVar(&l.flags.sarifOutput, "rust_sarif_output", "", "file to write parse errors, unresolved imports and ambiguous imports to as SARIF, relative to the repository root, or - for stdout")
+	fs.StringVar(&l.flags.crateMapOutput, "rust_crate_map_output", "", "file to write every workspace, provided and Cargo.lock crate to as JSON, with its label and package, relative to the repository root, or - for stdout")
+	fs.StringVar(&l.flags.migrateMoves, "rust_migrate_moves", "", "crate map written by -rust_crate_map_output before crates moved, relative to the repository root; rename the rules of crates whose crate root moved to another directory and rewrite their labels")
+	fs.StringVar(&l.flags.repinCommand, "rust_repin_command", "", "shell command repinning the crate universe, such as `CARGO_BAZEL_REPIN=1 bazel mod deps`, printed when rules depend on crates -rust_crate_universe_lockfile doesn't pin")
+	fs.BoolVar(&l.flags.repin, "rust_repin", false, "run -rust_repin_command from the repository root when rules depend on crates -rust_crate_universe_lockfile doesn't pin, instead of printing it")
+	fs.BoolVar(&l.flags.featureReport, "rust_feature_report", false, "print the features each crate's consumers require of it in their Cargo.toml next to those -rust_crate_universe_lockfile builds it with, and exit without writing BUILD files, failing if any is missing")
+	fs.StringVar(&l.flags.publicAPIOutput, "rust_public_api_output", "", "file to write the public items of every rust_library to as JSON, relative to the repository root, or - for stdout")
+	fs.StringVar(&l.flags.publicAPIDiff, "rust_public_api_diff", "", "public API written by -rust_public_api_output at another revision, relative to the repository root; print the items each library added, changed or removed since, and exit without writing BUILD files, failing if any was changed or removed")
+	fs.StringVar(&l.flags.advisoryDatabase, "rust_advisory_db", "", "checkout of the RustSec advisory database, relative to the repository root; print the advisories affecting crates Rust rules depend on, with the rules, and exit without writing BUILD files, failing if any is a vulnerability")
+}
+
+func (l *rustLang) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
+	rc := getRustConfig(c)
+	flags := &l.flags
+
+	if rc.cratesPrefix == "" {
+		return fmt.Errorf("-rust_crates_prefix must not be empty")
+	}
+	if rc.lockfilePath == "" {
+		return fmt.Errorf("-rust_lockfile must not be empty")
+	}
+	if flags.noLockfile && flags.crateUniverseLockfilePath != "" {
+		return fmt.Errorf("-rust_no_lockfile can't be combined with -rust_crate_universe_lockfile, which is checked against Cargo.lock")
+	}
+	if flags.parserWorkers < 1 {
+		return fmt.Errorf("-rust_parser_workers must be at least 1, got %d", flags.parserWorkers)
+	}
+	if flags.cacheDir != "" && flags.parserAddress != "" {
+		// Cache entries are keyed by the local parser binary.
+		return fmt.Errorf("-rust_cache_dir can't be combined with -rust_parser_address")
+	}
+	if flags.parserDaemon && flags.parserAddress != "" {
+		return fmt.Errorf("-rust_parser_daemon can't be combined with -rust_parser_address")
+	}
+	if flags.maxSourceSize < 0 {
+		return fmt.Errorf("-rust_max_source_size must not be negative, got %d", flags.maxSourceSize)
+	}
+	if err := checkStateFileConflicts(flags, rc); err != nil {
+		return err
+	}
+	if flags.buildozer && flags.resolveQuery != "" {
+		return fmt.Errorf("-rust_buildozer can't be combined with -rust_resolve_query")
+	}
+	if flags.check && (flags.buildozer || flags.resolveQuery != "" || flags.advisoryDatabase != "") {
+		return fmt.Errorf("-rust_check can't be combined with -rust_buildozer, -rust_resolve_query or -rust_advisory_db")
+	}
+	if flags.advisoryDatabase != "" && (flags.buildozer || flags.resolveQuery != "") {
+		return fmt.Errorf("-rust_advisory_db can't be combined with -rust_buildozer or -rust_resolve_query")
+	}
+	if flags.repinCommand != "" && flags.crateUniverseLockfilePath == "" {
+		return fmt.Errorf("-rust_repin_command requires -rust_crate_universe_lockfile, which tells which crates are pinned")
+	}
+	if flags.repin && flags.repinCommand == "" {
+		return fmt.Errorf("-rust_repin requires -rust_repin_command")
+	}
+	if flags.repin && (flags.buildozer || flags.check || flags.resolveQuery != "" || flags.featureReport) {
+		// Those modes report rather than change the repository.
+		return fmt.Errorf("-rust_repin can't be combined with -rust_buildozer, -rust_check, -rust_resolve_query or -rust_feature_report")
+	}
+	if flags.featureReport && flags.crateUniverseLockfilePath == "" {
+		return fmt.Errorf("-rust_feature_report requires -rust_crate_universe_lockfile, which has the features crates are built with")
+	}
+	if flags.featureReport && (flags.buildozer || flags.check || flags.resolveQuery != "" || flags.advisoryDatabase != "") {
+		return fmt.Errorf("-rust_feature_report can't be combined with -rust_buildozer, -rust_check, -rust_resolve_query or -rust_advisory_db")
+	}
+	if flags.publicAPIDiff != "" && (flags.buildozer || flags.check || flags.resolveQuery != "" || flags.featureReport || flags.advisoryDatabase != "") {
+		return fmt.Errorf("-rust_public_api_diff can't be combined with -rust_buildozer, -rust_check, -rust_resolve_query, -rust_feature_report or -rust_advisory_db")
+	}
+	if flags.migrateMoves != "" && (flags.buildozer || flags.check) {
+		// Labels are rewritten in place rather than by generated rules.
+		return fmt.Errorf("-rust_migrate_moves can't be combined with -rust_buildozer or -rust_check")
+	}
+	if flags.cacheDir != "" {
+		if !filepath.IsAbs(flags.cacheDir) {
+			flags.cacheDir = filepath.Join(c.RepoRoot, flags.cacheDir)
+		}
+		if err := os.MkdirAll(flags.cacheDir, 0o755); err != nil {
+			return fmt.Errorf("-rust_cache_dir: %w", err)
+		}
+	}
+
+	if flags.stateFile != "" {
+		if !filepath.IsAbs(flags.stateFile) {
+			flags.stateFile = filepath.Join(c.RepoRoot, flags.stateFile)
+		}
+		state, err := loadIncrementalState(flags.stateFile, rc.lockfileAbsolutePath(c.RepoRoot))
+		if err != nil {
+			return fmt.Errorf("-rust_state_file: %w", err)
+		}
+		l.state = state
+	}
+
+	if flags.resolveQuery != "" {
+		query, err := parseResolveQuery(flags.resolveQuery, flags.strict)
+		if err != nil {
+			return err
+		}
+		l.resolveQuery = query
+	}
+
+	if flags.crateUniverseLockfilePath != "" {
+		universeLockfile, err := readCrateUniverseLockfile(c.RepoRoot, flags)
+		if err != nil {
+			return fmt.Errorf("-rust_crate_universe_lockfile: %w", err)
+		}
+		checkLockfileDrift(rc, flags, universeLockfile, getExternalCrates(c))
+		l.unpinnedCrates = newUnpinnedCrates(c.RepoRoot, rc, flags, universeLockfile)
+		l.buildScriptAnnotations = newBuildScriptAnnotations(flags, universeLockfile)
+		if flags.featureReport {
+			l.featureReport = newFeatureReport(flags, universeLockfile)
+		}
+	}
+
+	l.cargoManifests = newCargoManifestIndex(c.RepoRoot)
+	if flags.checkCargoToml {
+		l.cargoManifestCheck = newCargoManifestCheck(l.cargoManifests, getExternalCrates(c))
+	}
+
+	if flags.sarifOutput != "" {
+		if flags.sarifOutput != sarifStdout && !filepath.IsAbs(flags.sarifOutput) {
+			flags.sarifOutput = filepath.Join(c.RepoRoot, flags.sarifOutput)
+		}
+		l.sarifReport = newSarifReport(flags.sarifOutput, flags.strict)
+	}
+
+	if flags.buildozer || flags.check {
+		l.ruleChanges = newRuleChanges(c, l)
+		l.checkFreshness = flags.check
+	}
+
+	if flags.crateMapOutput != "" {
+		if flags.crateMapOutput != crateMapStdout && !filepath.IsAbs(flags.crateMapOutput) {
+			flags.crateMapOutput = filepath.Join(c.RepoRoot, flags.crateMapOutput)
+		}
+		l.crateMap = newCrateMap(flags.crateMapOutput, c.RepoRoot)
+	}
+
+	if flags.publicAPIOutput != "" || flags.publicAPIDiff != "" {
+		if flags.publicAPIOutput != "" && flags.publicAPIOutput != publicAPIStdout && !filepath.IsAbs(flags.publicAPIOutput) {
+			flags.publicAPIOutput = filepath.Join(c.RepoRoot, flags.publicAPIOutput)
+		}
+		if flags.publicAPIDiff != "" && !filepath.IsAbs(flags.publicAPIDiff) {
+			flags.publicAPIDiff = filepath.Join(c.RepoRoot, flags.publicAPIDiff)
+		}
+		api, err := newPublicAPI(flags.publicAPIOutput, flags.publicAPIDiff)
+		if err != nil {
+			return fmt.Errorf("-rust_public_api_diff: %w", err)
+		}
+		l.publicAPI = api
+	}
+
+	if flags.migrateMoves != "" {
+		if !filepath.IsAbs(flags.migrateMoves) {
+			flags.migrateMoves = filepath.Join(c.RepoRoot, flags.migrateMoves)
+		}
+		moves, err := loadCrateMoves(flags.migrateMoves, c.RepoRoot, flags.canonicalLoads)
+		if err != nil {
+			return fmt.Errorf("-rust_migrate_moves: %w", err)
+		}
+		l.crateMoves = moves
+	}
+
+	if flags.advisoryDatabase != "" {
+		if !filepath.IsAbs(flags.advisoryDatabase) {
+			flags.advisoryDatabase = filepath.Join(c.RepoRoot, flags.advisoryDatabase)
+		}
+		audit, err := newAdvisoryAudit(flags.advisoryDatabase)
+		if err != nil {
+			return fmt.Errorf("-rust_advisory_db: %w", err)
+		}
+		l.advisoryAudit = audit
+	}
+
+	l.hakariPackage = detectHakariPackage(c.RepoRoot)
+	l.parseDiagnostics = newParseDiagnostics(c.RepoRoot, flags.strictParse)
+	l.largeSources = newLargeSources(c.RepoRoot, flags.maxSourceSize)
+	licenseSourceDirectories := defaultLicenseSourceDirectories()
+	if flags.licenseSources != "" {
+		licenseSourceDirectories = []string{flags.licenseSources}
+		if !filepath.IsAbs(flags.licenseSources) {
+			licenseSourceDirectories = []string{filepath.Join(c.RepoRoot, flags.licenseSources)}
+		}
+	}
+	l.licenseReports = newLicenseReports(licenseSourceDirectories)
+	l.repoRoot = c.RepoRoot
+	l.parser = rust_analysis.NewParser(rust_analysis.ParserOptions{
+		WorkerCount: flags.parserWorkers,
+		CacheDir:    flags.cacheDir,
+		Address:     flags.parserAddress,
+		Daemon:      flags.parserDaemon,
+	})
+	return nil
+}
+
+const defaultCrateRoot = "lib.rs"
+
+// Return the root file of the library in this directory.
+func (rc *rustConfig) libraryCrateRoot() string {
+	if rc.crateRoot != "" {
+		return rc.crateRoot
+	}
+	return defaultCrateRoot
+}
+
+// Return the absolute path of the configured Cargo.lock.
+func (rc *rustConfig) lockfileAbsolutePath(repoRoot string) string {
+	if filepath.IsAbs(rc.lockfilePath) {
+		return rc.lockfilePath
+	}
+	return filepath.Join(repoRoot, rc.lockfilePath)
+}
+
+const (
+	extensionDirective        = "rust_extension"
+	generationModeDirective   = "rust_generation_mode"
+	visibilityDirective       = "rust_visibility"
+	visibilityModeDirective   = "rust_visibility_mode"
+	cratesPrefixDirective     = "rust_crates_prefix"
+	registryDirective         = "rust_registry"
+	crateUniverseDirective    = "rust_crate_universe"
+	crateFeaturesDirective    = "rust_crate_features"
+	builtinCratesDirective    = "rust_builtin_crates"
+	providedCrateDirective    = "rust_provided_crate"
+	nativeDepsDirective       = "rust_native_deps"
+	nativeLinkDirective       = "rust_native_link"
+	macroCrateDirective       = "rust_macro_crate"
+	deriveCrateDirective      = "rust_derive_crate"
+	ambiguousImportsDirective = "rust_ambiguous_imports"
+	resolutionOrderDirective  = "rust_resolution_order"
+	// Per crate resolution order: "<crate> <source>...".
+	crateResolutionOrderDirective = "rust_crate_resolution_order"
+	// Repeatable; each directive adds one pattern for the subtree.
+	forbiddenDependencyDirective  = "rust_forbidden_dependency"
+	layeringEnforcementDirective  = "rust_layering_enforcement"
+	singleFileLibraryDirective    = "rust_single_file_library"
+	ffiLibrariesDirective         = "rust_ffi_libraries"
+	testShardThresholdDirective   = "rust_test_shard_threshold"
+	defaultTestDepsDirective      = "rust_default_test_deps"
+	recursiveTestsDirective       = "rust_recursive_tests"
+	ignoredTestTagsDirective      = "rust_ignored_test_tags"
+	nightlyFeaturesDirective      = "rust_nightly_features"
+	firmwareDirective             = "rust_firmware"
+	unsafeTagsDirective           = "rust_unsafe_tags"
+	testCoverageDirective         = "rust_test_coverage"
+	binaryPlatformDirective       = "rust_binary_platform"
+	rustcFlagsDirective           = "rust_rustc_flags"
+	workspaceHackDirective        = "rust_workspace_hack"
+	licenseReportsDirective       = "rust_license_reports"
+	resolveAliasesDirective       = "rust_resolve_aliases"
+	resolveLibraryGroupsDirective = "rust_resolve_library_groups"
+	sqlxOfflineDirDirective       = "rust_sqlx_offline_dir"
+	dieselMigrationsDirDirective  = "rust_diesel_migrations_dir"
+	cargoPackageEnvDirective      = "rust_cargo_package_env"
+	prostCrateNamesDirective      = "rust_prost_crate_names"
+	// Apply only to the directory they are declared in.
+	crateRootDirective           = "rust_crate_root"
+	additionalLibraryDirective   = "rust_additional_library"
+	testSuiteDirective           = "rust_test_suite"
+	libraryGroupDirective        = "rust_library_group"
+	testTargetsDirective         = "rust_test_targets"
+	testFilePatternsDirective    = "rust_test_file_patterns"
+	customTestHarnessDirective   = "rust_custom_test_harness"
+	testDataDirective            = "rust_test_data"
+	largeSourcesDirective        = "rust_large_sources"
+	rustAnalyzerProjectDirective = "rust_analyzer_project"
+)
+
+func (*rustLang) KnownDirectives() []string {
+	return []string{
+		extensionDirective,
+		generationModeDirective,
+		visibilityDirective,
+		visibilityModeDirective,
+		cratesPrefixDirective,
+		registryDirective,
+		crateUniverseDirective,
+		crateFeaturesDirective,
+		builtinCratesDirective,
+		providedCrateDirective,
+		nativeDepsDirective,
+		nativeLinkDirective,
+		macroCrateDirective,
+		deriveCrateDirective,
+		ambiguousImportsDirective,
+		resolutionOrderDirective,
+		crateResolutionOrderDirective,
+		forbiddenDependencyDirective,
+		layeringEnforcementDirective,
+		crateRootDirective,
+		additionalLibraryDirective,
+		singleFileLibraryDirective,
+		ffiLibrariesDirective,
+		testShardThresholdDirective,
+		defaultTestDepsDirective,
+		recursiveTestsDirective,
+		ignoredTestTagsDirective,
+		nightlyFeaturesDirective,
+		firmwareDirective,
+		unsafeTagsDirective,
+		testCoverageDirective,
+		binaryPlatformDirective,
+		rustcFlagsDirective,
+		workspaceHackDirective,
+		licenseReportsDirective,
+		resolveAliasesDirective,
+		resolveLibraryGroupsDirective,
+		sqlxOfflineDirDirective,
+		dieselMigrationsDirDirective,
+		cargoPackageEnvDirective,
+		prostCrateNamesDirective,
+		testSuiteDirective,
+		libraryGroupDirective,
+		testTargetsDirective,
+		testFilePatternsDirective,
+		customTestHarnessDirective,
+		testDataDirective,
+		largeSourcesDirective,
+		rustAnalyzerProjectDirective,
+	}
+}
+
+func (l *rustLang) Configure(c *config.Config, rel string, f *rule.File) {
+	rc := getRustConfig(c).clone()
+	c.Exts[langName] = rc
+	rc.crateRoot = ""
+	rc.additionalCrateRootByName = make(map[string]string)
+	rc.testSuite = ""
+	rc.libraryGroup = ""
+	rc.rustAnalyzerProject = ""
+
+	if f == nil {
+		return
+	}
+
+	// Visibility directives in a directory replace the inherited visibility
+	// rather than appending to it.
+	var visibility []string
+
+	for _, directive := range f.Directives {
+		switch directive.Key {
+		case extensionDirective:
+			setEnabled(f, directive, &rc.enabled)
+		case generationModeDirective:
+			switch mode := generationMode(directive.Value); mode {
+			case packageGenerationMode, updateOnlyGenerationMode:
+				rc.generationMode = mode
+			case fileGenerationMode:
+				if !l.flags.canonicalLoads {
+					log.Printf("%s: %s %s requires -rust_canonical_loads; the repository macros only build one library per package", f.Path, generationModeDirective, mode)
+					continue
+				}
+				rc.generationMode = mode
+			default:
+				log.Printf("%s: invalid %s value %q, expected %q, %q or %q", f.Path, generationModeDirective, directive.Value, packageGenerationMode, updateOnlyGenerationMode, fileGenerationMode)
+			}
+		case visibilityDirective:
+			visibility = append(visibility, strings.Fields(directive.Value)...)
+		case visibilityModeDirective:
+			switch mode := visibilityMode(directive.Value); mode {
+			case fixedVisibilityMode, consumersVisibilityMode:
+				rc.visibilityMode = mode
+			default:
+				log.Printf("%s: invalid %s value %q, expected %q or %q", f.Path, visibilityModeDirective, directive.Value, fixedVisibilityMode, consumersVisibilityMode)
+			}
+		case cratesPrefixDirective:
+			if directive.Value == "" {
+				log.Printf("%s: %s must not be empty", f.Path, cratesPrefixDirective)
+				continue
+			}
+			rc.cratesPrefix = directive.Value
+		case registryDirective:
+			fields := strings.Fields(directive.Value)
+			switch len(fields) {
+			case 1:
+				delete(rc.prefixByRegistry, fields[0])
+			case 2:
+				rc.prefixByRegistry[fields[0]] = fields[1]
+			default:
+				log.Printf("%s: invalid %s value %q, expected a registry index URL and a label prefix", f.Path, registryDirective, directive.Value)
+			}
+		case crateUniverseDirective:
+			fields := strings.Fields(directive.Value)
+			if len(fields) != 2 {
+				log.Printf("%s: invalid %s value %q, expected a label prefix and a Cargo.lock path relative to the repository root", f.Path, crateUniverseDirective, directive.Value)
+				continue
+			}
+			rc.cratesPrefix = fields[0]
+			rc.lockfilePath = fields[1]
+		case crateFeaturesDirective:
+			// An empty value clears the inherited features.
+			rc.crateFeatures = strings.Fields(directive.Value)
+		case builtinCratesDirective:
+			for _, crate := range strings.Fields(directive.Value) {
+				rc.builtinCrates[strings.ReplaceAll(crate, "-", "_")] = true
+			}
+		case providedCrateDirective:
+			fields := strings.Fields(directive.Value)
+			if len(fields) != 2 {
+				log.Printf("%s: invalid %s value %q, expected \"<crate> <label>\"", f.Path, providedCrateDirective, directive.Value)
+				continue
+			}
+			rc.providedLabelByCrate[strings.ReplaceAll(fields[0], "-", "_")] = fields[1]
+		case nativeDepsDirective:
+			fields := strings.Fields(directive.Value)
+			if len(fields) == 0 {
+				log.Printf("%s: invalid %s value %q, expected \"<crate> <label>...\"", f.Path, nativeDepsDirective, directive.Value)
+				continue
+			}
+			var nativeDeps []label.Label
+			for _, value := range fields[1:] {
+				nativeDep, err := label.Parse(value)
+				if err != nil {
+					log.Printf("%s: invalid %s label %q: %v", f.Path, nativeDepsDirective, value, err)
+					continue
+				}
+				nativeDeps = append(nativeDeps, nativeDep.Abs("", rel))
+			}
+			rc.nativeDepsByCrate[strings.ReplaceAll(fields[0], "-", "_")] = nativeDeps
+		case nativeLinkDirective:
+			fields := strings.Fields(directive.Value)
+			if len(fields) == 0 {
+				log.Printf("%s: invalid %s value %q, expected \"<name> <label or rustc flag>...\"", f.Path, nativeLinkDirective, directive.Value)
+				continue
+			}
+			if len(fields) == 1 {
+				delete(rc.nativeLinkByName, fields[0])
+				continue
+			}
+			link, err := parseNativeLink(fields[1:])
+			if err != nil {
+				log.Printf("%s: invalid %s value %q: %v", f.Path, nativeLinkDirective, directive.Value, err)
+				continue
+			}
+			for i, dep := range link.deps {
+				link.deps[i] = dep.Abs("", rel)
+			}
+			rc.nativeLinkByName[fields[0]] = link
+		case macroCrateDirective:
+			fields := strings.Fields(directive.Value)
+			if len(fields) != 1 && len(fields) != 2 {
+				log.Printf("%s: invalid %s value %q, expected \"<macro> <crate>\"", f.Path, macroCrateDirective, directive.Value)
+				continue
+			}
+			crate := ""
+			if len(fields) == 2 {
+				crate = strings.ReplaceAll(fields[1], "-", "_")
+			}
+			rc.crateByMacro[fields[0]] = crate
+		case deriveCrateDirective:
+			fields := strings.Fields(directive.Value)
+			if len(fields) != 1 && len(fields) != 2 {
+				log.Printf("%s: invalid %s value %q, expected \"<derive> <crate>\"", f.Path, deriveCrateDirective, directive.Value)
+				continue
+			}
+			crate := ""
+			if len(fields) == 2 {
+				crate = strings.ReplaceAll(fields[1], "-", "_")
+			}
+			rc.crateByDerive[fields[0]] = crate
+		case ambiguousImportsDirective:
+			switch mode := ambiguousImports(directive.Value); mode {
+			case errorAmbiguousImports, rankAmbiguousImports:
+				rc.ambiguousImports = mode
+			default:
+				log.Printf("%s: invalid %s value %q, expected %q or %q", f.Path, ambiguousImportsDirective, directive.Value, errorAmbiguousImports, rankAmbiguousImports)
+			}
+		case resolutionOrderDirective:
+			order, err := parseResolutionOrder(strings.Fields(directive.Value))
+			if err != nil {
+				log.Printf("%s: invalid %s value %q: %v", f.Path, resolutionOrderDirective, directive.Value, err)
+				continue
+			}
+			rc.resolutionOrder = order
+		case crateResolutionOrderDirective:
+			fields := strings.Fields(directive.Value)
+			if len(fields) == 0 {
+				log.Printf("%s: invalid %s value %q, expected \"<crate> <source>...\"", f.Path, crateResolutionOrderDirective, directive.Value)
+				continue
+			}
+			order, err := parseResolutionOrder(fields[1:])
+			if err != nil {
+				log.Printf("%s: invalid %s value %q: %v", f.Path, crateResolutionOrderDirective, directive.Value, err)
+				continue
+			}
+			rc.resolutionOrderByCrate[strings.ReplaceAll(fields[0], "-", "_")] = order
+		case forbiddenDependencyDirective:
+			pattern, err := parseDependencyPattern(directive.Value)
+			if err != nil {
+				log.Printf("%s: invalid %s value: %v", f.Path, forbiddenDependencyDirective, err)
+				continue
+			}
+			rc.forbiddenDependencies = append(rc.forbiddenDependencies, pattern)
+		case layeringEnforcementDirective:
+			switch enforcement := layeringEnforcement(directive.Value); enforcement {
+			case warnLayeringEnforcement, errorLayeringEnforcement:
+				rc.layeringEnforcement = enforcement
+			default:
+				log.Printf("%s: invalid %s value %q, expected %q or %q", f.Path, layeringEnforcementDirective, directive.Value, warnLayeringEnforcement, errorLayeringEnforcement)
+			}
+		case crateRootDirective:
+			if !strings.HasSuffix(directive.Value, ".rs") || path.IsAbs(directive.Value) || strings.HasPrefix(path.Clean(directive.Value), "..") {
+				log.Printf("%s: invalid %s value %q, expected a .rs file in the directory", f.Path, crateRootDirective, directive.Value)
+				continue
+			}
+			if !l.flags.canonicalLoads && path.Clean(directive.Value) != defaultCrateRoot {
+				log.Printf("%s: %s requires -rust_canonical_loads; the repository macros only build lib.rs crate roots", f.Path, crateRootDirective)
+				continue
+			}
+			rc.crateRoot = path.Clean(directive.Value)
+		case additionalLibraryDirective:
+			fields := strings.Fields(directive.Value)
+			if len(fields) != 2 || !strings.HasSuffix(fields[1], ".rs") || path.IsAbs(fields[1]) || strings.HasPrefix(path.Clean(fields[1]), "..") {
+				log.Printf("%s: invalid %s value %q, expected a library name and a .rs file in the directory", f.Path, additionalLibraryDirective, directive.Value)
+				continue
+			}
+			if !l.flags.canonicalLoads {
+				log.Printf("%s: %s requires -rust_canonical_loads; the repository macros build one library per package", f.Path, additionalLibraryDirective)
+				continue
+			}
+			rc.additionalCrateRootByName[fields[0]] = path.Clean(fields[1])
+		case singleFileLibraryDirective:
+			setEnabled(f, directive, &rc.singleFileLibrary)
+		case recursiveTestsDirective:
+			switch directive.Value {
+			case "on":
+				rc.recursiveTests = true
+			case "off":
+				rc.recursiveTests = false
+			default:
+				log.Printf("%s: invalid %s value %q, expected \"on\" or \"off\"", f.Path, recursiveTestsDirective, directive.Value)
+			}
+		case testTargetsDirective:
+			switch mode := testTargets(directive.Value); mode {
+			case aggregatedTestTargets, perFileTestTargets:
+				rc.testTargets = mode
+			default:
+				log.Printf("%s: invalid %s value %q, expected %q or %q", f.Path, testTargetsDirective, directive.Value, aggregatedTestTargets, perFileTestTargets)
+			}
+		case testFilePatternsDirective:
+			patterns, err := parseTestFilePatterns(strings.Fields(directive.Value))
+			if err != nil {
+				log.Printf("%s: invalid %s value %q: %v", f.Path, testFilePatternsDirective, directive.Value, err)
+				continue
+			}
+			rc.testFilePatterns = patterns
+		case testDataDirective:
+			globs, err := parseTestDataGlobs(strings.Fields(directive.Value))
+			if err != nil {
+				log.Printf("%s: invalid %s value %q: %v", f.Path, testDataDirective, directive.Value, err)
+				continue
+			}
+			rc.testDataGlobs = globs
+		case customTestHarnessDirective:
+			for _, file := range strings.Fields(directive.Value) {
+				rc.customHarnessFiles[path.Join(rel, file)] = true
+			}
+		case testSuiteDirective:
+			if strings.ContainsAny(directive.Value, ":/ ") {
+				log.Printf("%s: invalid %s value %q, expected a target name", f.Path, testSuiteDirective, directive.Value)
+				continue
+			}
+			rc.testSuite = directive.Value
+		case libraryGroupDirective:
+			if strings.ContainsAny(directive.Value, ":/ ") {
+				log.Printf("%s: invalid %s value %q, expected a target name", f.Path, libraryGroupDirective, directive.Value)
+				continue
+			}
+			rc.libraryGroup = directive.Value
+		case rustAnalyzerProjectDirective:
+			if strings.ContainsAny(directive.Value, ":/ ") {
+				log.Printf("%s: invalid %s value %q, expected a target name", f.Path, rustAnalyzerProjectDirective, directive.Value)
+				continue
+			}
+			rc.rustAnalyzerProject = directive.Value
+		case largeSourcesDirective:
+			for _, file := range strings.Fields(directive.Value) {
+				rc.largeSources[path.Join(rel, file)] = true
+			}
+		case testShardThresholdDirective:
+			threshold, err := strconv.Atoi(directive.Value)
+			if err != nil || threshold < 0 {
+				log.Printf("%s: invalid %s value %q, expected a test count, or 0 to disable sharding", f.Path, testShardThresholdDirective, directive.Value)
+				continue
+			}
+			rc.testShardThreshold = threshold
+		case workspaceHackDirective:
+			if directive.Value == "disabled" {
+				rc.workspaceHackDisabled = true
+				continue
+			}
+			workspaceHack, err := label.Parse(directive.Value)
+			if err != nil {
+				log.Printf("%s: invalid %s value %q, expected a label or \"disabled\"", f.Path, workspaceHackDirective, directive.Value)
+				continue
+			}
+			rc.workspaceHack = workspaceHack.Abs("", rel)
+			rc.workspaceHackDisabled = false
+		case licenseReportsDirective:
+			setEnabled(f, directive, &rc.licenseReports)
+		case sqlxOfflineDirDirective:
+			if directive.Value == "" || path.IsAbs(directive.Value) {
+				log.Printf("%s: invalid %s value %q, expected a directory relative to the package", f.Path, sqlxOfflineDirDirective, directive.Value)
+				continue
+			}
+			rc.sqlxOfflineDirectory = directive.Value
+		case dieselMigrationsDirDirective:
+			if directive.Value == "" || path.IsAbs(directive.Value) {
+				log.Printf("%s: invalid %s value %q, expected a directory relative to the package", f.Path, dieselMigrationsDirDirective, directive.Value)
+				continue
+			}
+			rc.dieselMigrationsDirectory = directive.Value
+		case cargoPackageEnvDirective:
+			variable, value, _ := strings.Cut(strings.TrimSpace(directive.Value), " ")
+			if !strings.HasPrefix(variable, cargoPackageVariablePrefix) {
+				log.Printf("%s: invalid %s value %q, expected \"%s<name> <value>\"", f.Path, cargoPackageEnvDirective, directive.Value, cargoPackageVariablePrefix)
+				continue
+			}
+			rc.cargoPackageEnvByVariable[variable] = strings.TrimSpace(value)
+		case resolveAliasesDirective:
+			setEnabled(f, directive, &rc.resolveAliases)
+		case resolveLibraryGroupsDirective:
+			setEnabled(f, directive, &rc.resolveLibraryGroups)
+		case defaultTestDepsDirective:
+			var deps []label.Label
+			for _, value := range strings.Fields(directive.Value) {
+				dep, err := label.Parse(value)
+				if err != nil {
+					log.Printf("%s: invalid %s label %q: %v", f.Path, defaultTestDepsDirective, value, err)
+					continue
+				}
+				deps = append(deps, dep.Abs("", rel))
+			}
+			rc.defaultTestDeps = deps
+		case ignoredTestTagsDirective:
+			fields := strings.Fields(directive.Value)
+			if len(fields) == 0 || (fields[0] != string(allIgnoredTests) && fields[0] != string(someIgnoredTests)) {
+				log.Printf("%s: invalid %s value %q, expected \"%s|%s <tag>...\"", f.Path, ignoredTestTagsDirective, directive.Value, allIgnoredTests, someIgnoredTests)
+				continue
+			}
+			rc.ignoredTestTagsByCoverage[ignoredTestCoverage(fields[0])] = fields[1:]
+		case unsafeTagsDirective:
+			rc.unsafeTags = strings.Fields(directive.Value)
+		case testCoverageDirective:
+			switch directive.Value {
+			case "":
+				log.Printf("%s: invalid %s value %q, expected an LLVM_PROFILE_FILE pattern or \"disabled\"", f.Path, testCoverageDirective, directive.Value)
+			case "disabled":
+				rc.testCoverageProfile, rc.testCoverageDisabled = "", true
+			default:
+				rc.testCoverageProfile, rc.testCoverageDisabled = directive.Value, false
+			}
+		case rustcFlagsDirective:
+			rc.rustcFlags = strings.Fields(directive.Value)
+		case nightlyFeaturesDirective:
+			fields := strings.Fields(directive.Value)
+			if len(fields) == 0 || (fields[0] != "tags" && fields[0] != "rustc_flags") {
+				log.Printf("%s: invalid %s value %q, expected \"tags|rustc_flags <value>...\"", f.Path, nightlyFeaturesDirective, directive.Value)
+				continue
+			}
+			if fields[0] == "tags" {
+				rc.nightlyTags = fields[1:]
+			} else {
+				rc.nightlyRustcFlags = fields[1:]
+			}
+		case binaryPlatformDirective:
+			if directive.Value == "" {
+				rc.binaryPlatform = label.NoLabel
+				continue
+			}
+			platform, err := label.Parse(directive.Value)
+			if err != nil {
+				log.Printf("%s: invalid %s value %q, expected a label", f.Path, binaryPlatformDirective, directive.Value)
+				continue
+			}
+			rc.binaryPlatform = platform.Abs("", rel)
+		case prostCrateNamesDirective:
+			naming, err := parseProstCrateNaming(strings.Fields(directive.Value))
+			if err != nil {
+				log.Printf("%s: invalid %s value %q: %v", f.Path, prostCrateNamesDirective, directive.Value, err)
+				continue
+			}
+			rc.prostCrateNaming = naming
+		case firmwareDirective:
+			fields := strings.Fields(directive.Value)
+			if len(fields) == 0 || (fields[0] != "tags" && fields[0] != "target_compatible_with") {
+				log.Printf("%s: invalid %s value %q, expected \"tags|target_compatible_with <value>...\"", f.Path, firmwareDirective, directive.Value)
+				continue
+			}
+			if fields[0] == "tags" {
+				rc.firmwareTags = fields[1:]
+			} else {
+				rc.firmwareTargetCompatibleWith = fields[1:]
+			}
+		case ffiLibrariesDirective:
+			setEnabled(f, directive, &rc.ffiLibraries)
+		}
+	}
+
+	if len(visibility) > 0 {
+		rc.visibility = visibility
+	}
+	if err := checkStateFileConflicts(&l.flags, rc); err != nil {
+		log.Fatalf("%s: %v", f.Path, err)
+	}
+}
+
+// Set value from a directive that is "enabled" or "disabled".
+func setEnabled(f *rule.File, directive rule.Directive, value *bool) {
+	switch directive.Value {
+	case "enabled":
+		*value = true
+	case "disabled":
+		*value = false
+	default:
+		log.Printf("%s: invalid %s value %q, expected \"enabled\" or \"disabled\"", f.Path, directive.Key, directive.Value)
+	}
+}
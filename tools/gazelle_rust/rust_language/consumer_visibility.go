@@ -0,0 +1,84 @@
+package rust_language
+
+// `# gazelle:rust_visibility_mode consumers` narrows the visibility of each
+// library in the subtree to the packages whose rules depend on it, instead of
+// the configured rust_visibility. Libraries nothing depends on keep the
+// configured visibility, which may name a package_group; libraries only used
+// in their own package become private.
+//
+// Consumers are only known for packages resolved in this run, so the directive
+// can't be combined with -rust_state_file.
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+type visibilityMode string
+
+const (
+	// Always use the configured rust_visibility.
+	fixedVisibilityMode visibilityMode = "fixed"
+	// Use the packages consuming each library.
+	consumersVisibilityMode visibilityMode = "consumers"
+)
+
+type consumerVisibility struct {
+	consumerPackagesByLibrary map[string]map[string]bool
+	libraries                 []consumedLibrary
+}
+
+type consumedLibrary struct {
+	label label.Label
+	// The rule written to the BUILD file: the existing rule, or the new one.
+	rule               *rule.Rule
+	fallbackVisibility []string
+}
+
+func newConsumerVisibility() *consumerVisibility {
+	return &consumerVisibility{consumerPackagesByLibrary: make(map[string]map[string]bool)}
+}
+
+func (visibility *consumerVisibility) addConsumer(library label.Label, consumer label.Label) {
+	key := library.String()
+	if visibility.consumerPackagesByLibrary[key] == nil {
+		visibility.consumerPackagesByLibrary[key] = make(map[string]bool)
+	}
+	visibility.consumerPackagesByLibrary[key][consumer.Pkg] = true
+}
+
+func (visibility *consumerVisibility) addLibrary(library label.Label, r *rule.Rule, fallbackVisibility []string) {
+	if r.ShouldKeep() || rule.ShouldKeep(r.Attr("visibility")) {
+		return
+	}
+	visibility.libraries = append(visibility.libraries, consumedLibrary{
+		label:              library,
+		rule:               r,
+		fallbackVisibility: fallbackVisibility,
+	})
+}
+
+func (visibility *consumerVisibility) apply() {
+	for _, library := range visibility.libraries {
+		consumerPackages := visibility.consumerPackagesByLibrary[library.label.String()]
+		if len(consumerPackages) == 0 {
+			library.rule.SetAttr("visibility", library.fallbackVisibility)
+			continue
+		}
+
+		var values []string
+		for _, pkg := range slices.Sorted(maps.Keys(consumerPackages)) {
+			// Rules are always visible within their own package.
+			if pkg != library.label.Pkg {
+				values = append(values, label.New("", pkg, "__pkg__").String())
+			}
+		}
+		if len(values) == 0 {
+			values = []string{"//visibility:private"}
+		}
+		library.rule.SetAttr("visibility", values)
+	}
+}
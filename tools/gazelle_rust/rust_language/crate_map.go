@@ -0,0 +1,136 @@
+package rust_language
+
+// -rust_crate_map_output writes every crate name imports can resolve to, with
+// its label and package, as JSON, so other tools resolve crates the same way
+// gazelle does: workspace crates from the rule index, provided crates, and
+// the packages of each crate universe's Cargo.lock. A crate name appears once
+// per label providing it. Workspace crates also record their crate root and
+// its digest, which -rust_migrate_moves finds moved crates by.
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+// The -rust_crate_map_output value writing the map to stdout.
+const crateMapStdout = "-"
+
+type crateMap struct {
+	path             string
+	repoRoot         string
+	entryByKey       map[string]crateMapEntry
+	visitedUniverses map[string]bool
+}
+
+type crateMapFile struct {
+	Crates []crateMapEntry `json:"crates"`
+}
+
+type crateMapEntry struct {
+	Crate string `json:"crate"`
+	Label string `json:"label"`
+	// The Bazel package of the label.
+	Package string           `json:"package"`
+	Source  resolutionSource `json:"source"`
+	// The locked version, for Cargo.lock packages.
+	Version string `json:"version,omitempty"`
+	// The crate root, relative to the repository root, and the hex SHA-256
+	// of its content, for workspace crates.
+	CrateRoot       string `json:"crate_root,omitempty"`
+	CrateRootDigest string `json:"crate_root_digest,omitempty"`
+}
+
+func newCrateMap(path, repoRoot string) *crateMap {
+	return &crateMap{
+		path:             path,
+		repoRoot:         repoRoot,
+		entryByKey:       make(map[string]crateMapEntry),
+		visitedUniverses: make(map[string]bool),
+	}
+}
+
+func (crates *crateMap) add(entry crateMapEntry) {
+	crates.entryByKey[entry.Crate+" "+entry.Label] = entry
+}
+
+// Record a workspace rule providing a crate, with its crate root if known.
+func (crates *crateMap) addWorkspaceCrate(crateName string, from label.Label, crateRoot string) {
+	entry := crateMapEntry{Crate: crateName, Label: from.String(), Package: from.Pkg, Source: workspaceResolution}
+	if crateRoot != "" {
+		if digest, err := rust_analysis.FileDigest(filepath.Join(crates.repoRoot, crateRoot)); err == nil {
+			entry.CrateRoot = crateRoot
+			entry.CrateRootDigest = hex.EncodeToString(digest)
+		}
+	}
+	crates.add(entry)
+}
+
+// Record the provided crates and crate universe packages a directory's imports
+// can resolve to.
+func (crates *crateMap) addDirectory(rc *rustConfig, externalCrates *ExternalCrates) {
+	for crateName, providedLabel := range rc.providedLabelByCrate {
+		crates.add(crateMapEntry{Crate: crateName, Label: providedLabel, Package: labelPackage(providedLabel), Source: providedResolution})
+	}
+
+	key := rc.cratesPrefix + " " + rc.lockfilePath
+	if crates.visitedUniverses[key] {
+		return
+	}
+	crates.visitedUniverses[key] = true
+	for _, crate := range externalCrates.Packages() {
+		if crate.Source == "" {
+			continue
+		}
+		crateLabel := rc.lockedCrateLabel(crate)
+		crates.add(crateMapEntry{
+			Crate:   rust_analysis.NormalizeCrateName(crate.Name),
+			Label:   crateLabel,
+			Package: labelPackage(crateLabel),
+			Source:  lockfileResolution,
+			Version: crate.Version,
+		})
+	}
+}
+
+func labelPackage(value string) string {
+	parsed, err := label.Parse(value)
+	if err != nil {
+		return ""
+	}
+	return parsed.Pkg
+}
+
+func (crates *crateMap) write() {
+	entries := slices.Collect(maps.Values(crates.entryByKey))
+	slices.SortFunc(entries, func(a, b crateMapEntry) int {
+		return cmp.Or(cmp.Compare(a.Crate, b.Crate), cmp.Compare(a.Label, b.Label))
+	})
+	if entries == nil {
+		entries = []crateMapEntry{}
+	}
+	var contents bytes.Buffer
+	encoder := json.NewEncoder(&contents)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(crateMapFile{Crates: entries}); err != nil {
+		log.Fatalf("-rust_crate_map_output: %v", err)
+	}
+	if crates.path == crateMapStdout {
+		os.Stdout.Write(contents.Bytes())
+		return
+	}
+	if err := os.WriteFile(crates.path, contents.Bytes(), 0o644); err != nil {
+		log.Printf("-rust_crate_map_output: %v", err)
+	}
+}
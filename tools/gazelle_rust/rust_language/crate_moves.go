@@ -0,0 +1,212 @@
+package rust_language
+
+// -rust_migrate_moves reads a crate map written by -rust_crate_map_output
+// before crates were moved. A workspace crate whose crate root is gone from
+// where the map recorded it, and whose content is now a file of the same name
+// in another directory, moved there. Its rule takes the name of the new
+// package, a moved BUILD file's rule being renamed, and every label of the old
+// rule in the BUILD files gazelle visits is rewritten to the new one,
+// including deps marked # keep and references from other languages' rules.
+// With -rust_canonical_loads, crate_name keeps the crate name sources import;
+// the repository macros name crates after their package, so importers have to
+// be updated by hand.
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+type crateMove struct {
+	from label.Label
+	to   label.Label
+	// The crate root now, relative to the repository root.
+	crateRoot string
+	// The crate name sources imported before the move.
+	crateName string
+}
+
+type crateMoves struct {
+	moveByNewLabel map[label.Label]*crateMove
+	newLabelByOld  map[label.Label]label.Label
+	// Whether -rust_canonical_loads names crates after their rules.
+	canonicalLoads bool
+}
+
+func loadCrateMoves(crateMapPath, repoRoot string, canonicalLoads bool) (*crateMoves, error) {
+	data, err := os.ReadFile(crateMapPath)
+	if err != nil {
+		return nil, err
+	}
+	var recorded crateMapFile
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return nil, fmt.Errorf("%s: %w", crateMapPath, err)
+	}
+
+	// Crates whose root is gone, by the name and digest of the root.
+	missingByKey := make(map[string][]crateMapEntry)
+	recordedRoots := make(map[string]bool)
+	for _, entry := range recorded.Crates {
+		if entry.Source != workspaceResolution || entry.CrateRoot == "" {
+			continue
+		}
+		recordedRoots[entry.CrateRoot] = true
+		if _, err := os.Stat(filepath.Join(repoRoot, entry.CrateRoot)); errors.Is(err, fs.ErrNotExist) {
+			key := path.Base(entry.CrateRoot) + " " + entry.CrateRootDigest
+			missingByKey[key] = append(missingByKey[key], entry)
+		}
+	}
+
+	moves := &crateMoves{
+		moveByNewLabel: make(map[label.Label]*crateMove),
+		newLabelByOld:  make(map[label.Label]label.Label),
+		canonicalLoads: canonicalLoads,
+	}
+	if len(missingByKey) == 0 {
+		return moves, nil
+	}
+
+	crateRootNames := make(map[string]bool)
+	for key := range missingByKey {
+		name, _, _ := strings.Cut(key, " ")
+		crateRootNames[name] = true
+	}
+	candidatesByKey := make(map[string][]string)
+	err = filepath.WalkDir(repoRoot, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if file != repoRoot && (strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "bazel-")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !crateRootNames[entry.Name()] {
+			return nil
+		}
+		rel, err := filepath.Rel(repoRoot, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if recordedRoots[rel] {
+			return nil
+		}
+		digest, err := rust_analysis.FileDigest(file)
+		if err != nil {
+			return err
+		}
+		key := entry.Name() + " " + hex.EncodeToString(digest)
+		candidatesByKey[key] = append(candidatesByKey[key], rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(missingByKey)) {
+		entries := missingByKey[key]
+		candidates := candidatesByKey[key]
+		if len(candidates) == 0 {
+			continue
+		}
+		if len(entries) > 1 || len(candidates) > 1 {
+			log.Printf("%s: crate root content is now at %s; move the crate by hand", entries[0].CrateRoot, strings.Join(candidates, " and "))
+			continue
+		}
+		from, err := label.Parse(entries[0].Label)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", crateMapPath, err)
+		}
+		crateRoot := candidates[0]
+		pkg := path.Dir(crateRoot)
+		if pkg == "." {
+			pkg = ""
+		}
+		name := path.Base(pkg)
+		if pkg == "" {
+			name = filepath.Base(repoRoot)
+		}
+		move := &crateMove{from: from, to: label.New("", pkg, name), crateRoot: crateRoot, crateName: entries[0].Crate}
+		moves.moveByNewLabel[move.to] = move
+		moves.newLabelByOld[move.from] = move.to
+		log.Printf("%s: moved to %s, since its crate root %s is now %s", move.from, move.to, entries[0].CrateRoot, crateRoot)
+		if newCrateName := strings.ReplaceAll(pkg, "/", "__"); !canonicalLoads && newCrateName != move.crateName {
+			log.Printf("%s: crate name changes from %s to %s; update the sources importing it", move.to, move.crateName, newCrateName)
+		}
+	}
+	return moves, nil
+}
+
+// Rename the rules of moved crates in a BUILD file carried along with them,
+// and rewrite the labels of moved crates' old rules.
+func (moves *crateMoves) fix(f *rule.File) {
+	newLabelByOld := moves.newLabelByOld
+	for _, r := range f.Rules {
+		for to, move := range moves.moveByNewLabel {
+			if to.Pkg != f.Pkg || r.Name() != move.from.Name || r.Name() == to.Name || path.Join(f.Pkg, libraryCrateRoot(r)) != move.crateRoot {
+				continue
+			}
+			// Labels within the file still use the old name.
+			newLabelByOld = maps.Clone(newLabelByOld)
+			newLabelByOld[label.New("", f.Pkg, r.Name())] = to
+			r.SetName(to.Name)
+			moves.setCrateName(r, to)
+		}
+	}
+
+	for _, r := range f.Rules {
+		for _, key := range r.AttrKeys() {
+			if key == "name" {
+				continue
+			}
+			expr := r.Attr(key)
+			rewritten := false
+			bzl.Walk(expr, func(x bzl.Expr, _ []bzl.Expr) {
+				value, ok := x.(*bzl.StringExpr)
+				if !ok || !strings.HasPrefix(value.Value, "//") && !strings.HasPrefix(value.Value, ":") {
+					return
+				}
+				parsed, err := label.Parse(value.Value)
+				if err != nil || parsed.Repo != "" {
+					return
+				}
+				if to, ok := newLabelByOld[parsed.Abs("", f.Pkg)]; ok {
+					value.Value = to.Rel("", f.Pkg).String()
+					rewritten = true
+				}
+			})
+			if rewritten {
+				r.SetAttr(key, expr)
+			}
+		}
+	}
+}
+
+// Keep the crate name sources import on the rule of a moved crate, where
+// rules_rust allows it.
+func (moves *crateMoves) setCrateName(r *rule.Rule, to label.Label) {
+	move, ok := moves.moveByNewLabel[to]
+	if !ok || !moves.canonicalLoads || r.Attr("crate_name") != nil {
+		return
+	}
+	if strings.ReplaceAll(r.Name(), "-", "_") != move.crateName {
+		r.SetAttr("crate_name", move.crateName)
+	}
+}
@@ -0,0 +1,81 @@
+package rust_language
+
+// Rules in different directories can end up with the same crate name, such as
+// two rust_proc_macro rules named `derive`, or with -rust_canonical_loads two
+// libraries named `util`. Imports of the name then match both rules, and which
+// one a rule depends on depends on the ambiguity settings rather than the
+// source. Every crate name indexed for more than one rule is reported with
+// the rules sharing it and how to rename all but the first.
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+type crateNameCollisions struct {
+	ownersByCrateName map[string][]crateNameOwner
+}
+
+type crateNameOwner struct {
+	label label.Label
+	// How to give the rule another crate name.
+	fix string
+}
+
+func newCrateNameCollisions() *crateNameCollisions {
+	return &crateNameCollisions{ownersByCrateName: make(map[string][]crateNameOwner)}
+}
+
+func (collisions *crateNameCollisions) add(canonicalLoads bool, r *rule.Rule, pkg, crateName string) {
+	owner := crateNameOwner{label: label.New("", pkg, r.Name())}
+	if slices.ContainsFunc(collisions.ownersByCrateName[crateName], func(existing crateNameOwner) bool { return existing.label == owner.label }) {
+		return
+	}
+	switch {
+	case r.Kind() == "rust_prost_library":
+		owner.fix = fmt.Sprintf("name %s differently with # gazelle:%s", owner.label, prostCrateNamesDirective)
+	case canonicalLoads:
+		owner.fix = fmt.Sprintf("set crate_name = %q on %s", suggestedCrateName(owner.label), owner.label)
+	case r.Kind() == "rust_library":
+		// The repository macro names libraries after their package.
+		owner.fix = fmt.Sprintf("rename the directory of %s", owner.label)
+	default:
+		owner.fix = fmt.Sprintf("rename %s", owner.label)
+	}
+	collisions.ownersByCrateName[crateName] = append(collisions.ownersByCrateName[crateName], owner)
+}
+
+// Name a crate after its label, which is unique in the repository.
+func suggestedCrateName(target label.Label) string {
+	name := path.Join(target.Pkg, target.Name)
+	if path.Base(target.Pkg) == target.Name {
+		name = target.Pkg
+	}
+	return strings.NewReplacer("/", "_", "-", "_", ".", "_").Replace(name)
+}
+
+func (collisions *crateNameCollisions) report() {
+	for _, crateName := range slices.Sorted(maps.Keys(collisions.ownersByCrateName)) {
+		owners := collisions.ownersByCrateName[crateName]
+		if len(owners) < 2 {
+			continue
+		}
+		slices.SortFunc(owners, func(a, b crateNameOwner) int { return strings.Compare(a.label.String(), b.label.String()) })
+		labels := make([]string, len(owners))
+		for i, owner := range owners {
+			labels[i] = owner.label.String()
+		}
+		var fixes []string
+		for _, owner := range owners[1:] {
+			fixes = append(fixes, "\n  "+owner.fix)
+		}
+		log.Printf("crate name %s is shared by %s, so imports of it are ambiguous; to keep %s:%s", crateName, strings.Join(labels, " and "), owners[0].label, strings.Join(fixes, ""))
+	}
+}
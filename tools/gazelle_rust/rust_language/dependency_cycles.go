@@ -0,0 +1,114 @@
+package rust_language
+
+// Cycles among workspace libraries can't be built by rustc, and Bazel reports
+// them without the imports that caused them. Record the resolved edges between
+// libraries and report each cycle with the import behind every edge.
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+type dependencyGraph struct {
+	edgesByLibrary map[string][]dependencyEdge
+}
+
+type dependencyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Source file, relative to the repository root, and the import in it
+	// that resolved to the dependency.
+	File       string `json:"file"`
+	ImportName string `json:"import"`
+}
+
+func newDependencyGraph() *dependencyGraph {
+	return &dependencyGraph{edgesByLibrary: make(map[string][]dependencyEdge)}
+}
+
+func (graph *dependencyGraph) addLibrary(library label.Label) {
+	key := library.String()
+	if _, ok := graph.edgesByLibrary[key]; !ok {
+		graph.edgesByLibrary[key] = nil
+	}
+}
+
+func (graph *dependencyGraph) addEdge(from, to label.Label, src, importName string) {
+	key := from.String()
+	// One edge per dependency is enough to explain a cycle.
+	for _, edge := range graph.edgesByLibrary[key] {
+		if edge.To == to.String() {
+			return
+		}
+	}
+	graph.edgesByLibrary[key] = append(graph.edgesByLibrary[key], dependencyEdge{
+		From:       key,
+		To:         to.String(),
+		File:       path.Join(from.Pkg, src),
+		ImportName: importName,
+	})
+}
+
+// Return each cycle found by a depth-first search, as the edges along it.
+func (graph *dependencyGraph) cycles() [][]dependencyEdge {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	stateByLibrary := make(map[string]int)
+	// The libraries on the search path, and the edges between them.
+	var stack []string
+	var searchPath []dependencyEdge
+	var cycles [][]dependencyEdge
+
+	var visit func(library string)
+	visit = func(library string) {
+		stateByLibrary[library] = visiting
+		stack = append(stack, library)
+		edges := slices.Clone(graph.edgesByLibrary[library])
+		slices.SortFunc(edges, func(a, b dependencyEdge) int { return strings.Compare(a.To, b.To) })
+		for _, edge := range edges {
+			if _, ok := graph.edgesByLibrary[edge.To]; !ok {
+				// Not a Rust library, such as rust_prost_library.
+				continue
+			}
+			switch stateByLibrary[edge.To] {
+			case unvisited:
+				searchPath = append(searchPath, edge)
+				visit(edge.To)
+				searchPath = searchPath[:len(searchPath)-1]
+			case visiting:
+				start := slices.Index(stack, edge.To)
+				cycles = append(cycles, append(slices.Clone(searchPath[start:]), edge))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		stateByLibrary[library] = visited
+	}
+
+	for _, library := range slices.Sorted(maps.Keys(graph.edgesByLibrary)) {
+		if stateByLibrary[library] == unvisited {
+			visit(library)
+		}
+	}
+	return cycles
+}
+
+func (graph *dependencyGraph) report() {
+	for _, cycle := range graph.cycles() {
+		libraries := []string{cycle[0].From}
+		var imports []string
+		for _, edge := range cycle {
+			libraries = append(libraries, edge.To)
+			imports = append(imports, fmt.Sprintf("\n  %s: %s imports %q", edge.From, edge.File, edge.ImportName))
+		}
+		log.Printf("dependency cycle: %s%s", strings.Join(libraries, " -> "), strings.Join(imports, ""))
+	}
+}
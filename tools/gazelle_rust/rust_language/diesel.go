@@ -0,0 +1,35 @@
+package rust_language
+
+// diesel_migrations' `embed_migrations!` reads the migrations directory while
+// compiling, so rules invoking it get the directory's files in compile_data,
+// replacing entries for files since removed. The directory is the macro's
+// argument, relative to the package, or else migrations unless set with
+// `# gazelle:rust_diesel_migrations_dir <path>`. Schemas declared with
+// `diesel::table!` are plain sources and need nothing more.
+
+import (
+	"log"
+	"path"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+const defaultDieselMigrationsDirectory = "migrations"
+
+func (l *rustLang) setDieselMigrations(r *rule.Rule, rc *rustConfig, dir string, sources []ParsedSource) {
+	pkg := l.packageOf(dir)
+	for _, source := range sources {
+		for _, directory := range source.Response.EmbeddedMigrations {
+			if directory == "" {
+				directory = rc.dieselMigrationsDirectory
+			}
+			directory = path.Clean(directory)
+			switch files, inPackage := l.setDirectoryCompileData(r, dir, directory); {
+			case !inPackage:
+				log.Printf("//%s:%s: %s embeds migrations in %s, which is outside the package; add them to compile_data by hand", pkg, r.Name(), source.Src, directory)
+			case files == 0:
+				log.Printf("//%s:%s: %s embeds migrations in %s, which has no files", pkg, r.Name(), source.Src, directory)
+			}
+		}
+	}
+}
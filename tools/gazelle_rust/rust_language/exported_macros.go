@@ -0,0 +1,74 @@
+package rust_language
+
+// Crates written in the pre-2018 style invoke the macros another crate
+// exports with #[macro_export] by a bare name, with the dependency only
+// visible as a `#[macro_use] extern crate` elsewhere, if at all. Libraries
+// index each macro they export as `<name>!`, so bare macros no
+// `# gazelle:rust_macro_crate` maps resolve to the workspace crate exporting
+// them, or to no dependency.
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Private attribute holding the macros a generated library exports.
+const exportedMacrosAttr = "_rust_exported_macros"
+
+func exportedMacroImport(name string) string {
+	return name + "!"
+}
+
+func isExportedMacroImport(importName string) bool {
+	return strings.HasSuffix(importName, "!")
+}
+
+// Record the macros a library's sources export, for indexing.
+func setExportedMacros(r *rule.Rule, sources []ParsedSource) {
+	if r.Kind() != "rust_library" {
+		return
+	}
+	var macros []string
+	for _, source := range sources {
+		macros = append(macros, source.Response.ExportedMacros...)
+	}
+	r.SetPrivateAttr(exportedMacrosAttr, macros)
+}
+
+// Return the macros a library exports. Rules not generated in this run, such
+// as those in directories gazelle only indexes, are parsed here.
+func (l *rustLang) exportedMacros(c *config.Config, r *rule.Rule, pkg string) []string {
+	if macros, ok := r.PrivateAttr(exportedMacrosAttr).([]string); ok {
+		return macros
+	}
+
+	dir := filepath.Join(c.RepoRoot, pkg)
+	srcs := r.AttrStrings("srcs")
+	if srcsExpr := computedSrcs(r); srcsExpr != nil {
+		srcs, _ = l.expandSrcs(srcsExpr, dir)
+	}
+	var macros []string
+	for _, source := range l.parseSrcs(getRustConfig(c), dir, srcs) {
+		macros = append(macros, source.Response.ExportedMacros...)
+	}
+	return macros
+}
+
+// Resolve a bare macro to the workspace crate exporting it.
+func resolveExportedMacro(c *config.Config, ix *resolve.RuleIndex, rc *rustConfig, spec resolve.ImportSpec, from label.Label) importResolution {
+	matches := ix.FindRulesByImportWithConfig(c, spec, langName)
+	for _, match := range matches {
+		if match.IsSelfImport(from) {
+			return importResolution{source: selfResolution}
+		}
+	}
+	if len(matches) == 0 {
+		return importResolution{source: unexportedMacroResolution}
+	}
+	return workspaceImportResolution(c, rc, matches, from)
+}
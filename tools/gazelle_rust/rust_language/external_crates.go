@@ -1,94 +1,75 @@
 package rust_language
 
-// Metadata about external crates, parsed from Cargo.lock.
+// External crates, parsed from Cargo.lock with the renames declared in the
+// Cargo.toml beside it.
 
 import (
-	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strings"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
 )
 
+// Crates locked in Cargo.lock, for resolving imports to the crate universe.
 type ExternalCrates struct {
-	nameByImport map[string]string
+	*rust_analysis.ExternalCrates
+	// Cargo.lock doesn't exist, and whether that has been reported.
+	lockfileMissing         bool
+	lockfileMissingReported bool
 }
 
-func NewExternalCrates(repoRoot string) *ExternalCrates {
-	externalCrates := &ExternalCrates{
-		nameByImport: make(map[string]string),
-	}
+func newExternalCrates(lockfilePath string) *ExternalCrates {
+	externalCrates := &ExternalCrates{ExternalCrates: rust_analysis.NewExternalCrates(lockfilePath)}
 
-	lockfilePath := filepath.Join(repoRoot, "Cargo.lock")
-	if err := externalCrates.parseLockfile(lockfilePath); err != nil {
-		return externalCrates
+	// Members inherit renames from the workspace root with workspace = true,
+	// so the root's renames apply throughout the workspace.
+	if manifest, err := parseCargoManifest(filepath.Join(filepath.Dir(lockfilePath), "Cargo.toml")); err == nil {
+		for _, dependency := range manifest.dependencies {
+			externalCrates.AddRename(dependency.name, dependency.lockedPackage(manifest))
+		}
+		for _, dependency := range manifest.workspaceDependencyByImport {
+			externalCrates.AddRename(dependency.name, dependency.lockedPackage(manifest))
+		}
 	}
 
 	return externalCrates
 }
 
-func (externalCrates *ExternalCrates) GetName(importName string) string {
-	normalized := strings.ReplaceAll(importName, "-", "_")
-
-	if name, ok := externalCrates.nameByImport[normalized]; ok {
-		return name
-	}
-
-	return importName
-}
-
-var packageNameRegex = regexp.MustCompile(`^name\s*=\s*"([^"]+)"`)
-
-// Read Cargo.lock and extract package names.
-func (externalCrates *ExternalCrates) parseLockfile(path string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	inPackage := false
-	currentPackage := ""
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		trimmed := strings.TrimSpace(line)
+// Crates parsed from each Cargo.lock, shared by all directories' configs.
+const externalCratesByLockfileKey = "rust_external_crates"
 
-		if trimmed == "[[package]]" {
-			if currentPackage != "" {
-				normalized := strings.ReplaceAll(currentPackage, "-", "_")
-				externalCrates.nameByImport[normalized] = currentPackage
-			}
-
-			inPackage = true
-			currentPackage = ""
-			continue
-		}
-
-		if inPackage {
-			if matches := packageNameRegex.FindStringSubmatch(trimmed); len(matches) > 1 {
-				currentPackage = matches[1]
-			}
-		}
+// Return the crates of the Cargo.lock the directory resolves against.
+func getExternalCrates(c *config.Config) *ExternalCrates {
+	externalCratesByLockfile := c.Exts[externalCratesByLockfileKey].(map[string]*ExternalCrates)
+	lockfilePath := getRustConfig(c).lockfileAbsolutePath(c.RepoRoot)
+	if externalCrates, ok := externalCratesByLockfile[lockfilePath]; ok {
+		return externalCrates
 	}
-
-	if currentPackage != "" {
-		normalized := strings.ReplaceAll(currentPackage, "-", "_")
-		externalCrates.nameByImport[normalized] = currentPackage
+	externalCrates := newExternalCrates(lockfilePath)
+	if _, err := os.Stat(lockfilePath); errors.Is(err, fs.ErrNotExist) {
+		externalCrates.lockfileMissing = true
 	}
-
-	return scanner.Err()
+	externalCratesByLockfile[lockfilePath] = externalCrates
+	return externalCrates
 }
 
-func getExternalCrates(c *config.Config) *ExternalCrates {
-	const key = "rust_external_crates"
-	if externalCrates, ok := c.Exts[key].(*ExternalCrates); ok {
-		return externalCrates
+// Report, the first time an import is guessed, that the Cargo.lock doesn't
+// exist, failing under -rust_strict, unless -rust_no_lockfile declares there
+// is none.
+func (externalCrates *ExternalCrates) reportMissingLockfile(rc *rustConfig, flags *rustFlags) {
+	if !externalCrates.lockfileMissing || externalCrates.lockfileMissingReported || flags.noLockfile {
+		return
 	}
-	externalCrates := NewExternalCrates(c.RepoRoot)
-	c.Exts[key] = externalCrates
-	return externalCrates
+	externalCrates.lockfileMissingReported = true
+	message := fmt.Sprintf("%s does not exist, so imports of external crates resolve to guessed labels under %s; pass -rust_no_lockfile if the repository has no Cargo.lock", rc.lockfilePath, rc.cratesPrefix)
+	if flags.strict {
+		log.Fatal(message)
+	}
+	log.Print(message)
 }
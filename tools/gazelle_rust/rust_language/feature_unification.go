@@ -0,0 +1,168 @@
+package rust_language
+
+// -rust_feature_report prints, for each crates.io crate Rust rules depend on,
+// the features each rule's Cargo.toml requires of it, through the
+// dependency's `features = [...]`, its [workspace.dependencies] entry, and
+// the `<crate>/<feature>` entries of the [features] the rule's crate_features
+// enable, next to the features the crate universe builds it with. Cargo
+// unifies features across the workspace when pinning, so a required feature
+// the crate universe lacks, such as after editing Cargo.toml without
+// repinning, fails the build far from its cause. Gazelle exits before any
+// BUILD file is written, failing if any required feature is missing.
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+type featureReport struct {
+	lockfilePath string
+	// Keyed by "<name> <version>".
+	pinned              map[string]bool
+	configuredByCrate   map[string][]string
+	requirementsByCrate map[string][]featureRequirement
+}
+
+type featureRequirement struct {
+	rule label.Label
+	// The Cargo.toml requiring the features, relative to the repository root.
+	manifestPath string
+	features     []string
+}
+
+func newFeatureReport(flags *rustFlags, universeLockfile *crateUniverseLockfile) *featureReport {
+	report := &featureReport{
+		lockfilePath:        flags.crateUniverseLockfilePath,
+		pinned:              make(map[string]bool),
+		configuredByCrate:   make(map[string][]string),
+		requirementsByCrate: make(map[string][]featureRequirement),
+	}
+	for _, crate := range universeLockfile.Crates {
+		if len(crate.Repository) == 0 || string(crate.Repository) == "null" {
+			continue
+		}
+		key := crate.Name + " " + crate.Version
+		report.pinned[key] = true
+		features := slices.Clone(crate.CommonAttrs.CrateFeatures.Common)
+		for _, platformFeatures := range crate.CommonAttrs.CrateFeatures.Selects {
+			features = append(features, platformFeatures...)
+		}
+		slices.Sort(features)
+		report.configuredByCrate[key] = slices.Compact(features)
+	}
+	return report
+}
+
+// Record the features r's Cargo.toml requires of the crate it imports as
+// importName.
+func (report *featureReport) addDependency(manifests *cargoManifestIndex, r *rule.Rule, from label.Label, importName string, crate rust_analysis.ExternalCrate) {
+	if crate.Source != rust_analysis.CratesIORegistrySource {
+		return
+	}
+	manifest := manifests.manifestFor(from.Pkg)
+	if manifest == nil {
+		return
+	}
+	var crateFeatures []string
+	if _, ok := r.Attr("crate_features").(*bzl.ListExpr); ok {
+		crateFeatures = r.AttrStrings("crate_features")
+	}
+	features := manifest.requiredFeatures(importName, crateFeatures)
+	if len(features) == 0 {
+		return
+	}
+	key := crate.Name + " " + crate.Version
+	if slices.ContainsFunc(report.requirementsByCrate[key], func(requirement featureRequirement) bool { return requirement.rule == from }) {
+		return
+	}
+	report.requirementsByCrate[key] = append(report.requirementsByCrate[key], featureRequirement{rule: from, manifestPath: manifest.path, features: features})
+}
+
+// Return the features of the dependency imported as importName that the
+// manifest requires with crateFeatures enabled, sorted.
+func (manifest *indexedManifest) requiredFeatures(importName string, crateFeatures []string) []string {
+	name := rust_analysis.NormalizeCrateName(importName)
+	var features []string
+	for _, dependency := range manifest.dependencies {
+		if rust_analysis.NormalizeCrateName(dependency.name) != name {
+			continue
+		}
+		features = append(features, dependency.features...)
+		if dependency.inherited && manifest.workspaceRoot != nil {
+			if inherited, ok := manifest.workspaceRoot.workspaceDependencyByImport[name]; ok {
+				features = append(features, inherited.features...)
+			}
+		}
+	}
+
+	visited := make(map[string]bool)
+	pending := slices.Clone(crateFeatures)
+	for len(pending) > 0 {
+		feature := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if visited[feature] {
+			continue
+		}
+		visited[feature] = true
+		for _, value := range manifest.enabledByFeature[feature] {
+			dependencyName, dependencyFeature, isDependencyFeature := strings.Cut(value, "/")
+			switch {
+			case isDependencyFeature && rust_analysis.NormalizeCrateName(strings.TrimSuffix(dependencyName, "?")) == name:
+				// The rule imports the dependency, so a `name?/feature` entry
+				// applies too.
+				features = append(features, dependencyFeature)
+			case !isDependencyFeature && !strings.HasPrefix(value, "dep:"):
+				pending = append(pending, value)
+			}
+		}
+	}
+	slices.Sort(features)
+	return slices.Compact(features)
+}
+
+// Print the features each crate is required and built with, and exit.
+func (report *featureReport) finish() {
+	mismatched := 0
+	for _, key := range slices.Sorted(maps.Keys(report.requirementsByCrate)) {
+		configured := report.configuredByCrate[key]
+		switch {
+		case !report.pinned[key]:
+			fmt.Printf("%s: not pinned by %s\n", key, report.lockfilePath)
+		case len(configured) == 0:
+			fmt.Printf("%s: %s enables no features\n", key, report.lockfilePath)
+		default:
+			fmt.Printf("%s: %s enables %s\n", key, report.lockfilePath, strings.Join(configured, " "))
+		}
+
+		requirements := report.requirementsByCrate[key]
+		slices.SortFunc(requirements, func(a, b featureRequirement) int { return strings.Compare(a.rule.String(), b.rule.String()) })
+		var missing []string
+		for _, requirement := range requirements {
+			fmt.Printf("  %s requires %s (%s)\n", requirement.rule, strings.Join(requirement.features, " "), requirement.manifestPath)
+			for _, feature := range requirement.features {
+				if !slices.Contains(configured, feature) && !slices.Contains(missing, feature) {
+					missing = append(missing, feature)
+				}
+			}
+		}
+		if report.pinned[key] && len(missing) > 0 {
+			slices.Sort(missing)
+			fmt.Printf("  missing: %s\n", strings.Join(missing, " "))
+			mismatched++
+		}
+	}
+	if mismatched > 0 {
+		log.Fatalf("-rust_feature_report: %d crates are built without features that Rust rules require; repin the crate universe", mismatched)
+	}
+	os.Exit(0)
+}
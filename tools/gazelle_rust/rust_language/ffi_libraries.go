@@ -0,0 +1,49 @@
+package rust_language
+
+// `# gazelle:rust_ffi_libraries enabled` gives a package library whose sources
+// export C symbols rust_shared_library and rust_static_library variants built
+// from the same sources, so C and C++ rules can link against the crate. The
+// `<name>_cc` alias names the static library, which provides CcInfo, for use
+// in cc_library and cc_binary deps.
+
+import (
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+var ffiLibraryKinds = map[string]bool{
+	"rust_shared_library": true,
+	"rust_static_library": true,
+}
+
+func (l *rustLang) emitFFILibraries(result *language.GenerateResult, rc *rustConfig, dirName, dir string, existingRuleNames map[string]bool) {
+	var srcs []string
+	exportsCSymbols := false
+	for i, r := range result.Gen {
+		if r.Kind() != "rust_library" || r.Name() != dirName {
+			continue
+		}
+		srcs = r.AttrStrings("srcs")
+		for _, source := range result.Imports[i].(RuleData).Sources {
+			exportsCSymbols = exportsCSymbols || source.Response.ExportsCSymbols
+		}
+	}
+	if !exportsCSymbols {
+		return
+	}
+
+	if name := dirName + "_shared"; !existingRuleNames[name] {
+		l.emitNewRule(result, rc, "rust_shared_library", name, dir, srcs)
+	}
+	staticName := dirName + "_static"
+	if !existingRuleNames[staticName] {
+		l.emitNewRule(result, rc, "rust_static_library", staticName, dir, srcs)
+	}
+	if name := dirName + "_cc"; !existingRuleNames[name] {
+		alias := rule.NewRule("alias", name)
+		alias.SetAttr("actual", ":"+staticName)
+		alias.SetAttr("visibility", rc.visibility)
+		result.Gen = append(result.Gen, alias)
+		result.Imports = append(result.Imports, nil)
+	}
+}
@@ -0,0 +1,72 @@
+package rust_language
+
+// Firmware crates, those with `#![no_main]`, an embedded runtime's entry point
+// such as cortex-m-rt's `#[entry]`, or `#![no_std]` with a
+// `#[global_allocator]`, only build for their target platform. A file with an
+// entry point is a rust_binary like one with `fn main()`.
+// `# gazelle:rust_firmware tags <tag>...` tags firmware rules, such as
+// `manual` for keeping them out of host `bazel build //...`, and
+// `# gazelle:rust_firmware target_compatible_with <label>...` constrains them
+// to the platforms they run on. Giving no values clears the setting.
+
+import (
+	"slices"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+
+	messages "coppice/tools/gazelle_rust/proto"
+)
+
+func isFirmware(sources []ParsedSource) bool {
+	return slices.ContainsFunc(sources, func(source ParsedSource) bool {
+		return source.Response.NoMain || source.Response.HasEmbeddedEntry || source.Response.NoStd && source.Response.HasGlobalAllocator
+	})
+}
+
+// Report whether the file is a binary's crate root.
+func isBinaryRoot(response *messages.ParseResponse) bool {
+	return response.HasMain || response.HasEmbeddedEntry
+}
+
+// Set the target_compatible_with of firmware rules and crates gated on the
+// target with `#![cfg(...)]`, replacing any configured constraint the rule no
+// longer needs and keeping the existing rule's others. A select() or other
+// computed value is kept as written.
+func setTargetCompatibleWith(r *rule.Rule, rc *rustConfig, sources []ParsedSource, existingRule *rule.Rule) {
+	var constraints []string
+	if existingRule != nil && existingRule.Attr("target_compatible_with") != nil {
+		if _, ok := existingRule.Attr("target_compatible_with").(*bzl.ListExpr); !ok {
+			r.SetAttr("target_compatible_with", preservedExpr{expr: existingRule.Attr("target_compatible_with")})
+			return
+		}
+		for _, constraint := range existingRule.AttrStrings("target_compatible_with") {
+			if !slices.Contains(rc.firmwareTargetCompatibleWith, constraint) {
+				constraints = append(constraints, constraint)
+			}
+		}
+	}
+	if isFirmware(sources) {
+		constraints = append(constraints, rc.firmwareTargetCompatibleWith...)
+	}
+	derivedConstraints, selects := cfgConstraints(r, sources)
+	for _, constraint := range derivedConstraints {
+		if !slices.Contains(constraints, constraint) {
+			constraints = append(constraints, constraint)
+		}
+	}
+	var expr bzl.Expr
+	if len(constraints) > 0 {
+		expr = rule.ExprFromValue(constraints)
+	}
+	for _, selected := range selects {
+		if expr == nil {
+			expr = selected
+		} else {
+			expr = &bzl.BinaryExpr{X: expr, Op: "+", Y: selected}
+		}
+	}
+	if expr != nil {
+		r.SetAttr("target_compatible_with", expr)
+	}
+}
@@ -0,0 +1,61 @@
+package rust_language
+
+// -rust_check prints the Rust rules that are out of date, grouped by BUILD
+// file with one line per attribute change, and exits without writing BUILD
+// files, with status 1 if any rule is out of date. A presubmit running it
+// tells developers exactly which directories to run gazelle in.
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Print the out of date rules of every package and exit.
+func printStaleRules(changes *ruleChanges) {
+	var stalePackages []string
+	for _, change := range changes.collect() {
+		description := staleRuleDescription(change)
+		if description == "" {
+			continue
+		}
+		if len(stalePackages) == 0 || stalePackages[len(stalePackages)-1] != change.pkg {
+			stalePackages = append(stalePackages, change.pkg)
+			fmt.Printf("%s:\n", path.Join(change.pkg, changes.buildFileName))
+		}
+		fmt.Printf("  %s\n", description)
+	}
+	if len(stalePackages) == 0 {
+		os.Exit(0)
+	}
+	directories := make([]string, len(stalePackages))
+	for i, pkg := range stalePackages {
+		directories[i] = path.Join(".", pkg)
+	}
+	fmt.Printf("Rust rules are out of date; run gazelle in: %s\n", strings.Join(directories, " "))
+	os.Exit(1)
+}
+
+func staleRuleDescription(change ruleChange) string {
+	switch change.action {
+	case touchChange:
+		return "BUILD file missing"
+	case newChange:
+		return fmt.Sprintf("%s: missing %s", change.target, change.values[0])
+	case kindChange:
+		return fmt.Sprintf("%s: kind should be %s", change.target, change.values[0])
+	case addChange:
+		return fmt.Sprintf("%s: %s: missing %s", change.target, change.attr, strings.Join(change.values, " "))
+	case removeChange:
+		return fmt.Sprintf("%s: %s: stale %s", change.target, change.attr, strings.Join(change.values, " "))
+	case removeAttrChange:
+		return fmt.Sprintf("%s: %s: should be unset", change.target, change.attr)
+	case setChange, replaceChange:
+		return fmt.Sprintf("%s: %s: should be %s", change.target, change.attr, change.values[0])
+	case deleteChange:
+		return fmt.Sprintf("%s: stale rule", change.target)
+	}
+	// Loads come with the rules needing them.
+	return ""
+}
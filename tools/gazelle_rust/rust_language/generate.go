@@ -1,24 +1,115 @@
 package rust_language
 
 import (
-	"os"
 	"path"
 	"path/filepath"
-	"sort"
+	"slices"
 	"strings"
 
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
 
 	messages "coppice/tools/gazelle_rust/proto"
+	"coppice/tools/gazelle_rust/rust_analysis"
 )
 
 // Metadata about a generated rule for use during resolution.
 type RuleData struct {
-	Responses []*messages.ParseResponse
+	// Parse responses keep only what resolution reads; see
+	// resolutionResponse.
+	Sources []ParsedSource
+	// The rule in the existing BUILD file, if any, for checking the deps
+	// gazelle doesn't manage.
+	ExistingRule *rule.Rule
 }
 
 func (l *rustLang) GenerateRules(args language.GenerateArgs) language.GenerateResult {
+	rc := getRustConfig(args.Config)
+	if !rc.enabled {
+		return language.GenerateResult{}
+	}
+
+	if l.state != nil {
+		fingerprint, err := l.state.directoryFingerprint(args.Dir, rc.lockfileAbsolutePath(args.Config.RepoRoot), &l.flags, rc, args.File, l.listPackageFiles(args.Dir, true))
+		if err != nil {
+			l.state.invalidate(args.Rel)
+		} else if l.state.update(args.Rel, fingerprint) && rc.testSuite == "" && rc.libraryGroup == "" {
+			// The existing rules stand for this directory's rules.
+			l.state.skip(args.Rel)
+			if args.File != nil {
+				l.testSuites.addTests(args.Rel, args.File.Rules)
+				l.libraryGroups.addLibraries(args.Rel, args.File.Rules)
+			}
+			return language.GenerateResult{}
+		}
+		l.state.regenerate(args.Rel)
+	}
+
+	failureCount := len(l.parseDiagnostics.messageByFile)
+	result := l.generatePackageRules(args, rc)
+	checkSourceClaims(args.Rel, result.Gen)
+	if l.state != nil && len(l.parseDiagnostics.messageByFile) > failureCount {
+		// Regenerate next run, so the failures are reported again.
+		l.state.invalidate(args.Rel)
+	}
+	l.testSuites.addTests(args.Rel, result.Gen)
+	l.libraryGroups.addLibraries(args.Rel, result.Gen)
+	if rc.licenseReports {
+		for _, r := range slices.Clone(result.Gen) {
+			if r.Kind() == "rust_binary" {
+				l.emitLicenseReport(&result, args.File, r)
+			}
+		}
+	}
+	if rc.testSuite != "" {
+		l.testSuites.emitTestSuite(&result, args.Rel, rc.testSuite)
+	}
+	if rc.libraryGroup != "" {
+		l.libraryGroups.emitLibraryGroup(&result, rc, args.Rel, rc.libraryGroup)
+	}
+	if rc.rustAnalyzerProject != "" {
+		emitRustAnalyzerProject(&result, args.Rel, rc.rustAnalyzerProject)
+	}
+	if l.ruleChanges != nil {
+		l.ruleChanges.addPackage(args, result)
+	}
+	keepProvenances := l.sarifReport != nil || l.resolveQuery != nil
+	for i, imports := range result.Imports {
+		if ruleData, ok := imports.(RuleData); ok {
+			for j, source := range ruleData.Sources {
+				ruleData.Sources[j].Response = resolutionResponse(source.Response, keepProvenances, l.publicAPI != nil)
+			}
+			result.Imports[i] = ruleData
+		}
+	}
+	return result
+}
+
+// Return the parts of a parse response resolution reads, so the rest can be
+// freed once the directory's rules are generated rather than held for every
+// file of the repository until resolution. Import provenances are only read
+// for SARIF reports and resolve queries, and public items for the public API.
+func resolutionResponse(response *messages.ParseResponse, keepProvenances, keepPublicItems bool) *messages.ParseResponse {
+	distilled := &messages.ParseResponse{
+		Success:       response.Success,
+		Imports:       response.Imports,
+		BareMacros:    response.BareMacros,
+		BareDerives:   response.BareDerives,
+		IncludedFiles: response.IncludedFiles,
+		LinkNames:     response.LinkNames,
+	}
+	if keepProvenances {
+		distilled.ImportProvenances = response.ImportProvenances
+	}
+	if keepPublicItems {
+		distilled.PublicItems = response.PublicItems
+	}
+	return distilled
+}
+
+func (l *rustLang) generatePackageRules(args language.GenerateArgs, rc *rustConfig) language.GenerateResult {
 	result := language.GenerateResult{}
 
 	dirName := path.Base(args.Rel)
@@ -26,6 +117,7 @@ func (l *rustLang) GenerateRules(args language.GenerateArgs) language.GenerateRe
 		dirName = path.Base(args.Config.RepoRoot)
 	}
 
+	crateRoot := rc.libraryCrateRoot()
 	filesInExistingRules := make(map[string]bool)
 	existingRuleNames := make(map[string]bool)
 
@@ -36,20 +128,50 @@ func (l *rustLang) GenerateRules(args language.GenerateArgs) language.GenerateRe
 			existingRuleNames[existingRule.Name()] = true
 
 			kind := existingRule.Kind()
-			if kind != "rust_library" && kind != "rust_binary" && kind != "rust_test" {
+			if kind != "rust_library" && kind != "rust_binary" && kind != "rust_test" && !ffiLibraryKinds[kind] {
 				continue
 			}
 
 			var validSrcs []string
 
 			// Re-discover sources to pick up new files.
-			if kind == "rust_library" && fileExists(args.Dir, "lib.rs") {
-				validSrcs = l.discoverModules(args.Dir, "lib.rs")
-			} else if kind == "rust_test" {
-				validSrcs = l.collectTestFiles(args.Dir, filesInExistingRules)
+			if srcsExpr := computedSrcs(existingRule); srcsExpr != nil {
+				srcs, ok := l.expandSrcs(srcsExpr, args.Dir)
+				if !ok {
+					continue
+				}
+				validSrcs = srcs
+			} else if additionalRoot, ok := rc.additionalCrateRootByName[existingRule.Name()]; ok && kind == "rust_library" && l.fileExists(args.Dir, additionalRoot) {
+				validSrcs = l.discoverModules(rc, args.Dir, additionalRoot)
+			} else if (kind == "rust_library" || ffiLibraryKinds[kind]) && isPackageLibrary(existingRule, dirName, crateRoot) && l.fileExists(args.Dir, crateRoot) {
+				validSrcs = l.discoverModules(rc, args.Dir, crateRoot)
+			} else if kind == "rust_test" && !isCrateTest(existingRule) && rc.testTargets == perFileTestTargets {
+				srcs, ok := l.perFileTestSrcs(rc, existingRule, args.Dir, dirName)
+				if !ok {
+					result.Empty = append(result.Empty, rule.NewRule(kind, existingRule.Name()))
+					continue
+				}
+				validSrcs = srcs
+			} else if kind == "rust_test" && !isCrateTest(existingRule) {
+				// Bench and custom harness files move to rules of their own,
+				// unless no new rule can be generated for them.
+				tests, benches, customHarness := l.classifyTestFiles(rc, args.Dir, l.collectTestFiles(rc, args.Dir, filesInExistingRules))
+				harnessIndex := slices.IndexFunc(customHarness, func(file string) bool { return perFileTestName(file) == existingRule.Name() })
+				switch {
+				case existingRule.Name() == dirName+"_bench":
+					validSrcs = benches
+				case harnessIndex >= 0:
+					validSrcs = customHarness[harnessIndex : harnessIndex+1]
+				case rc.generationMode == updateOnlyGenerationMode:
+					validSrcs = slices.Concat(tests, benches, customHarness)
+				default:
+					validSrcs = tests
+				}
+			} else if binaryRoot := binaryCrateRoot(existingRule); kind == "rust_binary" && binaryRoot != "" && l.fileExists(args.Dir, binaryRoot) {
+				validSrcs = l.discoverModules(rc, args.Dir, binaryRoot)
 			} else {
 				for _, filename := range existingRule.AttrStrings("srcs") {
-					if fileExists(args.Dir, filename) {
+					if l.sourceExists(args.Dir, filename) {
 						validSrcs = append(validSrcs, filename)
 					}
 				}
@@ -59,20 +181,24 @@ func (l *rustLang) GenerateRules(args language.GenerateArgs) language.GenerateRe
 				filesInExistingRules[src] = true
 			}
 
-			l.cloneExistingRule(&result, kind, existingRule.Name(), args.Dir, validSrcs)
+			l.cloneExistingRule(&result, rc, existingRule, args.Dir, validSrcs)
 		}
 	}
 
+	if rc.generationMode == updateOnlyGenerationMode {
+		return result
+	}
+
 	// Collect candidate crate roots from the current directory.
 	// Module files and test files in subdirectories are discovered separately.
 	var crateRootCandidates []string
 	for _, filename := range args.RegularFiles {
-		if strings.HasSuffix(filename, ".rs") && !strings.Contains(filename, "/") {
+		if strings.HasSuffix(filename, ".rs") && !strings.Contains(filename, "/") && !l.isEmptyFile(rc, args.Dir, filename) {
 			crateRootCandidates = append(crateRootCandidates, filename)
 		}
 	}
 
-	if len(crateRootCandidates) == 0 {
+	if len(crateRootCandidates) == 0 && rc.crateRoot == "" && len(rc.additionalCrateRootByName) == 0 {
 		return result
 	}
 
@@ -81,24 +207,57 @@ func (l *rustLang) GenerateRules(args language.GenerateArgs) language.GenerateRe
 		claimedFiles[f] = true
 	}
 
-	// lib.rs -> rust_library
-	if fileExists(args.Dir, "lib.rs") && !filesInExistingRules["lib.rs"] && !existingRuleNames[dirName] {
-		srcs := l.discoverModules(args.Dir, "lib.rs")
+	// lib.rs, or the rust_crate_root file -> rust_library. A configured root
+	// may not exist yet when another rule generates it.
+	if (l.fileExists(args.Dir, crateRoot) && !l.isEmptyFile(rc, args.Dir, crateRoot) || rc.crateRoot != "") && !filesInExistingRules[crateRoot] && !existingRuleNames[dirName] {
+		srcs := l.discoverModules(rc, args.Dir, crateRoot)
 		for _, src := range srcs {
 			claimedFiles[src] = true
 		}
-		l.emitNewRule(&result, "rust_library", dirName, args.Dir, srcs)
+		l.emitNewRule(&result, rc, "rust_library", dirName, args.Dir, srcs)
+	}
+	l.emitAdditionalLibraries(&result, rc, args.Dir, existingRuleNames, claimedFiles)
+
+	// A directory's only file -> rust_library, with rust_single_file_library.
+	// rules_rust uses the single source as the crate root.
+	if rc.singleFileLibrary && rc.crateRoot == "" && len(crateRootCandidates) == 1 && !existingRuleNames[dirName] {
+		filename := crateRootCandidates[0]
+		if !claimedFiles[filename] && !rc.isTestFile(filename) {
+			response, err := l.parse(rc, path.Join(args.Dir, filename))
+			if err == nil && response.Success && !isBinaryRoot(response) && len(response.ExternalModules) == 0 {
+				l.emitNewRule(&result, rc, "rust_library", dirName, args.Dir, []string{filename})
+				claimedFiles[filename] = true
+			}
+		}
+	}
+
+	// Module-less files -> one rust_library each, in file generation mode.
+	if rc.generationMode == fileGenerationMode {
+		for _, filename := range crateRootCandidates {
+			targetName := strings.TrimSuffix(filename, ".rs")
+			if claimedFiles[filename] || rc.isTestFile(filename) || existingRuleNames[targetName] || targetName == dirName {
+				continue
+			}
+
+			response, err := l.parse(rc, path.Join(args.Dir, filename))
+			if err != nil || !response.Success || isBinaryRoot(response) || len(response.ExternalModules) > 0 {
+				continue
+			}
+
+			l.emitNewRule(&result, rc, "rust_library", targetName, args.Dir, []string{filename})
+			claimedFiles[filename] = true
+		}
 	}
 
-	// Files with `fn main()` -> rust_binary
+	// Files with `fn main()` or an embedded entry point -> rust_binary
 	for _, filename := range crateRootCandidates {
-		if claimedFiles[filename] || strings.HasSuffix(filename, "_test.rs") {
+		if claimedFiles[filename] || rc.isTestFile(filename) {
 			continue
 		}
 
 		fullPath := path.Join(args.Dir, filename)
-		response, err := l.parser.Parse(fullPath)
-		if err != nil || !response.Success || !response.HasMain {
+		response, err := l.parse(rc, fullPath)
+		if err != nil || !response.Success || !isBinaryRoot(response) {
 			continue
 		}
 
@@ -107,144 +266,303 @@ func (l *rustLang) GenerateRules(args language.GenerateArgs) language.GenerateRe
 			continue
 		}
 
-		l.emitNewRule(&result, "rust_binary", targetName, args.Dir, []string{filename})
+		l.emitNewRule(&result, rc, "rust_binary", targetName, args.Dir, []string{filename})
 		claimedFiles[filename] = true
 	}
 
-	// `*_test.rs` files -> rust_test
+	// Test files, `*_test.rs` unless rust_test_file_patterns is set -> rust_test
 	testRuleName := dirName + "_test"
-	if !existingRuleNames[testRuleName] {
-		testFiles := l.collectTestFiles(args.Dir, claimedFiles)
-		if len(testFiles) > 0 {
-			l.emitNewRule(&result, "rust_test", testRuleName, args.Dir, testFiles)
+	if rc.testTargets == perFileTestTargets {
+		for _, file := range l.collectTestFiles(rc, args.Dir, claimedFiles) {
+			if name := perFileTestName(file); !existingRuleNames[name] {
+				l.emitNewRule(&result, rc, "rust_test", name, args.Dir, []string{file})
+			}
+		}
+	} else {
+		testFiles, benchFiles, customHarnessFiles := l.classifyTestFiles(rc, args.Dir, l.collectTestFiles(rc, args.Dir, claimedFiles))
+		if len(testFiles) > 0 && !existingRuleNames[testRuleName] {
+			l.emitNewRule(&result, rc, "rust_test", testRuleName, args.Dir, testFiles)
+		}
+		// Files with #[bench] functions -> a rust_test of their own, since
+		// they only compile on nightly.
+		if benchRuleName := dirName + "_bench"; len(benchFiles) > 0 && !existingRuleNames[benchRuleName] {
+			l.emitNewRule(&result, rc, "rust_test", benchRuleName, args.Dir, benchFiles)
 		}
+		for _, file := range customHarnessFiles {
+			if name := perFileTestName(file); !existingRuleNames[name] {
+				l.emitNewRule(&result, rc, "rust_test", name, args.Dir, []string{file})
+			}
+		}
+	}
+
+	if rc.ffiLibraries {
+		l.emitFFILibraries(&result, rc, dirName, args.Dir, existingRuleNames)
 	}
 
 	return result
 }
 
-func (l *rustLang) emitNewRule(result *language.GenerateResult, kind, name, dir string, srcs []string) {
-	r := rule.NewRule(kind, name)
-	r.SetAttr("srcs", srcs)
-	if kind == "rust_library" {
-		r.SetAttr("visibility", []string{"//:__subpackages__"})
+// Report whether an existing library is the package's crate rooted at
+// crateRoot, rather than a per-file library.
+func isPackageLibrary(r *rule.Rule, dirName, crateRoot string) bool {
+	return r.Name() == dirName || slices.Contains(r.AttrStrings("srcs"), crateRoot)
+}
+
+// Report whether r is a test of another crate's sources, as in
+// `rust_test(name = "lib_test", crate = ":lib")`. rules_rust gives it the
+// crate's srcs and deps, so it has no srcs of its own and only needs deps
+// beyond the crate's.
+func isCrateTest(r *rule.Rule) bool {
+	return r.Kind() == "rust_test" && r.Attr("crate") != nil && r.Attr("srcs") == nil
+}
+
+// Return the crate root of an existing binary the way rules_rust infers it:
+// its crate_root, or else main.rs, the file named after the rule, or the only
+// source.
+func binaryCrateRoot(r *rule.Rule) string {
+	if crateRoot := r.AttrString("crate_root"); crateRoot != "" {
+		return crateRoot
 	}
-	result.Gen = append(result.Gen, r)
-	result.Imports = append(result.Imports, RuleData{Responses: l.parseSrcs(dir, srcs)})
+	srcs := r.AttrStrings("srcs")
+	for _, candidate := range []string{"main.rs", r.Name() + ".rs"} {
+		if slices.Contains(srcs, candidate) {
+			return candidate
+		}
+	}
+	if len(srcs) == 1 {
+		return srcs[0]
+	}
+	return ""
+}
+
+// Return the crate root of an existing library the way rules_rust infers it:
+// its crate_root, or else lib.rs, the file named after the rule, or the only
+// source.
+func libraryCrateRoot(r *rule.Rule) string {
+	if crateRoot := r.AttrString("crate_root"); crateRoot != "" {
+		return crateRoot
+	}
+	srcs := r.AttrStrings("srcs")
+	for _, candidate := range []string{defaultCrateRoot, r.Name() + ".rs"} {
+		if slices.Contains(srcs, candidate) {
+			return candidate
+		}
+	}
+	if len(srcs) == 1 {
+		return srcs[0]
+	}
+	return ""
 }
 
-func (l *rustLang) cloneExistingRule(result *language.GenerateResult, kind, name, dir string, srcs []string) {
+func (l *rustLang) emitNewRule(result *language.GenerateResult, rc *rustConfig, kind, name, dir string, srcs []string) {
 	r := rule.NewRule(kind, name)
 	r.SetAttr("srcs", srcs)
+	if kind == "rust_library" || ffiLibraryKinds[kind] {
+		crateRoot := rc.libraryCrateRoot()
+		if additionalRoot, ok := rc.additionalCrateRootByName[name]; ok {
+			crateRoot = additionalRoot
+		}
+		if crateRoot != defaultCrateRoot && slices.Contains(srcs, crateRoot) {
+			r.SetAttr("crate_root", crateRoot)
+		}
+		r.SetAttr("visibility", rc.visibility)
+		if l.crateMoves != nil {
+			l.crateMoves.setCrateName(r, label.New("", l.packageOf(dir), name))
+		}
+	}
+	if len(rc.crateFeatures) > 0 {
+		r.SetAttr("crate_features", rc.crateFeatures)
+	}
+	sources := l.parseSrcs(rc, dir, srcs)
+	if kind == "rust_test" {
+		l.setShardCount(r, rc, sources, nil)
+		l.setTestData(r, rc, dir, nil)
+		setTestCoverageEnv(r, rc, l.packageOf(dir), nil)
+	}
+	l.setTestHarness(r, rc, dir, sources)
+	setTags(r, rc, sources, nil)
+	setRustcFlags(r, rc, sources, nil)
+	setTargetCompatibleWith(r, rc, sources, nil)
+	setBinaryPlatform(r, rc, l.packageOf(dir), nil)
+	l.setCompileData(r, dir, sources, nil)
+	l.setSqlxOfflineData(r, rc, dir, sources)
+	l.setCargoPackageEnv(r, rc, dir, sources)
+	l.setDieselMigrations(r, rc, dir, sources)
+	setExportedMacros(r, sources)
 	result.Gen = append(result.Gen, r)
-	result.Imports = append(result.Imports, RuleData{Responses: l.parseSrcs(dir, srcs)})
+	result.Imports = append(result.Imports, RuleData{Sources: sources})
 }
 
-func (l *rustLang) parseSrcs(dir string, srcs []string) []*messages.ParseResponse {
-	var responses []*messages.ParseResponse
-	for _, src := range srcs {
-		if !strings.HasSuffix(src, ".rs") {
-			continue
-		}
-		response, err := l.parser.Parse(path.Join(dir, src))
-		if err == nil && response.Success {
-			responses = append(responses, response)
+func (l *rustLang) cloneExistingRule(result *language.GenerateResult, rc *rustConfig, existingRule *rule.Rule, dir string, srcs []string) {
+	r := rule.NewRule(existingRule.Kind(), existingRule.Name())
+	copyUnmanagedAttrs(r, existingRule, l.Kinds()[r.Kind()].MergeableAttrs)
+	if srcsExpr := computedSrcs(existingRule); srcsExpr != nil {
+		r.SetAttr("srcs", preservedExpr{expr: srcsExpr})
+	} else if !isCrateTest(existingRule) {
+		r.SetAttr("srcs", srcs)
+	}
+	if existingRule.Attr("deps") != nil {
+		// Keep the expression intact until resolution replaces its list.
+		r.SetAttr("deps", preservingDeps{})
+	}
+	if existingRule.Attr("proc_macro_deps") != nil {
+		r.SetAttr("proc_macro_deps", preservedExpr{expr: existingRule.Attr("proc_macro_deps")})
+	}
+	if existingRule.Attr("rustc_env") != nil {
+		// Kept as written, apart from the entries set below.
+		r.SetAttr("rustc_env", preservedExpr{expr: existingRule.Attr("rustc_env")})
+	}
+	sources := l.parseSrcs(rc, dir, srcs)
+	if r.Kind() == "rust_test" {
+		l.setShardCount(r, rc, sources, existingRule)
+		l.setTestData(r, rc, dir, existingRule)
+		if existingRule.Attr("env") != nil {
+			r.SetAttr("env", preservedExpr{expr: existingRule.Attr("env")})
 		}
+		setTestCoverageEnv(r, rc, l.packageOf(dir), existingRule)
 	}
-	return responses
+	l.setTestHarness(r, rc, dir, sources)
+	setTags(r, rc, sources, existingRule)
+	setRustcFlags(r, rc, sources, existingRule)
+	setTargetCompatibleWith(r, rc, sources, existingRule)
+	setBinaryPlatform(r, rc, l.packageOf(dir), existingRule)
+	l.setCompileData(r, dir, sources, existingRule)
+	l.setSqlxOfflineData(r, rc, dir, sources)
+	l.setCargoPackageEnv(r, rc, dir, sources)
+	l.setDieselMigrations(r, rc, dir, sources)
+	setExportedMacros(r, sources)
+	result.Gen = append(result.Gen, r)
+	result.Imports = append(result.Imports, RuleData{
+		Sources:      sources,
+		ExistingRule: existingRule,
+	})
 }
 
-// Recursively discovers all source files for a crate starting from a root file.
-func (l *rustLang) discoverModules(dir, rootFile string) []string {
-	srcs := []string{rootFile}
-	visited := make(map[string]bool)
-	visited[rootFile] = true
-
-	l.discoverModulesRecursive(dir, rootFile, &srcs, visited)
-
-	sort.Strings(srcs)
-	return srcs
+// Copy attributes the extension doesn't manage, such as edition or
+// crate_features, so the generated rule describes the whole target.
+func copyUnmanagedAttrs(r, existingRule *rule.Rule, managedAttrs map[string]bool) {
+	for _, key := range existingRule.AttrKeys() {
+		if key != "name" && !managedAttrs[key] {
+			r.SetAttr(key, existingRule.Attr(key))
+		}
+	}
 }
 
-func (l *rustLang) discoverModulesRecursive(dir, file string, srcs *[]string, visited map[string]bool) {
-	fullPath := filepath.Join(dir, file)
-	response, err := l.parser.Parse(fullPath)
-	if err != nil {
-		return
+// Keep an existing rule's attribute as written, reporting whether it was kept,
+// when no directive in this directory manages it, or when it is computed, such
+// as with a select() or a variable, rather than a list of strings to edit.
+func keepExistingAttr(r, existingRule *rule.Rule, attr string, managed bool) bool {
+	if existingRule == nil || existingRule.Attr(attr) == nil {
+		return false
 	}
-
-	fileDir := filepath.Dir(file)
-	if fileDir == "." {
-		fileDir = ""
+	if managed && isStringList(existingRule.Attr(attr)) {
+		return false
 	}
+	r.SetAttr(attr, preservedExpr{expr: existingRule.Attr(attr)})
+	return true
+}
 
-	for _, modName := range response.ExternalModules {
-		// Try adjacent file: {mod}.rs
-		adjacentFile := filepath.Join(fileDir, modName+".rs")
-		if !visited[adjacentFile] && fileExists(dir, adjacentFile) {
-			visited[adjacentFile] = true
-			*srcs = append(*srcs, adjacentFile)
-			l.discoverModulesRecursive(dir, adjacentFile, srcs, visited)
-			continue
+func isStringList(expr bzl.Expr) bool {
+	list, ok := expr.(*bzl.ListExpr)
+	if !ok {
+		return false
+	}
+	for _, element := range list.List {
+		if _, ok := element.(*bzl.StringExpr); !ok {
+			return false
 		}
+	}
+	return true
+}
 
-		// Try subdir with mod.rs: {mod}/mod.rs
-		modFile := filepath.Join(fileDir, modName, "mod.rs")
-		if !visited[modFile] && fileExists(dir, modFile) {
-			visited[modFile] = true
-			*srcs = append(*srcs, modFile)
-			l.discoverModulesRecursive(dir, modFile, srcs, visited)
+// Shard tests with more test functions than the rust_test_shard_threshold,
+// one shard per threshold's worth of tests. Without a threshold, an existing
+// rule's shard_count is left as written.
+func (l *rustLang) setShardCount(r *rule.Rule, rc *rustConfig, sources []ParsedSource, existingRule *rule.Rule) {
+	if rc.testShardThreshold == 0 {
+		if existingRule != nil && existingRule.Attr("shard_count") != nil {
+			r.SetAttr("shard_count", existingRule.Attr("shard_count"))
+		}
+		return
+	}
+	// The repository macro builds a test per source file, each with the same
+	// shard_count, so the largest file decides the count.
+	testCount := 0
+	for _, source := range sources {
+		if l.flags.canonicalLoads {
+			testCount += int(source.Response.TestCount)
+		} else {
+			testCount = max(testCount, int(source.Response.TestCount))
 		}
 	}
+	if testCount > rc.testShardThreshold {
+		r.SetAttr("shard_count", (testCount+rc.testShardThreshold-1)/rc.testShardThreshold)
+	}
 }
 
-// Find all `*_test.rs` files in the directory and subdirectories, stopping at
-// package boundaries (directories with BUILD files).
-func (l *rustLang) collectTestFiles(dir string, claimedFiles map[string]bool) []string {
-	var testFiles []string
+// A successfully parsed source file of a rule.
+type ParsedSource struct {
+	// Path relative to the rule's package.
+	Src      string
+	Response *messages.ParseResponse
+}
 
-	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
+func (l *rustLang) parseSrcs(rc *rustConfig, dir string, srcs []string) []ParsedSource {
+	var sources []ParsedSource
+	for _, src := range srcs {
+		if !strings.HasSuffix(src, ".rs") || l.isGeneratedFile(dir, src) {
+			continue
 		}
-
-		if info.IsDir() {
-			if p == dir {
-				return nil
-			}
-			if isPackageDir(p) {
-				return filepath.SkipDir
-			}
-			return nil
+		response, err := l.parse(rc, path.Join(dir, src))
+		if err == nil && response.Success {
+			sources = append(sources, ParsedSource{Src: src, Response: response})
 		}
+	}
+	return sources
+}
 
-		relPath, err := filepath.Rel(dir, p)
-		if err != nil {
-			return nil
-		}
+// Recursively discovers all source files for a crate starting from a root file.
+func (l *rustLang) discoverModules(rc *rustConfig, dir, rootFile string) []string {
+	return rust_analysis.DiscoverModules(packageSourceTree{l: l, rc: rc, dir: dir}, rootFile)
+}
 
-		if !claimedFiles[relPath] && strings.HasSuffix(relPath, "_test.rs") {
-			testFiles = append(testFiles, relPath)
-		}
+// The files gazelle's walk found in a package directory, parsed with parse
+// diagnostics recorded.
+type packageSourceTree struct {
+	l   *rustLang
+	rc  *rustConfig
+	dir string
+}
 
-		return nil
-	})
+func (tree packageSourceTree) Parse(file string) (*messages.ParseResponse, error) {
+	if tree.l.isGeneratedFile(tree.dir, file) {
+		return nil, rust_analysis.ErrGeneratedFile
+	}
+	return tree.l.parse(tree.rc, filepath.Join(tree.dir, file))
+}
 
-	sort.Strings(testFiles)
-	return testFiles
+func (tree packageSourceTree) Exists(file string) bool {
+	return tree.l.sourceExists(tree.dir, file)
 }
 
-// Check if a directory is a Bazel package.
-func isPackageDir(dir string) bool {
-	for _, buildFile := range []string{"BUILD", "BUILD.bazel", "MODULE.bazel"} {
-		if _, err := os.Stat(filepath.Join(dir, buildFile)); err == nil {
-			return true
+// Find all test files in the directory and, unless rust_recursive_tests
+// is off, its subdirectories, stopping at package boundaries (directories with
+// BUILD files).
+func (l *rustLang) collectTestFiles(rc *rustConfig, dir string, claimedFiles map[string]bool) []string {
+	var testFiles []string
+
+	for _, file := range l.listPackageFiles(dir, rc.recursiveTests) {
+		if !claimedFiles[file] && rc.isTestFile(file) && !l.isEmptyFile(rc, dir, file) {
+			testFiles = append(testFiles, file)
 		}
 	}
-	return false
+
+	return testFiles
 }
 
-func fileExists(dir, file string) bool {
-	info, err := os.Stat(filepath.Join(dir, file))
-	return err == nil && !info.IsDir()
+// Report whether a file has nothing to compile, such as only a license header
+// or cfg'd-out code, so it doesn't warrant a rule.
+func (l *rustLang) isEmptyFile(rc *rustConfig, dir, file string) bool {
+	response, err := l.parse(rc, path.Join(dir, file))
+	return err == nil && response.Success && response.IsEmpty
 }
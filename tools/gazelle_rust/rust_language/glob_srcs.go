@@ -0,0 +1,103 @@
+package rust_language
+
+// Existing rules may compute their srcs, as in `srcs = glob(["**/*.rs"])`.
+// The expression is kept as written, and the files its globs and lists match
+// are parsed for resolution. Rules with srcs that can't be evaluated, such as
+// a variable or a select, are left untouched.
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// A value implementing rule.Merger that keeps the existing expression.
+type preservedExpr struct {
+	expr bzl.Expr
+}
+
+func (value preservedExpr) BzlExpr() bzl.Expr {
+	return value.expr
+}
+
+func (value preservedExpr) Merge(existing bzl.Expr) bzl.Expr {
+	return existing
+}
+
+// Return the srcs expression of r if it's anything other than a list.
+func computedSrcs(r *rule.Rule) bzl.Expr {
+	expr := r.Attr("srcs")
+	if _, ok := expr.(*bzl.ListExpr); ok {
+		return nil
+	}
+	return expr
+}
+
+// Return the package files a srcs expression of glob() calls and lists
+// matches, or false if it contains anything else.
+func (l *rustLang) expandSrcs(expr bzl.Expr, dir string) ([]string, bool) {
+	var srcs []string
+	var packageFiles []string
+	for _, part := range sumParts(expr) {
+		if list, ok := part.(*bzl.ListExpr); ok {
+			for _, element := range list.List {
+				str, ok := element.(*bzl.StringExpr)
+				if !ok {
+					return nil, false
+				}
+				if l.sourceExists(dir, str.Value) {
+					srcs = append(srcs, str.Value)
+				}
+			}
+			continue
+		}
+
+		glob, ok := rule.ParseGlobExpr(part)
+		if !ok {
+			return nil, false
+		}
+		if packageFiles == nil {
+			packageFiles = l.listPackageFiles(dir, true)
+		}
+		for _, file := range packageFiles {
+			if matchesAnyGlob(glob.Patterns, file) && !matchesAnyGlob(glob.Excludes, file) {
+				srcs = append(srcs, file)
+			}
+		}
+	}
+	slices.Sort(srcs)
+	return slices.Compact(srcs), true
+}
+
+func matchesAnyGlob(patterns []string, file string) bool {
+	for _, pattern := range patterns {
+		if matchGlobSegments(strings.Split(pattern, "/"), strings.Split(file, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match path segments against pattern segments, where `**` matches any
+// number of segments.
+func matchGlobSegments(patterns, segments []string) bool {
+	if len(patterns) == 0 {
+		return len(segments) == 0
+	}
+	if patterns[0] == "**" {
+		for i := range len(segments) + 1 {
+			if matchGlobSegments(patterns[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	matched, err := path.Match(patterns[0], segments[0])
+	return err == nil && matched && matchGlobSegments(patterns[1:], segments[1:])
+}
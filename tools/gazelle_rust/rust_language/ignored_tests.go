@@ -0,0 +1,30 @@
+package rust_language
+
+// `# gazelle:rust_ignored_test_tags all <tag>...` tags rust_test rules whose
+// tests are all #[ignore]d, and `# gazelle:rust_ignored_test_tags mixed
+// <tag>...` those where only some are, so suites known to be skipped can be
+// filtered out with --test_tag_filters or kept out of wildcards with `manual`.
+// Giving no tags clears the mapping.
+
+type ignoredTestCoverage string
+
+const (
+	allIgnoredTests  ignoredTestCoverage = "all"
+	someIgnoredTests ignoredTestCoverage = "mixed"
+)
+
+func ignoredTestTags(rc *rustConfig, sources []ParsedSource) []string {
+	testCount, ignoredTestCount := 0, 0
+	for _, source := range sources {
+		testCount += int(source.Response.TestCount)
+		ignoredTestCount += int(source.Response.IgnoredTestCount)
+	}
+	switch {
+	case ignoredTestCount == 0:
+		return nil
+	case ignoredTestCount == testCount:
+		return rc.ignoredTestTagsByCoverage[allIgnoredTests]
+	default:
+		return rc.ignoredTestTagsByCoverage[someIgnoredTests]
+	}
+}
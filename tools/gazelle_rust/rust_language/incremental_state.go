@@ -0,0 +1,302 @@
+package rust_language
+
+// Per-directory fingerprints of generation inputs, persisted between runs so
+// directories whose inputs haven't changed are skipped.
+//
+// A fingerprint covers the directory's Rust sources (up to package
+// boundaries), the kinds and names of its existing rules, the directory's
+// configuration, and Cargo.lock. Skipped directories keep their BUILD files
+// as they are, and their existing rules are still indexed for resolution. Moving
+// a crate to another package without editing its importers leaves their deps
+// stale until they are regenerated, so run without the state file after such
+// moves. Reports and directives that need every directory's rules on every run
+// refuse the state file. What resolving a directory contributes to the checks
+// that always run, such as dependency cycles and layering warnings, is recorded
+// with its fingerprint and replayed while it's skipped.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+// Bump whenever generation changes in a way that makes old fingerprints
+// unreliable.
+const incrementalStateVersion = 2
+
+type incrementalState struct {
+	path string
+	// Digests of the Cargo.lock files directories resolve against, nil for
+	// missing ones.
+	digestByLockfile map[string][]byte
+	// Fingerprints from the previous run, including directories this run
+	// doesn't visit.
+	fingerprintByDirectory map[string]string
+	// Recorded alongside the fingerprints: by this run for directories it
+	// regenerates, and by earlier runs for the rest.
+	resolutionByDirectory map[string]*directoryResolution
+	// Directories this run skips, in the order visited.
+	skippedDirectories []string
+}
+
+// What resolving a directory's rules contributed to the checks that run over
+// the whole repository. Only warnings are recorded: a run failing a check
+// isn't saved.
+type directoryResolution struct {
+	// The dependency edges of each library, for finding cycles.
+	EdgesByLibrary map[string][]dependencyEdge `json:"edges_by_library,omitempty"`
+	// External crates the crate universe doesn't pin.
+	UnpinnedDependencies []unpinnedDependency `json:"unpinned_dependencies,omitempty"`
+	// Such as layering violations and unused kept deps.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type unpinnedDependency struct {
+	Dependency string `json:"dependency"`
+	From       string `json:"from"`
+}
+
+type incrementalStateFile struct {
+	Version                int                             `json:"version"`
+	FingerprintByDirectory map[string]string               `json:"fingerprint_by_directory"`
+	ResolutionByDirectory  map[string]*directoryResolution `json:"resolution_by_directory,omitempty"`
+}
+
+// Fail when the state file is combined with flags, or directives in rc, that
+// need every directory generated on every run.
+func checkStateFileConflicts(flags *rustFlags, rc *rustConfig) error {
+	if flags.stateFile == "" {
+		return nil
+	}
+	options := []struct {
+		name string
+		set  bool
+	}{
+		{"-rust_check_cargo_toml", flags.checkCargoToml},
+		{"-rust_buildozer", flags.buildozer},
+		{"-rust_check", flags.check},
+		{"-rust_sarif_output", flags.sarifOutput != ""},
+		{"-rust_crate_map_output", flags.crateMapOutput != ""},
+		{"-rust_advisory_db", flags.advisoryDatabase != ""},
+		{"-rust_feature_report", flags.featureReport},
+		{"-rust_public_api_output", flags.publicAPIOutput != ""},
+		{"-rust_public_api_diff", flags.publicAPIDiff != ""},
+		{"# gazelle:" + licenseReportsDirective + " enabled", rc.licenseReports},
+		{"# gazelle:" + visibilityModeDirective + " " + string(consumersVisibilityMode), rc.visibilityMode == consumersVisibilityMode},
+	}
+	var conflicts []string
+	for _, option := range options {
+		if option.set {
+			conflicts = append(conflicts, option.name)
+		}
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s can't be combined with -rust_state_file, since directories it skips would be left out", strings.Join(conflicts, ", "))
+}
+
+func loadIncrementalState(path, lockfilePath string) (*incrementalState, error) {
+	state := &incrementalState{
+		path:                   path,
+		digestByLockfile:       make(map[string][]byte),
+		fingerprintByDirectory: make(map[string]string),
+		resolutionByDirectory:  make(map[string]*directoryResolution),
+	}
+
+	if _, err := state.lockFileDigest(lockfilePath); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stateFile incrementalStateFile
+	if err := json.Unmarshal(data, &stateFile); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// State from other versions is discarded rather than trusted.
+	if stateFile.Version == incrementalStateVersion && stateFile.FingerprintByDirectory != nil {
+		state.fingerprintByDirectory = stateFile.FingerprintByDirectory
+		if stateFile.ResolutionByDirectory != nil {
+			state.resolutionByDirectory = stateFile.ResolutionByDirectory
+		}
+	}
+	return state, nil
+}
+
+// Record the directory's fingerprint, reporting whether it is unchanged since
+// the previous run.
+func (state *incrementalState) update(rel, fingerprint string) bool {
+	unchanged := state.fingerprintByDirectory[rel] == fingerprint
+	state.fingerprintByDirectory[rel] = fingerprint
+	return unchanged
+}
+
+// Remove a directory's fingerprint so the next run regenerates it.
+func (state *incrementalState) invalidate(rel string) {
+	delete(state.fingerprintByDirectory, rel)
+}
+
+// Record that this run skips a directory, so its recorded resolution is
+// replayed.
+func (state *incrementalState) skip(rel string) {
+	state.skippedDirectories = append(state.skippedDirectories, rel)
+}
+
+// Discard a directory's recorded resolution, since this run resolves it
+// again.
+func (state *incrementalState) regenerate(rel string) {
+	state.resolutionByDirectory[rel] = &directoryResolution{}
+}
+
+// Return the resolution this run records for a regenerated directory.
+func (state *incrementalState) resolution(rel string) *directoryResolution {
+	resolution, ok := state.resolutionByDirectory[rel]
+	if !ok {
+		resolution = &directoryResolution{}
+		state.resolutionByDirectory[rel] = resolution
+	}
+	return resolution
+}
+
+func (state *incrementalState) save() error {
+	// Directories the next run regenerates need no record.
+	for rel := range state.resolutionByDirectory {
+		if _, ok := state.fingerprintByDirectory[rel]; !ok {
+			delete(state.resolutionByDirectory, rel)
+		}
+	}
+	data, err := json.MarshalIndent(incrementalStateFile{
+		Version:                incrementalStateVersion,
+		FingerprintByDirectory: state.fingerprintByDirectory,
+		ResolutionByDirectory:  state.resolutionByDirectory,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(state.path), filepath.Base(state.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, writeErr := tempFile.Write(data)
+	closeErr := tempFile.Close()
+	if err := errors.Join(writeErr, closeErr); err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	return os.Rename(tempFile.Name(), state.path)
+}
+
+func (state *incrementalState) lockFileDigest(lockfilePath string) ([]byte, error) {
+	if digest, ok := state.digestByLockfile[lockfilePath]; ok {
+		return digest, nil
+	}
+	digest, err := rust_analysis.FileDigest(lockfilePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	state.digestByLockfile[lockfilePath] = digest
+	return digest, nil
+}
+
+// Fingerprint the flags, a directory's configuration, Cargo.lock, rules, and
+// the Rust sources among its package files.
+func (state *incrementalState) directoryFingerprint(dir, lockfilePath string, flags *rustFlags, rc *rustConfig, f *rule.File, packageFiles []string) (string, error) {
+	lockfileDigest, err := state.lockFileDigest(lockfilePath)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "flags %+v\x00", *flags)
+	fmt.Fprintf(hash, "config %+v\x00", *rc)
+	fmt.Fprintf(hash, "lockfile %x\x00", lockfileDigest)
+	if f != nil {
+		for _, r := range f.Rules {
+			fmt.Fprintf(hash, "rule %s %s\x00", r.Kind(), r.Name())
+		}
+	}
+
+	for _, file := range packageFiles {
+		if !strings.HasSuffix(file, ".rs") {
+			continue
+		}
+		digest, err := rust_analysis.FileDigest(filepath.Join(dir, file))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "source %s %x\x00", file, digest)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Print a warning about a rule, recording it to print again while the rule's
+// directory is skipped.
+func (l *rustLang) resolveWarning(from label.Label, message string) {
+	log.Print(message)
+	if l.state != nil {
+		resolution := l.state.resolution(from.Pkg)
+		resolution.Warnings = append(resolution.Warnings, message)
+	}
+}
+
+// Add what resolving each skipped directory contributed in the run that last
+// resolved it.
+func (l *rustLang) replaySkippedResolutions() {
+	for _, rel := range l.state.skippedDirectories {
+		resolution, ok := l.state.resolutionByDirectory[rel]
+		if !ok {
+			continue
+		}
+		for library, edges := range resolution.EdgesByLibrary {
+			l.dependencyGraph.edgesByLibrary[library] = edges
+		}
+		if l.unpinnedCrates != nil {
+			for _, dependency := range resolution.UnpinnedDependencies {
+				if from, err := label.Parse(dependency.From); err == nil {
+					l.unpinnedCrates.addDependency(dependency.Dependency, from)
+				}
+			}
+		}
+		for _, warning := range resolution.Warnings {
+			log.Print(warning)
+		}
+	}
+}
+
+// Record each library's dependency edges with its directory, and save the
+// state. Only called once resolution succeeds, so a directory failing a check
+// is regenerated, and fails it again, on the next run.
+func (l *rustLang) saveIncrementalState() {
+	for library, edges := range l.dependencyGraph.edgesByLibrary {
+		libraryLabel, err := label.Parse(library)
+		if err != nil {
+			continue
+		}
+		resolution := l.state.resolution(libraryLabel.Pkg)
+		if resolution.EdgesByLibrary == nil {
+			resolution.EdgesByLibrary = make(map[string][]dependencyEdge)
+		}
+		resolution.EdgesByLibrary[library] = edges
+	}
+	if err := l.state.save(); err != nil {
+		log.Printf("save %s: %v", l.state.path, err)
+	}
+}
@@ -0,0 +1,198 @@
+package rust_language
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// Runs generation over one directory with a state file, run after run,
+// changing an input before each.
+func TestIncrementalState(t *testing.T) {
+	repoRoot := t.TempDir()
+	dir := filepath.Join(repoRoot, "lib")
+	statePath := filepath.Join(repoRoot, ".gazelle_state")
+	lockfilePath := filepath.Join(repoRoot, "Cargo.lock")
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(filepath.Join(dir, "lib.rs"), "pub fn a() {}\n")
+	writeFile(lockfilePath, "version = 4\n")
+
+	flags := &rustFlags{stateFile: statePath}
+	rc := &rustConfig{visibility: []string{"//:__subpackages__"}}
+	// Run once, reporting whether the directory was skipped.
+	run := func(invalidate bool) bool {
+		t.Helper()
+		state, err := loadIncrementalState(statePath, lockfilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var packageFiles []string
+		for _, entry := range entries {
+			packageFiles = append(packageFiles, entry.Name())
+		}
+		fingerprint, err := state.directoryFingerprint(dir, lockfilePath, flags, rc, nil, packageFiles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		skipped := state.update("lib", fingerprint)
+		if invalidate {
+			state.invalidate("lib")
+		}
+		if err := state.save(); err != nil {
+			t.Fatal(err)
+		}
+		return skipped
+	}
+
+	tests := []struct {
+		name   string
+		change func()
+		// Whether the run after the change skips the directory. Another run
+		// always does.
+		wantSkipped bool
+	}{
+		{name: "first run", change: func() {}, wantSkipped: false},
+		{name: "unchanged", change: func() {}, wantSkipped: true},
+		{name: "source edited", change: func() { writeFile(filepath.Join(dir, "lib.rs"), "pub fn b() {}\n") }, wantSkipped: false},
+		{name: "source added", change: func() { writeFile(filepath.Join(dir, "util.rs"), "") }, wantSkipped: false},
+		{name: "non-Rust file added", change: func() { writeFile(filepath.Join(dir, "README.md"), "") }, wantSkipped: true},
+		{name: "lockfile changed", change: func() { writeFile(lockfilePath, "version = 4\n\n[[package]]\nname = \"log\"\n") }, wantSkipped: false},
+		{name: "lockfile removed", change: func() { os.Remove(lockfilePath) }, wantSkipped: false},
+		{name: "config changed", change: func() { rc.visibility = []string{"//visibility:public"} }, wantSkipped: false},
+		{name: "flag changed", change: func() { flags.canonicalLoads = true }, wantSkipped: false},
+		{
+			name: "version bumped",
+			change: func() {
+				// Fingerprints from another version are discarded, so the
+				// current ones are recorded under an older one.
+				data, err := os.ReadFile(statePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var stateFile incrementalStateFile
+				if err := json.Unmarshal(data, &stateFile); err != nil {
+					t.Fatal(err)
+				}
+				stateFile.Version--
+				data, err = json.Marshal(stateFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeFile(statePath, string(data))
+			},
+			wantSkipped: false,
+		},
+	}
+	for _, test := range tests {
+		test.change()
+		if skipped := run(false); skipped != test.wantSkipped {
+			t.Errorf("%s: skipped = %t, want %t", test.name, skipped, test.wantSkipped)
+		}
+		if !run(false) {
+			t.Errorf("%s: the next run didn't skip the directory", test.name)
+		}
+	}
+
+	// A directory invalidated by a run, as one with parse failures is, is
+	// regenerated by the next.
+	run(true)
+	if run(false) {
+		t.Errorf("invalidated: skipped the directory")
+	}
+}
+
+// A skipped directory's rules aren't resolved, so what resolving them last
+// contributed to the checks over the whole repository is replayed.
+func TestIncrementalStateReplaysResolution(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), ".gazelle_state")
+	lockfilePath := filepath.Join(t.TempDir(), "Cargo.lock")
+	from := label.New("", "lib", "lib")
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	// Start a run, visiting the directory with the given fingerprint.
+	startRun := func(fingerprint string) *rustLang {
+		t.Helper()
+		state, err := loadIncrementalState(statePath, lockfilePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.update("lib", fingerprint) {
+			state.skip("lib")
+		} else {
+			state.regenerate("lib")
+		}
+		logged.Reset()
+		return &rustLang{
+			state:           state,
+			dependencyGraph: newDependencyGraph(),
+			unpinnedCrates: &unpinnedCrates{
+				cratesPrefix:    "@crates//:",
+				pinned:          map[string]bool{"log": true},
+				importerByCrate: make(map[string]label.Label),
+			},
+		}
+	}
+
+	l := startRun("first")
+	l.dependencyGraph.addLibrary(from)
+	l.dependencyGraph.addEdge(from, label.New("", "util", "util"), "lib.rs", "util")
+	l.addUnpinnedDependency("@crates//:serde", from)
+	l.addUnpinnedDependency("@crates//:log", from)
+	l.resolveWarning(from, "//lib: dependency //old is kept but no source file imports it")
+	l.saveIncrementalState()
+
+	l = startRun("first")
+	l.replaySkippedResolutions()
+	edges := l.dependencyGraph.edgesByLibrary["//lib"]
+	if len(edges) != 1 || edges[0].To != "//util" || edges[0].File != "lib/lib.rs" {
+		t.Errorf("replayed edges = %+v, want one to //util from lib/lib.rs", edges)
+	}
+	if crates := slices.Collect(maps.Keys(l.unpinnedCrates.importerByCrate)); !slices.Equal(crates, []string{"serde"}) {
+		t.Errorf("replayed unpinned crates = %q, want serde", crates)
+	}
+	if !strings.Contains(logged.String(), "//lib: dependency //old is kept") {
+		t.Errorf("the warning wasn't replayed; logged %q", logged.String())
+	}
+	// Skipping it again keeps the record.
+	l.saveIncrementalState()
+	l = startRun("first")
+	l.replaySkippedResolutions()
+	if len(l.dependencyGraph.edgesByLibrary["//lib"]) != 1 || logged.Len() == 0 {
+		t.Errorf("a second skip lost the record")
+	}
+
+	// Regenerating it replaces the record.
+	l = startRun("second")
+	l.dependencyGraph.addLibrary(from)
+	l.saveIncrementalState()
+	l = startRun("second")
+	l.replaySkippedResolutions()
+	if edges, ok := l.dependencyGraph.edgesByLibrary["//lib"]; !ok || len(edges) != 0 {
+		t.Errorf("replayed edges after regenerating = %+v, want none", edges)
+	}
+	if len(l.unpinnedCrates.importerByCrate) != 0 || logged.Len() != 0 {
+		t.Errorf("regenerating kept the old record")
+	}
+}
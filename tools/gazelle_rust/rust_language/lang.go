@@ -1,23 +1,96 @@
 package rust_language
 
 import (
-	"flag"
+	"context"
+	"log"
+	"sync"
 
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
 )
 
 const langName = "rust"
 
 type rustLang struct {
-	parser *Parser
+	// Started by CheckFlags once the worker count is known.
+	parser *rust_analysis.Parser
+	// Guards what parse records, since module discovery parses a crate's
+	// files concurrently.
+	parseMutex sync.Mutex
+	flags      rustFlags
+	repoRoot   string
+	// Set once gazelle's walk is over, after which directory contents are
+	// read from the filesystem.
+	walkDone bool
+	// Nil unless -rust_state_file is set.
+	state *incrementalState
+	// Nil unless -rust_resolve_query is set.
+	resolveQuery *resolveQuery
+	// Nil unless -rust_check_cargo_toml is set.
+	cargoManifestCheck *cargoManifestCheck
+	// Workspace library dependencies found while resolving.
+	dependencyGraph    *dependencyGraph
+	consumerVisibility *consumerVisibility
+	// Tests generated so far, for rust_test_suite.
+	testSuites *testSuites
+	// Libraries generated so far, for rust_library_group.
+	libraryGroups *libraryGroups
+	// Files that failed to parse, reported after resolving.
+	parseDiagnostics *parseDiagnostics
+	// Imports several workspace crates claim, reported after resolving.
+	ambiguityMessages []string
+	// Nil unless -rust_buildozer or -rust_check is set.
+	ruleChanges *ruleChanges
+	// Whether -rust_check reports ruleChanges, rather than -rust_buildozer.
+	checkFreshness bool
+	// Nil unless -rust_sarif_output is set.
+	sarifReport *sarifReport
+	// Nil unless -rust_crate_map_output is set.
+	crateMap *crateMap
+	// Nil unless -rust_migrate_moves is set.
+	crateMoves *crateMoves
+	// Nil unless -rust_crate_universe_lockfile is set.
+	unpinnedCrates *unpinnedCrates
+	// Nil unless -rust_crate_universe_lockfile is set.
+	buildScriptAnnotations *buildScriptAnnotations
+	// Nil unless -rust_feature_report is set.
+	featureReport *featureReport
+	// Nil unless -rust_public_api_output or -rust_public_api_diff is set.
+	publicAPI *publicAPI
+	// Nil unless -rust_advisory_db is set.
+	advisoryAudit *advisoryAudit
+	// Files skipped for their size, reported after resolving.
+	largeSources *largeSources
+	// Dependencies of resolved rules, for the license reports of binaries
+	// and the advisory audit.
+	licenseReports *licenseReports
+	// Cargo.toml files, for the optional dependencies rules leave disabled,
+	// the CARGO_PKG_* variables sources read, and the Cargo.toml check.
+	cargoManifests *cargoManifestIndex
+	// Crate names of workspace rules, found while indexing.
+	crateNameCollisions *crateNameCollisions
+	// Workspace rust_proc_macro rules, found while indexing.
+	procMacroLabels map[label.Label]bool
+	// Crate names of indexed workspace rules, for the kept deps check.
+	crateNameByLabel map[label.Label]string
+	// The workspace-hack package named by hakari.toml, if any.
+	hakariPackage        string
+	hakariPackageMissing bool
 }
 
 func NewLanguage() language.Language {
 	return &rustLang{
-		parser: NewParser(),
+		dependencyGraph:     newDependencyGraph(),
+		consumerVisibility:  newConsumerVisibility(),
+		testSuites:          newTestSuites(),
+		libraryGroups:       newLibraryGroups(),
+		crateNameCollisions: newCrateNameCollisions(),
+		procMacroLabels:     make(map[label.Label]bool),
+		crateNameByLabel:    make(map[label.Label]string),
 	}
 }
 
@@ -27,18 +100,53 @@ func (*rustLang) Kinds() map[string]rule.KindInfo {
 	return map[string]rule.KindInfo{
 		"rust_library": {
 			NonEmptyAttrs:  map[string]bool{"srcs": true},
-			MergeableAttrs: map[string]bool{"srcs": true, "deps": true},
-			ResolveAttrs:   map[string]bool{"deps": true},
+			MergeableAttrs: map[string]bool{"srcs": true, "deps": true, "tags": true, "rustc_flags": true, "target_compatible_with": true, "rustc_env": true, "compile_data": true, "proc_macro_deps": true},
+			ResolveAttrs:   map[string]bool{"deps": true, "proc_macro_deps": true},
 		},
 		"rust_binary": {
 			NonEmptyAttrs:  map[string]bool{"srcs": true},
-			MergeableAttrs: map[string]bool{"srcs": true, "deps": true},
-			ResolveAttrs:   map[string]bool{"deps": true},
+			MergeableAttrs: map[string]bool{"srcs": true, "deps": true, "tags": true, "rustc_flags": true, "target_compatible_with": true, "platform": true, "rustc_env": true, "compile_data": true, "proc_macro_deps": true},
+			ResolveAttrs:   map[string]bool{"deps": true, "proc_macro_deps": true},
 		},
 		"rust_test": {
 			NonEmptyAttrs:  map[string]bool{"srcs": true},
-			MergeableAttrs: map[string]bool{"srcs": true, "deps": true},
-			ResolveAttrs:   map[string]bool{"deps": true},
+			MergeableAttrs: map[string]bool{"srcs": true, "deps": true, "shard_count": true, "tags": true, "rustc_flags": true, "target_compatible_with": true, "rustc_env": true, "env": true, "compile_data": true, "data": true, "proc_macro_deps": true},
+			ResolveAttrs:   map[string]bool{"deps": true, "proc_macro_deps": true},
+		},
+		"rust_shared_library": {
+			NonEmptyAttrs:  map[string]bool{"srcs": true},
+			MergeableAttrs: map[string]bool{"srcs": true, "deps": true, "tags": true, "rustc_flags": true, "target_compatible_with": true, "rustc_env": true, "compile_data": true, "proc_macro_deps": true},
+			ResolveAttrs:   map[string]bool{"deps": true, "proc_macro_deps": true},
+		},
+		"rust_static_library": {
+			NonEmptyAttrs:  map[string]bool{"srcs": true},
+			MergeableAttrs: map[string]bool{"srcs": true, "deps": true, "tags": true, "rustc_flags": true, "target_compatible_with": true, "rustc_env": true, "compile_data": true, "proc_macro_deps": true},
+			ResolveAttrs:   map[string]bool{"deps": true, "proc_macro_deps": true},
+		},
+		"test_suite": {
+			MergeableAttrs: map[string]bool{"tests": true},
+		},
+		// Indexed so imports of members can resolve to the group.
+		libraryGroupKind: {
+			MergeableAttrs: map[string]bool{"deps": true},
+			ResolveAttrs:   map[string]bool{},
+		},
+		"alias": {
+			NonEmptyAttrs:  map[string]bool{"actual": true},
+			MergeableAttrs: map[string]bool{"actual": true},
+		},
+		rustAnalyzerProjectKind: {
+			MergeableAttrs: map[string]bool{"src": true, "args": true},
+		},
+		// License reports, whose content is set once every rule is resolved.
+		licenseReportKind: {
+			MergeableAttrs: map[string]bool{"out": true},
+			ResolveAttrs:   map[string]bool{},
+		},
+		// Index rust_proc_macro so consumers get them in proc_macro_deps.
+		"rust_proc_macro": {
+			MergeableAttrs: map[string]bool{},
+			ResolveAttrs:   map[string]bool{},
 		},
 		// Index rust_prost_library so we can resolve deps to proto targets.
 		"rust_prost_library": {
@@ -48,23 +156,106 @@ func (*rustLang) Kinds() map[string]rule.KindInfo {
 	}
 }
 
-func (*rustLang) Loads() []rule.LoadInfo {
-	return []rule.LoadInfo{
+func (l *rustLang) Loads() []rule.LoadInfo {
+	rulesRustSymbols := []string{libraryGroupKind, "rust_shared_library", "rust_static_library"}
+	skylibLoads := []rule.LoadInfo{
+		{
+			Name:    "@bazel_skylib//rules:native_binary.bzl",
+			Symbols: []string{rustAnalyzerProjectKind},
+		},
+		{
+			Name:    "@bazel_skylib//rules:write_file.bzl",
+			Symbols: []string{licenseReportKind},
+		},
+	}
+	if l.flags.canonicalLoads {
+		return append([]rule.LoadInfo{
+			{
+				Name:    "@rules_rust//rust:defs.bzl",
+				Symbols: append([]string{"rust_library", "rust_binary", "rust_test"}, rulesRustSymbols...),
+			},
+		}, skylibLoads...)
+	}
+	return append([]rule.LoadInfo{
 		{
 			Name:    "//tools/bazel/macros:rust.bzl",
 			Symbols: []string{"rust_library", "rust_binary", "rust_test"},
 		},
-	}
+		// The repository macros don't wrap the FFI library rules or
+		// rust_library_group.
+		{
+			Name:    "@rules_rust//rust:defs.bzl",
+			Symbols: rulesRustSymbols,
+		},
+	}, skylibLoads...)
 }
 
-func (*rustLang) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {}
-
-func (*rustLang) CheckFlags(fs *flag.FlagSet, c *config.Config) error { return nil }
+func (*rustLang) Embeds(r *rule.Rule, from label.Label) []label.Label { return nil }
 
-func (*rustLang) KnownDirectives() []string { return nil }
+func (l *rustLang) Fix(c *config.Config, f *rule.File) {
+	if l.crateMoves != nil {
+		l.crateMoves.fix(f)
+	}
+}
 
-func (*rustLang) Configure(c *config.Config, rel string, f *rule.File) {}
+// The parser is only needed while generating rules.
+func (l *rustLang) DoneGeneratingRules() {
+	l.walkDone = true
+	if err := l.parser.Close(); err != nil {
+		log.Printf("rust parser: %v", err)
+	}
+}
 
-func (*rustLang) Embeds(r *rule.Rule, from label.Label) []label.Label { return nil }
+func (*rustLang) Before(ctx context.Context) {}
 
-func (*rustLang) Fix(c *config.Config, f *rule.File) {}
+func (l *rustLang) AfterResolvingDeps(ctx context.Context) {
+	// Written first, since strict parsing fails the run.
+	l.writeSarifReport()
+	if l.crateMap != nil {
+		l.crateMap.write()
+	}
+	if l.publicAPI != nil {
+		l.publicAPI.write()
+	}
+	if l.state != nil {
+		l.replaySkippedResolutions()
+	}
+	l.parseDiagnostics.report()
+	l.largeSources.report()
+	l.crateNameCollisions.report()
+	// Fails the run before the state file is saved, so the consuming
+	// directories are resolved again next run.
+	l.reportAmbiguities()
+	l.dependencyGraph.report()
+	l.consumerVisibility.apply()
+	l.licenseReports.apply()
+	if l.resolveQuery != nil {
+		l.resolveQuery.finish()
+	}
+	if l.cargoManifestCheck != nil {
+		l.cargoManifestCheck.report()
+	}
+	if l.unpinnedCrates != nil && l.resolveQuery == nil {
+		l.unpinnedCrates.finish()
+	}
+	if l.buildScriptAnnotations != nil && l.resolveQuery == nil {
+		l.buildScriptAnnotations.report(l.licenseReports)
+	}
+	if l.ruleChanges != nil && l.checkFreshness {
+		printStaleRules(l.ruleChanges)
+	} else if l.ruleChanges != nil {
+		printBuildozerCommands(l.ruleChanges)
+	}
+	if l.advisoryAudit != nil {
+		l.advisoryAudit.finish(l.licenseReports)
+	}
+	if l.featureReport != nil {
+		l.featureReport.finish()
+	}
+	if l.publicAPI != nil {
+		l.publicAPI.finish()
+	}
+	if l.state != nil {
+		l.saveIncrementalState()
+	}
+}
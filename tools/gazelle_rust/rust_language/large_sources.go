@@ -0,0 +1,59 @@
+package rust_language
+
+// Checked-in generated files can be tens of megabytes, and parsing one stalls
+// its parser connection. With -rust_max_source_size, files larger than it stay
+// in srcs but aren't parsed, so they contribute no imports or modules, and are
+// reported at the end of the run. `# gazelle:rust_large_sources <file>...`
+// lists files in its directory, and is inherited by subdirectories, to parse
+// regardless of their size.
+
+import (
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+type largeSources struct {
+	repoRoot string
+	// Disabled when zero.
+	maxSize int64
+	// Keyed by the path relative to the repository root.
+	sizeByFile map[string]int64
+}
+
+func newLargeSources(repoRoot string, maxSize int64) *largeSources {
+	return &largeSources{
+		repoRoot:   repoRoot,
+		maxSize:    maxSize,
+		sizeByFile: make(map[string]int64),
+	}
+}
+
+// Whether a file is too large to parse, recording it if so.
+func (sources *largeSources) skip(rc *rustConfig, filePath string) bool {
+	if sources.maxSize == 0 {
+		return false
+	}
+	info, err := os.Stat(filePath)
+	if err != nil || info.Size() <= sources.maxSize {
+		return false
+	}
+	file, err := filepath.Rel(sources.repoRoot, filePath)
+	if err != nil {
+		file = filePath
+	}
+	file = filepath.ToSlash(file)
+	if rc.largeSources[file] {
+		return false
+	}
+	sources.sizeByFile[file] = info.Size()
+	return true
+}
+
+func (sources *largeSources) report() {
+	for _, file := range slices.Sorted(maps.Keys(sources.sizeByFile)) {
+		log.Printf("%s: not parsed: %d bytes exceeds -rust_max_source_size=%d; its imports and modules are unresolved, list it in # gazelle:%s to parse it anyway", file, sources.sizeByFile[file], sources.maxSize, largeSourcesDirective)
+	}
+}
@@ -0,0 +1,70 @@
+package rust_language
+
+// Layering policy: `# gazelle:rust_forbidden_dependency <pattern>` forbids
+// rules in the directive's subtree from depending on rules matching the
+// pattern, such as `//services` for that package alone, `//services/...` for
+// it and its subpackages, or `@crates//` for every crate in a repository.
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+type layeringEnforcement string
+
+const (
+	warnLayeringEnforcement  layeringEnforcement = "warn"
+	errorLayeringEnforcement layeringEnforcement = "error"
+)
+
+type dependencyPattern struct {
+	// As written in the directive, for diagnostics.
+	value string
+	repo  string
+	pkg   string
+	// Whether the pattern ends in `...`, matching subpackages too.
+	recursive bool
+}
+
+func parseDependencyPattern(value string) (dependencyPattern, error) {
+	repo, pkg, ok := strings.Cut(value, "//")
+	if !ok || strings.Contains(pkg, ":") {
+		return dependencyPattern{}, fmt.Errorf("expected a package pattern like //path or //path/..., got %q", value)
+	}
+	pattern := dependencyPattern{value: value, repo: strings.TrimPrefix(repo, "@")}
+	if pkg == "..." || strings.HasSuffix(pkg, "/...") {
+		pattern.recursive = true
+		pkg = strings.TrimSuffix(strings.TrimSuffix(pkg, "..."), "/")
+	}
+	pattern.pkg = pkg
+	return pattern, nil
+}
+
+func (pattern dependencyPattern) matches(dep label.Label) bool {
+	if pattern.repo != dep.Repo {
+		return false
+	}
+	if dep.Pkg == pattern.pkg {
+		return true
+	}
+	return pattern.recursive && (pattern.pkg == "" || strings.HasPrefix(dep.Pkg, pattern.pkg+"/"))
+}
+
+// Report a dependency forbidden by the layering policy of the consuming
+// rule's directory.
+func (l *rustLang) checkLayering(rc *rustConfig, dep label.Label, importName string, from label.Label) {
+	for _, pattern := range rc.forbiddenDependencies {
+		if !pattern.matches(dep) {
+			continue
+		}
+		message := fmt.Sprintf("%s: import %q resolves to %s, which matches forbidden dependency %s", from, importName, dep, pattern.value)
+		if rc.layeringEnforcement == errorLayeringEnforcement {
+			log.Fatal(message)
+		}
+		l.resolveWarning(from, message)
+		return
+	}
+}
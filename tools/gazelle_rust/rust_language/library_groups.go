@@ -0,0 +1,99 @@
+package rust_language
+
+// `# gazelle:rust_library_group <name>` adds a rust_library_group to the
+// directory listing every rust_library in its subtree, so the subtree can be
+// depended on through one label. With
+// `# gazelle:rust_resolve_library_groups enabled`, imports of a group's
+// members from outside the group's subtree depend on the group instead,
+// whether gazelle generated it or it was written by hand. Rules inside the
+// subtree keep depending on members directly, since depending on a group
+// containing themselves would be a cycle. When several groups contain a
+// crate, the first by label wins.
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+const libraryGroupKind = "rust_library_group"
+
+// Groups containing each workspace rule, found while indexing and shared by
+// all directories' configs.
+const libraryGroupsByMemberKey = "rust_library_groups"
+
+type libraryGroups struct {
+	libraryNamesByPackage map[string][]string
+}
+
+func newLibraryGroups() *libraryGroups {
+	return &libraryGroups{libraryNamesByPackage: make(map[string][]string)}
+}
+
+func (groups *libraryGroups) addLibraries(pkg string, rules []*rule.Rule) {
+	for _, r := range rules {
+		if r.Kind() == "rust_library" && (len(r.AttrStrings("srcs")) > 0 || computedSrcs(r) != nil) {
+			groups.libraryNamesByPackage[pkg] = append(groups.libraryNamesByPackage[pkg], r.Name())
+		}
+	}
+}
+
+func (groups *libraryGroups) emitLibraryGroup(result *language.GenerateResult, rc *rustConfig, pkg, name string) {
+	var members []string
+	for _, libraryPackage := range slices.Sorted(maps.Keys(groups.libraryNamesByPackage)) {
+		if !isInSubtree(libraryPackage, pkg) {
+			continue
+		}
+		for _, libraryName := range groups.libraryNamesByPackage[libraryPackage] {
+			members = append(members, label.New("", libraryPackage, libraryName).Rel("", pkg).String())
+		}
+	}
+
+	group := rule.NewRule(libraryGroupKind, name)
+	if len(members) == 0 {
+		result.Empty = append(result.Empty, group)
+		return
+	}
+	group.SetAttr("deps", members)
+	group.SetAttr("visibility", rc.visibility)
+	result.Gen = append(result.Gen, group)
+	result.Imports = append(result.Imports, nil)
+}
+
+func recordLibraryGroup(c *config.Config, r *rule.Rule, pkg string) {
+	groupsByMember := c.Exts[libraryGroupsByMemberKey].(map[label.Label][]label.Label)
+	group := label.New("", pkg, r.Name())
+	for _, dep := range r.AttrStrings("deps") {
+		member, err := label.Parse(dep)
+		if err != nil || member.Repo != "" {
+			continue
+		}
+		member = member.Abs("", pkg)
+		groupsByMember[member] = append(groupsByMember[member], group)
+	}
+}
+
+// Return the group to depend on for a workspace rule, if groups are resolved
+// to and one outside from's subtree contains it.
+func libraryGroupOf(c *config.Config, rc *rustConfig, target, from label.Label) (label.Label, bool) {
+	if !rc.resolveLibraryGroups {
+		return label.NoLabel, false
+	}
+	groups := slices.DeleteFunc(slices.Clone(c.Exts[libraryGroupsByMemberKey].(map[label.Label][]label.Label)[target]), func(group label.Label) bool {
+		return isInSubtree(from.Pkg, group.Pkg)
+	})
+	if len(groups) == 0 {
+		return label.NoLabel, false
+	}
+	return slices.MinFunc(groups, func(a, b label.Label) int { return strings.Compare(a.String(), b.String()) }), true
+}
+
+// Report whether pkg is root or one of its subpackages.
+func isInSubtree(pkg, root string) bool {
+	return root == "" || pkg == root || strings.HasPrefix(pkg, root+"/")
+}
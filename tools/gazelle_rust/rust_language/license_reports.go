@@ -0,0 +1,222 @@
+package rust_language
+
+// `# gazelle:rust_license_reports enabled` gives each rust_binary a
+// `<binary>_licenses` write_file target listing the crates from Cargo.lock it
+// depends on, directly or through workspace libraries and other crates, with
+// their versions and the license in their Cargo.toml. Licenses are read from
+// extracted crate sources, `<name>-<version>` directories such as those in
+// $CARGO_HOME/registry/src or a `cargo vendor` directory.
+//
+// Dependencies are only known for packages resolved in this run, so the
+// directive can't be combined with -rust_state_file.
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+const (
+	licenseReportKind = "write_file"
+	// The binary a generated report lists the crates of.
+	licenseReportBinaryAttr = "_rust_license_report_binary"
+	unknownLicense          = "unknown"
+)
+
+var (
+	manifestLicenseRegex     = regexp.MustCompile(`^license\s*=\s*"([^"]+)"`)
+	manifestLicenseFileRegex = regexp.MustCompile(`^license-file\s*=\s*"([^"]+)"`)
+)
+
+type licenseReports struct {
+	sourceDirectories  []string
+	dependenciesByRule map[label.Label]*ruleDependencies
+	reports            []licenseReport
+	licenseByCrate     map[string]string
+}
+
+// What a rule depends on directly.
+type ruleDependencies struct {
+	libraries      []label.Label
+	crates         []rust_analysis.ExternalCrate
+	externalCrates *ExternalCrates
+}
+
+type licenseReport struct {
+	binary label.Label
+	// The rule written to the BUILD file: the existing rule, or the new one.
+	rule *rule.Rule
+}
+
+func newLicenseReports(sourceDirectories []string) *licenseReports {
+	return &licenseReports{
+		sourceDirectories:  sourceDirectories,
+		dependenciesByRule: make(map[label.Label]*ruleDependencies),
+		licenseByCrate:     make(map[string]string),
+	}
+}
+
+// Return the directories holding the crates.io sources Cargo extracted.
+func defaultLicenseSourceDirectories() []string {
+	cargoHome := os.Getenv("CARGO_HOME")
+	if cargoHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		cargoHome = filepath.Join(home, ".cargo")
+	}
+	directories, _ := filepath.Glob(filepath.Join(cargoHome, "registry", "src", "*"))
+	return directories
+}
+
+func (l *rustLang) emitLicenseReport(result *language.GenerateResult, f *rule.File, binary *rule.Rule) {
+	r := rule.NewRule(licenseReportKind, binary.Name()+"_licenses")
+	r.SetAttr("out", r.Name()+".txt")
+	r.SetPrivateAttr(licenseReportBinaryAttr, binary.Name())
+
+	var existingRule *rule.Rule
+	if f != nil {
+		for _, fileRule := range f.Rules {
+			if fileRule.Kind() == licenseReportKind && fileRule.Name() == r.Name() {
+				existingRule = fileRule
+			}
+		}
+	}
+	result.Gen = append(result.Gen, r)
+	result.Imports = append(result.Imports, RuleData{ExistingRule: existingRule})
+}
+
+func (reports *licenseReports) addDependencies(from label.Label, externalCrates *ExternalCrates) *ruleDependencies {
+	dependencies := &ruleDependencies{externalCrates: externalCrates}
+	reports.dependenciesByRule[from] = dependencies
+	return dependencies
+}
+
+func (reports *licenseReports) addReport(r *rule.Rule, ruleData RuleData, from label.Label) {
+	writtenRule := r
+	if ruleData.ExistingRule != nil {
+		writtenRule = ruleData.ExistingRule
+	}
+	if writtenRule.ShouldKeep() {
+		return
+	}
+	binary := label.New(from.Repo, from.Pkg, r.PrivateAttr(licenseReportBinaryAttr).(string))
+	reports.reports = append(reports.reports, licenseReport{binary: binary, rule: writtenRule})
+}
+
+// Set the content of every report.
+func (reports *licenseReports) apply() {
+	for _, report := range reports.reports {
+		var lines []string
+		for _, crate := range reports.crates(report.binary) {
+			lines = append(lines, fmt.Sprintf("%s %s %s", crate.Name, crate.Version, reports.license(crate)))
+		}
+		slices.Sort(lines)
+		report.rule.SetAttr("content", lines)
+	}
+}
+
+// Return the Cargo.lock packages the rule depends on, directly or not.
+func (reports *licenseReports) crates(from label.Label) []rust_analysis.ExternalCrate {
+	visitedRules := make(map[label.Label]bool)
+	visitedCrates := make(map[string]bool)
+	var crates []rust_analysis.ExternalCrate
+
+	var visitCrate func(crate rust_analysis.ExternalCrate, externalCrates *ExternalCrates)
+	visitCrate = func(crate rust_analysis.ExternalCrate, externalCrates *ExternalCrates) {
+		key := crate.Name + " " + crate.Version
+		if crate.Source == "" || visitedCrates[key] {
+			return
+		}
+		visitedCrates[key] = true
+		crates = append(crates, crate)
+		for _, entry := range crate.Dependencies {
+			if dependency, ok := externalCrates.LockedDependency(entry); ok {
+				visitCrate(dependency, externalCrates)
+			}
+		}
+	}
+	var visitRule func(r label.Label)
+	visitRule = func(r label.Label) {
+		dependencies, ok := reports.dependenciesByRule[r]
+		if !ok || visitedRules[r] {
+			return
+		}
+		visitedRules[r] = true
+		for _, crate := range dependencies.crates {
+			visitCrate(crate, dependencies.externalCrates)
+		}
+		for _, library := range dependencies.libraries {
+			visitRule(library)
+		}
+	}
+	visitRule(from)
+	return crates
+}
+
+// Return the license of a package, from the Cargo.toml of its extracted
+// sources.
+func (reports *licenseReports) license(crate rust_analysis.ExternalCrate) string {
+	key := crate.Name + " " + crate.Version
+	if license, ok := reports.licenseByCrate[key]; ok {
+		return license
+	}
+	license := unknownLicense
+	for _, directory := range reports.sourceDirectories {
+		if found, ok := readManifestLicense(filepath.Join(directory, crate.Name+"-"+crate.Version, "Cargo.toml")); ok {
+			license = found
+			break
+		}
+	}
+	reports.licenseByCrate[key] = license
+	return license
+}
+
+// Return the license a Cargo.toml declares, or the file holding it.
+func readManifestLicense(manifestPath string) (string, bool) {
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	license := unknownLicense
+	inPackage := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inPackage = line == "[package]"
+			continue
+		}
+		if !inPackage {
+			continue
+		}
+		if matches := manifestLicenseRegex.FindStringSubmatch(line); matches != nil {
+			return matches[1], true
+		}
+		if matches := manifestLicenseFileRegex.FindStringSubmatch(line); matches != nil {
+			license = "see " + matches[1]
+		}
+	}
+	return license, true
+}
+
+// Return the resolved rules, sorted.
+func (reports *licenseReports) rules() []label.Label {
+	rules := slices.Collect(maps.Keys(reports.dependenciesByRule))
+	slices.SortFunc(rules, func(a, b label.Label) int { return strings.Compare(a.String(), b.String()) })
+	return rules
+}
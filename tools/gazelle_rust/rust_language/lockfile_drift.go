@@ -0,0 +1,96 @@
+package rust_language
+
+// Imports resolve against Cargo.lock, but crate universe repositories are
+// generated from cargo-bazel-lock.json. When the two disagree, such as after
+// a `cargo update` without repinning, resolved labels name crates the
+// repository doesn't define. With -rust_crate_universe_lockfile, packages
+// whose versions differ are reported before generating.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// The parts of cargo-bazel-lock.json compared with Cargo.lock.
+type crateUniverseLockfile struct {
+	Crates map[string]struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		// Null for workspace members.
+		Repository  json.RawMessage `json:"repository"`
+		CommonAttrs struct {
+			// The features the crate is built with, on every platform and
+			// by platform.
+			CrateFeatures struct {
+				Common  []string            `json:"common"`
+				Selects map[string][]string `json:"selects"`
+			} `json:"crate_features"`
+		} `json:"common_attrs"`
+		// Nil when the crate's build script isn't run.
+		BuildScriptAttrs *struct {
+			BuildScriptEnv struct {
+				Common  map[string]string            `json:"common"`
+				Selects map[string]map[string]string `json:"selects"`
+			} `json:"build_script_env"`
+		} `json:"build_script_attrs"`
+	} `json:"crates"`
+}
+
+func readCrateUniverseLockfile(repoRoot string, flags *rustFlags) (*crateUniverseLockfile, error) {
+	universeLockfilePath := flags.crateUniverseLockfilePath
+	if !filepath.IsAbs(universeLockfilePath) {
+		universeLockfilePath = filepath.Join(repoRoot, universeLockfilePath)
+	}
+	data, err := os.ReadFile(universeLockfilePath)
+	if err != nil {
+		return nil, err
+	}
+	var universeLockfile crateUniverseLockfile
+	if err := json.Unmarshal(data, &universeLockfile); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", flags.crateUniverseLockfilePath, err)
+	}
+	return &universeLockfile, nil
+}
+
+// Log the packages whose locked versions differ between Cargo.lock and the
+// crate universe lockfile.
+func checkLockfileDrift(rc *rustConfig, flags *rustFlags, universeLockfile *crateUniverseLockfile, externalCrates *ExternalCrates) {
+	pinned := make(map[string]bool)
+	for _, crate := range universeLockfile.Crates {
+		if len(crate.Repository) > 0 && string(crate.Repository) != "null" {
+			pinned[crate.Name+" "+crate.Version] = true
+		}
+	}
+	locked := make(map[string]bool)
+	for _, crate := range externalCrates.Packages() {
+		if crate.Source != "" {
+			locked[crate.Name+" "+crate.Version] = true
+		}
+	}
+
+	drifted := false
+	for _, crate := range slices.Sorted(maps.Keys(locked)) {
+		if !pinned[crate] {
+			log.Printf("%s: %s is in %s but not pinned", flags.crateUniverseLockfilePath, crate, rc.lockfilePath)
+			drifted = true
+		}
+	}
+	for _, crate := range slices.Sorted(maps.Keys(pinned)) {
+		if !locked[crate] {
+			log.Printf("%s: %s is pinned but not in %s", flags.crateUniverseLockfilePath, crate, rc.lockfilePath)
+			drifted = true
+		}
+	}
+	if drifted {
+		repinCommand := "CARGO_BAZEL_REPIN=1"
+		if flags.repinCommand != "" {
+			repinCommand = flags.repinCommand
+		}
+		log.Printf("%s is out of date with %s, so some resolved labels may not exist; repin with %s", flags.crateUniverseLockfilePath, rc.lockfilePath, repinCommand)
+	}
+}
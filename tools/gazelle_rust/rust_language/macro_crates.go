@@ -0,0 +1,65 @@
+package rust_language
+
+// Macros invoked by a bare name come from a crate the file may not otherwise
+// reference, typically through a `#[macro_use] extern crate` in another file
+// of the crate. Attribute macros such as `#[rstest]` are mapped alongside
+// function-like ones. `# gazelle:rust_macro_crate <macro> <crate>` adds to the
+// well-known ones below, and an empty crate unmaps a macro.
+//
+// Derives are mapped the same way with `# gazelle:rust_derive_crate <derive>
+// <crate>`. Derive names are generic enough, such as `Error` or `Parser`, that
+// a well-known crate only provides a derive when Cargo.lock contains it.
+
+import (
+	"slices"
+)
+
+var defaultCrateByMacro = map[string]string{
+	"async_trait": "async_trait",
+	"automock":    "mockall",
+	"bitflags":    "bitflags",
+	"cfg_if":      "cfg_if",
+	"lazy_static": "lazy_static",
+	"quick_error": "quick_error",
+	"rstest":      "rstest",
+	"test_case":   "test_case",
+}
+
+var defaultCrateByDerive = map[string]string{
+	"Args":        "clap",
+	"Builder":     "derive_builder",
+	"Deserialize": "serde",
+	"Error":       "thiserror",
+	"JsonSchema":  "schemars",
+	"Parser":      "clap",
+	"Serialize":   "serde",
+	"Subcommand":  "clap",
+	"ValueEnum":   "clap",
+}
+
+// Return the crates the source imports, including those providing the bare
+// macros and derives it invokes. Bare macros without a known crate become
+// exported macro imports.
+func sourceImports(rc *rustConfig, externalCrates *ExternalCrates, source ParsedSource) []string {
+	imports := source.Response.Imports
+	addImport := func(crate string) {
+		if crate != "" && !slices.Contains(imports, crate) {
+			imports = append(slices.Clip(imports), crate)
+		}
+	}
+	for _, name := range source.Response.BareMacros {
+		if crate, ok := rc.crateByMacro[name]; ok {
+			addImport(crate)
+		} else {
+			addImport(exportedMacroImport(name))
+		}
+	}
+	for _, name := range source.Response.BareDerives {
+		if crate, ok := rc.crateByDerive[name]; ok {
+			addImport(crate)
+		} else if crate := defaultCrateByDerive[name]; externalCrates.Contains(crate) {
+			addImport(crate)
+		}
+	}
+	return imports
+}
@@ -0,0 +1,53 @@
+package rust_language
+
+// `# gazelle:rust_native_link <name> <value>...` maps a native library bound
+// with `#[link(name = "<name>")]` to what rules binding it need: labels, such
+// as cc_library targets, become deps, and other values, such as
+// `-Lnative=/opt/zlib/lib`, become rustc_flags. Giving no values unmaps the
+// library.
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+type nativeLink struct {
+	deps       []label.Label
+	rustcFlags []string
+}
+
+func parseNativeLink(values []string) (nativeLink, error) {
+	var link nativeLink
+	for _, value := range values {
+		if !strings.HasPrefix(value, "//") && !strings.HasPrefix(value, "@") && !strings.HasPrefix(value, ":") {
+			link.rustcFlags = append(link.rustcFlags, value)
+			continue
+		}
+		dep, err := label.Parse(value)
+		if err != nil {
+			return nativeLink{}, err
+		}
+		link.deps = append(link.deps, dep)
+	}
+	return link, nil
+}
+
+// Return the mapped native libraries the sources bind, in name order.
+func nativeLinks(rc *rustConfig, sources []ParsedSource) []nativeLink {
+	linked := make(map[string]bool)
+	for _, source := range sources {
+		for _, name := range source.Response.LinkNames {
+			linked[name] = true
+		}
+	}
+	var links []nativeLink
+	for _, name := range slices.Sorted(maps.Keys(linked)) {
+		if link, ok := rc.nativeLinkByName[name]; ok {
+			links = append(links, link)
+		}
+	}
+	return links
+}
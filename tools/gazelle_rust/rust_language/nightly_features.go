@@ -0,0 +1,18 @@
+package rust_language
+
+// `# gazelle:rust_nightly_features tags <tag>...` tags rules whose sources
+// enable unstable features with `#![feature(...)]` or have `#[bench]`
+// functions, such as
+// `requires-nightly` for excluding them from stable CI with
+// --test_tag_filters, and `# gazelle:rust_nightly_features rustc_flags
+// <flag>...` gives them rustc_flags. Giving no values clears the setting.
+
+import (
+	"slices"
+)
+
+func usesNightlyFeatures(sources []ParsedSource) bool {
+	return slices.ContainsFunc(sources, func(source ParsedSource) bool {
+		return len(source.Response.NightlyFeatures) > 0 || source.Response.BenchCount > 0
+	})
+}
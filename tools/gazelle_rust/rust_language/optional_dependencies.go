@@ -0,0 +1,92 @@
+package rust_language
+
+// Optional dependencies in Cargo.toml are only compiled in when a feature
+// enables them, with `dep:name`, `name/feature`, or the implicit feature named
+// after a dependency no `dep:` entry refers to. A `name?/feature` entry only
+// enables the feature if something else enables the dependency. Rules don't
+// depend on optional dependencies of the nearest Cargo.toml that their
+// crate_features leave disabled, even when sources import them under
+// #[cfg(feature = "...")].
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+// Return the normalized names of the optional dependencies r's crate_features
+// leave disabled.
+func (l *rustLang) disabledOptionalDependencies(r *rule.Rule, pkg string) map[string]bool {
+	manifest := l.cargoManifests.manifestFor(pkg)
+	if manifest == nil {
+		return nil
+	}
+	if _, ok := r.Attr("crate_features").(*bzl.ListExpr); !ok && r.Attr("crate_features") != nil {
+		// Features chosen with select() may enable anything.
+		return nil
+	}
+
+	enabled := manifest.enabledOptionalDependencies(r.AttrStrings("crate_features"))
+	disabled := make(map[string]bool)
+	for _, dependency := range manifest.dependencies {
+		if dependency.optional && !enabled[rust_analysis.NormalizeCrateName(dependency.name)] {
+			disabled[rust_analysis.NormalizeCrateName(dependency.name)] = true
+		}
+	}
+	return disabled
+}
+
+// Return the normalized names of the optional dependencies the features
+// enable, following the features they enable in turn.
+func (manifest *cargoManifest) enabledOptionalDependencies(features []string) map[string]bool {
+	optional := make(map[string]bool)
+	for _, dependency := range manifest.dependencies {
+		if dependency.optional {
+			optional[rust_analysis.NormalizeCrateName(dependency.name)] = true
+		}
+	}
+	// Dependencies a `dep:` entry refers to have no implicit feature.
+	explicit := make(map[string]bool)
+	for _, values := range manifest.enabledByFeature {
+		for _, value := range values {
+			if name, ok := strings.CutPrefix(value, "dep:"); ok {
+				explicit[rust_analysis.NormalizeCrateName(name)] = true
+			}
+		}
+	}
+
+	enabled := make(map[string]bool)
+	visited := make(map[string]bool)
+	pending := slices.Clone(features)
+	for len(pending) > 0 {
+		feature := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if visited[feature] {
+			continue
+		}
+		visited[feature] = true
+		if name := rust_analysis.NormalizeCrateName(feature); optional[name] && !explicit[name] {
+			enabled[name] = true
+		}
+
+		for _, value := range manifest.enabledByFeature[feature] {
+			name, _, isDependencyFeature := strings.Cut(value, "/")
+			switch {
+			case strings.HasPrefix(value, "dep:"):
+				enabled[rust_analysis.NormalizeCrateName(strings.TrimPrefix(value, "dep:"))] = true
+			case isDependencyFeature && strings.HasSuffix(name, "?"):
+				// Enables a feature of the dependency only if it is enabled
+				// elsewhere.
+			case isDependencyFeature:
+				enabled[rust_analysis.NormalizeCrateName(name)] = true
+			default:
+				pending = append(pending, value)
+			}
+		}
+	}
+	return enabled
+}
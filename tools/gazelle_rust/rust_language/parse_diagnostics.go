@@ -0,0 +1,80 @@
+package rust_language
+
+// Files that fail to parse are left out of srcs and contribute no deps, so
+// failures are collected while generating and reported together at the end
+// of the run rather than silently producing incomplete rules. With
+// -rust_strict_parse, failures fail the run before BUILD files are written.
+
+import (
+	"errors"
+	"log"
+	"maps"
+	"path/filepath"
+	"slices"
+
+	messages "coppice/tools/gazelle_rust/proto"
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+type parseDiagnostics struct {
+	repoRoot string
+	strict   bool
+	// Keyed by the path relative to the repository root.
+	messageByFile map[string]string
+}
+
+func newParseDiagnostics(repoRoot string, strict bool) *parseDiagnostics {
+	return &parseDiagnostics{repoRoot: repoRoot, strict: strict, messageByFile: make(map[string]string)}
+}
+
+// Parse a source file, recording why it failed. Files too large to parse
+// yield an empty response.
+func (l *rustLang) parse(rc *rustConfig, filePath string) (*messages.ParseResponse, error) {
+	l.parseMutex.Lock()
+	skip := l.largeSources.skip(rc, filePath)
+	l.parseMutex.Unlock()
+	if skip {
+		return &messages.ParseResponse{Success: true}, nil
+	}
+	response, err := l.parser.Parse(filePath)
+	if err != nil {
+		l.parseMutex.Lock()
+		defer l.parseMutex.Unlock()
+		l.parseDiagnostics.add(filePath, err)
+		if l.sarifReport != nil {
+			l.sarifReport.addParseError(l.parseDiagnostics.relativePath(filePath), err)
+		}
+	}
+	return response, err
+}
+
+func (diagnostics *parseDiagnostics) add(filePath string, err error) {
+	message := err.Error()
+	var parseError *rust_analysis.ParseError
+	if errors.As(err, &parseError) {
+		message = parseError.Message
+	}
+	diagnostics.messageByFile[diagnostics.relativePath(filePath)] = message
+}
+
+// Return the slash-separated path of a file relative to the repository root.
+func (diagnostics *parseDiagnostics) relativePath(filePath string) string {
+	file, err := filepath.Rel(diagnostics.repoRoot, filePath)
+	if err != nil {
+		file = filePath
+	}
+	return filepath.ToSlash(file)
+}
+
+func (diagnostics *parseDiagnostics) report() {
+	if len(diagnostics.messageByFile) == 0 {
+		return
+	}
+	for _, file := range slices.Sorted(maps.Keys(diagnostics.messageByFile)) {
+		log.Printf("%s: failed to parse: %s", file, diagnostics.messageByFile[file])
+	}
+	if diagnostics.strict {
+		log.Fatalf("-rust_strict_parse: %d files failed to parse", len(diagnostics.messageByFile))
+	}
+	log.Printf("%d files failed to parse; their rules are missing srcs or deps", len(diagnostics.messageByFile))
+}
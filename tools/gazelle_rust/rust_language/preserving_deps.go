@@ -0,0 +1,119 @@
+package rust_language
+
+// Deps of existing rules are merged here rather than by gazelle, which
+// deletes them before resolution and so loses comments on the attribute and
+// its elements, and drops select branches no generated value accounts for.
+// Resolved deps replace the unconditional list, keeping the elements that
+// remain along with their comments, and every other part of the expression,
+// such as the select in `deps = [...] + select({...})`, is preserved as
+// written.
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// A deps value implementing rule.Merger.
+type preservingDeps struct {
+	// Package of the rule, for comparing relative labels.
+	pkg string
+	// Nil before resolution, when the existing expression is kept as is.
+	resolved []string
+}
+
+func (deps preservingDeps) BzlExpr() bzl.Expr {
+	return rule.ExprFromValue(deps.resolved)
+}
+
+func (deps preservingDeps) Merge(existing bzl.Expr) bzl.Expr {
+	if deps.resolved == nil {
+		return existing
+	}
+
+	parts := sumParts(existing)
+	listIndex := -1
+	conditionalLabels := make(map[string]bool)
+	for i, part := range parts {
+		if _, ok := part.(*bzl.ListExpr); ok && listIndex < 0 {
+			listIndex = i
+			continue
+		}
+		bzl.Walk(part, func(x bzl.Expr, _ []bzl.Expr) {
+			if str, ok := x.(*bzl.StringExpr); ok {
+				conditionalLabels[deps.normalize(str.Value)] = true
+			}
+		})
+	}
+
+	// Deps already listed under a condition stay conditional.
+	generated := &bzl.ListExpr{}
+	for _, dep := range deps.resolved {
+		if !conditionalLabels[deps.normalize(dep)] {
+			generated.List = append(generated.List, &bzl.StringExpr{Value: dep})
+		}
+	}
+
+	var existingList bzl.Expr
+	if listIndex >= 0 {
+		existingList = parts[listIndex]
+	}
+	merged := rule.MergeList(generated, existingList)
+	hasList := merged != nil && len(merged.List) > 0
+	if hasList {
+		// Added deps are appended; order them as generated lists are.
+		slices.SortStableFunc(merged.List, func(a, b bzl.Expr) int {
+			return strings.Compare(stringValue(a), stringValue(b))
+		})
+	}
+
+	var result []bzl.Expr
+	if hasList && listIndex < 0 {
+		result = append(result, merged)
+	}
+	for i, part := range parts {
+		if i != listIndex {
+			result = append(result, part)
+		} else if hasList {
+			result = append(result, merged)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+	sum := result[0]
+	for _, part := range result[1:] {
+		sum = &bzl.BinaryExpr{X: sum, Op: "+", Y: part}
+	}
+	return sum
+}
+
+// Flatten `a + b + c` into its operands, in order.
+func sumParts(expr bzl.Expr) []bzl.Expr {
+	sum, ok := expr.(*bzl.BinaryExpr)
+	if !ok || sum.Op != "+" {
+		return []bzl.Expr{expr}
+	}
+	return append(sumParts(sum.X), sumParts(sum.Y)...)
+}
+
+func stringValue(expr bzl.Expr) string {
+	if str, ok := expr.(*bzl.StringExpr); ok {
+		return str.Value
+	}
+	return ""
+}
+
+// Return dep as an absolute label string, so `:foo` and `//pkg:foo` compare
+// equal.
+func (deps preservingDeps) normalize(dep string) string {
+	depLabel, err := label.Parse(dep)
+	if err != nil {
+		return dep
+	}
+	return depLabel.Abs("", deps.pkg).String()
+}
@@ -0,0 +1,39 @@
+package rust_language
+
+// rules_rust requires proc-macro crates in proc_macro_deps rather than deps,
+// so with -rust_canonical_loads imports of workspace rust_proc_macro rules
+// resolve there. Other entries, such as crate universe proc macros added by
+// hand, are kept, and stay out of deps. The repository macros take no
+// proc_macro_deps.
+
+import (
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// Set r's proc_macro_deps to the resolved workspace proc macros and the
+// existing entries cloneExistingRule carried over, removing those from deps.
+func (l *rustLang) setProcMacroDeps(r *rule.Rule, resolved, deps map[string]bool, from label.Label) {
+	if existing := r.Attr("proc_macro_deps"); existing != nil {
+		if _, ok := existing.(*bzl.ListExpr); !ok {
+			return
+		}
+	}
+
+	procMacroDeps := resolved
+	for _, dep := range r.AttrStrings("proc_macro_deps") {
+		depLabel, err := label.Parse(dep)
+		if err == nil && l.procMacroLabels[depLabel.Abs("", from.Pkg)] {
+			continue
+		}
+		procMacroDeps[dep] = true
+		delete(deps, dep)
+	}
+
+	if len(procMacroDeps) > 0 {
+		r.SetAttr("proc_macro_deps", sortedKeys(procMacroDeps))
+	} else {
+		r.DelAttr("proc_macro_deps")
+	}
+}
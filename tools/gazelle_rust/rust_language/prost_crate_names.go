@@ -0,0 +1,82 @@
+package rust_language
+
+// rust_prost_library crates are named after their proto target by default,
+// with dashes as underscores, which is what rules_rust_prost does. Repositories
+// whose prost codegen names crates differently say how with
+// `# gazelle:rust_prost_crate_names <source> [trim_suffix=<suffix>]
+// [suffix=<suffix>]`, where the source is `proto` for the proto target's name,
+// `rule` for the rust_prost_library's own name, or `package` for the proto
+// target's package path with slashes as underscores. trim_suffix removes a
+// suffix such as `_proto` from the source name before suffix appends one such
+// as `_rs`. The directive applies to rust_prost_library rules in its
+// directory and subdirectories.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+type prostCrateNameSource string
+
+const (
+	protoProstCrateNames   prostCrateNameSource = "proto"
+	ruleProstCrateNames    prostCrateNameSource = "rule"
+	packageProstCrateNames prostCrateNameSource = "package"
+)
+
+type prostCrateNaming struct {
+	source     prostCrateNameSource
+	trimSuffix string
+	suffix     string
+}
+
+func parseProstCrateNaming(fields []string) (prostCrateNaming, error) {
+	if len(fields) == 0 {
+		return prostCrateNaming{}, fmt.Errorf("expected \"%s|%s|%s [trim_suffix=<suffix>] [suffix=<suffix>]\"", protoProstCrateNames, ruleProstCrateNames, packageProstCrateNames)
+	}
+	naming := prostCrateNaming{source: prostCrateNameSource(fields[0])}
+	switch naming.source {
+	case protoProstCrateNames, ruleProstCrateNames, packageProstCrateNames:
+	default:
+		return prostCrateNaming{}, fmt.Errorf("unknown source %q, expected %q, %q or %q", fields[0], protoProstCrateNames, ruleProstCrateNames, packageProstCrateNames)
+	}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		switch {
+		case ok && key == "trim_suffix":
+			naming.trimSuffix = value
+		case ok && key == "suffix":
+			naming.suffix = value
+		default:
+			return prostCrateNaming{}, fmt.Errorf("unknown option %q, expected trim_suffix=<suffix> or suffix=<suffix>", field)
+		}
+	}
+	return naming, nil
+}
+
+// Return the crate name of a rust_prost_library in pkg, or "" if it has no
+// proto to name it after.
+func (naming prostCrateNaming) crateName(r *rule.Rule, pkg string) string {
+	protoAttr := r.AttrString("proto")
+	if protoAttr == "" {
+		return ""
+	}
+	protoLabel, err := label.Parse(protoAttr)
+	if err != nil {
+		return ""
+	}
+	var name string
+	switch naming.source {
+	case ruleProstCrateNames:
+		name = r.Name()
+	case packageProstCrateNames:
+		name = strings.ReplaceAll(protoLabel.Abs("", pkg).Pkg, "/", "_")
+	default:
+		name = protoLabel.Name
+	}
+	name = strings.TrimSuffix(name, naming.trimSuffix) + naming.suffix
+	return strings.ReplaceAll(name, "-", "_")
+}
@@ -0,0 +1,244 @@
+package rust_language
+
+// -rust_public_api_output writes the public API of every rust_library as
+// JSON: the items declared `pub` in modules the crate root makes public, each
+// as its path and its declaration without a body, such as
+// `Config::new: fn new(name: &str) -> Self`. -rust_public_api_diff reads one
+// written at another revision, such as the base of a change, prints the
+// items each library added, changed or removed, and exits without writing
+// BUILD files, with status 1 if any library changed or removed items, since
+// those break its consumers. Libraries that didn't exist at the other
+// revision aren't reported.
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// The -rust_public_api_output value writing the API to stdout.
+const publicAPIStdout = "-"
+
+type publicAPI struct {
+	// Disabled when empty.
+	outputPath   string
+	crateByLabel map[string]publicAPICrate
+	// The API -rust_public_api_diff compares with, or nil.
+	base *publicAPIFile
+}
+
+type publicAPIFile struct {
+	Crates []publicAPICrate `json:"crates"`
+}
+
+type publicAPICrate struct {
+	Crate string `json:"crate"`
+	Label string `json:"label"`
+	// "<path>: <declaration>", sorted.
+	Items []string `json:"items"`
+}
+
+func newPublicAPI(outputPath, basePath string) (*publicAPI, error) {
+	api := &publicAPI{outputPath: outputPath, crateByLabel: make(map[string]publicAPICrate)}
+	if basePath == "" {
+		return api, nil
+	}
+	data, err := os.ReadFile(basePath)
+	if err != nil {
+		return nil, err
+	}
+	api.base = &publicAPIFile{}
+	if err := json.Unmarshal(data, api.base); err != nil {
+		return nil, fmt.Errorf("%s: %w", basePath, err)
+	}
+	return api, nil
+}
+
+// Record the public items of a library's sources.
+func (api *publicAPI) addLibrary(r *rule.Rule, crateName string, sources []ParsedSource, from label.Label) {
+	crateRoot := libraryCrateRoot(r)
+	if crateRoot == "" {
+		return
+	}
+	publicModules := map[string]bool{"": true}
+	itemsByModule := make(map[string][]string)
+	for _, source := range sources {
+		module, ok := modulePath(crateRoot, source.Src)
+		if !ok {
+			continue
+		}
+		for _, item := range source.Response.PublicItems {
+			itemPath := item.Path
+			if module != "" {
+				itemPath = module + "::" + item.Path
+			}
+			if strings.HasPrefix(item.Declaration, "mod ") {
+				publicModules[itemPath] = true
+			}
+			itemsByModule[module] = append(itemsByModule[module], itemPath+": "+item.Declaration)
+		}
+	}
+
+	items := []string{}
+	for module, moduleItems := range itemsByModule {
+		if isPublicModule(publicModules, module) {
+			items = append(items, moduleItems...)
+		}
+	}
+	slices.Sort(items)
+	api.crateByLabel[from.String()] = publicAPICrate{
+		Crate: crateName,
+		Label: from.String(),
+		Items: slices.Compact(items),
+	}
+}
+
+// Return the module path of a source file, such as "net::http" for
+// net/http.rs or net/http/mod.rs, or false if the file isn't under the crate
+// root's directory.
+func modulePath(crateRoot, src string) (string, bool) {
+	if src == crateRoot {
+		return "", true
+	}
+	rel := src
+	if directory := path.Dir(crateRoot); directory != "." {
+		var ok bool
+		if rel, ok = strings.CutPrefix(src, directory+"/"); !ok {
+			return "", false
+		}
+	}
+	rel = strings.TrimSuffix(strings.TrimSuffix(rel, ".rs"), "/mod")
+	return strings.ReplaceAll(rel, "/", "::"), true
+}
+
+// Report whether the module and every module enclosing it are declared pub.
+func isPublicModule(publicModules map[string]bool, module string) bool {
+	for prefix, rest := "", module; rest != ""; {
+		name, remainder, _ := strings.Cut(rest, "::")
+		if prefix != "" {
+			prefix += "::"
+		}
+		prefix += name
+		if !publicModules[prefix] {
+			return false
+		}
+		rest = remainder
+	}
+	return true
+}
+
+func (api *publicAPI) crates() []publicAPICrate {
+	crates := slices.Collect(maps.Values(api.crateByLabel))
+	slices.SortFunc(crates, func(a, b publicAPICrate) int {
+		return cmp.Compare(a.Label, b.Label)
+	})
+	if crates == nil {
+		crates = []publicAPICrate{}
+	}
+	return crates
+}
+
+func (api *publicAPI) write() {
+	if api.outputPath == "" {
+		return
+	}
+	var contents bytes.Buffer
+	encoder := json.NewEncoder(&contents)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(publicAPIFile{Crates: api.crates()}); err != nil {
+		log.Fatalf("-rust_public_api_output: %v", err)
+	}
+	if api.outputPath == publicAPIStdout {
+		os.Stdout.Write(contents.Bytes())
+		return
+	}
+	if err := os.WriteFile(api.outputPath, contents.Bytes(), 0o644); err != nil {
+		log.Printf("-rust_public_api_output: %v", err)
+	}
+}
+
+// Print the differences from the base API and exit.
+func (api *publicAPI) finish() {
+	if api.base == nil {
+		return
+	}
+	breaking := false
+	for _, baseCrate := range api.base.Crates {
+		crate, ok := api.crateByLabel[baseCrate.Label]
+		if !ok {
+			fmt.Printf("%s: removed\n", baseCrate.Label)
+			breaking = true
+			continue
+		}
+		lines, changed := apiChanges(baseCrate.Items, crate.Items)
+		if len(lines) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", baseCrate.Label)
+		for _, line := range lines {
+			fmt.Printf("  %s\n", line)
+		}
+		breaking = breaking || changed
+	}
+	if breaking {
+		fmt.Println("Public APIs changed incompatibly; review the changed and removed items above")
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// Describe the items added, changed or removed, by path, and report whether
+// any was changed or removed.
+func apiChanges(baseItems, items []string) ([]string, bool) {
+	declarationsByPath := func(items []string) map[string][]string {
+		byPath := make(map[string][]string)
+		for _, item := range items {
+			itemPath, declaration, _ := strings.Cut(item, ": ")
+			byPath[itemPath] = append(byPath[itemPath], declaration)
+		}
+		return byPath
+	}
+	baseByPath := declarationsByPath(baseItems)
+	byPath := declarationsByPath(items)
+
+	var lines []string
+	breaking := false
+	paths := slices.Concat(slices.Collect(maps.Keys(baseByPath)), slices.Collect(maps.Keys(byPath)))
+	slices.Sort(paths)
+	for _, itemPath := range slices.Compact(paths) {
+		var removed, added []string
+		for _, declaration := range baseByPath[itemPath] {
+			if !slices.Contains(byPath[itemPath], declaration) {
+				removed = append(removed, declaration)
+			}
+		}
+		for _, declaration := range byPath[itemPath] {
+			if !slices.Contains(baseByPath[itemPath], declaration) {
+				added = append(added, declaration)
+			}
+		}
+		if len(removed) == 1 && len(added) == 1 {
+			lines = append(lines, fmt.Sprintf("changed %s: %s => %s", itemPath, removed[0], added[0]))
+		} else {
+			for _, declaration := range removed {
+				lines = append(lines, fmt.Sprintf("removed %s: %s", itemPath, declaration))
+			}
+			for _, declaration := range added {
+				lines = append(lines, fmt.Sprintf("added %s: %s", itemPath, declaration))
+			}
+		}
+		breaking = breaking || len(removed) > 0
+	}
+	return lines, breaking
+}
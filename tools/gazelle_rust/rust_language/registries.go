@@ -0,0 +1,32 @@
+package rust_language
+
+// Packages in Cargo.lock from a registry other than crates.io, with a source
+// such as "registry+https://my-registry/index" or
+// "sparse+https://my-registry/index/", usually come from their own crate
+// universe. `# gazelle:rust_registry <index URL> <label prefix>` resolves the
+// registry's packages to labels under the prefix, such as `@my_crates//:`,
+// rather than the crate universe's. Giving only the URL resolves the
+// registry's packages to the crate universe again.
+
+import (
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+// Return the label of a Cargo.lock package: under its registry's prefix if one
+// is configured, or else the crate universe's.
+func (rc *rustConfig) lockedCrateLabel(crate rust_analysis.ExternalCrate) string {
+	if prefix, ok := rc.registryPrefix(crate); ok {
+		return prefix + crate.Name
+	}
+	return rc.cratesPrefix + crate.Name
+}
+
+// Return the label prefix configured for the registry a package comes from.
+func (rc *rustConfig) registryPrefix(crate rust_analysis.ExternalCrate) (string, bool) {
+	registry := crate.Registry()
+	if registry == "" {
+		return "", false
+	}
+	prefix, ok := rc.prefixByRegistry[registry]
+	return prefix, ok
+}
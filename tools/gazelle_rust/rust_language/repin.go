@@ -0,0 +1,96 @@
+package rust_language
+
+// With -rust_crate_universe_lockfile, imports that resolve to crates the crate
+// universe doesn't pin, such as a dependency just added to Cargo.toml, are
+// reported after resolving, since their labels don't exist until the crate
+// universe is repinned. -rust_repin_command gives the command that repins it,
+// such as `CARGO_BAZEL_REPIN=1 bazel mod deps`, which is printed, or run from
+// the repository root with -rust_repin.
+
+import (
+	"log"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+type unpinnedCrates struct {
+	repoRoot     string
+	lockfilePath string
+	cratesPrefix string
+	command      string
+	run          bool
+	pinned       map[string]bool
+	// The first rule importing each unpinned crate.
+	importerByCrate map[string]label.Label
+}
+
+func newUnpinnedCrates(repoRoot string, rc *rustConfig, flags *rustFlags, universeLockfile *crateUniverseLockfile) *unpinnedCrates {
+	pinned := make(map[string]bool)
+	for _, crate := range universeLockfile.Crates {
+		if len(crate.Repository) > 0 && string(crate.Repository) != "null" {
+			pinned[crate.Name] = true
+		}
+	}
+	return &unpinnedCrates{
+		repoRoot:        repoRoot,
+		lockfilePath:    flags.crateUniverseLockfilePath,
+		cratesPrefix:    rc.cratesPrefix,
+		command:         flags.repinCommand,
+		run:             flags.repin,
+		pinned:          pinned,
+		importerByCrate: make(map[string]label.Label),
+	}
+}
+
+// Record a dependency on an external crate, reporting whether it's unpinned.
+// Subtrees with their own crate universe, under another prefix, aren't pinned
+// by this lockfile.
+func (crates *unpinnedCrates) addDependency(dependency string, from label.Label) bool {
+	crate, ok := strings.CutPrefix(dependency, crates.cratesPrefix)
+	if !ok || crates.pinned[crate] {
+		return false
+	}
+	if _, ok := crates.importerByCrate[crate]; !ok {
+		crates.importerByCrate[crate] = from
+	}
+	return true
+}
+
+// Record a dependency on an external crate, and while its directory is
+// skipped, replay it when unpinned.
+func (l *rustLang) addUnpinnedDependency(dependency string, from label.Label) {
+	if l.unpinnedCrates.addDependency(dependency, from) && l.state != nil {
+		resolution := l.state.resolution(from.Pkg)
+		resolution.UnpinnedDependencies = append(resolution.UnpinnedDependencies, unpinnedDependency{Dependency: dependency, From: from.String()})
+	}
+}
+
+func (crates *unpinnedCrates) finish() {
+	if len(crates.importerByCrate) == 0 {
+		return
+	}
+	for _, crate := range slices.Sorted(maps.Keys(crates.importerByCrate)) {
+		log.Printf("%s: depends on %s%s, which %s doesn't pin", crates.importerByCrate[crate], crates.cratesPrefix, crate, crates.lockfilePath)
+	}
+	if crates.command == "" {
+		log.Printf("repin with CARGO_BAZEL_REPIN=1, or pass -rust_repin_command to name the command")
+		return
+	}
+	if !crates.run {
+		log.Printf("repin with: %s", crates.command)
+		return
+	}
+	log.Printf("repinning with: %s", crates.command)
+	cmd := exec.Command("sh", "-c", crates.command)
+	cmd.Dir = crates.repoRoot
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("-rust_repin_command: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package rust_language
+
+// `# gazelle:rust_resolution_order <source>...` sets which sources imports
+// resolve from, and in what order, after builtin crates, the rule's own crate
+// and gazelle:resolve directives. `# gazelle:rust_crate_resolution_order
+// <crate> <source>...` sets them for one crate, for instance to keep
+// depending on the crate universe package while a same-named workspace crate
+// replaces it. Imports found in none of the sources are guessed to be in the
+// crate universe.
+
+import (
+	"fmt"
+	"slices"
+)
+
+type resolutionStep string
+
+const (
+	workspaceResolutionStep resolutionStep = "workspace"
+	providedResolutionStep  resolutionStep = "provided"
+	lockfileResolutionStep  resolutionStep = "lockfile"
+)
+
+var (
+	resolutionSteps        = []resolutionStep{workspaceResolutionStep, providedResolutionStep, lockfileResolutionStep}
+	defaultResolutionOrder = resolutionSteps
+)
+
+func parseResolutionOrder(values []string) ([]resolutionStep, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("expected at least one of %q, %q or %q", workspaceResolutionStep, providedResolutionStep, lockfileResolutionStep)
+	}
+	var order []resolutionStep
+	for _, value := range values {
+		step := resolutionStep(value)
+		if !slices.Contains(resolutionSteps, step) {
+			return nil, fmt.Errorf("unknown source %q, expected %q, %q or %q", value, workspaceResolutionStep, providedResolutionStep, lockfileResolutionStep)
+		}
+		if slices.Contains(order, step) {
+			return nil, fmt.Errorf("source %q given twice", value)
+		}
+		order = append(order, step)
+	}
+	return order, nil
+}
+
+// Return the sources an import, with dashes normalized, resolves from.
+func (rc *rustConfig) importResolutionOrder(normalizedImport string) []resolutionStep {
+	if order, ok := rc.resolutionOrderByCrate[normalizedImport]; ok {
+		return order
+	}
+	return rc.resolutionOrder
+}
@@ -1,6 +1,9 @@
 package rust_language
 
 import (
+	"fmt"
+	"log"
+	"path"
 	"sort"
 	"strings"
 
@@ -9,11 +12,14 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
 )
 
-// Rust standard library crates that don't need external dependencies.
+// Rust standard library crates that don't need external dependencies, which
+// `# gazelle:rust_builtin_crates` extends.
 // Note: Primitive types (u32, char, etc.) are filtered by the parser.
-var builtins = map[string]bool{
+var defaultBuiltinCrates = map[string]bool{
 	"std":        true,
 	"core":       true,
 	"alloc":      true,
@@ -21,16 +27,16 @@ var builtins = map[string]bool{
 	"test":       true,
 }
 
-// Crates provided by external rules.
-var providedCrates = map[string]string{
-	"prost":    "@rules_rust_prost//private/3rdparty/crates:prost",
-	"runfiles": "@rules_rust//tools/runfiles",
-}
-
-const cratesPrefix = "@crates//:"
-
 // Return the crate name for a rule based on its package path.
-func getCrateName(r *rule.Rule, pkg string) string {
+func (l *rustLang) getCrateName(r *rule.Rule, pkg string) string {
+	if l.flags.canonicalLoads {
+		// rules_rust derives the crate name from the target name unless
+		// crate_name is set.
+		if crateName := r.AttrString("crate_name"); crateName != "" {
+			return crateName
+		}
+		return strings.ReplaceAll(r.Name(), "-", "_")
+	}
 	if r.Kind() == "rust_library" {
 		// Our wrapper macro converts package paths to crate names using double
 		// underscores.
@@ -48,76 +54,195 @@ func (l *rustLang) Imports(c *config.Config, r *rule.Rule, f *rule.File) []resol
 	var crateName string
 	switch r.Kind() {
 	case "rust_library":
-		crateName = getCrateName(r, pkg)
+		crateName = l.getCrateName(r, pkg)
+	case "rust_proc_macro":
+		crateName = l.getCrateName(r, pkg)
+		l.procMacroLabels[label.New("", pkg, r.Name())] = true
+	case "alias":
+		recordAlias(c, r, pkg)
+		return nil
+	case libraryGroupKind:
+		recordLibraryGroup(c, r, pkg)
+		return nil
 	case "rust_prost_library":
-		// rust_prost_library derives crate name from its proto attribute.
-		protoAttr := r.AttrString("proto")
-		if protoAttr == "" {
-			return nil
-		}
-		protoLabel, err := label.Parse(protoAttr)
-		if err != nil {
+		crateName = getRustConfig(c).prostCrateNaming.crateName(r, pkg)
+		if crateName == "" {
 			return nil
 		}
-		crateName = strings.ReplaceAll(protoLabel.Name, "-", "_")
 	default:
 		return nil
 	}
+	l.crateNameCollisions.add(l.flags.canonicalLoads, r, pkg, crateName)
+	l.crateNameByLabel[label.New("", pkg, r.Name())] = crateName
+	if l.crateMap != nil {
+		var crateRoot string
+		if r.Kind() != "rust_prost_library" {
+			if file := libraryCrateRoot(r); file != "" {
+				crateRoot = path.Join(pkg, file)
+			}
+		}
+		l.crateMap.addWorkspaceCrate(crateName, label.New("", pkg, r.Name()), crateRoot)
+	}
 
-	return []resolve.ImportSpec{
+	specs := []resolve.ImportSpec{
 		{
 			Lang: langName,
 			Imp:  crateName,
 		},
 	}
+	if r.Kind() == "rust_library" {
+		for _, name := range l.exportedMacros(c, r, pkg) {
+			specs = append(specs, resolve.ImportSpec{Lang: langName, Imp: exportedMacroImport(name)})
+		}
+	}
+	return specs
 }
 
-func (l *rustLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.RemoteCache, r *rule.Rule, imports any, from label.Label) {
+func (l *rustLang) Resolve(c *config.Config, ix *resolve.RuleIndex, remoteCache *repo.RemoteCache, r *rule.Rule, imports any, from label.Label) {
 	ruleData, ok := imports.(RuleData)
 	if !ok {
 		return
 	}
+	if r.Kind() == licenseReportKind {
+		l.licenseReports.addReport(r, ruleData, from)
+		return
+	}
 
+	rc := getRustConfig(c)
+	if l.publicAPI != nil && r.Kind() == "rust_library" {
+		l.publicAPI.addLibrary(r, l.getCrateName(r, from.Pkg), ruleData.Sources, from)
+	}
+	externalCrates := getExternalCrates(c)
+	licenseDependencies := l.licenseReports.addDependencies(from, externalCrates)
+	if l.crateMap != nil {
+		l.crateMap.addDirectory(rc, externalCrates)
+	}
 	deps := make(map[string]bool)
+	procMacroDeps := make(map[string]bool)
 
 	// Get this rule's crate name to skip self-imports.
-	selfCrateName := getCrateName(r, from.Pkg)
+	selfCrateName := l.getCrateName(r, from.Pkg)
 
-	for _, response := range ruleData.Responses {
-		for _, importName := range response.Imports {
-			if builtins[importName] {
-				continue
-			}
-
-			if importName == selfCrateName {
-				continue
-			}
+	workspaceHack, hasWorkspaceHack := l.workspaceHack(c, ix, rc)
+	isWorkspaceHack := hasWorkspaceHack && workspaceHack.Equal(from)
 
-			normalizedImport := strings.ReplaceAll(importName, "-", "_")
+	disabledOptionalDependencies := l.disabledOptionalDependencies(r, from.Pkg)
 
-			spec := resolve.ImportSpec{
-				Lang: langName,
-				Imp:  normalizedImport,
+	isLibrary := r.Kind() == "rust_library"
+	if isLibrary {
+		l.dependencyGraph.addLibrary(from)
+		if rc.visibilityMode == consumersVisibilityMode {
+			writtenRule := r
+			if ruleData.ExistingRule != nil {
+				writtenRule = ruleData.ExistingRule
 			}
+			l.consumerVisibility.addLibrary(from, writtenRule, rc.visibility)
+		}
+	}
 
-			// Check workspace first via rule index.
-			if matches := ix.FindRulesByImportWithConfig(c, spec, langName); len(matches) > 0 {
-				depLabel := matches[0].Label
-				relativeLabel := depLabel.Rel(from.Repo, from.Pkg)
-				deps[relativeLabel.String()] = true
+	for _, source := range ruleData.Sources {
+		for _, importName := range sourceImports(rc, externalCrates, source) {
+			if disabledOptionalDependencies[rust_analysis.NormalizeCrateName(importName)] {
 				continue
 			}
-
-			if providedLabel, ok := providedCrates[normalizedImport]; ok {
-				deps[providedLabel] = true
+			resolution := resolveImport(c, ix, rc, externalCrates, importName, selfCrateName, from)
+			if hasWorkspaceHack && resolution.absoluteLabel().Equal(workspaceHack) {
 				continue
 			}
+			if resolution.source == workspaceResolution {
+				l.consumerVisibility.addConsumer(resolution.dependency, from)
+				if group, ok := libraryGroupOf(c, rc, resolution.dependency, from); ok {
+					l.consumerVisibility.addConsumer(resolution.dependency, group)
+				}
+				if isLibrary {
+					l.dependencyGraph.addEdge(from, resolution.dependency, source.Src, importName)
+				}
+			}
+			if l.resolveQuery == nil {
+				if l.sarifReport != nil {
+					l.sarifReport.addResolution(rc, source, importName, resolution, from)
+				}
+				if resolution.source == guessedResolution {
+					externalCrates.reportMissingLockfile(rc, &l.flags)
+				}
+				if l.flags.strict && resolution.source == guessedResolution {
+					l.writeSarifReport()
+					log.Fatalf("%s: %s", from, unresolvedImportMessage(rc, importName))
+				}
+				if resolution.ambiguous {
+					l.ambiguityMessages = append(l.ambiguityMessages, fmt.Sprintf("%s: %s", from, ambiguityMessage(importName, resolution)))
+				}
+			}
+			if resolution.label == "" {
+				continue
+			}
+			switch resolution.source {
+			case workspaceResolution, overrideResolution:
+				licenseDependencies.libraries = append(licenseDependencies.libraries, resolution.dependency)
+			case lockfileResolution:
+				crate, _ := externalCrates.Get(importName)
+				licenseDependencies.crates = append(licenseDependencies.crates, crate)
+				if l.featureReport != nil {
+					l.featureReport.addDependency(l.cargoManifests, r, from, importName, crate)
+				}
+			}
+			if l.cargoManifestCheck != nil && (resolution.source == lockfileResolution || resolution.source == guessedResolution) {
+				l.cargoManifestCheck.addImport(importName, from)
+			}
+			if l.unpinnedCrates != nil && (resolution.source == lockfileResolution || resolution.source == guessedResolution) {
+				l.addUnpinnedDependency(resolution.label, from)
+			}
+			if len(rc.forbiddenDependencies) > 0 {
+				l.checkLayering(rc, resolution.absoluteLabel(), importName, from)
+			}
+			if l.flags.canonicalLoads && l.procMacroLabels[resolution.absoluteLabel()] {
+				procMacroDeps[resolution.label] = true
+			} else {
+				deps[resolution.label] = true
+			}
+			for _, nativeDep := range rc.nativeDepsByCrate[strings.ReplaceAll(importName, "-", "_")] {
+				deps[dependencyLabel(c, nativeDep, from).String()] = true
+			}
+		}
+	}
 
-			crateName := getExternalCrates(c).GetName(normalizedImport)
-			deps[cratesPrefix+crateName] = true
+	for _, link := range nativeLinks(rc, ruleData.Sources) {
+		for _, dep := range link.deps {
+			deps[dependencyLabel(c, dep, from).String()] = true
 		}
 	}
 
+	if isLibrary && hasWorkspaceHack && !isWorkspaceHack {
+		deps[dependencyLabel(c, workspaceHack, from).String()] = true
+	}
+
+	if r.Kind() == "rust_test" {
+		for _, dep := range rc.defaultTestDeps {
+			deps[dependencyLabel(c, dep, from).String()] = true
+		}
+	}
+
+	checkIncludedFiles(r, ruleData.Sources, from)
+
+	// A crate test's kept deps may serve the crate's sources, which aren't
+	// parsed for it, and the workspace-hack's only unify features.
+	if ruleData.ExistingRule != nil && !isCrateTest(ruleData.ExistingRule) && !isWorkspaceHack {
+		l.checkHandMaintainedDeps(rc, externalCrates, ruleData.ExistingRule, ruleData, deps, from)
+	}
+
+	if l.resolveQuery != nil {
+		l.resolveQuery.answer(c, ix, rc, externalCrates, r, ruleData.Sources, selfCrateName, from)
+	}
+
+	if l.flags.canonicalLoads {
+		l.setProcMacroDeps(r, procMacroDeps, deps, from)
+	}
+
+	if ruleData.ExistingRule != nil && ruleData.ExistingRule.Attr("deps") != nil {
+		r.SetAttr("deps", preservingDeps{pkg: from.Pkg, resolved: sortedKeys(deps)})
+		return
+	}
+
 	if len(deps) > 0 {
 		r.SetAttr("deps", sortedKeys(deps))
 	} else {
@@ -125,6 +250,159 @@ func (l *rustLang) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *repo.Rem
 	}
 }
 
+type resolutionSource string
+
+const (
+	builtinResolution   resolutionSource = "builtin"
+	selfResolution      resolutionSource = "self"
+	overrideResolution  resolutionSource = "resolve directive"
+	workspaceResolution resolutionSource = "workspace"
+	providedResolution  resolutionSource = "provided crate"
+	lockfileResolution  resolutionSource = "Cargo.lock"
+	// Not found anywhere; the crate universe label is a guess.
+	guessedResolution resolutionSource = "guessed crate"
+	// A bare macro no workspace crate exports, which needs no dependency.
+	unexportedMacroResolution resolutionSource = "macro no workspace crate exports"
+)
+
+type importResolution struct {
+	source resolutionSource
+	// Empty for imports that need no dependency.
+	label string
+	// The absolute label of workspace and override resolutions.
+	dependency label.Label
+	// All workspace rules providing the import, best ranked first, for
+	// workspace resolutions.
+	candidates []resolve.FindResult
+	// Several workspace crates claim the import and ranking isn't enabled, so
+	// the dependency is unknown.
+	ambiguous bool
+}
+
+// Return the dependency as an absolute label, for comparing with patterns.
+func (resolution importResolution) absoluteLabel() label.Label {
+	if resolution.source == workspaceResolution || resolution.source == overrideResolution {
+		return resolution.dependency
+	}
+	dep, err := label.Parse(resolution.label)
+	if err != nil {
+		return label.NoLabel
+	}
+	return dep
+}
+
+// Resolve one import, in order: standard library crates, the rule's own
+// crate, gazelle:resolve directives, then the configured sources, by default
+// workspace crates, provided crates, then the crate universe.
+func resolveImport(c *config.Config, ix *resolve.RuleIndex, rc *rustConfig, externalCrates *ExternalCrates, importName, selfCrateName string, from label.Label) importResolution {
+	if rc.builtinCrates[importName] {
+		return importResolution{source: builtinResolution}
+	}
+
+	if importName == selfCrateName {
+		return importResolution{source: selfResolution}
+	}
+
+	normalizedImport := strings.ReplaceAll(importName, "-", "_")
+
+	spec := resolve.ImportSpec{
+		Lang: langName,
+		Imp:  normalizedImport,
+	}
+
+	if dep, ok := resolve.FindRuleWithOverride(c, spec, langName); ok {
+		return importResolution{
+			source:     overrideResolution,
+			label:      dependencyLabel(c, dep, from).String(),
+			dependency: dep,
+		}
+	}
+
+	if isExportedMacroImport(importName) {
+		return resolveExportedMacro(c, ix, rc, spec, from)
+	}
+
+	for _, step := range rc.importResolutionOrder(normalizedImport) {
+		switch step {
+		case workspaceResolutionStep:
+			if matches := ix.FindRulesByImportWithConfig(c, spec, langName); len(matches) > 0 {
+				return workspaceImportResolution(c, rc, matches, from)
+			}
+		case providedResolutionStep:
+			if providedLabel, ok := rc.providedLabelByCrate[normalizedImport]; ok {
+				return importResolution{source: providedResolution, label: providedLabel}
+			}
+		case lockfileResolutionStep:
+			if crate, ok := externalCrates.Get(normalizedImport); ok {
+				return importResolution{
+					source: lockfileResolution,
+					label:  rc.lockedCrateLabel(crate),
+				}
+			}
+		}
+	}
+	return importResolution{
+		source: guessedResolution,
+		label:  rc.cratesPrefix + externalCrates.GetName(normalizedImport),
+	}
+}
+
+func workspaceImportResolution(c *config.Config, rc *rustConfig, matches []resolve.FindResult, from label.Label) importResolution {
+	matches = rankCandidates(matches, from)
+	depLabel, grouped := libraryGroupOf(c, rc, matches[0].Label, from)
+	if !grouped {
+		depLabel = aliasedLabel(c, rc, matches[0].Label)
+	}
+	return importResolution{
+		source:     workspaceResolution,
+		label:      dependencyLabel(c, depLabel, from).String(),
+		dependency: matches[0].Label,
+		candidates: matches,
+		ambiguous:  len(matches) > 1 && rc.ambiguousImports == errorAmbiguousImports,
+	}
+}
+
+func unresolvedImportMessage(rc *rustConfig, importName string) string {
+	return fmt.Sprintf("import %q does not match a workspace crate, a provided crate, or a package in %s", importName, rc.lockfilePath)
+}
+
+func ambiguityMessage(importName string, resolution importResolution) string {
+	var message strings.Builder
+	fmt.Fprintf(&message, "import %q matches %d workspace crates:", importName, len(resolution.candidates))
+	for _, candidate := range resolution.candidates {
+		fmt.Fprintf(&message, "\n  %s", candidate.Label)
+	}
+	fmt.Fprintf(&message, "\nchoose one with `# gazelle:resolve rust %s <label>`", strings.ReplaceAll(importName, "-", "_"))
+	return message.String()
+}
+
+func (l *rustLang) reportAmbiguities() {
+	if len(l.ambiguityMessages) == 0 {
+		return
+	}
+	sort.Strings(l.ambiguityMessages)
+	for _, message := range l.ambiguityMessages {
+		log.Print(message)
+	}
+	log.Fatalf("%d imports match several workspace crates", len(l.ambiguityMessages))
+}
+
+// Express a workspace match as a label usable from the consuming rule. Matches
+// in the consuming repository become package-relative, and matches in other
+// repositories use the apparent repository name declared in MODULE.bazel.
+func dependencyLabel(c *config.Config, depLabel, from label.Label) label.Label {
+	if depLabel.Repo == from.Repo {
+		return depLabel.Rel(from.Repo, from.Pkg)
+	}
+	if !depLabel.Canonical && c.ModuleToApparentName != nil {
+		if apparentName := c.ModuleToApparentName(depLabel.Repo); apparentName != "" {
+			depLabel.Repo = apparentName
+		}
+	}
+	// The apparent name may be how the consuming repository refers to itself.
+	return depLabel.Rel(from.Repo, from.Pkg)
+}
+
 func sortedKeys(set map[string]bool) []string {
 	keys := make([]string, 0, len(set))
 	for key := range set {
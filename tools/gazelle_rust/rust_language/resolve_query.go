@@ -0,0 +1,123 @@
+package rust_language
+
+// -rust_resolve_query=<package>:<import> explains how an import would resolve
+// from a package and exits before any BUILD file is written.
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+
+	messages "coppice/tools/gazelle_rust/proto"
+)
+
+var importReferenceDescriptions = map[messages.ImportReference]string{
+	messages.ImportReference_IMPORT_REFERENCE_PATH:         "qualified path",
+	messages.ImportReference_IMPORT_REFERENCE_USE:          "use statement",
+	messages.ImportReference_IMPORT_REFERENCE_EXTERN_CRATE: "extern crate",
+	messages.ImportReference_IMPORT_REFERENCE_ATTRIBUTE:    "attribute",
+}
+
+type resolveQuery struct {
+	pkg        string
+	importName string
+	// Whether -rust_strict rejects guessed labels.
+	strict   bool
+	answered bool
+}
+
+func parseResolveQuery(value string, strict bool) (*resolveQuery, error) {
+	separator := strings.LastIndex(value, ":")
+	if separator < 0 || separator == len(value)-1 {
+		return nil, fmt.Errorf("invalid -rust_resolve_query value %q, expected \"<package>:<import>\"", value)
+	}
+	return &resolveQuery{
+		pkg:        strings.TrimPrefix(value[:separator], "//"),
+		importName: value[separator+1:],
+		strict:     strict,
+	}, nil
+}
+
+// Print the resolution of the queried import for the first resolved rule in
+// the queried package.
+func (query *resolveQuery) answer(c *config.Config, ix *resolve.RuleIndex, rc *rustConfig, externalCrates *ExternalCrates, r *rule.Rule, sources []ParsedSource, selfCrateName string, from label.Label) {
+	if query.answered || from.Pkg != query.pkg {
+		return
+	}
+	query.answered = true
+
+	resolution := resolveImport(c, ix, rc, externalCrates, query.importName, selfCrateName, from)
+	normalizedImport := strings.ReplaceAll(query.importName, "-", "_")
+
+	fmt.Printf("resolving %q from %s (%s)\n", query.importName, from, r.Kind())
+	for _, source := range sources {
+		for _, provenance := range source.Response.ImportProvenances {
+			if strings.ReplaceAll(provenance.Name, "-", "_") != normalizedImport {
+				continue
+			}
+			var descriptions []string
+			for _, reference := range provenance.References {
+				descriptions = append(descriptions, importReferenceDescriptions[reference])
+			}
+			fmt.Printf("  referenced in %s by %s\n", source.Src, strings.Join(descriptions, ", "))
+		}
+	}
+	steps := []struct {
+		source resolutionSource
+		miss   string
+	}{
+		{builtinResolution, "not a standard library crate"},
+		{selfResolution, fmt.Sprintf("not this rule's crate %q", selfCrateName)},
+		{overrideResolution, "no gazelle:resolve directive matches"},
+		{workspaceResolution, fmt.Sprintf("no workspace rule provides crate %q", normalizedImport)},
+		{providedResolution, "not a provided crate"},
+		{lockfileResolution, fmt.Sprintf("no package in %s", rc.lockfilePath)},
+	}
+	for _, step := range steps {
+		if step.source != resolution.source {
+			fmt.Printf("  %s: %s\n", step.source, step.miss)
+			continue
+		}
+		switch step.source {
+		case workspaceResolution:
+			fmt.Printf("  %s: %d candidate(s)\n", step.source, len(resolution.candidates))
+			for i, candidate := range resolution.candidates {
+				marker := ""
+				if i == 0 && !resolution.ambiguous {
+					marker = " (used)"
+				}
+				fmt.Printf("    %s%s\n", candidate.Label, marker)
+			}
+		case lockfileResolution:
+			fmt.Printf("  %s: %s\n", step.source, externalCrates.Describe(query.importName))
+		default:
+			fmt.Printf("  %s: match\n", step.source)
+		}
+		break
+	}
+
+	switch {
+	case resolution.label == "":
+		fmt.Printf("result: no dependency (%s)\n", resolution.source)
+	case resolution.ambiguous:
+		fmt.Printf("result: error, %s\n", ambiguityMessage(query.importName, resolution))
+	case resolution.source == guessedResolution && query.strict:
+		fmt.Printf("result: error, -rust_strict rejects imports that match nothing\n")
+	default:
+		fmt.Printf("result: %s (%s)\n", resolution.label, resolution.source)
+	}
+}
+
+// Exit once every rule is resolved, before any BUILD file is written.
+func (query *resolveQuery) finish() {
+	if !query.answered {
+		log.Fatalf("-rust_resolve_query: no Rust rule in package %q was resolved; check the package path and that gazelle visits it", query.pkg)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,38 @@
+package rust_language
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// Index matches, including those from other repositories, as the consuming
+// rule refers to them.
+func TestDependencyLabel(t *testing.T) {
+	apparentNameByModule := map[string]string{
+		"shared_crates": "shared",
+		"app_repo":      "app",
+	}
+	c := &config.Config{
+		ModuleToApparentName: func(module string) string {
+			return apparentNameByModule[module]
+		},
+	}
+	from := label.New("app_repo", "server", "server")
+	tests := []struct {
+		dependency label.Label
+		want       string
+	}{
+		{label.New("app_repo", "server", "handlers"), ":handlers"},
+		{label.New("app_repo", "util", "util"), "//util"},
+		{label.New("shared_crates", "util", "util"), "@shared//util"},
+		{label.New("platform_crates", "platform", "platform"), "@platform_crates//platform"},
+		{label.Label{Repo: "shared_crates+", Pkg: "pinned", Name: "pinned", Canonical: true}, "@@shared_crates+//pinned"},
+	}
+	for _, test := range tests {
+		if got := dependencyLabel(c, test.dependency, from).String(); got != test.want {
+			t.Errorf("dependencyLabel(%s) = %s, want %s", test.dependency, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package rust_language
+
+// The changes to Rust rules, for the modes reporting them instead of writing
+// BUILD files. Each rule is compared with the BUILD file on disk once
+// resolution is done. Changes made by other languages aren't included.
+
+import (
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+type ruleChanges struct {
+	buildFileName string
+	loads         []rule.LoadInfo
+	kinds         map[string]rule.KindInfo
+	packages      []changedPackage
+}
+
+type changedPackage struct {
+	rel string
+	// The file gazelle merges rules into, or nil if the package has no BUILD
+	// file yet.
+	file *rule.File
+	// The rules generated for a package without a BUILD file.
+	generated []*rule.Rule
+}
+
+type changeAction string
+
+const (
+	// The package's BUILD file is created.
+	touchChange changeAction = "touch"
+	// A load of the kind in values[1] from values[0] is added.
+	loadChange changeAction = "load"
+	// A rule of the kind in values[0] is added.
+	newChange changeAction = "new"
+	// The rule's kind becomes values[0].
+	kindChange changeAction = "kind"
+	// Values are added to, or removed from, a string list attribute.
+	addChange    changeAction = "add"
+	removeChange changeAction = "remove"
+	// The attribute is removed.
+	removeAttrChange changeAction = "remove attribute"
+	// A scalar attribute is set to values[0].
+	setChange changeAction = "set"
+	// Any other attribute is replaced by the expression in values[0].
+	replaceChange changeAction = "replace"
+	// The rule is deleted.
+	deleteChange changeAction = "delete"
+)
+
+type ruleChange struct {
+	pkg    string
+	target label.Label
+	action changeAction
+	attr   string
+	values []string
+}
+
+func newRuleChanges(c *config.Config, l *rustLang) *ruleChanges {
+	return &ruleChanges{
+		buildFileName: c.DefaultBuildFileName(),
+		loads:         l.Loads(),
+		kinds:         l.Kinds(),
+	}
+}
+
+func (changes *ruleChanges) addPackage(args language.GenerateArgs, result language.GenerateResult) {
+	changes.packages = append(changes.packages, changedPackage{rel: args.Rel, file: args.File, generated: result.Gen})
+}
+
+// Return the changes of every package, in package order.
+func (changes *ruleChanges) collect() []ruleChange {
+	slices.SortStableFunc(changes.packages, func(a, b changedPackage) int {
+		return strings.Compare(a.rel, b.rel)
+	})
+	var collected []ruleChange
+	for _, pkg := range changes.packages {
+		collected = changes.diffPackage(collected, pkg)
+	}
+	return collected
+}
+
+func (changes *ruleChanges) diffPackage(collected []ruleChange, pkg changedPackage) []ruleChange {
+	var original *rule.File
+	finalRules := pkg.generated
+	if pkg.file != nil {
+		loaded, err := rule.LoadFile(pkg.file.Path, pkg.rel)
+		if err != nil {
+			log.Fatalf("%s: %v", pkg.file.Path, err)
+		}
+		original = loaded
+		finalRules = pkg.file.Rules
+	} else if len(pkg.generated) > 0 {
+		collected = append(collected, ruleChange{pkg: pkg.rel, target: label.New("", pkg.rel, "__pkg__"), action: touchChange})
+	}
+
+	originalByName := make(map[string]*rule.Rule)
+	loadedKinds := make(map[string]bool)
+	if original != nil {
+		for _, r := range original.Rules {
+			if _, ok := changes.kinds[r.Kind()]; ok {
+				originalByName[r.Name()] = r
+			}
+		}
+		for _, load := range original.Loads {
+			for _, symbol := range load.Symbols() {
+				loadedKinds[symbol] = true
+			}
+		}
+	}
+
+	finalNames := make(map[string]bool)
+	for _, r := range finalRules {
+		if _, ok := changes.kinds[r.Kind()]; !ok {
+			continue
+		}
+		finalNames[r.Name()] = true
+		collected = changes.diffRule(collected, pkg.rel, loadedKinds, originalByName[r.Name()], r)
+	}
+	if original == nil {
+		return collected
+	}
+	for _, r := range original.Rules {
+		if originalByName[r.Name()] != nil && !finalNames[r.Name()] {
+			collected = append(collected, ruleChange{pkg: pkg.rel, target: label.New("", pkg.rel, r.Name()), action: deleteChange})
+		}
+	}
+	return collected
+}
+
+func (changes *ruleChanges) diffRule(collected []ruleChange, pkg string, loadedKinds map[string]bool, before, after *rule.Rule) []ruleChange {
+	target := label.New("", pkg, after.Name())
+	if before == nil {
+		if !loadedKinds[after.Kind()] {
+			loadedKinds[after.Kind()] = true
+			for _, info := range changes.loads {
+				if slices.Contains(info.Symbols, after.Kind()) {
+					collected = append(collected, ruleChange{pkg: pkg, target: label.New("", pkg, "__pkg__"), action: loadChange, values: []string{info.Name, after.Kind()}})
+					break
+				}
+			}
+		}
+		collected = append(collected, ruleChange{pkg: pkg, target: target, action: newChange, values: []string{after.Kind()}})
+		before = rule.NewRule(after.Kind(), after.Name())
+	} else if before.Kind() != after.Kind() {
+		collected = append(collected, ruleChange{pkg: pkg, target: target, action: kindChange, values: []string{after.Kind()}})
+	}
+
+	var attrs []string
+	for _, attr := range append(before.AttrKeys(), after.AttrKeys()...) {
+		if attr != "name" && !slices.Contains(attrs, attr) {
+			attrs = append(attrs, attr)
+		}
+	}
+	slices.Sort(attrs)
+	for _, attr := range attrs {
+		collected = diffAttr(collected, pkg, target, attr, before.Attr(attr), after.Attr(attr))
+	}
+	return collected
+}
+
+func diffAttr(collected []ruleChange, pkg string, target label.Label, attr string, before, after bzl.Expr) []ruleChange {
+	change := ruleChange{pkg: pkg, target: target, attr: attr}
+	if after == nil {
+		if before != nil {
+			change.action = removeAttrChange
+			collected = append(collected, change)
+		}
+		return collected
+	}
+	if before != nil && bzl.FormatString(before) == bzl.FormatString(after) {
+		return collected
+	}
+
+	afterValues, afterIsList := stringList(after)
+	beforeValues, beforeIsList := stringList(before)
+	switch {
+	case afterIsList && (before == nil || beforeIsList):
+		var added, removed []string
+		for _, value := range afterValues {
+			if !slices.Contains(beforeValues, value) {
+				added = append(added, value)
+			}
+		}
+		for _, value := range beforeValues {
+			if !slices.Contains(afterValues, value) {
+				removed = append(removed, value)
+			}
+		}
+		if len(added) > 0 {
+			change.action, change.values = addChange, added
+			collected = append(collected, change)
+		}
+		if len(removed) > 0 {
+			change.action, change.values = removeChange, removed
+			collected = append(collected, change)
+		}
+	case isScalar(after):
+		change.action, change.values = setChange, []string{bzl.FormatString(after)}
+		collected = append(collected, change)
+	default:
+		change.action, change.values = replaceChange, []string{bzl.FormatString(after)}
+		collected = append(collected, change)
+	}
+	return collected
+}
+
+func stringList(expr bzl.Expr) ([]string, bool) {
+	list, ok := expr.(*bzl.ListExpr)
+	if !ok {
+		return nil, false
+	}
+	values := make([]string, 0, len(list.List))
+	for _, element := range list.List {
+		str, ok := element.(*bzl.StringExpr)
+		if !ok {
+			return nil, false
+		}
+		values = append(values, str.Value)
+	}
+	return values, true
+}
+
+func isScalar(expr bzl.Expr) bool {
+	switch expr.(type) {
+	case *bzl.StringExpr, *bzl.LiteralExpr, *bzl.Ident:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package rust_language
+
+// `# gazelle:rust_analyzer_project <name>` gives a directory a `<name>` target
+// running rules_rust's gen_rust_project for the targets in its subtree, so
+// `bazel run //<directory>:<name>` writes the rust-project.json rust-analyzer
+// reads without copying the invocation into every project.
+
+import (
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+const (
+	rustAnalyzerProjectKind = "native_binary"
+	genRustProjectLabel     = "@rules_rust//tools/rust_analyzer:gen_rust_project"
+)
+
+func emitRustAnalyzerProject(result *language.GenerateResult, pkg, name string) {
+	r := rule.NewRule(rustAnalyzerProjectKind, name)
+	r.SetAttr("src", genRustProjectLabel)
+	// native_binary copies src to out, which can't share the target's name.
+	r.SetAttr("out", name+"_bin")
+	pattern := "//..."
+	if pkg != "" {
+		pattern = "//" + pkg + "/..."
+	}
+	r.SetAttr("args", []string{pattern})
+	result.Gen = append(result.Gen, r)
+	result.Imports = append(result.Imports, nil)
+}
@@ -0,0 +1,71 @@
+package rust_language
+
+import (
+	"slices"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Set the rustc_flags of `# gazelle:rust_rustc_flags`, those for nightly
+// features and native libraries the rule uses, and those for test coverage,
+// keeping the existing rule's other flags in order. Without flag directives,
+// existing flags are left as written.
+func setRustcFlags(r *rule.Rule, rc *rustConfig, sources []ParsedSource, existingRule *rule.Rule) {
+	if keepExistingAttr(r, existingRule, "rustc_flags", hasConfiguredRustcFlags(r, rc)) {
+		return
+	}
+	var flags []string
+	if existingRule != nil {
+		flags = existingRule.AttrStrings("rustc_flags")
+		// Configured flags may take arguments, as in `-D warnings`, so they
+		// are removed as a run rather than one by one.
+		flags = withoutRun(flags, rc.rustcFlags)
+		flags = withoutRun(flags, rc.nightlyRustcFlags)
+		for _, link := range rc.nativeLinkByName {
+			flags = withoutRun(flags, link.rustcFlags)
+		}
+		if r.Kind() == "rust_test" && (rc.testCoverageProfile != "" || rc.testCoverageDisabled) {
+			flags = withoutRun(flags, testCoverageRustcFlags)
+		}
+	}
+	flags = append(flags, rc.rustcFlags...)
+	if usesNightlyFeatures(sources) {
+		flags = append(flags, rc.nightlyRustcFlags...)
+	}
+	for _, link := range nativeLinks(rc, sources) {
+		flags = append(flags, link.rustcFlags...)
+	}
+	if r.Kind() == "rust_test" && rc.testCoverageProfile != "" {
+		flags = append(flags, testCoverageRustcFlags...)
+	}
+	if len(flags) > 0 {
+		r.SetAttr("rustc_flags", flags)
+	}
+}
+
+func hasConfiguredRustcFlags(r *rule.Rule, rc *rustConfig) bool {
+	if len(rc.rustcFlags) > 0 || len(rc.nightlyRustcFlags) > 0 {
+		return true
+	}
+	for _, link := range rc.nativeLinkByName {
+		if len(link.rustcFlags) > 0 {
+			return true
+		}
+	}
+	return r.Kind() == "rust_test" && (rc.testCoverageProfile != "" || rc.testCoverageDisabled)
+}
+
+func withoutRun(flags, run []string) []string {
+	if len(run) == 0 {
+		return flags
+	}
+	var remaining []string
+	for i := 0; i < len(flags); i++ {
+		if i+len(run) <= len(flags) && slices.Equal(flags[i:i+len(run)], run) {
+			i += len(run) - 1
+			continue
+		}
+		remaining = append(remaining, flags[i])
+	}
+	return remaining
+}
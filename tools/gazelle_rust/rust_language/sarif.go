@@ -0,0 +1,223 @@
+package rust_language
+
+// -rust_sarif_output writes the run's parse errors, unresolved imports and
+// ambiguous imports as a SARIF 2.1.0 log, so code review systems can annotate
+// the source lines responsible. Runs that fail on one of them write the log
+// before exiting.
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+
+	messages "coppice/tools/gazelle_rust/proto"
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+const (
+	sarifVersion   = "2.1.0"
+	sarifSchemaURI = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	parseErrorRule       = "parse-error"
+	unresolvedImportRule = "unresolved-import"
+	ambiguousImportRule  = "ambiguous-import"
+
+	// The -rust_sarif_output value writing the log to stdout.
+	sarifStdout = "-"
+)
+
+var sarifRules = []sarifRule{
+	{ID: parseErrorRule, ShortDescription: sarifMessage{Text: "The file isn't valid Rust, so it contributes no srcs or deps."}},
+	{ID: unresolvedImportRule, ShortDescription: sarifMessage{Text: "The import matches no workspace crate, provided crate, or Cargo.lock package."}},
+	{ID: ambiguousImportRule, ShortDescription: sarifMessage{Text: "Several workspace crates provide the import."}},
+}
+
+type sarifReport struct {
+	path string
+	// Whether -rust_strict makes unresolved imports errors.
+	strict  bool
+	results []sarifResult
+	// Files may be parsed more than once, so each parse error is recorded
+	// once.
+	parseErrorFiles map[string]bool
+	written         bool
+}
+
+func newSarifReport(path string, strict bool) *sarifReport {
+	return &sarifReport{path: path, strict: strict, parseErrorFiles: make(map[string]bool)}
+}
+
+// The JSON shape of the parts of SARIF the report uses.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   uint32 `json:"startLine"`
+	StartColumn uint32 `json:"startColumn"`
+}
+
+// Record a file that failed to parse, by its path relative to the repository
+// root.
+func (report *sarifReport) addParseError(file string, err error) {
+	if report.parseErrorFiles[file] {
+		return
+	}
+	report.parseErrorFiles[file] = true
+	message := err.Error()
+	var location *messages.SourceLocation
+	var parseError *rust_analysis.ParseError
+	if errors.As(err, &parseError) {
+		message = parseError.Message
+		location = parseError.Location
+	}
+	report.add(parseErrorRule, "error", message, file, location)
+}
+
+// Record an import of source that resolves to a guessed crate or is
+// ambiguous.
+func (report *sarifReport) addResolution(rc *rustConfig, source ParsedSource, importName string, resolution importResolution, from label.Label) {
+	var rule, level, message string
+	switch {
+	case resolution.ambiguous:
+		rule, level, message = ambiguousImportRule, "error", ambiguityMessage(importName, resolution)
+	case resolution.source == guessedResolution:
+		level = "warning"
+		if report.strict {
+			level = "error"
+		}
+		rule, message = unresolvedImportRule, unresolvedImportMessage(rc, importName)
+	default:
+		return
+	}
+
+	var location *messages.SourceLocation
+	for _, provenance := range source.Response.ImportProvenances {
+		if provenance.Name == importName {
+			location = provenance.Location
+			break
+		}
+	}
+	report.add(rule, level, message, path.Join(from.Pkg, source.Src), location)
+}
+
+func (report *sarifReport) add(rule, level, message, file string, location *messages.SourceLocation) {
+	physicalLocation := sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: file}}
+	if location != nil {
+		physicalLocation.Region = &sarifRegion{StartLine: location.Line, StartColumn: location.Column}
+	}
+	report.results = append(report.results, sarifResult{
+		RuleID:    rule,
+		Level:     level,
+		Message:   sarifMessage{Text: message},
+		Locations: []sarifLocation{{PhysicalLocation: physicalLocation}},
+	})
+}
+
+func (l *rustLang) writeSarifReport() {
+	if l.sarifReport == nil || l.sarifReport.written {
+		return
+	}
+	report := l.sarifReport
+	report.written = true
+
+	results := slices.Clone(report.results)
+	if results == nil {
+		results = []sarifResult{}
+	}
+	slices.SortStableFunc(results, func(a, b sarifResult) int {
+		return cmp.Or(
+			cmp.Compare(a.Locations[0].PhysicalLocation.ArtifactLocation.URI, b.Locations[0].PhysicalLocation.ArtifactLocation.URI),
+			cmp.Compare(a.Locations[0].PhysicalLocation.Region.line(), b.Locations[0].PhysicalLocation.Region.line()),
+			cmp.Compare(a.Locations[0].PhysicalLocation.Region.column(), b.Locations[0].PhysicalLocation.Region.column()),
+		)
+	})
+	var contents bytes.Buffer
+	encoder := json.NewEncoder(&contents)
+	// Messages quote Rust syntax such as `<` and `&`.
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchemaURI,
+		Runs: []sarifRun{{
+			Tool:    sarifTool{Driver: sarifDriver{Name: "gazelle_rust", Rules: sarifRules}},
+			Results: results,
+		}},
+	}); err != nil {
+		log.Fatalf("-rust_sarif_output: %v", err)
+	}
+	if report.path == sarifStdout {
+		os.Stdout.Write(contents.Bytes())
+		return
+	}
+	if err := os.WriteFile(report.path, contents.Bytes(), 0o644); err != nil {
+		log.Printf("-rust_sarif_output: %v", err)
+	}
+}
+
+func (region *sarifRegion) line() uint32 {
+	if region == nil {
+		return 0
+	}
+	return region.StartLine
+}
+
+func (region *sarifRegion) column() uint32 {
+	if region == nil {
+		return 0
+	}
+	return region.StartColumn
+}
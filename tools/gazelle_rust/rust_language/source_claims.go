@@ -0,0 +1,41 @@
+package rust_language
+
+// A source file in the srcs of several crates, such as a module both lib.rs
+// and main.rs declare with `mod`, or one left in an old library's srcs after
+// moving it to another, is compiled into each, and its items are distinct
+// types in every crate that has it. Generation fails, naming the rules that
+// claim each such file. Tests and the shared and static variants of FFI
+// libraries are left out, since they compile the same sources again by design.
+
+import (
+	"log"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func checkSourceClaims(pkg string, rules []*rule.Rule) {
+	ruleNamesBySrc := make(map[string][]string)
+	for _, r := range rules {
+		if r.Kind() == "rust_test" || ffiLibraryKinds[r.Kind()] {
+			continue
+		}
+		// Computed srcs, such as a glob, are left out.
+		for _, src := range r.AttrStrings("srcs") {
+			ruleNamesBySrc[src] = append(ruleNamesBySrc[src], ":"+r.Name())
+		}
+	}
+	conflicted := false
+	for _, src := range slices.Sorted(maps.Keys(ruleNamesBySrc)) {
+		if ruleNames := ruleNamesBySrc[src]; len(ruleNames) > 1 {
+			log.Printf("%s: in the srcs of %s, but a file can only belong to one crate", path.Join(pkg, src), strings.Join(ruleNames, " and "))
+			conflicted = true
+		}
+	}
+	if conflicted {
+		log.Fatalf("//%s: source files are claimed by several crates; keep each in only one rule's srcs", pkg)
+	}
+}
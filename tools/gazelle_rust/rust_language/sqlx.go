@@ -0,0 +1,66 @@
+package rust_language
+
+// sqlx's query macros, such as `sqlx::query!`, check queries against a live
+// database unless SQLX_OFFLINE is set, when they read the query data `cargo
+// sqlx prepare` saves instead. Rules invoking them get the data directory's
+// files in compile_data, replacing entries for files since removed, and
+// SQLX_OFFLINE and SQLX_OFFLINE_DIR in rustc_env. The directory is .sqlx in
+// the rule's package unless set with `# gazelle:rust_sqlx_offline_dir <path>`,
+// relative to the package.
+
+import (
+	"log"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+const defaultSqlxOfflineDirectory = ".sqlx"
+
+// Report whether the sources invoke a sqlx query macro by its path.
+func usesSqlxQueryMacros(sources []ParsedSource) bool {
+	for _, source := range sources {
+		for _, name := range source.Response.PathMacros {
+			if strings.HasPrefix(name, "sqlx::query") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (l *rustLang) setSqlxOfflineData(r *rule.Rule, rc *rustConfig, dir string, sources []ParsedSource) {
+	if !usesSqlxQueryMacros(sources) {
+		return
+	}
+	pkg := l.packageOf(dir)
+	offlineDirectory := path.Clean(rc.sqlxOfflineDirectory)
+	switch files, inPackage := l.setDirectoryCompileData(r, dir, offlineDirectory); {
+	case !inPackage:
+		log.Printf("//%s:%s: sqlx offline data in %s is outside the package; add it to compile_data by hand", pkg, r.Name(), offlineDirectory)
+	case files == 0:
+		log.Printf("//%s:%s: invokes sqlx query macros, but %s has no offline query data; run `cargo sqlx prepare`", pkg, r.Name(), offlineDirectory)
+	}
+
+	environment := map[string]string{
+		"SQLX_OFFLINE":     "true",
+		"SQLX_OFFLINE_DIR": "${pwd}/" + path.Join(pkg, offlineDirectory),
+	}
+	switch existing := r.Attr("rustc_env").(type) {
+	case nil:
+		r.SetAttr("rustc_env", environment)
+	case *bzl.DictExpr:
+		for _, entry := range existing.List {
+			if key, ok := entry.Key.(*bzl.StringExpr); ok {
+				delete(environment, key.Value)
+			}
+		}
+		if len(environment) > 0 {
+			log.Printf("//%s:%s: invokes sqlx query macros; add %s to rustc_env", pkg, r.Name(), strings.Join(slices.Sorted(maps.Keys(environment)), " and "))
+		}
+	}
+}
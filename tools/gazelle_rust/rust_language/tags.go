@@ -0,0 +1,49 @@
+package rust_language
+
+import (
+	"slices"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Set the tags the configuration maps the rule's sources to, replacing any
+// configured tag the rule no longer qualifies for and keeping the existing
+// rule's other tags. Without tag directives, existing tags are left as
+// written.
+func setTags(r *rule.Rule, rc *rustConfig, sources []ParsedSource, existingRule *rule.Rule) {
+	configured := slices.Concat(
+		rc.ignoredTestTagsByCoverage[allIgnoredTests],
+		rc.ignoredTestTagsByCoverage[someIgnoredTests],
+		rc.nightlyTags,
+		rc.firmwareTags,
+		rc.unsafeTags,
+	)
+	if keepExistingAttr(r, existingRule, "tags", len(configured) > 0) {
+		return
+	}
+	var tags []string
+	if existingRule != nil {
+		for _, tag := range existingRule.AttrStrings("tags") {
+			if !slices.Contains(configured, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	if r.Kind() == "rust_test" {
+		tags = append(tags, ignoredTestTags(rc, sources)...)
+	}
+	if usesNightlyFeatures(sources) {
+		tags = append(tags, rc.nightlyTags...)
+	}
+	if isFirmware(sources) {
+		tags = append(tags, rc.firmwareTags...)
+	}
+	if containsUnsafe(sources) {
+		tags = append(tags, rc.unsafeTags...)
+	}
+
+	slices.Sort(tags)
+	if tags = slices.Compact(tags); len(tags) > 0 {
+		r.SetAttr("tags", tags)
+	}
+}
@@ -0,0 +1,43 @@
+package rust_language
+
+// `# gazelle:rust_test_coverage <LLVM_PROFILE_FILE>` builds rust_test rules
+// with LLVM source-based coverage: rustc_flags get -Cinstrument-coverage, and
+// env sets LLVM_PROFILE_FILE to the pattern instrumented tests write their
+// raw profiles to, such as `%p-%m.profraw`, for llvm-profdata to merge. Other
+// env entries are kept as written. `disabled` removes both, and without the
+// directive, rules are left as written.
+
+import (
+	"log"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+var testCoverageRustcFlags = []string{"-Cinstrument-coverage"}
+
+const profileFileVariable = "LLVM_PROFILE_FILE"
+
+func setTestCoverageEnv(r *rule.Rule, rc *rustConfig, pkg string, existingRule *rule.Rule) {
+	var ok bool
+	switch {
+	case rc.testCoverageProfile != "":
+		ok = setEnvEntries(r, "env", map[string]string{profileFileVariable: rc.testCoverageProfile}, nil)
+	case rc.testCoverageDisabled:
+		ok = setEnvEntries(r, "env", nil, []string{profileFileVariable})
+	default:
+		return
+	}
+	if !ok {
+		log.Printf("//%s:%s: env isn't a dict; update %s in it by hand", pkg, r.Name(), profileFileVariable)
+		return
+	}
+	if env, isDict := r.Attr("env").(*bzl.DictExpr); isDict && len(env.List) == 0 {
+		// Merging can't remove a dict attribute, and an empty one would be
+		// copied to the existing rule after resolving.
+		r.DelAttr("env")
+		if existingRule != nil {
+			existingRule.DelAttr("env")
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package rust_language
+
+// Files tests read at runtime, such as fixtures opened by path, aren't found
+// by parsing, so `# gazelle:rust_test_data <glob>...` names them. Each
+// rust_test gets the package files the globs match in data, replacing entries
+// under the globs for files since removed and keeping the others. Without the
+// directive, data is left as written.
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+func parseTestDataGlobs(values []string) ([]string, error) {
+	for _, pattern := range values {
+		if _, err := path.Match(pattern, ""); err != nil || path.IsAbs(pattern) || pattern == ".." || strings.HasPrefix(pattern, "../") {
+			return nil, fmt.Errorf("%q is not a glob of package files", pattern)
+		}
+	}
+	return values, nil
+}
+
+func (l *rustLang) setTestData(r *rule.Rule, rc *rustConfig, dir string, existingRule *rule.Rule) {
+	if keepExistingAttr(r, existingRule, "data", len(rc.testDataGlobs) > 0) || len(rc.testDataGlobs) == 0 {
+		return
+	}
+	var data []string
+	if existingRule != nil {
+		data = existingRule.AttrStrings("data")
+	}
+	data = slices.DeleteFunc(data, func(entry string) bool {
+		return matchesAnyGlob(rc.testDataGlobs, entry)
+	})
+	for _, file := range l.listPackageFiles(dir, true) {
+		if matchesAnyGlob(rc.testDataGlobs, file) {
+			data = append(data, file)
+		}
+	}
+
+	slices.Sort(data)
+	if data = slices.Compact(data); len(data) > 0 {
+		r.SetAttr("data", data)
+	}
+}
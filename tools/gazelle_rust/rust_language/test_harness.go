@@ -0,0 +1,66 @@
+package rust_language
+
+// Tests providing their own main, as with libtest-mimic or criterion's
+// criterion_main!, build with `use_libtest_harness = False`, the Bazel
+// spelling of Cargo's `harness = false`. Test files defining `fn main` or
+// benchmarking with criterion are found by parsing, and
+// `# gazelle:rust_custom_test_harness <file>...` marks others, relative to the
+// directive's package. Each gets a rust_test of its own, named like per-file
+// tests, since its main can't share a crate with other test files.
+
+import (
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+// Split test files into those run by libtest, those with #[bench] functions,
+// and those with a custom harness.
+func (l *rustLang) classifyTestFiles(rc *rustConfig, dir string, files []string) ([]string, []string, []string) {
+	var tests, benches, customHarness []string
+	pkg := l.packageOf(dir)
+	for _, file := range files {
+		response, err := l.parse(rc, path.Join(dir, file))
+		switch {
+		case err != nil || !response.Success:
+			tests = append(tests, file)
+		case hasCustomTestHarness(rc, pkg, ParsedSource{Src: file, Response: response}):
+			customHarness = append(customHarness, file)
+		case response.BenchCount > 0:
+			benches = append(benches, file)
+		default:
+			tests = append(tests, file)
+		}
+	}
+	return tests, benches, customHarness
+}
+
+func hasCustomTestHarness(rc *rustConfig, pkg string, source ParsedSource) bool {
+	if rc.customHarnessFiles[path.Join(pkg, source.Src)] || source.Response.HasMain {
+		return true
+	}
+	// criterion_main! is usually imported with the rest of criterion, so a
+	// file importing criterion without any #[test] is taken for a benchmark.
+	return slices.Contains(source.Response.Imports, "criterion") && source.Response.TestCount == 0
+}
+
+// Turn off the libtest harness for tests with a custom one.
+func (l *rustLang) setTestHarness(r *rule.Rule, rc *rustConfig, dir string, sources []ParsedSource) {
+	pkg := l.packageOf(dir)
+	if r.Kind() == "rust_test" && slices.ContainsFunc(sources, func(source ParsedSource) bool {
+		return hasCustomTestHarness(rc, pkg, source)
+	}) {
+		r.SetAttr("use_libtest_harness", false)
+	}
+}
+
+// Return the repository-relative package of a directory in the repository.
+func (l *rustLang) packageOf(dir string) string {
+	pkg, _ := filepath.Rel(l.repoRoot, dir)
+	if pkg == "." {
+		return ""
+	}
+	return filepath.ToSlash(pkg)
+}
@@ -0,0 +1,54 @@
+package rust_language
+
+// `# gazelle:rust_test_suite <name>` adds a test_suite to the directory
+// listing every rust_test in its subtree, so the subtree's Rust tests can be
+// run with `bazel test //path:<name>`. Gazelle generates subdirectories before
+// their parents, so the subtree's tests are known by the time the directory
+// itself is generated.
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+type testSuites struct {
+	testNamesByPackage map[string][]string
+}
+
+func newTestSuites() *testSuites {
+	return &testSuites{testNamesByPackage: make(map[string][]string)}
+}
+
+func (suites *testSuites) addTests(pkg string, rules []*rule.Rule) {
+	for _, r := range rules {
+		if r.Kind() == "rust_test" && (len(r.AttrStrings("srcs")) > 0 || computedSrcs(r) != nil) {
+			suites.testNamesByPackage[pkg] = append(suites.testNamesByPackage[pkg], r.Name())
+		}
+	}
+}
+
+func (suites *testSuites) emitTestSuite(result *language.GenerateResult, pkg, name string) {
+	var tests []string
+	for _, testPackage := range slices.Sorted(maps.Keys(suites.testNamesByPackage)) {
+		if pkg != "" && testPackage != pkg && !strings.HasPrefix(testPackage, pkg+"/") {
+			continue
+		}
+		for _, testName := range suites.testNamesByPackage[testPackage] {
+			tests = append(tests, label.New("", testPackage, testName).Rel("", pkg).String())
+		}
+	}
+
+	suite := rule.NewRule("test_suite", name)
+	if len(tests) == 0 {
+		result.Empty = append(result.Empty, suite)
+		return
+	}
+	suite.SetAttr("tests", tests)
+	result.Gen = append(result.Gen, suite)
+	result.Imports = append(result.Imports, nil)
+}
@@ -0,0 +1,77 @@
+package rust_language
+
+// Test files are those matching `*_test.rs`, or with
+// `# gazelle:rust_test_file_patterns <glob>...` any of the globs, such as
+// `test_*.rs`, matched against file names. They go in one `<dir>_test` rule
+// by default. With
+// `# gazelle:rust_test_targets per_file`, each gets its own rust_test named
+// after its path, such as `nested_parse_test` for nested/parse_test.rs, so
+// tests build and run in parallel and cache separately. Switching a subtree
+// to per-file targets removes its `<dir>_test` rule; rules with other names
+// keep their srcs.
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+type testTargets string
+
+const (
+	aggregatedTestTargets testTargets = "aggregated"
+	perFileTestTargets    testTargets = "per_file"
+)
+
+var defaultTestFilePatterns = []string{"*_test.rs"}
+
+// Report whether a file, relative to its package, is a test root.
+func (rc *rustConfig) isTestFile(file string) bool {
+	base := path.Base(file)
+	for _, pattern := range rc.testFilePatterns {
+		if matched, _ := path.Match(pattern, base); matched && strings.HasSuffix(base, ".rs") {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTestFilePatterns(values []string) ([]string, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("expected at least one glob")
+	}
+	for _, pattern := range values {
+		if _, err := path.Match(pattern, ""); err != nil || strings.Contains(pattern, "/") || !strings.HasSuffix(pattern, ".rs") {
+			return nil, fmt.Errorf("%q is not a glob of .rs file names", pattern)
+		}
+	}
+	return values, nil
+}
+
+// Return the name of the per-file rust_test of a test file.
+func perFileTestName(file string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(file, ".rs"), "/", "_")
+}
+
+// Return the srcs of an existing rust_test in per-file mode: the test file it
+// is named after, or else its existing srcs. Reports false for the aggregated
+// rule, which per-file rules replace.
+func (l *rustLang) perFileTestSrcs(rc *rustConfig, existingRule *rule.Rule, dir, dirName string) ([]string, bool) {
+	for _, file := range l.collectTestFiles(rc, dir, nil) {
+		if perFileTestName(file) == existingRule.Name() {
+			return []string{file}, true
+		}
+	}
+	if existingRule.Name() == dirName+"_test" {
+		return nil, false
+	}
+	var srcs []string
+	for _, file := range existingRule.AttrStrings("srcs") {
+		if l.sourceExists(dir, file) {
+			srcs = append(srcs, file)
+		}
+	}
+	return srcs, true
+}
@@ -0,0 +1,17 @@
+package rust_language
+
+// `# gazelle:rust_unsafe_tags <tag>...` tags rules whose sources have an
+// `unsafe` block, function, impl or trait, such as `contains-unsafe`, so
+// security reviews can find the unsafe code in the build graph with
+// `bazel query 'attr(tags, contains-unsafe, //...)'`. Giving no tags clears the
+// setting.
+
+import (
+	"slices"
+)
+
+func containsUnsafe(sources []ParsedSource) bool {
+	return slices.ContainsFunc(sources, func(source ParsedSource) bool {
+		return source.Response.ContainsUnsafe
+	})
+}
@@ -0,0 +1,80 @@
+package rust_language
+
+// Hand-maintained deps, either values marked `# keep` or every dep of a rule
+// marked `# keep`, survive merging even once no source file refers to them.
+// Report those, and remove them with -rust_prune_unused_deps. Only deps whose
+// crate name is known, crates.io crates under the crates prefix and indexed
+// workspace rules, are checked; any other dep is left alone.
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+func (l *rustLang) checkHandMaintainedDeps(rc *rustConfig, externalCrates *ExternalCrates, existingRule *rule.Rule, ruleData RuleData, resolvedLabels map[string]bool, from label.Label) {
+	deps, ok := existingRule.Attr("deps").(*bzl.ListExpr)
+	if !ok {
+		// Absent, or computed with select() or concatenation.
+		return
+	}
+
+	importedCrates := make(map[string]bool)
+	for _, source := range ruleData.Sources {
+		for _, importName := range sourceImports(rc, externalCrates, source) {
+			importedCrates[strings.ReplaceAll(importName, "-", "_")] = true
+		}
+	}
+
+	ruleKept := existingRule.ShouldKeep()
+	var remaining []bzl.Expr
+	for _, element := range deps.List {
+		dep, ok := element.(*bzl.StringExpr)
+		if !ok || !(ruleKept || rule.ShouldKeep(element)) || resolvedLabels[dep.Value] {
+			remaining = append(remaining, element)
+			continue
+		}
+		if crateName, ok := l.depCrateName(rc, dep.Value, from); !ok || importedCrates[crateName] {
+			remaining = append(remaining, element)
+			continue
+		}
+		if l.flags.pruneUnusedDeps {
+			log.Printf("%s: removing dependency %s, which no source file imports", from, dep.Value)
+			continue
+		}
+		l.resolveWarning(from, fmt.Sprintf("%s: dependency %s is kept but no source file imports it", from, dep.Value))
+		remaining = append(remaining, element)
+	}
+
+	if len(remaining) == len(deps.List) {
+		return
+	}
+	if len(remaining) == 0 {
+		existingRule.DelAttr("deps")
+		return
+	}
+	deps.List = remaining
+	existingRule.SetAttr("deps", deps)
+}
+
+// Return the crate name a dep label provides, if it is a crate under the
+// crates prefix or an indexed workspace rule.
+func (l *rustLang) depCrateName(rc *rustConfig, dep string, from label.Label) (string, bool) {
+	if crateName, ok := strings.CutPrefix(dep, rc.cratesPrefix); ok {
+		return strings.ReplaceAll(crateName, "-", "_"), true
+	}
+	depLabel, err := label.Parse(dep)
+	if err != nil || depLabel.Repo != "" {
+		return "", false
+	}
+	if depLabel.Relative {
+		depLabel.Pkg = from.Pkg
+		depLabel.Relative = false
+	}
+	crateName, ok := l.crateNameByLabel[label.New("", depLabel.Pkg, depLabel.Name)]
+	return crateName, ok
+}
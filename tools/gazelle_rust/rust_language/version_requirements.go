@@ -0,0 +1,118 @@
+package rust_language
+
+// Cargo's version requirements, such as "^1.2", ">= 0.3.1, < 0.4" or "=0.1.*",
+// matched against locked versions. Pre-release versions order before their
+// release and otherwise compare as plain versions.
+
+import (
+	"strconv"
+	"strings"
+)
+
+type requiredVersion struct {
+	// Missing components are zero.
+	parts [3]int
+	// How many components were given before any wildcard.
+	count      int
+	preRelease string
+}
+
+// Two character operators first, so ">=" isn't read as ">".
+var versionOperators = []string{">=", "<=", ">", "<", "=", "~", "^"}
+
+// Report whether a version satisfies every comma separated comparator.
+func matchesVersionRequirement(version, requirement string) bool {
+	locked := parseRequiredVersion(version)
+	for _, comparator := range strings.Split(requirement, ",") {
+		if !matchesComparator(locked, strings.TrimSpace(comparator)) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesComparator(version requiredVersion, comparator string) bool {
+	operator := ""
+	for _, candidate := range versionOperators {
+		if strings.HasPrefix(comparator, candidate) {
+			operator = candidate
+			break
+		}
+	}
+	required := parseRequiredVersion(strings.TrimSpace(strings.TrimPrefix(comparator, operator)))
+	if required.count == 0 {
+		return true
+	}
+	comparison := compareRequiredVersions(version, required)
+	switch operator {
+	case "=":
+		return comparison >= 0 && compareRequiredVersions(version, required.upperBound(required.count)) < 0
+	case ">":
+		if required.count < 3 {
+			return compareRequiredVersions(version, required.upperBound(required.count)) >= 0
+		}
+		return comparison > 0
+	case ">=":
+		return comparison >= 0
+	case "<":
+		return comparison < 0
+	case "<=":
+		if required.count < 3 {
+			return compareRequiredVersions(version, required.upperBound(required.count)) < 0
+		}
+		return comparison <= 0
+	case "~":
+		return comparison >= 0 && compareRequiredVersions(version, required.upperBound(min(required.count, 2))) < 0
+	default:
+		// "^" and bare requirements allow changes that keep the leftmost
+		// nonzero component.
+		significant := required.count
+		for i := range required.count {
+			if required.parts[i] != 0 {
+				significant = i + 1
+				break
+			}
+		}
+		return comparison >= 0 && compareRequiredVersions(version, required.upperBound(significant)) < 0
+	}
+}
+
+func parseRequiredVersion(value string) requiredVersion {
+	var version requiredVersion
+	value, _, _ = strings.Cut(value, "+")
+	value, version.preRelease, _ = strings.Cut(value, "-")
+	for i, part := range strings.Split(value, ".") {
+		number, err := strconv.Atoi(part)
+		if i >= len(version.parts) || err != nil {
+			break
+		}
+		version.parts[i] = number
+		version.count++
+	}
+	return version
+}
+
+// Return the lowest version that changes one of the first count components.
+func (version requiredVersion) upperBound(count int) requiredVersion {
+	upper := requiredVersion{count: 3}
+	copy(upper.parts[:], version.parts[:count])
+	upper.parts[count-1]++
+	return upper
+}
+
+func compareRequiredVersions(a, b requiredVersion) int {
+	for i := range a.parts {
+		if a.parts[i] != b.parts[i] {
+			return a.parts[i] - b.parts[i]
+		}
+	}
+	switch {
+	case a.preRelease == b.preRelease:
+		return 0
+	case a.preRelease == "":
+		return 1
+	case b.preRelease == "":
+		return -1
+	}
+	return strings.Compare(a.preRelease, b.preRelease)
+}
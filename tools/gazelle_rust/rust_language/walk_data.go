@@ -0,0 +1,112 @@
+package rust_language
+
+// Directory contents come from gazelle's walk rather than the filesystem, so
+// paths ignored by .bazelignore or excluded with `# gazelle:exclude` are never
+// parsed or listed in srcs, and each directory is read once. walk.GetDirInfo
+// shares the walk's cache, returning args.RegularFiles and args.Subdirs for
+// the directory being generated. Once the walk is done, when indexing
+// follows modules of rules gazelle didn't generate, and for paths outside the
+// repository, the filesystem is read instead.
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+type walkedDirectory struct {
+	regularFiles []string
+	subdirs      []string
+	// The out and outs of rules in the directory's BUILD file.
+	genFiles []string
+}
+
+// Return the files and subdirectories of dir, an absolute path.
+func (l *rustLang) walkedDirectory(dir string) walkedDirectory {
+	rel, err := filepath.Rel(l.repoRoot, dir)
+	if l.walkDone || err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return readDirectory(dir)
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		rel = ""
+	}
+	// Excluded directories come back empty with an error, and directories
+	// with an unreadable BUILD file with their contents and an error.
+	info, _ := walk.GetDirInfo(rel)
+	return walkedDirectory{regularFiles: info.RegularFiles, subdirs: info.Subdirs, genFiles: info.GenFiles}
+}
+
+func readDirectory(dir string) walkedDirectory {
+	var directory walkedDirectory
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		info, err := os.Stat(filepath.Join(dir, entry.Name()))
+		switch {
+		case err != nil:
+		case info.IsDir():
+			directory.subdirs = append(directory.subdirs, entry.Name())
+		case info.Mode().IsRegular():
+			directory.regularFiles = append(directory.regularFiles, entry.Name())
+		}
+	}
+	return directory
+}
+
+// Report whether file, relative to dir, is a regular file the walk found.
+func (l *rustLang) fileExists(dir, file string) bool {
+	filePath := filepath.Join(dir, file)
+	return slices.Contains(l.walkedDirectory(filepath.Dir(filePath)).regularFiles, filepath.Base(filePath))
+}
+
+// Report whether file, relative to the package directory dir, is output by a
+// rule in the package rather than checked in. Generated files can't be parsed,
+// so they contribute no modules or deps of their own.
+func (l *rustLang) isGeneratedFile(dir, file string) bool {
+	return !l.fileExists(dir, file) && slices.Contains(l.walkedDirectory(dir).genFiles, filepath.ToSlash(file))
+}
+
+// Report whether a source file, relative to the package directory dir, is
+// checked in or generated in the package.
+func (l *rustLang) sourceExists(dir, file string) bool {
+	return l.fileExists(dir, file) || l.isGeneratedFile(dir, file)
+}
+
+// Report whether a directory is a Bazel package.
+func (l *rustLang) isPackageDir(dir string) bool {
+	regularFiles := l.walkedDirectory(dir).regularFiles
+	for _, buildFile := range []string{"BUILD", "BUILD.bazel", "MODULE.bazel"} {
+		if slices.Contains(regularFiles, buildFile) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the files under dir, relative to it, descending into subdirectories
+// unless they are packages or recursive is false.
+func (l *rustLang) listPackageFiles(dir string, recursive bool) []string {
+	var files []string
+	var visit func(rel string)
+	visit = func(rel string) {
+		directory := l.walkedDirectory(filepath.Join(dir, rel))
+		for _, file := range directory.regularFiles {
+			files = append(files, path.Join(rel, file))
+		}
+		if !recursive {
+			return
+		}
+		for _, subdir := range directory.subdirs {
+			if !l.isPackageDir(filepath.Join(dir, rel, subdir)) {
+				visit(path.Join(rel, subdir))
+			}
+		}
+	}
+	visit("")
+	slices.Sort(files)
+	return files
+}
@@ -0,0 +1,69 @@
+package rust_language
+
+// cargo-hakari unifies features across a workspace with a workspace-hack
+// crate that every member depends on without importing it. When
+// .config/hakari.toml names the hack package, every library depends on the
+// workspace crate providing it, and imports of it add no other dependency.
+// `# gazelle:rust_workspace_hack <label>` sets the crate for a subtree, and
+// `disabled` turns this off.
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+
+	"coppice/tools/gazelle_rust/rust_analysis"
+)
+
+const hakariConfigPath = ".config/hakari.toml"
+
+var hakariPackageRegex = regexp.MustCompile(`^hakari-package\s*=\s*"([^"]+)"`)
+
+// Return the workspace-hack package hakari.toml names, or "" if there is none.
+func detectHakariPackage(repoRoot string) string {
+	file, err := os.Open(filepath.Join(repoRoot, hakariConfigPath))
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if matches := hakariPackageRegex.FindStringSubmatch(strings.TrimSpace(scanner.Text())); matches != nil {
+			return matches[1]
+		}
+	}
+	return ""
+}
+
+// Return the workspace-hack library rules under rc depend on, or false if
+// there is none.
+func (l *rustLang) workspaceHack(c *config.Config, ix *resolve.RuleIndex, rc *rustConfig) (label.Label, bool) {
+	if rc.workspaceHackDisabled {
+		return label.NoLabel, false
+	}
+	if !rc.workspaceHack.Equal(label.NoLabel) {
+		return rc.workspaceHack, true
+	}
+	if l.hakariPackage == "" {
+		return label.NoLabel, false
+	}
+
+	spec := resolve.ImportSpec{Lang: langName, Imp: rust_analysis.NormalizeCrateName(l.hakariPackage)}
+	matches := ix.FindRulesByImportWithConfig(c, spec, langName)
+	if len(matches) == 0 {
+		if !l.hakariPackageMissing {
+			log.Printf("%s: hakari package %q is not a workspace library; set its label with # gazelle:%s", hakariConfigPath, l.hakariPackage, workspaceHackDirective)
+			l.hakariPackageMissing = true
+		}
+		return label.NoLabel, false
+	}
+	return matches[0].Label, true
+}
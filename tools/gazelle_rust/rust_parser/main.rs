@@ -2,14 +2,33 @@
 
 use clap::Parser;
 use prost::Message;
+use std::collections::HashMap;
 use std::error::Error;
-use std::fs::File;
 use std::io::{Read, Write};
+use std::net::TcpListener;
+use std::os::unix::net::{UnixListener, UnixStream};
 use std::path::Path;
 use std::path::PathBuf;
+use std::sync::{Arc, Mutex, mpsc};
+use std::time::{Duration, Instant};
 
-use gazelle_rust_proto::{ParseRequest, ParseResponse};
-use tools__gazelle_rust__rust_parser::parser::{SourceInfo, parse_source};
+use gazelle_rust_proto::{
+    CfgOperator, CfgPredicate, ConditionalAttribute, ConditionalImport, HandshakeRequest,
+    HandshakeResponse, ImportProvenance, ImportReference, ParseRequest, ParseResponse, PublicItem,
+    SourceLocation,
+};
+use tools__gazelle_rust__rust_parser::parser::{self, SourceInfo, SyntaxError, parse_source};
+
+/// Bump together with `parserProtocolVersion` in rust_analysis/parser.go
+/// whenever the framing or message semantics change incompatibly.
+const PROTOCOL_VERSION: u32 = 1;
+
+/// Optional protocol features this parser can provide when requested.
+const CAPABILITIES: &[&str] = &["file_contents", PIPELINING_CAPABILITY];
+
+/// Requests on a connection are parsed concurrently and answered in the order
+/// they finish, identified by their request IDs.
+const PIPELINING_CAPABILITY: &str = "pipelining";
 
 #[derive(clap::Parser)]
 #[command(name = "rust_parser")]
@@ -19,43 +38,577 @@ enum Args {
     Parse { path: PathBuf },
     /// Run as IPC server for Gazelle.
     Serve,
+    /// Run as a parse service accepting Gazelle connections over TCP or a Unix
+    /// socket. It only parses contents sent with each request and never reads
+    /// files, but anyone who can connect can use it, so a TCP address should
+    /// be loopback or firewalled to trusted clients.
+    Listen {
+        /// Address to listen on, e.g. 127.0.0.1:7420 or unix:/tmp/parser.sock.
+        address: String,
+        /// Exit after this many seconds without open connections.
+        #[arg(long)]
+        idle_timeout_secs: Option<u64>,
+    },
 }
 
 fn parse_file(path: &Path) -> Result<SourceInfo, Box<dyn Error>> {
-    let mut file = match File::open(path) {
-        Err(err) => {
-            eprintln!(
-                "Could not open file {}: {}",
-                path.to_str().unwrap_or("<utf-8 decode error>"),
-                err,
-            );
-            std::process::exit(1);
-        }
-        file => file?,
-    };
-    let mut contents = String::new();
-    file.read_to_string(&mut contents)?;
+    let contents = std::fs::read_to_string(path)
+        .map_err(|err| format!("Could not read file {}: {err}", path.display()))?;
+    parse_source(&contents)
+}
 
+fn parse_contents(path: &str, contents: Vec<u8>) -> Result<SourceInfo, Box<dyn Error>> {
+    let contents =
+        String::from_utf8(contents).map_err(|err| format!("{path} is not valid UTF-8: {err}"))?;
     parse_source(&contents)
 }
 
 fn handle_parse_request(request: ParseRequest) -> ParseResponse {
-    let path = PathBuf::from(request.file_path);
-    match parse_file(&path) {
+    let result = match request.contents {
+        Some(contents) => parse_contents(&request.file_path, contents),
+        None => parse_file(&PathBuf::from(request.file_path)),
+    };
+    parse_response(request.request_id, result)
+}
+
+fn parse_response(request_id: u64, result: Result<SourceInfo, Box<dyn Error>>) -> ParseResponse {
+    match result {
         Ok(result) => ParseResponse {
             success: true,
             error_msg: String::new(),
             imports: result.imports,
             external_modules: result.external_modules,
             has_main: result.has_main,
+            conditional_attributes: result
+                .conditional_attributes
+                .into_iter()
+                .map(|attribute| ConditionalAttribute {
+                    predicate: attribute.predicate,
+                    attributes: attribute.attributes,
+                    imports: attribute.imports,
+                })
+                .collect(),
+            exports_c_symbols: result.exports_c_symbols,
+            test_count: result.test_count,
+            ignored_test_count: result.ignored_test_count,
+            nightly_features: result.nightly_features,
+            bare_macros: result.bare_macros,
+            bare_derives: result.bare_derives,
+            included_files: result.included_files,
+            link_names: result.link_names,
+            is_empty: result.is_empty,
+            conditional_imports: result
+                .conditional_imports
+                .into_iter()
+                .map(|import| ConditionalImport {
+                    name: import.name,
+                    predicate: Some(cfg_predicate_message(import.predicate)),
+                })
+                .collect(),
+            import_provenances: result
+                .import_provenances
+                .into_iter()
+                .map(|provenance| ImportProvenance {
+                    name: provenance.name,
+                    references: provenance
+                        .references
+                        .into_iter()
+                        .map(|reference| import_reference_message(reference) as i32)
+                        .collect(),
+                    location: provenance.location.map(source_location_message),
+                })
+                .collect(),
+            exported_macros: result.exported_macros,
+            path_macros: result.path_macros,
+            embedded_migrations: result.embedded_migrations,
+            bench_count: result.bench_count,
+            environment_variables: result.environment_variables,
+            no_main: result.no_main,
+            no_std: result.no_std,
+            has_global_allocator: result.has_global_allocator,
+            has_embedded_entry: result.has_embedded_entry,
+            file_cfg: result.file_cfg.map(cfg_predicate_message),
+            contains_unsafe: result.contains_unsafe,
+            public_items: result
+                .public_items
+                .into_iter()
+                .map(|item| PublicItem {
+                    path: item.path,
+                    declaration: item.declaration,
+                })
+                .collect(),
+            request_id,
+            error_location: None,
         },
         Err(err) => ParseResponse {
             success: false,
             error_msg: err.to_string(),
+            error_location: err
+                .downcast_ref::<SyntaxError>()
+                .map(|err| source_location_message(err.location)),
             imports: vec![],
             external_modules: vec![],
             has_main: false,
+            conditional_attributes: vec![],
+            exports_c_symbols: false,
+            test_count: 0,
+            ignored_test_count: 0,
+            nightly_features: vec![],
+            bare_macros: vec![],
+            bare_derives: vec![],
+            included_files: vec![],
+            link_names: vec![],
+            is_empty: false,
+            conditional_imports: vec![],
+            import_provenances: vec![],
+            exported_macros: vec![],
+            path_macros: vec![],
+            embedded_migrations: vec![],
+            bench_count: 0,
+            environment_variables: vec![],
+            no_main: false,
+            no_std: false,
+            has_global_allocator: false,
+            has_embedded_entry: false,
+            file_cfg: None,
+            contains_unsafe: false,
+            public_items: vec![],
+            request_id,
+        },
+    }
+}
+
+fn import_reference_message(reference: parser::ImportReference) -> ImportReference {
+    match reference {
+        parser::ImportReference::Path => ImportReference::Path,
+        parser::ImportReference::Use => ImportReference::Use,
+        parser::ImportReference::ExternCrate => ImportReference::ExternCrate,
+        parser::ImportReference::Attribute => ImportReference::Attribute,
+    }
+}
+
+fn source_location_message(location: parser::SourceLocation) -> SourceLocation {
+    SourceLocation {
+        line: location.line,
+        column: location.column,
+    }
+}
+
+fn cfg_predicate_message(predicate: parser::CfgPredicate) -> CfgPredicate {
+    let (operator, operands) = match predicate {
+        parser::CfgPredicate::Option { name, value } => {
+            return CfgPredicate {
+                operator: CfgOperator::Option as i32,
+                name,
+                value,
+                operands: vec![],
+            };
+        }
+        parser::CfgPredicate::All(operands) => (CfgOperator::All, operands),
+        parser::CfgPredicate::Any(operands) => (CfgOperator::Any, operands),
+        parser::CfgPredicate::Not(operand) => (CfgOperator::Not, vec![*operand]),
+    };
+    CfgPredicate {
+        operator: operator as i32,
+        name: String::new(),
+        value: None,
+        operands: operands.into_iter().map(cfg_predicate_message).collect(),
+    }
+}
+
+/// Read a message with a 4-byte little-endian size prefix into `buf`, returning
+/// its size, or `None` once the client closes the connection.
+fn read_frame(reader: &mut impl Read, buf: &mut Vec<u8>) -> Result<Option<usize>, Box<dyn Error>> {
+    let mut size_bytes = [0; 4];
+    match reader.read_exact(&mut size_bytes) {
+        Err(err) if err.kind() == std::io::ErrorKind::UnexpectedEof => {
+            return Ok(None);
+        }
+        res => res?,
+    }
+    let size = u32::from_le_bytes(size_bytes) as usize;
+
+    if size > buf.len() {
+        buf.resize(size, 0);
+    }
+
+    reader.read_exact(&mut buf[..size])?;
+    Ok(Some(size))
+}
+
+/// Write a message with a 4-byte little-endian size prefix, encoding it into
+/// `buf`, which is reused across messages.
+fn write_frame(
+    writer: &mut impl Write,
+    message: &impl Message,
+    buf: &mut Vec<u8>,
+) -> Result<(), Box<dyn Error>> {
+    buf.clear();
+    message.encode(buf)?;
+    let size = u32::try_from(buf.len()).expect("message exceeds u32::MAX bytes");
+    writer.write_all(&size.to_le_bytes())?;
+    writer.write_all(buf)?;
+    writer.flush()?;
+    Ok(())
+}
+
+/// State shared by all connections of a parse service.
+struct Service {
+    /// Responses to earlier requests, so unchanged files are not parsed again
+    /// by later Gazelle runs.
+    responses: Mutex<ResponseCache>,
+    activity: Mutex<Activity>,
+    workers: WorkerPool,
+}
+
+/// Total size of the source contents `ResponseCache` holds responses for,
+/// beyond which the least recently used are evicted.
+const MAX_CACHED_CONTENTS_BYTES: usize = 256 << 20;
+
+/// Parse responses keyed by the full contents parsed, so distinct contents
+/// never share a response.
+struct ResponseCache {
+    entry_by_contents: HashMap<Vec<u8>, CachedResponse>,
+    cached_bytes: usize,
+    max_cached_bytes: usize,
+    /// Incremented on every lookup, ordering entries by when they were last
+    /// used.
+    clock: u64,
+}
+
+struct CachedResponse {
+    response: ParseResponse,
+    last_used: u64,
+}
+
+impl ResponseCache {
+    fn new(max_cached_bytes: usize) -> Self {
+        ResponseCache {
+            entry_by_contents: HashMap::new(),
+            cached_bytes: 0,
+            max_cached_bytes,
+            clock: 0,
+        }
+    }
+
+    fn get(&mut self, contents: &[u8]) -> Option<ParseResponse> {
+        self.clock += 1;
+        let entry = self.entry_by_contents.get_mut(contents)?;
+        entry.last_used = self.clock;
+        Some(entry.response.clone())
+    }
+
+    fn insert(&mut self, contents: Vec<u8>, response: ParseResponse) {
+        // Another connection may have parsed the same contents concurrently.
+        if contents.len() > self.max_cached_bytes || self.entry_by_contents.contains_key(&contents)
+        {
+            return;
+        }
+        self.cached_bytes += contents.len();
+        let last_used = self.clock;
+        self.entry_by_contents.insert(
+            contents,
+            CachedResponse {
+                response,
+                last_used,
+            },
+        );
+        while self.cached_bytes > self.max_cached_bytes {
+            let least_recently_used = self
+                .entry_by_contents
+                .iter()
+                .min_by_key(|(_, entry)| entry.last_used)
+                .map(|(contents, _)| contents.clone())
+                .expect("cached bytes without cached entries");
+            self.cached_bytes -= least_recently_used.len();
+            self.entry_by_contents.remove(&least_recently_used);
+        }
+    }
+}
+
+struct Activity {
+    open_connections: usize,
+    last_active: Instant,
+}
+
+impl Default for Activity {
+    fn default() -> Self {
+        Activity {
+            open_connections: 0,
+            last_active: Instant::now(),
+        }
+    }
+}
+
+/// Threads parsing the requests of pipelined connections, shared by all of a
+/// service's connections so each doesn't start its own.
+struct WorkerPool {
+    jobs: mpsc::Sender<Box<dyn FnOnce() + Send>>,
+}
+
+impl WorkerPool {
+    fn new(worker_count: usize) -> Self {
+        let (jobs, receiver) = mpsc::channel::<Box<dyn FnOnce() + Send>>();
+        let receiver = Arc::new(Mutex::new(receiver));
+        for _ in 0..worker_count {
+            let receiver = Arc::clone(&receiver);
+            std::thread::spawn(move || {
+                loop {
+                    let job = receiver.lock().expect("job queue lock poisoned").recv();
+                    // The sender is dropped with the pool.
+                    let Ok(job) = job else {
+                        return;
+                    };
+                    job();
+                }
+            });
+        }
+        WorkerPool { jobs }
+    }
+
+    fn with_available_parallelism() -> Self {
+        Self::new(std::thread::available_parallelism().map_or(1, usize::from))
+    }
+
+    fn submit(&self, job: impl FnOnce() + Send + 'static) {
+        self.jobs
+            .send(Box::new(job))
+            .expect("parse workers stopped");
+    }
+}
+
+impl Service {
+    fn new() -> Self {
+        Service {
+            responses: Mutex::new(ResponseCache::new(MAX_CACHED_CONTENTS_BYTES)),
+            activity: Mutex::new(Activity::default()),
+            workers: WorkerPool::with_available_parallelism(),
+        }
+    }
+
+    fn handle_parse_request(&self, request: ParseRequest) -> ParseResponse {
+        // Clients may be on other machines, so the service never reads files
+        // itself: that would expose whatever its user can read.
+        let Some(contents) = request.contents.clone() else {
+            let message = format!(
+                "{}: the parse service only parses contents sent with the request",
+                request.file_path
+            );
+            return parse_response(request.request_id, Err(message.into()));
+        };
+        if let Some(response) = self.lock_responses().get(&contents) {
+            return ParseResponse {
+                request_id: request.request_id,
+                ..response
+            };
+        }
+        let response = handle_parse_request(request);
+        // Failures are left out, since their messages name the file.
+        if response.success {
+            self.lock_responses().insert(contents, response.clone());
+        }
+        response
+    }
+
+    fn lock_responses(&self) -> std::sync::MutexGuard<'_, ResponseCache> {
+        self.responses.lock().expect("response cache lock poisoned")
+    }
+
+    fn update_open_connections(&self, update: impl FnOnce(&mut usize)) {
+        let mut activity = self.activity.lock().expect("activity lock poisoned");
+        update(&mut activity.open_connections);
+        activity.last_active = Instant::now();
+    }
+
+    /// Exit the process once no connection has been open for `timeout`.
+    fn exit_when_idle(self: Arc<Self>, timeout: Duration) {
+        std::thread::spawn(move || {
+            loop {
+                std::thread::sleep(Duration::from_secs(1));
+                let activity = self.activity.lock().expect("activity lock poisoned");
+                if activity.open_connections == 0 && activity.last_active.elapsed() >= timeout {
+                    std::process::exit(0);
+                }
+            }
+        });
+    }
+
+    /// Serve each connection to `listener` on its own thread.
+    fn accept_connections(self: &Arc<Self>, listener: Listener) -> Result<(), Box<dyn Error>> {
+        match listener {
+            Listener::Unix(listener) => {
+                for stream in listener.incoming() {
+                    let stream = stream?;
+                    let reader = stream.try_clone()?;
+                    self.spawn_connection(stream, reader, "unix socket".to_string());
+                }
+            }
+            Listener::Tcp(listener) => {
+                for stream in listener.incoming() {
+                    let stream = stream?;
+                    let peer = stream
+                        .peer_addr()
+                        .map_or_else(|_| "<unknown peer>".to_string(), |peer| peer.to_string());
+                    let reader = stream.try_clone()?;
+                    self.spawn_connection(stream, reader, peer);
+                }
+            }
+        }
+        Ok(())
+    }
+
+    fn spawn_connection<S: Read + Write + Send + 'static>(self: &Arc<Self>, stream: S, reader: S, peer: String) {
+        let service = Arc::clone(self);
+        service.update_open_connections(|count| *count += 1);
+        std::thread::spawn(move || {
+            let mut stream = stream;
+            let mut reader = reader;
+            if let Err(err) = serve(&mut reader, &mut stream, &service.workers, Some(&service)) {
+                eprintln!("connection from {peer}: {err}");
+            }
+            service.update_open_connections(|count| *count -= 1);
+        });
+    }
+}
+
+/// A bound socket of a parse service.
+enum Listener {
+    Unix(UnixListener),
+    Tcp(TcpListener),
+}
+
+impl Listener {
+    /// Bind to `address`, or return `None` if a service is already listening
+    /// on its Unix socket.
+    fn bind(address: &str) -> Result<Option<Listener>, Box<dyn Error>> {
+        let Some(path) = address.strip_prefix("unix:") else {
+            let listener = TcpListener::bind(address)?;
+            eprintln!("rust_parser listening on {}", listener.local_addr()?);
+            return Ok(Some(Listener::Tcp(listener)));
+        };
+        if Path::new(path).exists() {
+            if UnixStream::connect(path).is_ok() {
+                eprintln!("rust_parser is already listening on {address}");
+                return Ok(None);
+            }
+            // Left behind by a service that did not shut down cleanly.
+            std::fs::remove_file(path)?;
+        }
+        let listener = UnixListener::bind(path)?;
+        eprintln!("rust_parser listening on {address}");
+        Ok(Some(Listener::Unix(listener)))
+    }
+}
+
+fn listen(address: &str, idle_timeout: Option<Duration>) -> Result<(), Box<dyn Error>> {
+    let Some(listener) = Listener::bind(address)? else {
+        return Ok(());
+    };
+    let service = Arc::new(Service::new());
+    if let Some(timeout) = idle_timeout {
+        Arc::clone(&service).exit_when_idle(timeout);
+    }
+    service.accept_connections(listener)
+}
+
+/// Handle one Gazelle connection: a handshake followed by parse requests until
+/// the client closes the connection.
+fn serve(
+    reader: &mut impl Read,
+    writer: &mut (impl Write + Send),
+    workers: &WorkerPool,
+    service: Option<&Arc<Service>>,
+) -> Result<(), Box<dyn Error>> {
+    let mut buf: Vec<u8> = vec![0; 1024];
+    let mut response_buf: Vec<u8> = Vec::new();
+
+    let Some(size) = read_frame(reader, &mut buf)? else {
+        return Ok(());
+    };
+    let handshake = HandshakeRequest::decode(&buf[..size])?;
+    let capabilities: Vec<String> = handshake
+        .capabilities
+        .into_iter()
+        .filter(|capability| CAPABILITIES.contains(&capability.as_str()))
+        .collect();
+    let pipelined = capabilities
+        .iter()
+        .any(|capability| capability == PIPELINING_CAPABILITY);
+    write_frame(
+        writer,
+        &HandshakeResponse {
+            protocol_version: PROTOCOL_VERSION,
+            capabilities,
         },
+        &mut response_buf,
+    )?;
+    if handshake.protocol_version != PROTOCOL_VERSION {
+        return Err(format!(
+            "client speaks protocol version {}, but this parser speaks version {}",
+            handshake.protocol_version, PROTOCOL_VERSION,
+        )
+        .into());
+    }
+
+    if pipelined {
+        return serve_pipelined(reader, writer, workers, service, buf);
+    }
+    while let Some(size) = read_frame(reader, &mut buf)? {
+        let request = ParseRequest::decode(&buf[..size])?;
+        write_frame(
+            writer,
+            &respond(service.map(Arc::as_ref), request),
+            &mut response_buf,
+        )?;
+    }
+
+    Ok(())
+}
+
+/// Parse requests on the worker pool as they arrive and write each response as
+/// soon as it's ready, so a slow file doesn't hold up the requests behind it.
+fn serve_pipelined(
+    reader: &mut impl Read,
+    writer: &mut (impl Write + Send),
+    workers: &WorkerPool,
+    service: Option<&Arc<Service>>,
+    mut buf: Vec<u8>,
+) -> Result<(), Box<dyn Error>> {
+    let (sender, receiver) = mpsc::channel::<ParseResponse>();
+
+    std::thread::scope(|scope| {
+        // Stops once the client closes the connection and every request is
+        // answered, since the reading loop and each request hold a sender.
+        let response_writer = scope.spawn(move || -> Result<(), String> {
+            let mut response_buf: Vec<u8> = Vec::new();
+            for response in receiver {
+                write_frame(writer, &response, &mut response_buf).map_err(|err| err.to_string())?;
+            }
+            Ok(())
+        });
+
+        // Moved in, so returning early on a read error also stops the writer.
+        let sender = sender;
+        while let Some(size) = read_frame(reader, &mut buf)? {
+            let request = ParseRequest::decode(&buf[..size])?;
+            let sender = sender.clone();
+            let service = service.cloned();
+            workers.submit(move || {
+                // Sending fails only once writing responses has.
+                let _ = sender.send(respond(service.as_deref(), request));
+            });
+        }
+        drop(sender);
+
+        response_writer.join().expect("response writer panicked")?;
+        Ok(())
+    })
+}
+
+fn respond(service: Option<&Service>, request: ParseRequest) -> ParseResponse {
+    match service {
+        Some(service) => service.handle_parse_request(request),
+        None => handle_parse_request(request),
     }
 }
 
@@ -68,42 +621,386 @@ fn main() -> Result<(), Box<dyn Error>> {
             println!("imports: {:?}", result.imports);
             println!("external_modules: {:?}", result.external_modules);
             println!("has_main: {}", result.has_main);
+            println!("exports_c_symbols: {}", result.exports_c_symbols);
+            println!("test_count: {}", result.test_count);
+            println!("ignored_test_count: {}", result.ignored_test_count);
+            println!("bench_count: {}", result.bench_count);
+            println!("nightly_features: {:?}", result.nightly_features);
+            println!("bare_macros: {:?}", result.bare_macros);
+            println!("bare_derives: {:?}", result.bare_derives);
+            println!("included_files: {:?}", result.included_files);
+            println!("link_names: {:?}", result.link_names);
+            println!("is_empty: {}", result.is_empty);
+            println!("exported_macros: {:?}", result.exported_macros);
+            println!("path_macros: {:?}", result.path_macros);
+            println!("embedded_migrations: {:?}", result.embedded_migrations);
+            println!("environment_variables: {:?}", result.environment_variables);
+            println!("no_main: {}", result.no_main);
+            println!("no_std: {}", result.no_std);
+            println!("has_global_allocator: {}", result.has_global_allocator);
+            println!("has_embedded_entry: {}", result.has_embedded_entry);
+            println!("contains_unsafe: {}", result.contains_unsafe);
+            if let Some(predicate) = &result.file_cfg {
+                println!("file_cfg: {:?}", predicate);
+            }
+            for item in &result.public_items {
+                println!("pub {}: {}", item.path, item.declaration);
+            }
+            for provenance in &result.import_provenances {
+                println!("{} from {:?}", provenance.name, provenance.references);
+            }
+            for import in &result.conditional_imports {
+                println!("cfg {:?}: {}", import.predicate, import.name);
+            }
+            for attribute in &result.conditional_attributes {
+                println!(
+                    "cfg_attr({}): {:?} imports {:?}",
+                    attribute.predicate, attribute.attributes, attribute.imports
+                );
+            }
         }
         Args::Serve => {
-            let mut stdin = std::io::stdin();
-            let mut stdout = std::io::stdout();
-            let mut buf: Vec<u8> = vec![0; 1024];
+            serve(
+                &mut std::io::stdin(),
+                &mut std::io::stdout(),
+                &WorkerPool::with_available_parallelism(),
+                None,
+            )?;
+        }
+        Args::Listen {
+            address,
+            idle_timeout_secs,
+        } => {
+            listen(&address, idle_timeout_secs.map(Duration::from_secs))?;
+        }
+    }
 
-            loop {
-                // Read 4-byte little-endian size prefix.
-                match stdin.read_exact(&mut buf[..4]) {
-                    Err(err) if err.kind() == std::io::ErrorKind::UnexpectedEof => {
-                        break;
-                    }
-                    res => res?,
-                }
-                let size = u32::from_le_bytes(buf[..4].try_into()?) as usize;
+    Ok(())
+}
 
-                if size > buf.len() {
-                    buf.resize(size, 0);
-                }
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::net::{Shutdown, TcpStream};
 
-                stdin.read_exact(&mut buf[..size])?;
-                let request = ParseRequest::decode(&buf[..size])?;
+    fn handshake_request(protocol_version: u32, capabilities: &[&str]) -> HandshakeRequest {
+        HandshakeRequest {
+            protocol_version,
+            capabilities: capabilities.iter().map(ToString::to_string).collect(),
+        }
+    }
+
+    fn contents_request(request_id: u64, contents: &str) -> ParseRequest {
+        ParseRequest {
+            file_path: format!("file_{request_id}.rs"),
+            contents: Some(contents.as_bytes().to_vec()),
+            request_id,
+        }
+    }
+
+    /// Frame a handshake and requests as a client sends them.
+    fn client_input(handshake: &HandshakeRequest, requests: &[ParseRequest]) -> Vec<u8> {
+        let mut input = Vec::new();
+        let mut buf = Vec::new();
+        write_frame(&mut input, handshake, &mut buf).unwrap();
+        for request in requests {
+            write_frame(&mut input, request, &mut buf).unwrap();
+        }
+        input
+    }
+
+    /// Split what the parser wrote into its handshake response and the parse
+    /// responses, in the order they were written.
+    fn read_output(mut output: &[u8]) -> (HandshakeResponse, Vec<ParseResponse>) {
+        let mut buf = Vec::new();
+        let size = read_frame(&mut output, &mut buf)
+            .unwrap()
+            .expect("no handshake response");
+        let handshake = HandshakeResponse::decode(&buf[..size]).unwrap();
+        let mut responses = Vec::new();
+        while let Some(size) = read_frame(&mut output, &mut buf).unwrap() {
+            responses.push(ParseResponse::decode(&buf[..size]).unwrap());
+        }
+        (handshake, responses)
+    }
+
+    fn serve_input(input: &[u8], service: Option<&Arc<Service>>) -> (Result<(), String>, Vec<u8>) {
+        let mut output = Vec::new();
+        let result = serve(&mut &input[..], &mut output, &WorkerPool::new(2), service)
+            .map_err(|err| err.to_string());
+        (result, output)
+    }
+
+    #[test]
+    fn test_handshake_protocol_version_mismatch() {
+        let input = client_input(
+            &handshake_request(PROTOCOL_VERSION + 1, &[]),
+            &[contents_request(1, "use serde::Serialize;")],
+        );
+        let (result, output) = serve_input(&input, None);
+        assert!(result.unwrap_err().contains("protocol version"));
+        let (handshake, responses) = read_output(&output);
+        assert_eq!(handshake.protocol_version, PROTOCOL_VERSION);
+        assert!(responses.is_empty());
+    }
+
+    #[test]
+    fn test_file_contents_capability() {
+        let input = client_input(
+            &handshake_request(PROTOCOL_VERSION, &["file_contents", "unknown"]),
+            &[contents_request(7, "use serde::Serialize;")],
+        );
+        let (result, output) = serve_input(&input, None);
+        result.unwrap();
+        let (handshake, responses) = read_output(&output);
+        assert_eq!(handshake.capabilities, vec!["file_contents"]);
+        assert_eq!(responses.len(), 1);
+        assert!(responses[0].success);
+        assert_eq!(responses[0].imports, vec!["serde"]);
+        assert_eq!(responses[0].request_id, 7);
+    }
+
+    #[test]
+    fn test_missing_file_answers_with_error() {
+        let request = ParseRequest {
+            file_path: "does/not/exist.rs".to_string(),
+            contents: None,
+            request_id: 1,
+        };
+        let input = client_input(&handshake_request(PROTOCOL_VERSION, &[]), &[request]);
+        let (result, output) = serve_input(&input, None);
+        result.unwrap();
+        let (_, responses) = read_output(&output);
+        assert_eq!(responses.len(), 1);
+        assert!(!responses[0].success);
+        assert!(responses[0].error_msg.contains("does/not/exist.rs"));
+    }
+
+    #[test]
+    fn test_service_refuses_file_paths() {
+        let path = std::env::temp_dir().join(format!("rust_parser_{}.rs", std::process::id()));
+        std::fs::write(&path, "use serde::Serialize;").unwrap();
+        let request = ParseRequest {
+            file_path: path.to_string_lossy().into_owned(),
+            contents: None,
+            request_id: 1,
+        };
+        let input = client_input(&handshake_request(PROTOCOL_VERSION, &[]), &[request]);
+        let service = Arc::new(Service::new());
+        let (result, output) = serve_input(&input, Some(&service));
+        std::fs::remove_file(&path).unwrap();
+        result.unwrap();
+        let (_, responses) = read_output(&output);
+        assert_eq!(responses.len(), 1);
+        assert!(!responses[0].success);
+        assert!(responses[0].imports.is_empty());
+        assert!(responses[0].error_msg.contains("only parses contents"));
+    }
+
+    /// Collects output, signalling once the handshake and then `frames` more
+    /// frames have been written.
+    struct SignallingWriter {
+        output: Vec<u8>,
+        frames: usize,
+        written: Option<mpsc::Sender<()>>,
+    }
 
-                let response = handle_parse_request(request);
+    impl Write for SignallingWriter {
+        fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
+            self.output.write(buf)
+        }
 
-                // Write response with size prefix.
-                let response_bytes = response.encode_to_vec();
-                let size =
-                    u32::try_from(response_bytes.len()).expect("response exceeds u32::MAX bytes");
-                let size_bytes = size.to_le_bytes();
-                stdout.write_all(&size_bytes)?;
-                stdout.write_all(&response_bytes)?;
-                stdout.flush()?;
+        // write_frame flushes after each frame.
+        fn flush(&mut self) -> std::io::Result<()> {
+            match self.frames.checked_sub(1) {
+                Some(frames) => self.frames = frames,
+                None => {
+                    if let Some(written) = self.written.take() {
+                        let _ = written.send(());
+                    }
+                }
             }
+            Ok(())
         }
     }
 
-    Ok(())
+    #[test]
+    fn test_pipelined_responses_out_of_order() {
+        // Request 1 waits for the service's response cache, which is held
+        // until response 2 is written. Request 2 is answered without the
+        // cache, since the service refuses paths.
+        let service = Arc::new(Service::new());
+        let (written, release) = mpsc::channel();
+        let (locked, cache_held) = mpsc::channel();
+        let holder = {
+            let service = Arc::clone(&service);
+            std::thread::spawn(move || {
+                let _responses = service.lock_responses();
+                locked.send(()).unwrap();
+                let _ = release.recv();
+            })
+        };
+        cache_held.recv().unwrap();
+
+        let input = client_input(
+            &handshake_request(PROTOCOL_VERSION, &["file_contents", PIPELINING_CAPABILITY]),
+            &[
+                contents_request(1, "use serde::Serialize;"),
+                ParseRequest {
+                    file_path: "file_2.rs".to_string(),
+                    contents: None,
+                    request_id: 2,
+                },
+            ],
+        );
+        let mut writer = SignallingWriter {
+            output: Vec::new(),
+            frames: 1,
+            written: Some(written),
+        };
+        let workers = WorkerPool::new(2);
+        serve(&mut &input[..], &mut writer, &workers, Some(&service)).unwrap();
+        holder.join().unwrap();
+
+        let (handshake, responses) = read_output(&writer.output);
+        assert_eq!(
+            handshake.capabilities,
+            vec!["file_contents", PIPELINING_CAPABILITY]
+        );
+        let request_ids: Vec<u64> = responses
+            .iter()
+            .map(|response| response.request_id)
+            .collect();
+        assert_eq!(request_ids, vec![2, 1]);
+        assert!(!responses[0].success);
+        assert_eq!(responses[1].imports, vec!["serde"]);
+    }
+
+    #[test]
+    fn test_cached_response_answers_its_request() {
+        let service = Arc::new(Service::new());
+        let input = client_input(
+            &handshake_request(PROTOCOL_VERSION, &["file_contents"]),
+            &[
+                contents_request(1, "use serde::Serialize;"),
+                contents_request(2, "use serde::Serialize;"),
+                contents_request(3, "use tokio::runtime::Runtime;"),
+            ],
+        );
+        let (result, output) = serve_input(&input, Some(&service));
+        result.unwrap();
+        let (_, responses) = read_output(&output);
+        let imports: Vec<(u64, Vec<String>)> = responses
+            .into_iter()
+            .map(|response| (response.request_id, response.imports))
+            .collect();
+        assert_eq!(
+            imports,
+            vec![
+                (1, vec!["serde".to_string()]),
+                (2, vec!["serde".to_string()]),
+                (3, vec!["tokio".to_string()]),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_failures_are_not_cached() {
+        let service = Arc::new(Service::new());
+        let invalid_request = |request_id| ParseRequest {
+            file_path: format!("file_{request_id}.rs"),
+            contents: Some(vec![0xff]),
+            request_id,
+        };
+        let input = client_input(
+            &handshake_request(PROTOCOL_VERSION, &["file_contents"]),
+            &[invalid_request(1), invalid_request(2)],
+        );
+        let (result, output) = serve_input(&input, Some(&service));
+        result.unwrap();
+        let (_, responses) = read_output(&output);
+        assert_eq!(responses.len(), 2);
+        assert!(responses[0].error_msg.starts_with("file_1.rs "));
+        assert!(responses[1].error_msg.starts_with("file_2.rs "));
+    }
+
+    #[test]
+    fn test_response_cache_evicts_least_recently_used() {
+        let response = |request_id| ParseResponse {
+            request_id,
+            ..ParseResponse::default()
+        };
+        let mut cache = ResponseCache::new(10);
+        assert!(cache.get(b"aaaa").is_none());
+        cache.insert(b"aaaa".to_vec(), response(1));
+        assert!(cache.get(b"bbbb").is_none());
+        cache.insert(b"bbbb".to_vec(), response(2));
+        assert_eq!(cache.get(b"aaaa").unwrap().request_id, 1);
+        assert!(cache.get(b"cccc").is_none());
+        cache.insert(b"cccc".to_vec(), response(3));
+        assert!(cache.get(b"bbbb").is_none());
+        assert_eq!(cache.get(b"aaaa").unwrap().request_id, 1);
+        assert_eq!(cache.get(b"cccc").unwrap().request_id, 3);
+
+        // Contents larger than the whole cache aren't cached.
+        cache.insert(vec![b'd'; 11], response(4));
+        assert!(cache.get(&[b'd'; 11]).is_none());
+        assert_eq!(cache.get(b"cccc").unwrap().request_id, 3);
+    }
+
+    /// Send a pipelined request over a connection to a listening service and
+    /// return its response.
+    fn exchange(mut stream: impl Read + Write, shutdown: impl FnOnce()) -> ParseResponse {
+        let input = client_input(
+            &handshake_request(PROTOCOL_VERSION, &["file_contents", PIPELINING_CAPABILITY]),
+            &[contents_request(1, "use serde::Serialize;")],
+        );
+        stream.write_all(&input).unwrap();
+        shutdown();
+        let mut output = Vec::new();
+        stream.read_to_end(&mut output).unwrap();
+        let (_, mut responses) = read_output(&output);
+        assert_eq!(responses.len(), 1);
+        responses.remove(0)
+    }
+
+    #[test]
+    fn test_listen_tcp() {
+        let Some(Listener::Tcp(listener)) = Listener::bind("127.0.0.1:0").unwrap() else {
+            panic!("expected a TCP listener");
+        };
+        let address = listener.local_addr().unwrap();
+        let service = Arc::new(Service::new());
+        std::thread::spawn(move || {
+            let _ = service.accept_connections(Listener::Tcp(listener));
+        });
+
+        let stream = TcpStream::connect(address).unwrap();
+        let writer = stream.try_clone().unwrap();
+        let response = exchange(stream, || writer.shutdown(Shutdown::Write).unwrap());
+        assert_eq!(response.imports, vec!["serde"]);
+    }
+
+    #[test]
+    fn test_listen_unix() {
+        let path =
+            std::env::temp_dir().join(format!("rust_parser_test_{}.sock", std::process::id()));
+        let address = format!("unix:{}", path.display());
+        let listener = Listener::bind(&address)
+            .unwrap()
+            .expect("expected a listener");
+        let service = Arc::new(Service::new());
+        std::thread::spawn(move || {
+            let _ = service.accept_connections(listener);
+        });
+
+        // A second service finds this one listening.
+        assert!(Listener::bind(&address).unwrap().is_none());
+
+        let stream = UnixStream::connect(&path).unwrap();
+        let writer = stream.try_clone().unwrap();
+        let response = exchange(stream, || writer.shutdown(Shutdown::Write).unwrap());
+        assert_eq!(response.imports, vec!["serde"]);
+        std::fs::remove_file(&path).unwrap();
+    }
 }
@@ -1,17 +1,182 @@
 use std::collections::{HashSet, VecDeque};
 use std::error::Error;
+use std::fmt;
+use syn::buffer::{Cursor, TokenBuffer};
 use syn::parse_file;
 use syn::punctuated::Punctuated;
+use syn::spanned::Spanned;
 use syn::visit::{self, Visit};
 
 pub struct SourceInfo {
     pub imports: Vec<String>,
     pub external_modules: Vec<String>,
     pub has_main: bool,
+    pub conditional_attributes: Vec<ConditionalAttribute>,
+    pub exports_c_symbols: bool,
+    pub test_count: u32,
+    pub ignored_test_count: u32,
+    pub nightly_features: Vec<String>,
+    /// Macros invoked by a bare name that no `use` in the file imports and
+    /// the file doesn't define, such as `lazy_static!` from a
+    /// `#[macro_use] extern crate` elsewhere, including attribute macros such
+    /// as `#[rstest]`.
+    pub bare_macros: Vec<String>,
+    /// Like bare_macros, for derives other than the standard library's.
+    pub bare_derives: Vec<String>,
+    /// Files read with include_str! or include_bytes!, relative to the file.
+    pub included_files: Vec<String>,
+    /// Native libraries named by `#[link(name = "...")]` on extern blocks.
+    pub link_names: Vec<String>,
+    /// Whether the file has no items to compile, only comments, inner
+    /// attributes, or items disabled with `#[cfg(any())]`.
+    pub is_empty: bool,
+    /// Imports whose every use is under a cfg predicate, also in imports.
+    pub conditional_imports: Vec<ConditionalImport>,
+    /// How each import is referred to, in the order of imports.
+    pub import_provenances: Vec<ImportProvenance>,
+    /// Macros defined with `macro_rules!` and marked `#[macro_export]`, which
+    /// other crates can invoke by a bare name.
+    pub exported_macros: Vec<String>,
+    /// Macros invoked by a path, such as `sqlx::query!`, without the `!`.
+    pub path_macros: Vec<String>,
+    /// Directories diesel's `embed_migrations!` embeds, relative to the crate
+    /// manifest's directory, or empty for the default directory.
+    pub embedded_migrations: Vec<String>,
+    /// Number of functions marked `#[bench]`, which only compile on nightly.
+    pub bench_count: u32,
+    /// Environment variables read at compile time with `env!` or
+    /// `option_env!`, such as `CARGO_PKG_VERSION`, sorted.
+    pub environment_variables: Vec<String>,
+    /// Whether the file has `#![no_main]` or `#![no_std]`, including under
+    /// `#![cfg_attr(...)]`.
+    pub no_main: bool,
+    pub no_std: bool,
+    /// Whether a static is marked `#[global_allocator]`.
+    pub has_global_allocator: bool,
+    /// Whether a top-level function is marked with an embedded runtime's
+    /// entry attribute, such as cortex-m-rt's `#[entry]`.
+    pub has_embedded_entry: bool,
+    /// The predicate of the file's `#![cfg(...)]` attributes, combined with
+    /// all() when there are several.
+    pub file_cfg: Option<CfgPredicate>,
+    /// Whether the file has an `unsafe` block, function, impl or trait.
+    pub contains_unsafe: bool,
+    /// Items declared `pub`, outside private inline modules, in order.
+    pub public_items: Vec<PublicItem>,
+}
+
+/// How a file refers to a crate.
+#[derive(Clone, Copy, Debug, PartialEq)]
+pub enum ImportReference {
+    /// A qualified path, such as `serde_json::to_string(...)`.
+    Path,
+    Use,
+    ExternCrate,
+    /// An attribute or derive, such as `#[tokio::main]`.
+    Attribute,
+}
+
+#[derive(Debug)]
+pub struct ImportProvenance {
+    pub name: String,
+    /// In order of first occurrence.
+    pub references: Vec<ImportReference>,
+    /// Where the import first occurs.
+    pub location: Option<SourceLocation>,
+}
+
+/// A position in a source file. Lines and columns start at 1.
+#[derive(Clone, Copy, Debug, PartialEq)]
+pub struct SourceLocation {
+    pub line: u32,
+    pub column: u32,
+}
+
+impl SourceLocation {
+    fn of(span: proc_macro2::Span) -> Self {
+        let start = span.start();
+        Self {
+            line: u32::try_from(start.line).unwrap_or(u32::MAX),
+            column: u32::try_from(start.column + 1).unwrap_or(u32::MAX),
+        }
+    }
+}
+
+/// Source that isn't valid Rust.
+#[derive(Debug)]
+pub struct SyntaxError {
+    pub message: String,
+    pub location: SourceLocation,
+}
+
+impl fmt::Display for SyntaxError {
+    fn fmt(&self, formatter: &mut fmt::Formatter<'_>) -> fmt::Result {
+        formatter.write_str(&self.message)
+    }
+}
+
+impl Error for SyntaxError {}
+
+/// A cfg predicate, such as `all(unix, feature = "tls")`.
+#[derive(Clone, Debug, PartialEq)]
+pub enum CfgPredicate {
+    /// A configuration option, such as `unix` or `feature = "tls"`.
+    Option {
+        name: String,
+        value: Option<String>,
+    },
+    All(Vec<CfgPredicate>),
+    Any(Vec<CfgPredicate>),
+    Not(Box<CfgPredicate>),
+}
+
+#[derive(Debug)]
+pub struct ConditionalImport {
+    pub name: String,
+    /// Uses under several predicates are combined with any(), and nested
+    /// predicates with all().
+    pub predicate: CfgPredicate,
+}
+
+/// An item of a file's public API.
+#[derive(Debug, PartialEq)]
+pub struct PublicItem {
+    /// The item's path within the file's module, such as `Config::new` or
+    /// `net::connect`.
+    pub path: String,
+    /// The item as declared, without its body or `pub`, such as
+    /// `fn new(name: &str) -> Self` or `mod net`.
+    pub declaration: String,
+}
+
+/// Attributes applied under `#[cfg_attr(predicate, attributes...)]`.
+#[derive(Debug)]
+pub struct ConditionalAttribute {
+    /// Predicates of nested cfg_attr are combined with `all(...)`.
+    pub predicate: String,
+    pub attributes: Vec<String>,
+    /// Crates referenced by the attributes, also reported as imports.
+    pub imports: Vec<String>,
 }
 
 pub fn parse_source(contents: &str) -> Result<SourceInfo, Box<dyn Error>> {
-    let ast = parse_file(contents)?;
+    let result = analyze_source(contents);
+    // Locations are resolved by now, so free the copy of the source that
+    // proc-macro2 keeps for this thread's spans.
+    proc_macro2::extra::invalidate_current_thread_spans();
+    result
+}
+
+fn analyze_source(contents: &str) -> Result<SourceInfo, Box<dyn Error>> {
+    let ast = parse_file(contents).map_err(|err| SyntaxError {
+        message: err.to_string(),
+        location: SourceLocation::of(err.span()),
+    })?;
+    let is_empty = ast.attrs.iter().any(is_disabled_cfg)
+        || ast
+            .items
+            .iter()
+            .all(|item| item_attributes(item).iter().any(is_disabled_cfg));
     let mut visitor = AstVisitor::default();
     visitor.visit_file(&ast);
 
@@ -19,14 +184,377 @@ pub fn parse_source(contents: &str) -> Result<SourceInfo, Box<dyn Error>> {
     assert!(visitor.mod_stack.is_empty(), "leftover scopes");
 
     root_scope.trim_early_imports();
+    let imports = filter_imports(root_scope.imports);
+    let conditional_imports = conditional_imports(&imports, &visitor.import_occurrences);
+    let import_provenances = import_provenances(&imports, &visitor.import_occurrences);
+
+    let mut bare_macros: Vec<String> = visitor
+        .bare_macros
+        .into_iter()
+        .filter(|name| !visitor.imported_names.contains(name))
+        .collect();
+    bare_macros.sort();
+    bare_macros.dedup();
+    let mut bare_derives: Vec<String> = visitor
+        .bare_derives
+        .into_iter()
+        .filter(|name| !visitor.imported_names.contains(name))
+        .collect();
+    bare_derives.sort();
+    bare_derives.dedup();
+    let mut path_macros = visitor.path_macros;
+    path_macros.sort();
+    path_macros.dedup();
+    let mut environment_variables = visitor.environment_variables;
+    environment_variables.sort();
+    environment_variables.dedup();
 
     Ok(SourceInfo {
-        imports: filter_imports(root_scope.imports),
+        imports,
         external_modules: visitor.extern_mods,
         has_main: visitor.has_main,
+        conditional_attributes: visitor.conditional_attributes,
+        exports_c_symbols: visitor.exports_c_symbols,
+        test_count: visitor.test_count,
+        ignored_test_count: visitor.ignored_test_count,
+        nightly_features: visitor.nightly_features,
+        bare_macros,
+        bare_derives,
+        included_files: visitor.included_files,
+        link_names: visitor.link_names,
+        is_empty,
+        conditional_imports,
+        import_provenances,
+        exported_macros: visitor.exported_macros,
+        path_macros,
+        embedded_migrations: visitor.embedded_migrations,
+        bench_count: visitor.bench_count,
+        environment_variables,
+        no_main: visitor.no_main,
+        no_std: visitor.no_std,
+        has_global_allocator: visitor.has_global_allocator,
+        has_embedded_entry: visitor.has_embedded_entry,
+        file_cfg: visitor.file_cfg,
+        contains_unsafe: visitor.contains_unsafe,
+        public_items: public_items(&ast.items, ""),
     })
 }
 
+/// Return how each import is referred to, given each occurrence.
+fn import_provenances(
+    imports: &[String],
+    import_occurrences: &[ImportOccurrence],
+) -> Vec<ImportProvenance> {
+    let mut provenances: Vec<ImportProvenance> = Vec::new();
+    for name in imports {
+        if provenances
+            .iter()
+            .any(|provenance| &provenance.name == name)
+        {
+            continue;
+        }
+        let mut references = Vec::new();
+        let mut location = None;
+        for occurrence in import_occurrences {
+            if &occurrence.name != name {
+                continue;
+            }
+            location = location.or(Some(occurrence.location));
+            if !references.contains(&occurrence.reference) {
+                references.push(occurrence.reference);
+            }
+        }
+        provenances.push(ImportProvenance {
+            name: name.clone(),
+            references,
+            location,
+        });
+    }
+    provenances
+}
+
+/// Return the imports that only occur under cfg predicates, given each
+/// occurrence.
+fn conditional_imports(
+    imports: &[String],
+    import_occurrences: &[ImportOccurrence],
+) -> Vec<ConditionalImport> {
+    let mut conditional_imports = Vec::new();
+    let mut seen = HashSet::new();
+    for name in imports {
+        if !seen.insert(name) {
+            continue;
+        }
+        let mut predicates = Vec::new();
+        let mut unconditional = false;
+        for occurrence in import_occurrences {
+            match &occurrence.condition {
+                _ if &occurrence.name != name => {}
+                None => unconditional = true,
+                Some(predicate) if !predicates.contains(predicate) => {
+                    predicates.push(predicate.clone());
+                }
+                Some(_) => {}
+            }
+        }
+        if unconditional || predicates.is_empty() {
+            continue;
+        }
+        let predicate = if predicates.len() == 1 {
+            predicates.remove(0)
+        } else {
+            CfgPredicate::Any(predicates)
+        };
+        conditional_imports.push(ConditionalImport {
+            name: name.clone(),
+            predicate,
+        });
+    }
+    conditional_imports
+}
+
+/// Return the public items, giving the paths of those in inline modules the
+/// module's prefix.
+fn public_items(items: &[syn::Item], prefix: &str) -> Vec<PublicItem> {
+    let mut found = Vec::new();
+    let mut add = |path: String, declaration: Option<String>| {
+        if let Some(declaration) = declaration {
+            found.push(PublicItem {
+                path: format!("{prefix}{path}"),
+                declaration,
+            });
+        }
+    };
+    for item in items {
+        match item {
+            syn::Item::Fn(item) if is_public(&item.vis) => {
+                add(
+                    item.sig.ident.to_string(),
+                    declaration(item.sig.span(), None),
+                );
+            }
+            syn::Item::Struct(item) if is_public(&item.vis) => {
+                let end = match &item.fields {
+                    syn::Fields::Named(fields) => fields.brace_token.span.open(),
+                    syn::Fields::Unnamed(fields) => fields.paren_token.span.open(),
+                    syn::Fields::Unit => {
+                        item.semi_token.map_or(item.ident.span(), |semi| semi.span)
+                    }
+                };
+                add(
+                    item.ident.to_string(),
+                    declaration(item.struct_token.span, Some(end)),
+                );
+                for (index, field) in item.fields.iter().enumerate() {
+                    if is_public(&field.vis) {
+                        let name = field
+                            .ident
+                            .as_ref()
+                            .map_or_else(|| index.to_string(), ToString::to_string);
+                        add(
+                            format!("{}::{name}", item.ident),
+                            declaration(field.ty.span(), None),
+                        );
+                    }
+                }
+            }
+            syn::Item::Enum(item) if is_public(&item.vis) => {
+                add(
+                    item.ident.to_string(),
+                    declaration(item.enum_token.span, Some(item.brace_token.span.open())),
+                );
+                for variant in &item.variants {
+                    let end = match (&variant.discriminant, &variant.fields) {
+                        (Some((_, discriminant)), _) => Some(discriminant.span()),
+                        (None, syn::Fields::Named(fields)) => Some(fields.brace_token.span.close()),
+                        (None, syn::Fields::Unnamed(fields)) => {
+                            Some(fields.paren_token.span.close())
+                        }
+                        (None, syn::Fields::Unit) => None,
+                    };
+                    add(
+                        format!("{}::{}", item.ident, variant.ident),
+                        declaration(variant.ident.span(), end),
+                    );
+                }
+            }
+            syn::Item::Union(item) if is_public(&item.vis) => {
+                add(
+                    item.ident.to_string(),
+                    declaration(
+                        item.union_token.span,
+                        Some(item.fields.brace_token.span.open()),
+                    ),
+                );
+            }
+            syn::Item::Trait(item) if is_public(&item.vis) => {
+                let start = item
+                    .unsafety
+                    .map_or(item.trait_token.span, |unsafety| unsafety.span);
+                add(
+                    item.ident.to_string(),
+                    declaration(start, Some(item.brace_token.span.open())),
+                );
+                for trait_item in &item.items {
+                    if let syn::TraitItem::Fn(function) = trait_item {
+                        add(
+                            format!("{}::{}", item.ident, function.sig.ident),
+                            declaration(function.sig.span(), None),
+                        );
+                    }
+                }
+            }
+            syn::Item::Type(item) if is_public(&item.vis) => {
+                add(
+                    item.ident.to_string(),
+                    declaration(item.type_token.span, Some(item.ty.span())),
+                );
+            }
+            syn::Item::Const(item) if is_public(&item.vis) => {
+                add(
+                    item.ident.to_string(),
+                    declaration(item.const_token.span, Some(item.ty.span())),
+                );
+            }
+            syn::Item::Static(item) if is_public(&item.vis) => {
+                add(
+                    item.ident.to_string(),
+                    declaration(item.static_token.span, Some(item.ty.span())),
+                );
+            }
+            syn::Item::Use(item) if is_public(&item.vis) => {
+                if let Some(tree) = declaration(item.tree.span(), None) {
+                    add(tree.clone(), Some(format!("use {tree}")));
+                }
+            }
+            syn::Item::Mod(item) if is_public(&item.vis) => {
+                add(item.ident.to_string(), Some(format!("mod {}", item.ident)));
+                if let Some((_, items)) = &item.content {
+                    for nested in public_items(items, &format!("{}::", item.ident)) {
+                        add(nested.path, Some(nested.declaration));
+                    }
+                }
+            }
+            syn::Item::Impl(item) if item.trait_.is_none() => {
+                let syn::Type::Path(self_type) = &*item.self_ty else {
+                    continue;
+                };
+                let Some(self_name) = self_type.path.segments.last() else {
+                    continue;
+                };
+                for impl_item in &item.items {
+                    if let syn::ImplItem::Fn(function) = impl_item
+                        && is_public(&function.vis)
+                    {
+                        add(
+                            format!("{}::{}", self_name.ident, function.sig.ident),
+                            declaration(function.sig.span(), None),
+                        );
+                    }
+                }
+            }
+            _ => {}
+        }
+    }
+    found
+}
+
+fn is_public(visibility: &syn::Visibility) -> bool {
+    matches!(visibility, syn::Visibility::Public(_))
+}
+
+/// Return the source text from start through end, on one line, without a
+/// trailing `{`, `(` or `;` or the trailing commas formatting adds.
+fn declaration(start: proc_macro2::Span, end: Option<proc_macro2::Span>) -> Option<String> {
+    let span = match end {
+        Some(end) => start.join(end)?,
+        None => start,
+    };
+    let mut text = span
+        .source_text()?
+        .split_whitespace()
+        .collect::<Vec<_>>()
+        .join(" ");
+    for (from, to) in [
+        ("( ", "("),
+        (" )", ")"),
+        ("[ ", "["),
+        (" ]", "]"),
+        (",)", ")"),
+        (",]", "]"),
+        (",>", ">"),
+    ] {
+        text = text.replace(from, to);
+    }
+    Some(text.trim_end_matches(['{', '(', ';', ',', ' ']).to_string())
+}
+
+const STANDARD_DERIVES: &[&str] = &[
+    "Clone",
+    "Copy",
+    "Debug",
+    "Default",
+    "Eq",
+    "Hash",
+    "Ord",
+    "PartialEq",
+    "PartialOrd",
+];
+
+/// Attributes built into the compiler, which aren't macros from a crate.
+const BUILTIN_ATTRIBUTES: &[&str] = &[
+    "allow",
+    "automatically_derived",
+    "bench",
+    "cfg",
+    "cfg_attr",
+    "cold",
+    "collapse_debuginfo",
+    "crate_name",
+    "crate_type",
+    "debugger_visualizer",
+    "deny",
+    "deprecated",
+    "derive",
+    "doc",
+    "expect",
+    "export_name",
+    "feature",
+    "forbid",
+    "global_allocator",
+    "ignore",
+    "inline",
+    "link",
+    "link_name",
+    "link_section",
+    "macro_export",
+    "macro_use",
+    "must_use",
+    "naked",
+    "no_builtins",
+    "no_implicit_prelude",
+    "no_main",
+    "no_mangle",
+    "no_std",
+    "non_exhaustive",
+    "panic_handler",
+    "path",
+    "proc_macro",
+    "proc_macro_attribute",
+    "proc_macro_derive",
+    "recursion_limit",
+    "repr",
+    "should_panic",
+    "target_feature",
+    "test",
+    "track_caller",
+    "type_length_limit",
+    "unsafe",
+    "used",
+    "warn",
+    "windows_subsystem",
+];
+
 const PRIMITIVES: &[&str] = &[
     "bool", "char", "str", "i8", "i16", "i32", "i64", "i128", "isize", "u8", "u16", "u32", "u64",
     "u128", "usize", "f32", "f64",
@@ -130,6 +658,45 @@ struct AstVisitor<'ast> {
     /// Prevents use statement items from shadowing their own crate import
     mod_denylist: HashSet<Ident<'ast>>,
     has_main: bool,
+    /// Predicates of the cfg_attr attributes being visited, outermost first.
+    cfg_predicates: Vec<String>,
+    conditional_attributes: Vec<ConditionalAttribute>,
+    exports_c_symbols: bool,
+    test_count: u32,
+    ignored_test_count: u32,
+    bench_count: u32,
+    nightly_features: Vec<String>,
+    bare_macros: Vec<String>,
+    bare_derives: Vec<String>,
+    included_files: Vec<String>,
+    link_names: Vec<String>,
+    exported_macros: Vec<String>,
+    path_macros: Vec<String>,
+    embedded_migrations: Vec<String>,
+    environment_variables: Vec<String>,
+    no_main: bool,
+    no_std: bool,
+    has_global_allocator: bool,
+    has_embedded_entry: bool,
+    file_cfg: Option<CfgPredicate>,
+    contains_unsafe: bool,
+    /// Names brought into scope by `use`, or defined with `macro_rules!`.
+    imported_names: HashSet<String>,
+    /// Predicates of the cfg and cfg_attr attributes enclosing the node being
+    /// visited, outermost first.
+    enclosing_cfgs: Vec<CfgPredicate>,
+    import_occurrences: Vec<ImportOccurrence>,
+    /// How the node being visited refers to crates.
+    reference: ImportReference,
+}
+
+#[derive(Debug)]
+struct ImportOccurrence {
+    name: String,
+    /// The predicates enclosing the occurrence, combined.
+    condition: Option<CfgPredicate>,
+    reference: ImportReference,
+    location: SourceLocation,
 }
 
 impl Default for AstVisitor<'_> {
@@ -142,6 +709,31 @@ impl Default for AstVisitor<'_> {
             extern_mods: Vec::default(),
             mod_denylist: HashSet::new(),
             has_main: false,
+            cfg_predicates: Vec::new(),
+            conditional_attributes: Vec::new(),
+            exports_c_symbols: false,
+            test_count: 0,
+            ignored_test_count: 0,
+            bench_count: 0,
+            nightly_features: Vec::new(),
+            bare_macros: Vec::new(),
+            bare_derives: Vec::new(),
+            included_files: Vec::new(),
+            link_names: Vec::new(),
+            exported_macros: Vec::new(),
+            path_macros: Vec::new(),
+            embedded_migrations: Vec::new(),
+            environment_variables: Vec::new(),
+            no_main: false,
+            no_std: false,
+            has_global_allocator: false,
+            has_embedded_entry: false,
+            file_cfg: None,
+            contains_unsafe: false,
+            imported_names: HashSet::new(),
+            enclosing_cfgs: Vec::new(),
+            import_occurrences: Vec::new(),
+            reference: ImportReference::Path,
         }
     }
 }
@@ -155,102 +747,48 @@ impl<'ast> AstVisitor<'ast> {
         }
 
         if !self.scope_mods.contains(&ident) {
+            let condition = self.enclosing_cfg();
+            self.import_occurrences.push(ImportOccurrence {
+                name: ident.to_string(),
+                condition,
+                reference: self.reference,
+                location: SourceLocation::of(ident.ident().span()),
+            });
             self.mod_stack.back_mut().unwrap().imports.push(ident);
         }
     }
 
-    /// Macro bodies are stored as TokenStream and not visited by syn's AST
-    /// traversal.
-    fn extract_paths_from_expression(&mut self, expression: &syn::Expr) {
-        match expression {
-            syn::Expr::Path(path_expression) => {
-                if path_expression.path.segments.len() > 1 {
-                    self.add_import(path_expression.path.segments[0].ident.clone());
-                }
-            }
-            syn::Expr::Call(call_expression) => {
-                self.extract_paths_from_expression(&call_expression.func);
-                for arg in &call_expression.args {
-                    self.extract_paths_from_expression(arg);
-                }
-            }
-            syn::Expr::MethodCall(method_call_expression) => {
-                self.extract_paths_from_expression(&method_call_expression.receiver);
-                for arg in &method_call_expression.args {
-                    self.extract_paths_from_expression(arg);
-                }
-            }
-            syn::Expr::Binary(binary_expression) => {
-                self.extract_paths_from_expression(&binary_expression.left);
-                self.extract_paths_from_expression(&binary_expression.right);
-            }
-            syn::Expr::Unary(unary_expression) => {
-                self.extract_paths_from_expression(&unary_expression.expr);
-            }
-            syn::Expr::Reference(reference_expression) => {
-                self.extract_paths_from_expression(&reference_expression.expr);
-            }
-            syn::Expr::Paren(parenthesis_expression) => {
-                self.extract_paths_from_expression(&parenthesis_expression.expr);
-            }
-            syn::Expr::Field(field_expression) => {
-                self.extract_paths_from_expression(&field_expression.base);
-            }
-            syn::Expr::Index(index_expression) => {
-                self.extract_paths_from_expression(&index_expression.expr);
-                self.extract_paths_from_expression(&index_expression.index);
-            }
-            syn::Expr::Tuple(tuple_expression) => {
-                for elem in &tuple_expression.elems {
-                    self.extract_paths_from_expression(elem);
-                }
-            }
-            syn::Expr::Array(array_expression) => {
-                for elem in &array_expression.elems {
-                    self.extract_paths_from_expression(elem);
-                }
-            }
-            syn::Expr::Cast(cast_expression) => {
-                self.extract_paths_from_expression(&cast_expression.expr);
-            }
-            syn::Expr::If(if_expression) => {
-                self.extract_paths_from_expression(&if_expression.cond);
-                for statement in &if_expression.then_branch.stmts {
-                    if let syn::Stmt::Expr(e, _) = statement {
-                        self.extract_paths_from_expression(e);
-                    }
-                }
-                if let Some((_, else_branch)) = &if_expression.else_branch {
-                    self.extract_paths_from_expression(else_branch);
-                }
-            }
-            syn::Expr::Match(match_expression) => {
-                self.extract_paths_from_expression(&match_expression.expr);
-                for arm in &match_expression.arms {
-                    self.extract_paths_from_expression(&arm.body);
-                }
-            }
-            syn::Expr::Block(block_expression) => {
-                for statement in &block_expression.block.stmts {
-                    if let syn::Stmt::Expr(e, _) = statement {
-                        self.extract_paths_from_expression(e);
-                    }
-                }
-            }
-            syn::Expr::Closure(closure_expression) => {
-                self.extract_paths_from_expression(&closure_expression.body);
-            }
-            syn::Expr::Struct(struct_expression) => {
-                if struct_expression.path.segments.len() > 1 {
-                    self.add_import(struct_expression.path.segments[0].ident.clone());
-                }
-                for field in &struct_expression.fields {
-                    self.extract_paths_from_expression(&field.expr);
-                }
+    /// Visit a node that refers to crates in the given way.
+    fn visit_as(&mut self, reference: ImportReference, visit: impl FnOnce(&mut Self)) {
+        let enclosing = std::mem::replace(&mut self.reference, reference);
+        visit(self);
+        self.reference = enclosing;
+    }
+
+    /// Visit a node under its cfg attributes' predicates.
+    /// The predicate of the cfg and cfg_attr attributes enclosing the node
+    /// being visited, combined with all().
+    fn enclosing_cfg(&self) -> Option<CfgPredicate> {
+        match self.enclosing_cfgs.as_slice() {
+            [] => None,
+            [predicate] => Some(predicate.clone()),
+            predicates => Some(CfgPredicate::All(predicates.to_vec())),
+        }
+    }
+
+    fn visit_under_cfgs(&mut self, attributes: &[syn::Attribute], visit: impl FnOnce(&mut Self)) {
+        let depth = self.enclosing_cfgs.len();
+        for attribute in attributes {
+            if let syn::Meta::List(list) = &attribute.meta
+                && list.path.is_ident("cfg")
+                && let Ok(predicate) = list.parse_args::<syn::Meta>()
+                && let Some(predicate) = cfg_predicate(&predicate)
+            {
+                self.enclosing_cfgs.push(predicate);
             }
-            // Skip literals, breaks, continues, etc.; they don't contain paths.
-            _ => {}
         }
+        visit(self);
+        self.enclosing_cfgs.truncate(depth);
     }
 
     fn add_mod<I: Into<Ident<'ast>>>(&mut self, ident: I) {
@@ -279,6 +817,14 @@ impl<'ast> AstVisitor<'ast> {
         parent_scope.imports.extend(scope.imports);
     }
 
+    fn add_bare_attribute(&mut self, path: &syn::Path) {
+        if let Some(name) = path.get_ident()
+            && !BUILTIN_ATTRIBUTES.contains(&name.to_string().as_str())
+        {
+            self.bare_macros.push(name.to_string());
+        }
+    }
+
     fn is_root_scope(&self) -> bool {
         self.mod_stack.len() == 1
     }
@@ -305,33 +851,289 @@ impl<'ast> AstVisitor<'ast> {
                             Punctuated::<syn::Meta, syn::Token![,]>::parse_terminated,
                         ) {
                             for derive in nested {
+                                if let Some(name) = derive.path().get_ident()
+                                    && !STANDARD_DERIVES.contains(&name.to_string().as_str())
+                                {
+                                    self.bare_derives.push(name.to_string());
+                                }
                                 self.visit_attr_meta(&derive);
                             }
                         }
-                    } else if ident == "cfg_attr"
-                        && let Ok(nested) = list.parse_args_with(
-                            Punctuated::<syn::Meta, syn::Token![,]>::parse_terminated,
-                        )
-                    {
-                        let mut iter = nested.into_iter();
-                        iter.next();
-                        if let Some(inner) = iter.next() {
-                            self.visit_attr_meta(&inner);
-                        }
+                    } else if ident == "cfg_attr" {
+                        self.visit_cfg_attr(list);
                     }
                 }
             }
             syn::Meta::NameValue(_) => (),
         }
     }
+
+    fn visit_cfg_attr(&mut self, list: &syn::MetaList) {
+        let Ok(nested) =
+            list.parse_args_with(Punctuated::<syn::Meta, syn::Token![,]>::parse_terminated)
+        else {
+            return;
+        };
+        let mut iter = nested.into_iter();
+        let Some(predicate) = iter.next() else {
+            return;
+        };
+        let (nested_cfg_attrs, attributes): (Vec<_>, Vec<_>) =
+            iter.partition(|attribute| attribute.path().is_ident("cfg_attr"));
+
+        self.cfg_predicates.push(render_meta(&predicate));
+        let depth = self.enclosing_cfgs.len();
+        self.enclosing_cfgs.extend(cfg_predicate(&predicate));
+        if !attributes.is_empty() {
+            let predicate = match self.cfg_predicates.as_slice() {
+                [predicate] => predicate.clone(),
+                predicates => format!("all({})", predicates.join(", ")),
+            };
+            let mut imports = Vec::new();
+            for attribute in &attributes {
+                self.add_bare_attribute(attribute.path());
+                // syn doesn't visit the macros of attributes under cfg_attr.
+                if let syn::Meta::NameValue(name_value) = attribute
+                    && let syn::Expr::Macro(expression) = &name_value.value
+                    && let Some(file) = included_file(&expression.mac)
+                {
+                    self.included_files.push(file);
+                }
+                let start = self.mod_stack.back().unwrap().imports.len();
+                self.visit_attr_meta(attribute);
+                imports.extend_from_slice(&self.mod_stack.back().unwrap().imports[start..]);
+            }
+            let mut imports = filter_imports(imports);
+            imports.sort();
+            imports.dedup();
+            self.conditional_attributes.push(ConditionalAttribute {
+                predicate,
+                attributes: attributes.iter().map(render_meta).collect(),
+                imports,
+            });
+        }
+        for nested_cfg_attr in &nested_cfg_attrs {
+            self.visit_attr_meta(nested_cfg_attr);
+        }
+        self.enclosing_cfgs.truncate(depth);
+        self.cfg_predicates.pop();
+    }
+}
+
+/// Whether the attribute exports a function under an unmangled symbol:
+/// `#[no_mangle]`, `#[export_name = "..."]`, or their `#[unsafe(...)]` forms.
+fn is_symbol_export(attribute: &syn::Attribute) -> bool {
+    let is_export = |path: &syn::Path| path.is_ident("no_mangle") || path.is_ident("export_name");
+    match &attribute.meta {
+        syn::Meta::List(list) if list.path.is_ident("unsafe") => list
+            .parse_args::<syn::Meta>()
+            .is_ok_and(|inner| is_export(inner.path())),
+        meta => is_export(meta.path()),
+    }
+}
+
+/// Whether the attribute marks a test: `#[test]`, or a runtime's test
+/// attribute such as `#[tokio::test]`.
+/// Whether the attribute marks an embedded runtime's entry point, as
+/// `#[entry]` or `#[cortex_m_rt::entry]` do.
+fn is_embedded_entry(attribute: &syn::Attribute) -> bool {
+    attribute
+        .path()
+        .segments
+        .last()
+        .is_some_and(|segment| segment.ident == "entry")
+}
+
+/// Whether the inner attributes include `#![name]`, directly or under
+/// `#![cfg_attr(...)]` as in `#![cfg_attr(not(test), no_main)]`.
+fn has_crate_attribute(attributes: &[syn::Attribute], name: &str) -> bool {
+    attributes
+        .iter()
+        .filter(|attribute| matches!(attribute.style, syn::AttrStyle::Inner(_)))
+        .any(|attribute| {
+            attribute.path().is_ident(name)
+                || attribute.path().is_ident("cfg_attr")
+                    && attribute
+                        .parse_args_with(
+                            Punctuated::<syn::Meta, syn::Token![,]>::parse_terminated,
+                        )
+                        .is_ok_and(|nested| {
+                            nested.iter().skip(1).any(|meta| meta.path().is_ident(name))
+                        })
+        })
+}
+
+fn is_test_attribute(attribute: &syn::Attribute) -> bool {
+    attribute
+        .path()
+        .segments
+        .last()
+        .is_some_and(|segment| segment.ident == "test")
+}
+
+/// Parse a cfg predicate, or return None for malformed ones.
+fn cfg_predicate(meta: &syn::Meta) -> Option<CfgPredicate> {
+    match meta {
+        syn::Meta::Path(path) => Some(CfgPredicate::Option {
+            name: render_path(path),
+            value: None,
+        }),
+        syn::Meta::NameValue(name_value) => match &name_value.value {
+            syn::Expr::Lit(syn::ExprLit {
+                lit: syn::Lit::Str(value),
+                ..
+            }) => Some(CfgPredicate::Option {
+                name: render_path(&name_value.path),
+                value: Some(value.value()),
+            }),
+            _ => None,
+        },
+        syn::Meta::List(list) => {
+            let operands = list
+                .parse_args_with(Punctuated::<syn::Meta, syn::Token![,]>::parse_terminated)
+                .ok()?
+                .iter()
+                .map(cfg_predicate)
+                .collect::<Option<Vec<_>>>()?;
+            let operator = list.path.get_ident()?.to_string();
+            match operator.as_str() {
+                "all" => Some(CfgPredicate::All(operands)),
+                "any" => Some(CfgPredicate::Any(operands)),
+                "not" if operands.len() == 1 => {
+                    Some(CfgPredicate::Not(Box::new(operands.into_iter().next()?)))
+                }
+                _ => None,
+            }
+        }
+    }
+}
+
+/// Whether the attribute is `cfg(any())`, which is never enabled.
+fn is_disabled_cfg(attribute: &syn::Attribute) -> bool {
+    let syn::Meta::List(list) = &attribute.meta else {
+        return false;
+    };
+    list.path.is_ident("cfg")
+        && list
+            .parse_args::<syn::MetaList>()
+            .is_ok_and(|predicate| predicate.path.is_ident("any") && predicate.tokens.is_empty())
+}
+
+fn item_attributes(item: &syn::Item) -> &[syn::Attribute] {
+    match item {
+        syn::Item::Const(item) => &item.attrs,
+        syn::Item::Enum(item) => &item.attrs,
+        syn::Item::ExternCrate(item) => &item.attrs,
+        syn::Item::Fn(item) => &item.attrs,
+        syn::Item::ForeignMod(item) => &item.attrs,
+        syn::Item::Impl(item) => &item.attrs,
+        syn::Item::Macro(item) => &item.attrs,
+        syn::Item::Mod(item) => &item.attrs,
+        syn::Item::Static(item) => &item.attrs,
+        syn::Item::Struct(item) => &item.attrs,
+        syn::Item::Trait(item) => &item.attrs,
+        syn::Item::TraitAlias(item) => &item.attrs,
+        syn::Item::Type(item) => &item.attrs,
+        syn::Item::Union(item) => &item.attrs,
+        syn::Item::Use(item) => &item.attrs,
+        _ => &[],
+    }
+}
+
+/// The file an `include_str!("...")` or `include_bytes!("...")` reads.
+fn included_file(mac: &syn::Macro) -> Option<String> {
+    let name = mac.path.get_ident()?;
+    if name != "include_str" && name != "include_bytes" {
+        return None;
+    }
+    mac.parse_body::<syn::LitStr>()
+        .ok()
+        .map(|file| file.value())
+}
+
+/// The variable an `env!("...")` or `option_env!("...")` reads. env! may
+/// have a second argument with an error message.
+fn environment_variable(mac: &syn::Macro) -> Option<String> {
+    let name = mac.path.get_ident()?;
+    if name != "env" && name != "option_env" {
+        return None;
+    }
+    mac.parse_body_with(Punctuated::<syn::LitStr, syn::Token![,]>::parse_terminated)
+        .ok()?
+        .first()
+        .map(|variable| variable.value())
+}
+
+fn embedded_migrations(mac: &syn::Macro) -> Option<String> {
+    if mac.path.segments.last()?.ident != "embed_migrations" {
+        return None;
+    }
+    if mac.tokens.is_empty() {
+        return Some(String::new());
+    }
+    mac.parse_body::<syn::LitStr>()
+        .ok()
+        .map(|directory| directory.value())
+}
+
+/// Render a cfg predicate or attribute as written, with normalized spacing.
+fn render_meta(meta: &syn::Meta) -> String {
+    match meta {
+        syn::Meta::Path(path) => render_path(path),
+        syn::Meta::List(list) => {
+            let arguments = list
+                .parse_args_with(Punctuated::<syn::Meta, syn::Token![,]>::parse_terminated)
+                .map_or_else(
+                    |_| list.tokens.to_string(),
+                    |nested| {
+                        nested
+                            .iter()
+                            .map(render_meta)
+                            .collect::<Vec<_>>()
+                            .join(", ")
+                    },
+                );
+            format!("{}({arguments})", render_path(&list.path))
+        }
+        syn::Meta::NameValue(name_value) => {
+            let value = match &name_value.value {
+                syn::Expr::Lit(syn::ExprLit {
+                    lit: syn::Lit::Str(string),
+                    ..
+                }) => format!("{:?}", string.value()),
+                syn::Expr::Lit(syn::ExprLit {
+                    lit: syn::Lit::Bool(boolean),
+                    ..
+                }) => boolean.value.to_string(),
+                _ => "..".to_string(),
+            };
+            format!("{} = {value}", render_path(&name_value.path))
+        }
+    }
+}
+
+fn render_path(path: &syn::Path) -> String {
+    let segments = path
+        .segments
+        .iter()
+        .map(|segment| segment.ident.to_string())
+        .collect::<Vec<_>>()
+        .join("::");
+    if path.leading_colon.is_some() {
+        format!("::{segments}")
+    } else {
+        segments
+    }
 }
 
 impl<'ast> Visit<'ast> for AstVisitor<'ast> {
     fn visit_use_name(&mut self, node: &'ast syn::UseName) {
+        self.imported_names.insert(node.ident.to_string());
         self.add_mod(&node.ident);
     }
 
     fn visit_use_rename(&mut self, node: &'ast syn::UseRename) {
+        self.imported_names.insert(node.rename.to_string());
         self.add_import(&node.ident);
         self.add_mod(&node.rename);
     }
@@ -347,9 +1149,11 @@ impl<'ast> Visit<'ast> for AstVisitor<'ast> {
         let mut imports = HashSet::new();
         parse_use_imports(&node.tree, &mut imports);
 
-        for import in &imports {
-            self.add_import(import.clone());
-        }
+        self.visit_as(ImportReference::Use, |visitor| {
+            for import in &imports {
+                visitor.add_import(import.clone());
+            }
+        });
 
         self.mod_denylist = imports;
         visit::visit_item_use(self, node);
@@ -370,7 +1174,9 @@ impl<'ast> Visit<'ast> for AstVisitor<'ast> {
     }
 
     fn visit_item_extern_crate(&mut self, node: &'ast syn::ItemExternCrate) {
-        self.add_import(&node.ident);
+        self.visit_as(ImportReference::ExternCrate, |visitor| {
+            visitor.add_import(&node.ident);
+        });
     }
 
     fn visit_block(&mut self, node: &'ast syn::Block) {
@@ -395,12 +1201,120 @@ impl<'ast> Visit<'ast> for AstVisitor<'ast> {
         if self.is_root_scope() && node.sig.ident == "main" {
             self.has_main = true;
         }
+        if self.is_root_scope() && node.attrs.iter().any(is_embedded_entry) {
+            self.has_embedded_entry = true;
+        }
+        if node.sig.abi.is_some() && node.attrs.iter().any(is_symbol_export) {
+            self.exports_c_symbols = true;
+        }
+        if node.attrs.iter().any(is_test_attribute) {
+            self.test_count += 1;
+            if node
+                .attrs
+                .iter()
+                .any(|attribute| attribute.path().is_ident("ignore"))
+            {
+                self.ignored_test_count += 1;
+            }
+        }
+        if node
+            .attrs
+            .iter()
+            .any(|attribute| attribute.path().is_ident("bench"))
+        {
+            self.bench_count += 1;
+        }
 
         self.push_scope();
         visit::visit_item_fn(self, node);
         self.pop_scope();
     }
 
+    fn visit_file(&mut self, node: &'ast syn::File) {
+        for attribute in &node.attrs {
+            if matches!(attribute.style, syn::AttrStyle::Inner(_))
+                && attribute.path().is_ident("feature")
+                && let Ok(features) = attribute
+                    .parse_args_with(Punctuated::<syn::Path, syn::Token![,]>::parse_terminated)
+            {
+                self.nightly_features
+                    .extend(features.iter().map(render_path));
+            }
+        }
+        self.no_main = has_crate_attribute(&node.attrs, "no_main");
+        self.no_std = has_crate_attribute(&node.attrs, "no_std");
+        self.visit_under_cfgs(&node.attrs, |visitor| {
+            visitor.file_cfg = visitor.enclosing_cfg();
+            visit::visit_file(visitor, node);
+        });
+    }
+
+    fn visit_item(&mut self, node: &'ast syn::Item) {
+        self.visit_under_cfgs(item_attributes(node), |visitor| {
+            visit::visit_item(visitor, node);
+        });
+    }
+
+    fn visit_item_foreign_mod(&mut self, node: &'ast syn::ItemForeignMod) {
+        for attribute in &node.attrs {
+            if attribute.path().is_ident("link")
+                && let Ok(arguments) = attribute
+                    .parse_args_with(Punctuated::<syn::Meta, syn::Token![,]>::parse_terminated)
+            {
+                for argument in arguments {
+                    if let syn::Meta::NameValue(name_value) = argument
+                        && name_value.path.is_ident("name")
+                        && let syn::Expr::Lit(syn::ExprLit {
+                            lit: syn::Lit::Str(name),
+                            ..
+                        }) = name_value.value
+                        && !self.link_names.contains(&name.value())
+                    {
+                        self.link_names.push(name.value());
+                    }
+                }
+            }
+        }
+        visit::visit_item_foreign_mod(self, node);
+    }
+
+    fn visit_item_static(&mut self, node: &'ast syn::ItemStatic) {
+        if node
+            .attrs
+            .iter()
+            .any(|attribute| attribute.path().is_ident("global_allocator"))
+        {
+            self.has_global_allocator = true;
+        }
+        visit::visit_item_static(self, node);
+    }
+
+    fn visit_expr_unsafe(&mut self, node: &'ast syn::ExprUnsafe) {
+        self.contains_unsafe = true;
+        visit::visit_expr_unsafe(self, node);
+    }
+
+    fn visit_signature(&mut self, node: &'ast syn::Signature) {
+        if node.unsafety.is_some() {
+            self.contains_unsafe = true;
+        }
+        visit::visit_signature(self, node);
+    }
+
+    fn visit_item_impl(&mut self, node: &'ast syn::ItemImpl) {
+        if node.unsafety.is_some() {
+            self.contains_unsafe = true;
+        }
+        visit::visit_item_impl(self, node);
+    }
+
+    fn visit_item_trait(&mut self, node: &'ast syn::ItemTrait) {
+        if node.unsafety.is_some() {
+            self.contains_unsafe = true;
+        }
+        visit::visit_item_trait(self, node);
+    }
+
     fn visit_item_struct(&mut self, node: &'ast syn::ItemStruct) {
         visit::visit_item_struct(self, node);
     }
@@ -414,8 +1328,11 @@ impl<'ast> Visit<'ast> for AstVisitor<'ast> {
     }
 
     fn visit_attribute(&mut self, node: &'ast syn::Attribute) {
-        self.visit_attr_meta(&node.meta);
-        visit::visit_attribute(self, node);
+        self.add_bare_attribute(node.path());
+        self.visit_as(ImportReference::Attribute, |visitor| {
+            visitor.visit_attr_meta(&node.meta);
+            visit::visit_attribute(visitor, node);
+        });
     }
 
     fn visit_item_macro(&mut self, node: &'ast syn::ItemMacro) {
@@ -423,22 +1340,128 @@ impl<'ast> Visit<'ast> for AstVisitor<'ast> {
             && macro_ident == "macro_rules"
             && let Some(new_ident) = &node.ident
         {
+            self.imported_names.insert(new_ident.to_string());
             self.add_mod(new_ident);
+            if node
+                .attrs
+                .iter()
+                .any(|attribute| attribute.path().is_ident("macro_export"))
+            {
+                self.exported_macros.push(new_ident.to_string());
+            }
         }
         visit::visit_item_macro(self, node);
     }
 
     fn visit_macro(&mut self, mac: &'ast syn::Macro) {
+        if let Some(file) = included_file(mac) {
+            self.included_files.push(file);
+        }
+        if let Some(name) = mac.path.get_ident()
+            && name != "macro_rules"
+        {
+            self.bare_macros.push(name.to_string());
+        }
+        if mac.path.segments.len() > 1 {
+            let segments: Vec<String> = mac
+                .path
+                .segments
+                .iter()
+                .map(|segment| segment.ident.to_string())
+                .collect();
+            self.path_macros.push(segments.join("::"));
+        }
+        if let Some(directory) = embedded_migrations(mac) {
+            self.embedded_migrations.push(directory);
+        }
+        if let Some(variable) = environment_variable(mac) {
+            self.environment_variables.push(variable);
+        }
+        let mut body_visitor = MacroBodyVisitor::default();
+        body_visitor.visit_macro_body(mac);
+        for import in body_visitor.imports {
+            self.add_import(import);
+        }
+        self.environment_variables
+            .extend(body_visitor.environment_variables);
+        visit::visit_macro(self, mac);
+    }
+}
+
+/// Collects the crates referenced by paths in macro bodies, which syn keeps as
+/// unparsed tokens, along with the variables of nested `env!`s, as in
+/// `println!("{}", env!("CARGO_PKG_VERSION"))`. The parsed bodies don't
+/// outlive the macro, so the idents are owned.
+#[derive(Default)]
+struct MacroBodyVisitor {
+    imports: Vec<syn::Ident>,
+    environment_variables: Vec<String>,
+}
+
+impl MacroBodyVisitor {
+    fn visit_macro_body(&mut self, mac: &syn::Macro) {
         // Try to parse macro body as comma-separated expressions. This handles
         // common macros like format!, println!, vec!, assert!, etc.
         if let Ok(args) =
             mac.parse_body_with(Punctuated::<syn::Expr, syn::Token![,]>::parse_terminated)
         {
             for expression in &args {
-                self.extract_paths_from_expression(expression);
+                self.visit_expr(expression);
             }
+        } else {
+            // The macro has custom syntax, such as lazy_static!'s `static ref`.
+            let buffer = TokenBuffer::new2(mac.tokens.clone());
+            self.scan_path_roots(buffer.begin());
+        }
+    }
+
+    /// Collect the first segment of each `a::b` or `::a::b` path in unparsed
+    /// tokens.
+    fn scan_path_roots(&mut self, mut cursor: Cursor) {
+        let mut after_ident = false;
+        let mut continues_path = false;
+        while !cursor.eof() {
+            if let Some((inside, _, _, next)) = cursor.any_group() {
+                self.scan_path_roots(inside);
+                cursor = next;
+                (after_ident, continues_path) = (false, false);
+            } else if let Some((ident, next)) = cursor.ident() {
+                if !continues_path && path_separator(next).is_some() {
+                    self.imports.push(ident);
+                }
+                cursor = next;
+                (after_ident, continues_path) = (true, false);
+            } else if let Some(next) = path_separator(cursor) {
+                cursor = next;
+                (after_ident, continues_path) = (false, after_ident);
+            } else if let Some((_, next)) = cursor.token_tree() {
+                cursor = next;
+                (after_ident, continues_path) = (false, false);
+            }
+        }
+    }
+}
+
+/// The cursor after a `::` at the cursor.
+fn path_separator(cursor: Cursor) -> Option<Cursor> {
+    let (first, next) = cursor.punct()?;
+    let (second, next) = next.punct()?;
+    (first.as_char() == ':' && second.as_char() == ':').then_some(next)
+}
+
+impl Visit<'_> for MacroBodyVisitor {
+    fn visit_path(&mut self, node: &syn::Path) {
+        if node.segments.len() > 1 {
+            self.imports.push(node.segments[0].ident.clone());
+        }
+        visit::visit_path(self, node);
+    }
+
+    fn visit_macro(&mut self, mac: &syn::Macro) {
+        if let Some(variable) = environment_variable(mac) {
+            self.environment_variables.push(variable);
         }
-        // If parsing fails, the macro has custom syntax; skip it gracefully.
+        self.visit_macro_body(mac);
         visit::visit_macro(self, mac);
     }
 }
@@ -1,4 +1,6 @@
-use tools__gazelle_rust__rust_parser::parser::parse_source;
+use tools__gazelle_rust__rust_parser::parser::{
+    CfgPredicate, ImportReference, PublicItem, SourceLocation, SyntaxError, parse_source,
+};
 
 #[test]
 fn test_simple_import() {
@@ -176,3 +178,714 @@ fn test_qualified_call_in_format_macro() {
     assert!(result.external_modules.is_empty());
     assert!(result.has_main);
 }
+
+#[test]
+fn test_cfg_attr_feature_gate() {
+    let code = r#"
+        #![cfg_attr(feature = "nightly", feature(test, portable_simd))]
+
+        pub fn add(a: u32, b: u32) -> u32 {
+            a + b
+        }
+    "#;
+    let result = parse_source(code).unwrap();
+    assert!(result.imports.is_empty());
+    assert_eq!(result.conditional_attributes.len(), 1);
+    let attribute = &result.conditional_attributes[0];
+    assert_eq!(attribute.predicate, r#"feature = "nightly""#);
+    assert_eq!(attribute.attributes, vec!["feature(test, portable_simd)"]);
+    assert!(attribute.imports.is_empty());
+}
+
+#[test]
+fn test_cfg_attr_derives() {
+    let code = r#"
+        #[cfg_attr(
+            all(feature = "serde", not(test)),
+            derive(serde::Serialize),
+            derive(schemars::JsonSchema)
+        )]
+        pub struct Config {
+            pub name: String,
+        }
+    "#;
+    let result = parse_source(code).unwrap();
+    assert_eq!(result.imports, vec!["serde", "schemars"]);
+    assert_eq!(result.conditional_attributes.len(), 1);
+    let attribute = &result.conditional_attributes[0];
+    assert_eq!(attribute.predicate, r#"all(feature = "serde", not(test))"#);
+    assert_eq!(
+        attribute.attributes,
+        vec!["derive(serde::Serialize)", "derive(schemars::JsonSchema)"]
+    );
+    assert_eq!(attribute.imports, vec!["schemars", "serde"]);
+}
+
+#[test]
+fn test_nested_cfg_attr() {
+    let code = r"
+        #[cfg_attr(unix, cfg_attr(test, derive(serde::Serialize)))]
+        pub struct Config;
+    ";
+    let result = parse_source(code).unwrap();
+    assert_eq!(result.imports, vec!["serde"]);
+    assert_eq!(result.conditional_attributes.len(), 1);
+    let attribute = &result.conditional_attributes[0];
+    assert_eq!(attribute.predicate, "all(unix, test)");
+    assert_eq!(attribute.imports, vec!["serde"]);
+}
+
+#[test]
+fn test_exports_c_symbols() {
+    let code = r#"
+        #[unsafe(no_mangle)]
+        pub extern "C" fn engine_tick(state: *mut u8) {}
+    "#;
+    let result = parse_source(code).unwrap();
+    assert!(result.exports_c_symbols);
+
+    let code = r#"
+        #[export_name = "engine_reset"]
+        pub extern "C" fn reset() {}
+    "#;
+    assert!(parse_source(code).unwrap().exports_c_symbols);
+}
+
+#[test]
+fn test_rust_abi_functions_export_no_c_symbols() {
+    let code = r#"
+        #[no_mangle]
+        pub fn tick() {}
+
+        pub extern "C" fn callback() {}
+    "#;
+    let result = parse_source(code).unwrap();
+    assert!(!result.exports_c_symbols);
+}
+
+#[test]
+fn test_counts_tests() {
+    let code = r"
+        #[test]
+        fn parses() {}
+
+        #[tokio::test]
+        async fn serves() {}
+
+        mod nested {
+            #[test]
+            fn nests() {}
+        }
+
+        fn helper() {}
+    ";
+    let result = parse_source(code).unwrap();
+    assert_eq!(result.test_count, 3);
+}
+
+#[test]
+fn test_counts_benches() {
+    let code = r"
+        #![feature(test)]
+        extern crate test;
+
+        #[bench]
+        fn encodes(bencher: &mut test::Bencher) {
+            bencher.iter(|| 1 + 1);
+        }
+
+        #[test]
+        fn decodes() {}
+    ";
+    let result = parse_source(code).unwrap();
+    assert_eq!(result.bench_count, 1);
+    assert_eq!(result.test_count, 1);
+    assert_eq!(result.nightly_features, vec!["test"]);
+}
+
+#[test]
+fn test_counts_ignored_tests() {
+    let code = r#"
+        #[test]
+        #[ignore]
+        fn slow() {}
+
+        #[test]
+        #[ignore = "needs a database"]
+        fn queries() {}
+
+        #[test]
+        fn runs() {}
+
+        #[ignore]
+        fn not_a_test() {}
+    "#;
+    let result = parse_source(code).unwrap();
+    assert_eq!(result.test_count, 3);
+    assert_eq!(result.ignored_test_count, 2);
+}
+
+#[test]
+fn test_nightly_features() {
+    let code = r"
+        #![feature(never_type, let_chains)]
+        #![feature(core_intrinsics)]
+        #![allow(dead_code)]
+
+        mod inner {
+            #![feature(ignored_outside_crate_root)]
+        }
+    ";
+    let result = parse_source(code).unwrap();
+    assert_eq!(
+        result.nightly_features,
+        vec!["never_type", "let_chains", "core_intrinsics"]
+    );
+}
+
+#[test]
+fn test_macro_body_paths() {
+    let code = r#"
+        fn run() {
+            assert!(matches!(parse(), Ok(nested::Value)));
+            let values = vec![|value: closure::Argument| value, typed::identity];
+            println!("{}", it.collect::<ordered::Map<_, _>>().len());
+        }
+    "#;
+    let result = parse_source(code).unwrap();
+    assert_eq!(
+        result.imports,
+        vec!["nested", "closure", "typed", "ordered"]
+    );
+}
+
+#[test]
+fn test_custom_syntax_macro_paths() {
+    let code = r#"
+        lazy_static! {
+            static ref PATTERN: regex::Regex = regex::Regex::new("[a-z]+").unwrap();
+            static ref CLIENT: (http::Client, ::absolute::Path) = build();
+        }
+    "#;
+    let result = parse_source(code).unwrap();
+    assert_eq!(result.imports, vec!["regex", "regex", "http", "absolute"]);
+}
+
+#[test]
+fn test_bare_macros() {
+    let code = r#"
+        use tracing::info;
+        use anyhow::bail as fail;
+
+        macro_rules! local {
+            () => {};
+        }
+
+        lazy_static! {}
+
+        fn run() {
+            info!("started");
+            fail!("stopped");
+            local!();
+            serde_json::json!({});
+            bitflags! {}
+            lazy_static! {}
+        }
+    "#;
+    let result = parse_source(code).unwrap();
+    assert_eq!(result.bare_macros, vec!["bitflags", "lazy_static"]);
+}
+
+#[test]
+fn test_bare_derives() {
+    let code = r"
+        use clap::Parser;
+
+        #[derive(Debug, Clone, Serialize, Deserialize, serde::Serialize)]
+        struct Config;
+
+        #[derive(Parser, Error)]
+        struct Arguments;
+    ";
+    let result = parse_source(code).unwrap();
+    assert_eq!(
+        result.bare_derives,
+        vec!["Deserialize", "Error", "Serialize"]
+    );
+}
+
+#[test]
+fn test_included_files() {
+    let code = r#"
+        #![doc = include_str!("../README.md")]
+        #![cfg_attr(docsrs, doc = include_str!("docs/features.md"))]
+
+        static FIXTURE: &[u8] = include_bytes!("testdata/fixture.bin");
+        const TEMPLATE: &str = include_str!(concat!(env!("OUT_DIR"), "/template"));
+    "#;
+    let result = parse_source(code).unwrap();
+    assert_eq!(
+        result.included_files,
+        vec!["../README.md", "docs/features.md", "testdata/fixture.bin"]
+    );
+}
+
+#[test]
+fn test_link_names() {
+    let code = r#"
+        #[link(name = "z")]
+        unsafe extern "C" {
+            fn inflate();
+        }
+
+        #[link(name = "ssl", kind = "static")]
+        #[link(name = "z")]
+        extern "C" {}
+
+        #[cfg(unix)]
+        extern "C" {}
+    "#;
+    let result = parse_source(code).unwrap();
+    assert_eq!(result.link_names, vec!["z", "ssl"]);
+}
+
+#[test]
+fn test_attribute_macros() {
+    let code = r#"
+        use test_case::test_case;
+
+        #[tokio::main]
+        async fn main() {}
+
+        #[tokio::test(flavor = "multi_thread")]
+        async fn serves() {}
+
+        #[test_case(1 ; "one")]
+        #[rstest]
+        #[cfg_attr(test, automock)]
+        #[inline]
+        fn cases(value: u32) {}
+    "#;
+    let mut result = parse_source(code).unwrap();
+    result.imports.sort();
+    result.imports.dedup();
+    assert_eq!(result.imports, vec!["test_case", "tokio"]);
+    assert_eq!(result.bare_macros, vec!["automock", "rstest"]);
+}
+
+#[test]
+fn test_empty_files() {
+    let empty = [
+        "// Copyright 2026 The Authors.\n",
+        "#![allow(dead_code)]\n//! Placeholder.\n",
+        "#[cfg(any())]\nfn disabled() {}\n",
+        "#![cfg(any())]\nfn disabled() {}\n",
+    ];
+    for code in empty {
+        assert!(parse_source(code).unwrap().is_empty, "{code}");
+    }
+
+    let non_empty = ["#[cfg(windows)]\nfn windows() {}\n", "mod helpers;\n"];
+    for code in non_empty {
+        assert!(!parse_source(code).unwrap().is_empty, "{code}");
+    }
+}
+
+#[test]
+fn test_conditional_imports() {
+    let code = r#"
+        use serde::Serialize;
+
+        #[cfg(unix)]
+        use nix::unistd;
+
+        #[cfg(windows)]
+        fn console() -> windows_sys::Console {}
+
+        #[cfg(all(feature = "tls", not(target_os = "ios")))]
+        fn connect() -> rustls::Config {}
+
+        #[cfg(feature = "tls")]
+        fn accept() -> rustls::Config {}
+
+        #[cfg(test)]
+        mod tests {
+            #[cfg(feature = "slow")]
+            fn uses_serde() -> serde::Value {}
+            fn temporary() -> tempfile::TempDir {}
+        }
+    "#;
+    let result = parse_source(code).unwrap();
+    let option = |name: &str, value: Option<&str>| CfgPredicate::Option {
+        name: name.to_string(),
+        value: value.map(str::to_string),
+    };
+    let conditional_imports: Vec<_> = result
+        .conditional_imports
+        .into_iter()
+        .map(|import| (import.name, import.predicate))
+        .collect();
+    assert_eq!(
+        conditional_imports,
+        vec![
+            ("nix".to_string(), option("unix", None)),
+            ("windows_sys".to_string(), option("windows", None)),
+            (
+                "rustls".to_string(),
+                CfgPredicate::Any(vec![
+                    CfgPredicate::All(vec![
+                        option("feature", Some("tls")),
+                        CfgPredicate::Not(Box::new(option("target_os", Some("ios")))),
+                    ]),
+                    option("feature", Some("tls")),
+                ]),
+            ),
+            ("tempfile".to_string(), option("test", None)),
+        ]
+    );
+}
+
+#[test]
+fn test_import_provenances() {
+    let code = r#"
+        #[macro_use]
+        extern crate log;
+        use serde::Serialize;
+
+        #[derive(Serialize, thiserror::Error)]
+        struct Failure;
+
+        #[tokio::main]
+        async fn main() {
+            serde::de::value::Error::custom("");
+            let _ = anyhow::anyhow!("failed");
+        }
+    "#;
+    let result = parse_source(code).unwrap();
+    let provenances: Vec<_> = result
+        .import_provenances
+        .into_iter()
+        .map(|provenance| (provenance.name, provenance.references))
+        .collect();
+    assert_eq!(
+        provenances,
+        vec![
+            ("log".to_string(), vec![ImportReference::ExternCrate]),
+            (
+                "serde".to_string(),
+                vec![ImportReference::Use, ImportReference::Path]
+            ),
+            ("thiserror".to_string(), vec![ImportReference::Attribute]),
+            ("tokio".to_string(), vec![ImportReference::Attribute]),
+            ("anyhow".to_string(), vec![ImportReference::Path]),
+        ]
+    );
+}
+
+#[test]
+fn test_exported_macros() {
+    let code = r"
+        #[macro_export]
+        macro_rules! retry {
+            ($body:expr) => { $body };
+        }
+
+        macro_rules! local {
+            () => {};
+        }
+
+        mod inner {
+            #[macro_export]
+            macro_rules! ensure_ok {
+                ($body:expr) => { $body.unwrap() };
+            }
+        }
+    ";
+    let result = parse_source(code).unwrap();
+    assert_eq!(result.exported_macros, vec!["retry", "ensure_ok"]);
+}
+
+#[test]
+fn test_source_locations() {
+    let code = "fn run() {\n    let value = serde_json::from_str(\"1\");\n}\n\nuse tokio::runtime::Runtime;\nfn again() -> serde_json::Value {}\n";
+    let result = parse_source(code).unwrap();
+    let locations: Vec<_> = result
+        .import_provenances
+        .into_iter()
+        .map(|provenance| (provenance.name, provenance.location))
+        .collect();
+    assert_eq!(
+        locations,
+        vec![
+            (
+                "serde_json".to_string(),
+                Some(SourceLocation {
+                    line: 2,
+                    column: 17
+                })
+            ),
+            (
+                "tokio".to_string(),
+                Some(SourceLocation { line: 5, column: 5 })
+            ),
+        ]
+    );
+}
+
+#[test]
+fn test_syntax_error_location() {
+    let Err(err) = parse_source("fn main() {\n    let = 1;\n}\n") else {
+        panic!("expected a syntax error");
+    };
+    let err = err.downcast_ref::<SyntaxError>().unwrap();
+    assert_eq!(err.location, SourceLocation { line: 2, column: 9 });
+}
+
+#[test]
+fn test_path_macros() {
+    let code = r#"
+        async fn load(pool: &sqlx::PgPool) -> Vec<User> {
+            let users = sqlx::query_as!(User, "select id from users")
+                .fetch_all(pool)
+                .await
+                .unwrap();
+            println!("{}", users.len());
+            let count = ::sqlx::query_scalar!("select count(*) from users");
+            users
+        }
+    "#;
+    let result = parse_source(code).unwrap();
+    assert_eq!(result.path_macros, vec!["sqlx::query_as", "sqlx::query_scalar"]);
+}
+
+#[test]
+fn test_embedded_migrations() {
+    let code = r#"
+        use diesel_migrations::{embed_migrations, EmbeddedMigrations};
+
+        pub const MIGRATIONS: EmbeddedMigrations = embed_migrations!();
+        pub const SEEDS: EmbeddedMigrations = diesel_migrations::embed_migrations!("../seeds");
+
+        diesel::table! {
+            users (id) {
+                id -> Integer,
+            }
+        }
+    "#;
+    let result = parse_source(code).unwrap();
+    assert_eq!(result.embedded_migrations, vec!["", "../seeds"]);
+    assert_eq!(
+        result.path_macros,
+        vec!["diesel::table", "diesel_migrations::embed_migrations"]
+    );
+}
+
+#[test]
+fn test_environment_variables() {
+    let code = r#"
+        pub const VERSION: &str = env!("CARGO_PKG_VERSION");
+
+        pub fn describe() -> String {
+            format!(
+                "{} {}",
+                env!("CARGO_PKG_NAME", "built by cargo"),
+                option_env!("CARGO_PKG_DESCRIPTION").unwrap_or_default(),
+            )
+        }
+
+        const TEMPLATE: &str = include_str!(concat!(env!("OUT_DIR"), "/template"));
+    "#;
+    let result = parse_source(code).unwrap();
+    assert_eq!(
+        result.environment_variables,
+        vec![
+            "CARGO_PKG_DESCRIPTION",
+            "CARGO_PKG_NAME",
+            "CARGO_PKG_VERSION",
+            "OUT_DIR"
+        ]
+    );
+}
+
+#[test]
+fn test_embedded_entry_point() {
+    let code = r#"
+        #![no_std]
+        #![cfg_attr(not(test), no_main)]
+
+        use cortex_m_rt::entry;
+
+        #[global_allocator]
+        static HEAP: Heap = Heap::empty();
+
+        #[entry]
+        fn start() -> ! {
+            loop {}
+        }
+    "#;
+    let result = parse_source(code).unwrap();
+    assert!(result.no_main);
+    assert!(result.no_std);
+    assert!(result.has_global_allocator);
+    assert!(result.has_embedded_entry);
+    assert!(!result.has_main);
+}
+
+#[test]
+fn test_host_binary_is_not_embedded() {
+    let code = r#"
+        #![cfg_attr(feature = "no_main_marker", allow(unused))]
+
+        mod inner {
+            #[entry]
+            fn start() {}
+        }
+
+        fn main() {}
+    "#;
+    let result = parse_source(code).unwrap();
+    assert!(!result.no_main);
+    assert!(!result.no_std);
+    assert!(!result.has_global_allocator);
+    assert!(!result.has_embedded_entry);
+}
+
+#[test]
+fn test_file_cfg() {
+    let code = r#"
+        #![cfg(target_os = "linux")]
+        #![cfg(not(target_arch = "wasm32"))]
+        #![cfg_attr(test, allow(unused))]
+
+        use nix::unistd;
+    "#;
+    let result = parse_source(code).unwrap();
+    let option = |name: &str, value: Option<&str>| CfgPredicate::Option {
+        name: name.to_string(),
+        value: value.map(str::to_string),
+    };
+    assert_eq!(
+        result.file_cfg,
+        Some(CfgPredicate::All(vec![
+            option("target_os", Some("linux")),
+            CfgPredicate::Not(Box::new(option("target_arch", Some("wasm32")))),
+        ]))
+    );
+}
+
+#[test]
+fn test_no_file_cfg() {
+    let code = r#"
+        #[cfg(unix)]
+        mod unix;
+    "#;
+    let result = parse_source(code).unwrap();
+    assert_eq!(result.file_cfg, None);
+}
+
+#[test]
+fn test_contains_unsafe() {
+    for code in [
+        "fn read(pointer: *const u8) -> u8 { unsafe { *pointer } }",
+        "unsafe fn read(pointer: *const u8) -> u8 { *pointer }",
+        "struct Handle; unsafe impl Send for Handle {}",
+        "unsafe trait Zeroable {}",
+        "impl Buffer { pub unsafe fn set_len(&mut self, len: usize) {} }",
+    ] {
+        assert!(parse_source(code).unwrap().contains_unsafe, "{code}");
+    }
+    let code = r#"
+        #![forbid(unsafe_code)]
+
+        fn safe() -> &'static str {
+            "unsafe { }"
+        }
+    "#;
+    assert!(!parse_source(code).unwrap().contains_unsafe);
+}
+
+#[test]
+fn test_public_items() {
+    let code = r#"
+        use std::fmt;
+
+        pub use inner::Handle;
+
+        /// Connection settings.
+        #[derive(Debug)]
+        pub struct Config<T>
+        where
+            T: Clone,
+        {
+            pub name: String,
+            retries: u32,
+        }
+
+        pub enum Mode {
+            Fast,
+            Careful { attempts: u32 },
+        }
+
+        pub trait Handler: Send {
+            fn handle(
+                &self,
+                request: &str,
+            ) -> Result<(), String>;
+        }
+
+        pub const MAX_RETRIES: u32 = 3;
+
+        impl<T: Clone> Config<T> {
+            pub fn new(name: &str) -> Self {
+                todo!()
+            }
+
+            fn validate(&self) {}
+        }
+
+        pub async fn connect(address: &str) {}
+
+        fn helper() {}
+
+        pub(crate) fn internal() {}
+
+        pub mod net;
+
+        mod inner {
+            pub struct Handle;
+        }
+
+        pub mod codec {
+            pub fn encode(bytes: &[u8]) -> Vec<u8> {
+                bytes.to_vec()
+            }
+        }
+    "#;
+    let result = parse_source(code).unwrap();
+    let item = |path: &str, declaration: &str| PublicItem {
+        path: path.to_string(),
+        declaration: declaration.to_string(),
+    };
+    assert_eq!(
+        result.public_items,
+        vec![
+            item("inner::Handle", "use inner::Handle"),
+            item("Config", "struct Config<T> where T: Clone"),
+            item("Config::name", "String"),
+            item("Mode", "enum Mode"),
+            item("Mode::Fast", "Fast"),
+            item("Mode::Careful", "Careful { attempts: u32 }"),
+            item("Handler", "trait Handler: Send"),
+            item(
+                "Handler::handle",
+                "fn handle(&self, request: &str) -> Result<(), String>"
+            ),
+            item("MAX_RETRIES", "const MAX_RETRIES: u32"),
+            item("Config::new", "fn new(name: &str) -> Self"),
+            item("connect", "async fn connect(address: &str)"),
+            item("net", "mod net"),
+            item("codec", "mod codec"),
+            item("codec::encode", "fn encode(bytes: &[u8]) -> Vec<u8>"),
+        ]
+    );
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+)
+
+const (
+	generatedComment = "# Generated by //tools/gazelle_rust/vendor_crates. Do not edit."
+	buildScriptName  = "build_script"
+	buildFileName    = "BUILD.bazel"
+)
+
+// Vendored sources aren't ours to fix.
+var vendoredRustcFlags = []string{"--cap-lints=allow"}
+
+// Write the BUILD file of a crate's directory, with its library and build
+// script.
+func writeCrateBuildFile(vendorDirectory string, vendored *vendoredCrates, resolved *resolvedFeatures, crate *vendoredCrate) error {
+	pkg := path.Join(vendored.pkg, crate.directory)
+	f := rule.EmptyFile(filepath.Join(vendorDirectory, crate.directory, buildFileName), pkg)
+
+	deps, procMacroDeps := crateDependencies(vendored, resolved, crate, "dependencies")
+	features := resolved.features(crate)
+
+	libraryKind := "rust_library"
+	if crate.procMacro {
+		libraryKind = "rust_proc_macro"
+	}
+	library := rule.NewRule(libraryKind, crate.name)
+	library.SetAttr("srcs", rule.GlobValue{Patterns: []string{"**/*.rs"}})
+	library.SetAttr("compile_data", rule.GlobValue{Patterns: []string{"**"}, Excludes: []string{"**/*.rs", buildFileName}})
+	library.SetAttr("crate_root", crate.libraryRoot)
+	if crate.libraryName != "" {
+		library.SetAttr("crate_name", crate.libraryName)
+	}
+	library.SetAttr("edition", crate.edition)
+	library.SetAttr("version", crate.version)
+	if len(features) > 0 {
+		library.SetAttr("crate_features", features)
+	}
+	library.SetAttr("rustc_flags", vendoredRustcFlags)
+	library.SetAttr("visibility", []string{"//visibility:public"})
+
+	kinds := []string{libraryKind}
+	if crate.buildScript != "" {
+		kinds = append(kinds, "cargo_build_script")
+		deps = append([]string{":" + buildScriptName}, deps...)
+		buildDeps, buildProcMacroDeps := crateDependencies(vendored, resolved, crate, "build-dependencies")
+
+		buildScript := rule.NewRule("cargo_build_script", buildScriptName)
+		buildScript.SetAttr("srcs", rule.GlobValue{Patterns: []string{"**/*.rs"}})
+		buildScript.SetAttr("data", rule.GlobValue{Patterns: []string{"**"}, Excludes: []string{buildFileName}})
+		buildScript.SetAttr("crate_root", crate.buildScript)
+		buildScript.SetAttr("crate_name", "build_script_build")
+		buildScript.SetAttr("edition", crate.edition)
+		buildScript.SetAttr("version", crate.version)
+		if len(features) > 0 {
+			buildScript.SetAttr("crate_features", features)
+		}
+		if crate.links != "" {
+			buildScript.SetAttr("links", crate.links)
+		}
+		buildScript.SetAttr("rustc_flags", vendoredRustcFlags)
+		if len(buildDeps) > 0 {
+			buildScript.SetAttr("deps", buildDeps)
+		}
+		if len(buildProcMacroDeps) > 0 {
+			buildScript.SetAttr("proc_macro_deps", buildProcMacroDeps)
+		}
+		buildScript.Insert(f)
+	}
+	if len(deps) > 0 {
+		library.SetAttr("deps", deps)
+	}
+	if len(procMacroDeps) > 0 {
+		library.SetAttr("proc_macro_deps", procMacroDeps)
+	}
+	library.Insert(f)
+
+	for _, table := range crate.targetTables {
+		log.Printf("%s: ignoring [%s]; platform-specific dependencies aren't supported", crate, table)
+	}
+	return writeBuildFile(f, kinds)
+}
+
+// Return the labels of the enabled dependencies in one of a crate's
+// dependency tables, separating proc macros.
+func crateDependencies(vendored *vendoredCrates, resolved *resolvedFeatures, crate *vendoredCrate, table string) (deps, procMacroDeps []string) {
+	for _, dependency := range crate.dependencies {
+		if dependency.table != table || !resolved.isEnabled(crate, dependency) {
+			continue
+		}
+		target := vendored.find(dependency)
+		if target == nil {
+			log.Printf("%s: no vendored version of %s satisfies %q", crate, dependency.packageName, dependency.requirement)
+			continue
+		}
+		dep := label.New("", path.Join(vendored.pkg, target.directory), target.name).String()
+		if target.procMacro {
+			procMacroDeps = append(procMacroDeps, dep)
+		} else {
+			deps = append(deps, dep)
+		}
+	}
+	return deps, procMacroDeps
+}
+
+// Write the vendor directory's BUILD file, aliasing each crate's name to its
+// newest version so the directory can serve as -rust_crates_prefix.
+func writeAliasBuildFile(vendorDirectory string, vendored *vendoredCrates) error {
+	f := rule.EmptyFile(filepath.Join(vendorDirectory, buildFileName), vendored.pkg)
+	for _, crate := range vendored.crates {
+		versions := vendored.cratesByName[crate.name]
+		if versions[len(versions)-1] != crate {
+			continue
+		}
+		alias := rule.NewRule("alias", crate.name)
+		alias.SetAttr("actual", label.New("", path.Join(vendored.pkg, crate.directory), crate.name).String())
+		alias.SetAttr("visibility", []string{"//visibility:public"})
+		alias.Insert(f)
+	}
+	// The vendored BUILD files are generated here, not by gazelle.
+	return writeBuildFile(f, nil, "# gazelle:rust_extension disabled")
+}
+
+func writeBuildFile(f *rule.File, kinds []string, directives ...string) error {
+	loadByName := make(map[string]*rule.Load)
+	for _, kind := range kinds {
+		loadName := "@rules_rust//rust:defs.bzl"
+		if kind == "cargo_build_script" {
+			loadName = "@rules_rust//cargo:defs.bzl"
+		}
+		if loadByName[loadName] == nil {
+			loadByName[loadName] = rule.NewLoad(loadName)
+		}
+		loadByName[loadName].Add(kind)
+	}
+	for i, loadName := range slices.Sorted(maps.Keys(loadByName)) {
+		loadByName[loadName].Insert(f, i)
+	}
+	header := append([]string{generatedComment}, directives...)
+	content := strings.Join(header, "\n") + "\n\n" + string(f.Format())
+	return os.WriteFile(f.Path, []byte(content), 0o644)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteBuildFiles(t *testing.T) {
+	vendorDirectory, vendored := readTestCrates(t, map[string]string{
+		"app-0.3.0": `
+[package]
+name = "app"
+version = "0.3.0"
+edition = "2021"
+build = "build/main.rs"
+links = "app"
+
+[lib]
+name = "application"
+path = "lib.rs"
+
+[dependencies]
+macros = "1"
+old = "0.1"
+optional-dep = { version = "1", optional = true }
+
+[build-dependencies]
+cc = "1.0"
+
+[features]
+default = ["fast"]
+fast = []
+`,
+		"cc-1.0.0": `
+[package]
+name = "cc"
+version = "1.0.0"
+`,
+		"macros-1.0.0": `
+[package]
+name = "macros"
+version = "1.0.0"
+edition = "2018"
+
+[lib]
+proc-macro = true
+`,
+		"old-0.1.0": `
+[package]
+name = "old"
+version = "0.1.0"
+`,
+		"old-0.2.0": `
+[package]
+name = "old"
+version = "0.2.0"
+`,
+		"optional-dep-1.0.0": `
+[package]
+name = "optional-dep"
+version = "1.0.0"
+`,
+	})
+	resolved := resolveFeatures(vendored)
+	for _, crate := range vendored.crates {
+		if err := writeCrateBuildFile(vendorDirectory, vendored, resolved, crate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeAliasBuildFile(vendorDirectory, vendored); err != nil {
+		t.Fatal(err)
+	}
+
+	contentByFile := map[string]string{
+		"BUILD.bazel": `# Generated by //tools/gazelle_rust/vendor_crates. Do not edit.
+# gazelle:rust_extension disabled
+
+alias(
+    name = "app",
+    actual = "//third_party/rust/app-0.3.0:app",
+    visibility = ["//visibility:public"],
+)
+
+alias(
+    name = "cc",
+    actual = "//third_party/rust/cc-1.0.0:cc",
+    visibility = ["//visibility:public"],
+)
+
+alias(
+    name = "macros",
+    actual = "//third_party/rust/macros-1.0.0:macros",
+    visibility = ["//visibility:public"],
+)
+
+alias(
+    name = "old",
+    actual = "//third_party/rust/old-0.2.0:old",
+    visibility = ["//visibility:public"],
+)
+
+alias(
+    name = "optional-dep",
+    actual = "//third_party/rust/optional-dep-1.0.0:optional-dep",
+    visibility = ["//visibility:public"],
+)
+`,
+		"app-0.3.0/BUILD.bazel": `# Generated by //tools/gazelle_rust/vendor_crates. Do not edit.
+
+load("@rules_rust//cargo:defs.bzl", "cargo_build_script")
+load("@rules_rust//rust:defs.bzl", "rust_library")
+
+cargo_build_script(
+    name = "build_script",
+    srcs = glob(["**/*.rs"]),
+    crate_features = [
+        "default",
+        "fast",
+    ],
+    crate_name = "build_script_build",
+    crate_root = "build/main.rs",
+    data = glob(
+        ["**"],
+        exclude = ["BUILD.bazel"],
+    ),
+    edition = "2021",
+    links = "app",
+    rustc_flags = ["--cap-lints=allow"],
+    version = "0.3.0",
+    deps = ["//third_party/rust/cc-1.0.0:cc"],
+)
+
+rust_library(
+    name = "app",
+    srcs = glob(["**/*.rs"]),
+    compile_data = glob(
+        ["**"],
+        exclude = [
+            "**/*.rs",
+            "BUILD.bazel",
+        ],
+    ),
+    crate_features = [
+        "default",
+        "fast",
+    ],
+    crate_name = "application",
+    crate_root = "lib.rs",
+    edition = "2021",
+    proc_macro_deps = ["//third_party/rust/macros-1.0.0:macros"],
+    rustc_flags = ["--cap-lints=allow"],
+    version = "0.3.0",
+    visibility = ["//visibility:public"],
+    deps = [
+        ":build_script",
+        "//third_party/rust/old-0.1.0:old",
+    ],
+)
+`,
+		"macros-1.0.0/BUILD.bazel": `# Generated by //tools/gazelle_rust/vendor_crates. Do not edit.
+
+load("@rules_rust//rust:defs.bzl", "rust_proc_macro")
+
+rust_proc_macro(
+    name = "macros",
+    srcs = glob(["**/*.rs"]),
+    compile_data = glob(
+        ["**"],
+        exclude = [
+            "**/*.rs",
+            "BUILD.bazel",
+        ],
+    ),
+    crate_root = "src/lib.rs",
+    edition = "2018",
+    rustc_flags = ["--cap-lints=allow"],
+    version = "1.0.0",
+    visibility = ["//visibility:public"],
+)
+`,
+	}
+	for file, want := range contentByFile {
+		got, err := os.ReadFile(filepath.Join(vendorDirectory, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s:\n%s\nwant:\n%s", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+// Cargo unifies the features every dependent requests of a crate. Without the
+// dependents outside the vendor directory to start from, every vendored crate
+// is treated as depended on with its default features, and the features
+// vendored crates enable on each other are unified on top.
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type vendoredCrates struct {
+	// The vendor directory's package.
+	pkg string
+	// Sorted by directory.
+	crates []*vendoredCrate
+	// Oldest first.
+	cratesByName map[string][]*vendoredCrate
+}
+
+func newVendoredCrates(pkg string, crates []*vendoredCrate) *vendoredCrates {
+	vendored := &vendoredCrates{pkg: pkg, crates: crates, cratesByName: make(map[string][]*vendoredCrate)}
+	for _, crate := range crates {
+		vendored.cratesByName[crate.name] = append(vendored.cratesByName[crate.name], crate)
+	}
+	for _, versions := range vendored.cratesByName {
+		slices.SortFunc(versions, func(a, b *vendoredCrate) int { return compareVersions(a.version, b.version) })
+	}
+	return vendored
+}
+
+var requirementVersionRegex = regexp.MustCompile(`\d+(\.\d+)*`)
+
+// Return the newest vendored version of the dependency compatible with its
+// requirement, or nil if none is.
+func (vendored *vendoredCrates) find(dependency *crateDependency) *vendoredCrate {
+	versions := vendored.cratesByName[dependency.packageName]
+	required := requirementVersionRegex.FindString(dependency.requirement)
+	for i := len(versions) - 1; i >= 0; i-- {
+		if required == "" || isCompatibleVersion(required, versions[i].version) {
+			return versions[i]
+		}
+	}
+	return nil
+}
+
+// Report whether version is semver compatible with required: no older, and
+// the same up to and including the first nonzero component.
+func isCompatibleVersion(required, version string) bool {
+	if compareVersions(version, required) < 0 {
+		return false
+	}
+	parts := versionParts(version)
+	for i, part := range versionParts(required) {
+		if i >= len(parts) || parts[i] != part {
+			return false
+		}
+		if part != 0 {
+			return true
+		}
+	}
+	return true
+}
+
+func versionParts(version string) []int {
+	version, _, _ = strings.Cut(version, "-")
+	version, _, _ = strings.Cut(version, "+")
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		number, _ := strconv.Atoi(part)
+		parts = append(parts, number)
+	}
+	return parts
+}
+
+func compareVersions(a, b string) int {
+	aParts, bParts := versionParts(a), versionParts(b)
+	for i := range max(len(aParts), len(bParts)) {
+		var aPart, bPart int
+		if i < len(aParts) {
+			aPart = aParts[i]
+		}
+		if i < len(bParts) {
+			bPart = bParts[i]
+		}
+		if aPart != bPart {
+			return aPart - bPart
+		}
+	}
+	return 0
+}
+
+// The features and optional dependencies each crate enables.
+type resolvedFeatures struct {
+	vendored *vendoredCrates
+	// Features in each crate's [features] table that are enabled.
+	featuresByCrate map[*vendoredCrate]map[string]bool
+	// Names of each crate's optional dependencies that are enabled.
+	dependenciesByCrate map[*vendoredCrate]map[string]bool
+	// Features requested with `dependency?/feature`, by crate and then
+	// dependency, waiting for the dependency to be enabled.
+	weakFeaturesByCrate map[*vendoredCrate]map[string][]string
+	visited             map[*vendoredCrate]map[string]bool
+}
+
+func resolveFeatures(vendored *vendoredCrates) *resolvedFeatures {
+	resolved := &resolvedFeatures{
+		vendored:            vendored,
+		featuresByCrate:     make(map[*vendoredCrate]map[string]bool),
+		dependenciesByCrate: make(map[*vendoredCrate]map[string]bool),
+		weakFeaturesByCrate: make(map[*vendoredCrate]map[string][]string),
+		visited:             make(map[*vendoredCrate]map[string]bool),
+	}
+	for _, crate := range vendored.crates {
+		resolved.enableFeature(crate, "default")
+	}
+	return resolved
+}
+
+func (resolved *resolvedFeatures) enableFeature(crate *vendoredCrate, feature string) {
+	if resolved.visited[crate] == nil {
+		resolved.visited[crate] = make(map[string]bool)
+		resolved.featuresByCrate[crate] = make(map[string]bool)
+		resolved.dependenciesByCrate[crate] = make(map[string]bool)
+		resolved.weakFeaturesByCrate[crate] = make(map[string][]string)
+		for _, dependency := range crate.dependencies {
+			if !dependency.optional {
+				resolved.requestDependencyFeatures(dependency)
+			}
+		}
+	}
+	if resolved.visited[crate][feature] {
+		return
+	}
+	resolved.visited[crate][feature] = true
+
+	values, isFeature := crate.enabledByFeature[feature]
+	if !isFeature {
+		// The implicit feature of an optional dependency no `dep:` entry
+		// refers to.
+		if crate.hasImplicitFeature(feature) {
+			resolved.featuresByCrate[crate][feature] = true
+			resolved.enableDependency(crate, feature)
+		}
+		return
+	}
+	resolved.featuresByCrate[crate][feature] = true
+	for _, value := range values {
+		name, dependencyFeature, isDependencyFeature := strings.Cut(value, "/")
+		switch {
+		case strings.HasPrefix(value, "dep:"):
+			resolved.enableDependency(crate, strings.TrimPrefix(value, "dep:"))
+		case isDependencyFeature && strings.HasSuffix(name, "?"):
+			name = strings.TrimSuffix(name, "?")
+			if resolved.dependenciesByCrate[crate][name] || !crate.isOptional(name) {
+				resolved.requestFeatures(crate, name, []string{dependencyFeature})
+			} else {
+				resolved.weakFeaturesByCrate[crate][name] = append(resolved.weakFeaturesByCrate[crate][name], dependencyFeature)
+			}
+		case isDependencyFeature:
+			if crate.hasImplicitFeature(name) {
+				resolved.enableFeature(crate, name)
+			}
+			resolved.enableDependency(crate, name)
+			resolved.requestFeatures(crate, name, []string{dependencyFeature})
+		default:
+			resolved.enableFeature(crate, value)
+		}
+	}
+}
+
+// Report whether every dependency entry of the name is optional.
+func (crate *vendoredCrate) isOptional(name string) bool {
+	return !slices.ContainsFunc(crate.dependencies, func(dependency *crateDependency) bool {
+		return !dependency.optional && dependency.name == name
+	})
+}
+
+// Report whether an optional dependency has a feature of its own name.
+func (crate *vendoredCrate) hasImplicitFeature(name string) bool {
+	optional := slices.ContainsFunc(crate.dependencies, func(dependency *crateDependency) bool {
+		return dependency.optional && dependency.name == name
+	})
+	if !optional {
+		return false
+	}
+	for _, values := range crate.enabledByFeature {
+		if slices.Contains(values, "dep:"+name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Enable an optional dependency of crate.
+func (resolved *resolvedFeatures) enableDependency(crate *vendoredCrate, name string) {
+	if resolved.dependenciesByCrate[crate][name] {
+		return
+	}
+	resolved.dependenciesByCrate[crate][name] = true
+	for _, dependency := range crate.dependencies {
+		if dependency.optional && dependency.name == name {
+			resolved.requestDependencyFeatures(dependency)
+		}
+	}
+	resolved.requestFeatures(crate, name, resolved.weakFeaturesByCrate[crate][name])
+	delete(resolved.weakFeaturesByCrate[crate], name)
+}
+
+// Enable the features a dependency entry asks for.
+func (resolved *resolvedFeatures) requestDependencyFeatures(dependency *crateDependency) {
+	target := resolved.vendored.find(dependency)
+	if target == nil {
+		return
+	}
+	features := slices.Clone(dependency.features)
+	if dependency.defaultFeatures {
+		features = append(features, "default")
+	}
+	for _, feature := range features {
+		resolved.enableFeature(target, feature)
+	}
+}
+
+// Enable features of every vendored crate the named dependency of crate
+// refers to.
+func (resolved *resolvedFeatures) requestFeatures(crate *vendoredCrate, name string, features []string) {
+	for _, dependency := range crate.dependencies {
+		if dependency.name != name {
+			continue
+		}
+		if target := resolved.vendored.find(dependency); target != nil {
+			for _, feature := range features {
+				resolved.enableFeature(target, feature)
+			}
+		}
+	}
+}
+
+// Return the enabled features of a crate, sorted.
+func (resolved *resolvedFeatures) features(crate *vendoredCrate) []string {
+	var features []string
+	for feature := range resolved.featuresByCrate[crate] {
+		features = append(features, feature)
+	}
+	slices.Sort(features)
+	return features
+}
+
+// Report whether a dependency of a crate is compiled in.
+func (resolved *resolvedFeatures) isEnabled(crate *vendoredCrate, dependency *crateDependency) bool {
+	return !dependency.optional || resolved.dependenciesByCrate[crate][dependency.name]
+}
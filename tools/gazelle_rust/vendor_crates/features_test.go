@@ -0,0 +1,234 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Write each Cargo.toml, keyed by crate directory, to a vendor directory, and
+// read the crates back.
+func readTestCrates(t *testing.T, manifestByDirectory map[string]string) (string, *vendoredCrates) {
+	t.Helper()
+	vendorDirectory := t.TempDir()
+	var crates []*vendoredCrate
+	for _, directory := range slices.Sorted(maps.Keys(manifestByDirectory)) {
+		if err := os.MkdirAll(filepath.Join(vendorDirectory, directory), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(vendorDirectory, directory, "Cargo.toml"), []byte(manifestByDirectory[directory]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		crate, err := readVendoredCrate(vendorDirectory, directory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crates = append(crates, crate)
+	}
+	return vendorDirectory, newVendoredCrates("third_party/rust", crates)
+}
+
+func crateByDirectory(vendored *vendoredCrates, directory string) *vendoredCrate {
+	for _, crate := range vendored.crates {
+		if crate.directory == directory {
+			return crate
+		}
+	}
+	return nil
+}
+
+func TestResolveFeatures(t *testing.T) {
+	tests := []struct {
+		name                string
+		manifestByDirectory map[string]string
+		// Enabled features, keyed by crate directory.
+		featuresByDirectory map[string][]string
+	}{
+		{
+			name: "default features",
+			manifestByDirectory: map[string]string{
+				"a-1.0.0": `
+[package]
+name = "a"
+version = "1.0.0"
+
+[features]
+default = ["std"]
+std = []
+serde = []
+`,
+			},
+			featuresByDirectory: map[string][]string{"a-1.0.0": {"default", "std"}},
+		},
+		{
+			name: "dependency features unify",
+			manifestByDirectory: map[string]string{
+				"a-1.0.0": `
+[package]
+name = "a"
+version = "1.0.0"
+
+[dependencies]
+c = { version = "1", default-features = false, features = ["alloc"] }
+`,
+				"b-1.0.0": `
+[package]
+name = "b"
+version = "1.0.0"
+
+[dependencies.c]
+version = "1.2"
+features = ["derive"]
+`,
+				"c-1.2.0": `
+[package]
+name = "c"
+version = "1.2.0"
+
+[features]
+default = ["std"]
+std = ["alloc"]
+alloc = []
+derive = []
+unused = []
+`,
+			},
+			featuresByDirectory: map[string][]string{
+				"a-1.0.0": nil,
+				"b-1.0.0": nil,
+				"c-1.2.0": {"alloc", "default", "derive", "std"},
+			},
+		},
+		{
+			name: "optional dependencies",
+			manifestByDirectory: map[string]string{
+				"a-1.0.0": `
+[package]
+name = "a"
+version = "1.0.0"
+
+[dependencies]
+explicit = { version = "1", optional = true }
+implicit = { version = "1", optional = true }
+weak = { version = "1", optional = true }
+unused = { version = "1", optional = true }
+
+[features]
+default = ["dep:explicit", "implicit/extra", "weak?/extra"]
+`,
+				"explicit-1.0.0": `
+[package]
+name = "explicit"
+version = "1.0.0"
+
+[features]
+default = ["std"]
+std = []
+`,
+				"implicit-1.0.0": `
+[package]
+name = "implicit"
+version = "1.0.0"
+
+[features]
+extra = []
+`,
+				"unused-1.0.0": `
+[package]
+name = "unused"
+version = "1.0.0"
+
+[features]
+default = []
+extra = []
+`,
+				"weak-1.0.0": `
+[package]
+name = "weak"
+version = "1.0.0"
+
+[features]
+extra = []
+`,
+			},
+			featuresByDirectory: map[string][]string{
+				"a-1.0.0":        {"default", "implicit"},
+				"explicit-1.0.0": {"default", "std"},
+				"implicit-1.0.0": {"extra"},
+				"unused-1.0.0":   {"default"},
+				"weak-1.0.0":     nil,
+			},
+		},
+		{
+			name: "compatible versions",
+			manifestByDirectory: map[string]string{
+				"a-1.0.0": `
+[package]
+name = "a"
+version = "1.0.0"
+
+[dependencies]
+old = { version = "0.1", features = ["first"] }
+new = { package = "old", version = "0.2.1", features = ["second"] }
+`,
+				"old-0.1.5": `
+[package]
+name = "old"
+version = "0.1.5"
+
+[features]
+first = []
+second = []
+`,
+				"old-0.2.3": `
+[package]
+name = "old"
+version = "0.2.3"
+
+[features]
+first = []
+second = []
+`,
+			},
+			featuresByDirectory: map[string][]string{
+				"a-1.0.0":   nil,
+				"old-0.1.5": {"first"},
+				"old-0.2.3": {"second"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, vendored := readTestCrates(t, test.manifestByDirectory)
+			resolved := resolveFeatures(vendored)
+			for directory, want := range test.featuresByDirectory {
+				if got := resolved.features(crateByDirectory(vendored, directory)); !slices.Equal(got, want) {
+					t.Errorf("features of %s = %q, want %q", directory, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIsCompatibleVersion(t *testing.T) {
+	tests := []struct {
+		required, version string
+		want              bool
+	}{
+		{"1.2", "1.2.0", true},
+		{"1.2", "1.9.4", true},
+		{"1.2", "1.1.9", false},
+		{"1.2", "2.0.0", false},
+		{"0.2", "0.2.7", true},
+		{"0.2", "0.3.0", false},
+		{"0.0.3", "0.0.4", false},
+		{"1", "1.0.0-rc.1", true},
+	}
+	for _, test := range tests {
+		if got := isCompatibleVersion(test.required, test.version); got != test.want {
+			t.Errorf("isCompatibleVersion(%q, %q) = %t, want %t", test.required, test.version, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Generate BUILD files for vendored crate sources, so a fully vendored
+// repository doesn't need a crate universe.
+//
+// Usage: bazel run //tools/gazelle_rust/vendor_crates -- [-dir third_party/rust]
+//
+// Each subdirectory of the vendor directory with a Cargo.toml, such as one
+// written by `cargo vendor --versioned-dirs`, gets a rust_library, or
+// rust_proc_macro, and a cargo_build_script if the crate has a build script.
+// The vendor directory's BUILD file aliases each crate's name to its newest
+// version, so gazelle resolves imports to vendored crates with
+// `-rust_crates_prefix=//third_party/rust:`. Existing BUILD files in these
+// directories are overwritten.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	vendorDir := flag.String("dir", "third_party/rust", "vendor directory, relative to the repository root")
+	flag.Parse()
+
+	repoRoot := os.Getenv("BUILD_WORKSPACE_DIRECTORY")
+	if repoRoot == "" {
+		var err error
+		repoRoot, err = os.Getwd()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	vendorDirectory := filepath.Join(repoRoot, *vendorDir)
+
+	entries, err := os.ReadDir(vendorDirectory)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// ReadDir sorts by name.
+	var crates []*vendoredCrate
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(vendorDirectory, entry.Name(), "Cargo.toml")); err != nil {
+			continue
+		}
+		crate, err := readVendoredCrate(vendorDirectory, entry.Name())
+		if err != nil {
+			log.Fatal(err)
+		}
+		crates = append(crates, crate)
+	}
+
+	vendored := newVendoredCrates(filepath.ToSlash(filepath.Clean(*vendorDir)), crates)
+	resolved := resolveFeatures(vendored)
+	for _, crate := range crates {
+		if err := writeCrateBuildFile(vendorDirectory, vendored, resolved, crate); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := writeAliasBuildFile(vendorDirectory, vendored); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("generated BUILD files for %d vendored crates", len(crates))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A vendored crate, read from the Cargo.toml in its directory.
+type vendoredCrate struct {
+	// The directory below the vendor directory, such as "serde-1.0.219".
+	directory string
+	name      string
+	version   string
+	edition   string
+	// The library's crate name, when [lib] renames it.
+	libraryName string
+	// Relative to the crate directory.
+	libraryRoot string
+	procMacro   bool
+	// Relative to the crate directory. Empty when the crate has no build
+	// script.
+	buildScript string
+	// The native library the crate declares with `links`.
+	links string
+	// What each feature in [features] enables.
+	enabledByFeature map[string][]string
+	dependencies     []*crateDependency
+	// Tables under [target.<cfg>], which only apply on some platforms.
+	targetTables []string
+}
+
+type crateDependency struct {
+	// The name the crate refers to the dependency by.
+	name string
+	// The package it refers to, which differs when renamed with
+	// `package = "..."`.
+	packageName     string
+	requirement     string
+	optional        bool
+	defaultFeatures bool
+	features        []string
+	// "dependencies" or "build-dependencies".
+	table string
+}
+
+func (crate *vendoredCrate) String() string {
+	return crate.name + " " + crate.version
+}
+
+func readVendoredCrate(vendorDirectory, directory string) (*vendoredCrate, error) {
+	manifestPath := filepath.Join(vendorDirectory, directory, "Cargo.toml")
+	contents, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	var manifest map[string]any
+	if err := toml.Unmarshal(contents, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", manifestPath, err)
+	}
+
+	pkg, _ := manifest["package"].(map[string]any)
+	crate := &vendoredCrate{
+		directory:        directory,
+		name:             stringValue(pkg["name"]),
+		version:          stringValue(pkg["version"]),
+		edition:          stringValue(pkg["edition"]),
+		links:            stringValue(pkg["links"]),
+		libraryRoot:      "src/lib.rs",
+		enabledByFeature: make(map[string][]string),
+	}
+	if crate.name == "" || crate.version == "" {
+		return nil, fmt.Errorf("%s: missing package name or version", manifestPath)
+	}
+	if crate.edition == "" {
+		crate.edition = "2015"
+	}
+
+	if lib, ok := manifest["lib"].(map[string]any); ok {
+		if libraryRoot := stringValue(lib["path"]); libraryRoot != "" {
+			crate.libraryRoot = libraryRoot
+		}
+		if libraryName := stringValue(lib["name"]); libraryName != "" && libraryName != normalizeCrateName(crate.name) {
+			crate.libraryName = libraryName
+		}
+		crate.procMacro = lib["proc-macro"] == true || lib["proc_macro"] == true
+	}
+
+	switch build := pkg["build"].(type) {
+	case string:
+		crate.buildScript = build
+	case nil:
+		if _, err := os.Stat(filepath.Join(vendorDirectory, directory, "build.rs")); err == nil {
+			crate.buildScript = "build.rs"
+		}
+	}
+
+	if features, ok := manifest["features"].(map[string]any); ok {
+		for feature, values := range features {
+			crate.enabledByFeature[feature] = stringValues(values)
+		}
+	}
+
+	crate.addDependencies(manifest["dependencies"], "dependencies")
+	crate.addDependencies(manifest["build-dependencies"], "build-dependencies")
+	// Cargo accepts the underscore spelling too.
+	crate.addDependencies(manifest["build_dependencies"], "build-dependencies")
+	if targets, ok := manifest["target"].(map[string]any); ok {
+		for cfg, tables := range targets {
+			tablesByName, _ := tables.(map[string]any)
+			for table := range tablesByName {
+				if table != "dev-dependencies" {
+					crate.targetTables = append(crate.targetTables, fmt.Sprintf("target.%s.%s", cfg, table))
+				}
+			}
+		}
+		slices.Sort(crate.targetTables)
+	}
+	return crate, nil
+}
+
+func (crate *vendoredCrate) addDependencies(table any, tableName string) {
+	entries, ok := table.(map[string]any)
+	if !ok {
+		return
+	}
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		dependency := &crateDependency{name: name, packageName: name, defaultFeatures: true, table: tableName}
+		switch entry := entries[name].(type) {
+		case string:
+			dependency.requirement = entry
+		case map[string]any:
+			dependency.requirement = stringValue(entry["version"])
+			if packageName := stringValue(entry["package"]); packageName != "" {
+				dependency.packageName = packageName
+			}
+			dependency.optional = entry["optional"] == true
+			dependency.defaultFeatures = entry["default-features"] != false && entry["default_features"] != false
+			dependency.features = stringValues(entry["features"])
+		}
+		crate.dependencies = append(crate.dependencies, dependency)
+	}
+}
+
+func stringValue(value any) string {
+	str, _ := value.(string)
+	return str
+}
+
+func stringValues(value any) []string {
+	values, _ := value.([]any)
+	var strs []string
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
+
+func normalizeCrateName(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
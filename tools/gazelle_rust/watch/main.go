@@ -0,0 +1,212 @@
+// Watch the repository for Rust source, BUILD, and Cargo.lock changes and
+// run gazelle on the affected packages.
+//
+// Usage: bazel run //tools/gazelle_rust/watch -- [flags] [-- gazelle command]
+//
+// The gazelle command defaults to `bazel run //:gazelle --`; the affected
+// package directories are appended to it. Pass -rust_state_file through the
+// command to make each run incremental. In a large repository, -root limits
+// scanning to the directories with Rust code, and -ignore skips directories
+// within them.
+package main
+
+import (
+	"flag"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var defaultGazelleCommand = []string{"bazel", "run", "//:gazelle", "--"}
+
+// The parts of the repository to watch.
+type watchedTree struct {
+	repoRoot string
+	// Directories to scan and update, relative to repoRoot. Empty for the
+	// whole repository.
+	roots []string
+	// Directories not to scan, relative to repoRoot.
+	ignoredPaths []string
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func main() {
+	interval := flag.Duration("interval", time.Second, "how often to scan the repository for changes")
+	settle := flag.Duration("settle", 300*time.Millisecond, "how long changes must stop before gazelle runs")
+	var tree watchedTree
+	flag.Func("root", "a directory to watch, relative to the repository root; repeat for several (default: the whole repository)", func(value string) error {
+		tree.roots = append(tree.roots, filepath.Clean(value))
+		return nil
+	})
+	flag.Func("ignore", "a directory not to watch, relative to the repository root; repeat for several", func(value string) error {
+		tree.ignoredPaths = append(tree.ignoredPaths, filepath.Clean(value))
+		return nil
+	})
+	flag.Parse()
+
+	gazelleCommand := flag.Args()
+	if len(gazelleCommand) == 0 {
+		gazelleCommand = defaultGazelleCommand
+	}
+
+	tree.repoRoot = os.Getenv("BUILD_WORKSPACE_DIRECTORY")
+	if tree.repoRoot == "" {
+		var err error
+		tree.repoRoot, err = os.Getwd()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Bring every package up to date first; runs limited to changed packages
+	// only resolve against rules that already exist elsewhere.
+	runGazelle(tree.repoRoot, gazelleCommand, tree.roots)
+	stateByPath := tree.scan()
+	log.Printf("watching %s", tree.repoRoot)
+	for {
+		time.Sleep(*interval)
+		current := tree.scan()
+		changedPaths := diffStates(stateByPath, current)
+		if len(changedPaths) == 0 {
+			continue
+		}
+
+		// Wait for editors and tools to finish writing before running.
+		for {
+			time.Sleep(*settle)
+			settled := tree.scan()
+			newlyChanged := diffStates(current, settled)
+			current = settled
+			if len(newlyChanged) == 0 {
+				break
+			}
+			changedPaths = append(changedPaths, newlyChanged...)
+		}
+
+		runGazelle(tree.repoRoot, gazelleCommand, tree.affectedPackages(changedPaths))
+		// Rescan so the BUILD files gazelle just wrote don't trigger another run.
+		stateByPath = tree.scan()
+	}
+}
+
+// Return the files under the roots that gazelle_rust reads, and the
+// repository's Cargo.lock, keyed by path relative to the repository root.
+func (tree watchedTree) scan() map[string]fileState {
+	stateByPath := make(map[string]fileState)
+	roots := tree.roots
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	for _, root := range roots {
+		filepath.WalkDir(filepath.Join(tree.repoRoot, root), func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			rel, err := filepath.Rel(tree.repoRoot, p)
+			if err != nil {
+				return nil
+			}
+			name := entry.Name()
+			if entry.IsDir() {
+				if rel != root && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "bazel-")) || tree.isIgnored(rel) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if isWatchedFile(name) {
+				if info, err := entry.Info(); err == nil {
+					stateByPath[rel] = fileState{modTime: info.ModTime(), size: info.Size()}
+				}
+			}
+			return nil
+		})
+	}
+	// Every package depends on the lockfile, wherever the roots are.
+	if info, err := os.Stat(filepath.Join(tree.repoRoot, "Cargo.lock")); err == nil {
+		stateByPath["Cargo.lock"] = fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stateByPath
+}
+
+func (tree watchedTree) isIgnored(rel string) bool {
+	return slices.ContainsFunc(tree.ignoredPaths, func(ignored string) bool {
+		return rel == ignored || strings.HasPrefix(rel, ignored+string(filepath.Separator))
+	})
+}
+
+func isWatchedFile(name string) bool {
+	return strings.HasSuffix(name, ".rs") || name == "BUILD" || name == "BUILD.bazel" || name == "Cargo.lock"
+}
+
+func diffStates(previous, current map[string]fileState) []string {
+	var changed []string
+	for p, state := range current {
+		if previousState, ok := previous[p]; !ok || previousState != state {
+			changed = append(changed, p)
+		}
+	}
+	for p := range previous {
+		if _, ok := current[p]; !ok {
+			changed = append(changed, p)
+		}
+	}
+	return changed
+}
+
+// Return the package directories containing the changed files, or the roots
+// when every package must be updated, which are nil for the whole repository.
+func (tree watchedTree) affectedPackages(changedPaths []string) []string {
+	packageSet := make(map[string]bool)
+	for _, p := range changedPaths {
+		// Every package's external deps depend on Cargo.lock.
+		if filepath.Base(p) == "Cargo.lock" {
+			return tree.roots
+		}
+		packageSet[containingPackage(tree.repoRoot, filepath.Dir(p))] = true
+	}
+	return slices.Sorted(maps.Keys(packageSet))
+}
+
+// Return the nearest directory at or above dir with a BUILD file; Rust
+// sources in subdirectories belong to the package above them.
+func containingPackage(repoRoot, dir string) string {
+	for dir != "." {
+		for _, buildFile := range []string{"BUILD", "BUILD.bazel"} {
+			if _, err := os.Stat(filepath.Join(repoRoot, dir, buildFile)); err == nil {
+				return dir
+			}
+		}
+		dir = filepath.Dir(dir)
+	}
+	return "."
+}
+
+func runGazelle(repoRoot string, gazelleCommand, packages []string) {
+	args := slices.Clone(gazelleCommand[1:])
+	for _, pkg := range packages {
+		args = append(args, filepath.Join(repoRoot, pkg))
+	}
+	if len(packages) == 0 {
+		log.Printf("updating all packages")
+	} else {
+		log.Printf("updating %s", strings.Join(packages, " "))
+	}
+
+	cmd := exec.Command(gazelleCommand[0], args...)
+	cmd.Dir = repoRoot
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("gazelle: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Create each file, relative to a new repository root, and return the root.
+func writeRepo(t *testing.T, files ...string) string {
+	t.Helper()
+	repoRoot := t.TempDir()
+	for _, file := range files {
+		path := filepath.Join(repoRoot, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repoRoot
+}
+
+func TestContainingPackage(t *testing.T) {
+	repoRoot := writeRepo(t,
+		"BUILD.bazel",
+		"lib/BUILD",
+		"lib/src/nested/mod.rs",
+		"app/main.rs",
+		"app/cli/BUILD.bazel",
+	)
+	tests := []struct {
+		dir, want string
+	}{
+		{"lib", "lib"},
+		{"lib/src", "lib"},
+		{"lib/src/nested", "lib"},
+		{"app", "."},
+		{"app/cli", "app/cli"},
+		{".", "."},
+	}
+	for _, test := range tests {
+		if got := containingPackage(repoRoot, test.dir); got != test.want {
+			t.Errorf("containingPackage(%q) = %q, want %q", test.dir, got, test.want)
+		}
+	}
+}
+
+func TestAffectedPackages(t *testing.T) {
+	repoRoot := writeRepo(t,
+		"lib/BUILD.bazel",
+		"lib/src/util.rs",
+		"app/BUILD.bazel",
+	)
+	tests := []struct {
+		name         string
+		roots        []string
+		changedPaths []string
+		want         []string
+	}{
+		{
+			name:         "sources and BUILD files",
+			changedPaths: []string{"lib/src/util.rs", "app/BUILD.bazel", "lib/lib.rs"},
+			want:         []string{"app", "lib"},
+		},
+		{
+			name:         "deleted source",
+			changedPaths: []string{"lib/removed.rs"},
+			want:         []string{"lib"},
+		},
+		{
+			name:         "outside any package",
+			changedPaths: []string{"tools/gen.rs"},
+			want:         []string{"."},
+		},
+		{
+			name:         "lockfile",
+			changedPaths: []string{"lib/lib.rs", "Cargo.lock"},
+			want:         nil,
+		},
+		{
+			name:         "lockfile with roots",
+			roots:        []string{"app", "lib"},
+			changedPaths: []string{"Cargo.lock"},
+			want:         []string{"app", "lib"},
+		},
+	}
+	for _, test := range tests {
+		tree := watchedTree{repoRoot: repoRoot, roots: test.roots}
+		if got := tree.affectedPackages(test.changedPaths); !slices.Equal(got, test.want) {
+			t.Errorf("%s: affectedPackages = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	repoRoot := writeRepo(t,
+		"Cargo.lock",
+		"README.md",
+		"lib/BUILD.bazel",
+		"lib/lib.rs",
+		"lib/generated/out.rs",
+		"lib/.cache/old.rs",
+		"app/main.rs",
+		"web/node_modules/pkg/index.rs",
+	)
+	tests := []struct {
+		name         string
+		roots        []string
+		ignoredPaths []string
+		want         []string
+	}{
+		{
+			name: "whole repository",
+			want: []string{"Cargo.lock", "app/main.rs", "lib/BUILD.bazel", "lib/generated/out.rs", "lib/lib.rs", "web/node_modules/pkg/index.rs"},
+		},
+		{
+			name:  "roots",
+			roots: []string{"lib"},
+			want:  []string{"Cargo.lock", "lib/BUILD.bazel", "lib/generated/out.rs", "lib/lib.rs"},
+		},
+		{
+			name:         "ignored paths",
+			ignoredPaths: []string{"web/node_modules", "lib/generated"},
+			want:         []string{"Cargo.lock", "app/main.rs", "lib/BUILD.bazel", "lib/lib.rs"},
+		},
+	}
+	for _, test := range tests {
+		tree := watchedTree{repoRoot: repoRoot, roots: test.roots, ignoredPaths: test.ignoredPaths}
+		if got := slices.Sorted(maps.Keys(tree.scan())); !slices.Equal(got, test.want) {
+			t.Errorf("%s: scan = %q, want %q", test.name, got, test.want)
+		}
+	}
+}